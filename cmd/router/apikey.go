@@ -0,0 +1,108 @@
+package main
+
+import (
+   "fmt"
+   "strings"
+
+   "github.com/spf13/cobra"
+   "github.com/router-production/internal/auth"
+)
+
+func apikeyCmd() *cobra.Command {
+   cmd := &cobra.Command{
+       Use:   "apikey",
+       Short: "Manage API keys",
+   }
+
+   // Create key
+   createCmd := &cobra.Command{
+       Use:   "create",
+       Short: "Create an API key",
+       RunE: func(cmd *cobra.Command, args []string) error {
+           name, _ := cmd.Flags().GetString("name")
+           scopes, _ := cmd.Flags().GetStringSlice("scopes")
+
+           db, err := getDB()
+           if err != nil {
+               return err
+           }
+
+           plaintext, key, err := auth.NewStore(db).Create(name, scopes)
+           if err != nil {
+               return err
+           }
+
+           fmt.Printf("API key %s created with scopes %s\n", key.Name, strings.Join(key.Scopes, ","))
+           fmt.Printf("\n  %s\n\n", plaintext)
+           fmt.Println("Store this key now, it cannot be shown again.")
+           return nil
+       },
+   }
+
+   createCmd.Flags().String("name", "", "Key name (required)")
+   createCmd.Flags().StringSlice("scopes", []string{auth.ScopeCall},
+       fmt.Sprintf("Scopes granted to the key (%s)", strings.Join(auth.AllScopes, ", ")))
+   createCmd.MarkFlagRequired("name")
+
+   // List keys
+   listCmd := &cobra.Command{
+       Use:   "list",
+       Short: "List API keys",
+       RunE: func(cmd *cobra.Command, args []string) error {
+           db, err := getDB()
+           if err != nil {
+               return err
+           }
+
+           keys, err := auth.NewStore(db).List()
+           if err != nil {
+               return err
+           }
+
+           fmt.Printf("%-5s %-20s %-12s %-18s %-20s %-20s\n", "ID", "NAME", "PREFIX", "SCOPES", "LAST USED", "STATUS")
+           fmt.Println(strings.Repeat("-", 100))
+
+           for _, k := range keys {
+               lastUsed := "never"
+               if k.LastUsedAt != nil {
+                   lastUsed = k.LastUsedAt.Format("2006-01-02 15:04:05")
+               }
+               status := "active"
+               if k.RevokedAt != nil {
+                   status = "revoked " + k.RevokedAt.Format("2006-01-02")
+               }
+
+               fmt.Printf("%-5d %-20s %-12s %-18s %-20s %-20s\n",
+                   k.ID, k.Name, k.Prefix, strings.Join(k.Scopes, ","), lastUsed, status)
+           }
+
+           return nil
+       },
+   }
+
+   // Revoke key
+   revokeCmd := &cobra.Command{
+       Use:   "revoke <name|id>",
+       Short: "Revoke an API key",
+       Args:  cobra.ExactArgs(1),
+       RunE: func(cmd *cobra.Command, args []string) error {
+           db, err := getDB()
+           if err != nil {
+               return err
+           }
+
+           if err := auth.NewStore(db).Revoke(args[0]); err != nil {
+               return err
+           }
+
+           fmt.Printf("API key %s revoked; running servers stop accepting it within %s\n", args[0], auth.CacheTTL)
+           return nil
+       },
+   }
+
+   cmd.AddCommand(createCmd)
+   cmd.AddCommand(listCmd)
+   cmd.AddCommand(revokeCmd)
+
+   return cmd
+}
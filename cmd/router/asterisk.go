@@ -0,0 +1,165 @@
+package main
+
+import (
+   "bytes"
+   "context"
+   "fmt"
+   "strings"
+
+   "github.com/spf13/cobra"
+   "github.com/router-production/internal/ami"
+   "github.com/router-production/internal/provider"
+)
+
+func asteriskCmd() *cobra.Command {
+   cmd := &cobra.Command{
+       Use:   "asterisk",
+       Short: "Preview and apply generated Asterisk configuration",
+   }
+   cmd.PersistentFlags().String("config-dir", "", "Asterisk config directory (overrides asterisk.config_dir)")
+
+   // Render
+   renderCmd := &cobra.Command{
+       Use:   "render [provider]",
+       Short: "Print the PJSIP and dialplan files generated for a provider, or the router dialplan",
+       Args:  cobra.MaximumNArgs(1),
+       RunE: func(cmd *cobra.Command, args []string) error {
+           reveal, _ := cmd.Flags().GetBool("reveal")
+
+           pm, err := asteriskManager(cmd, nil)
+           if err != nil {
+               return err
+           }
+
+           var files []provider.RenderedFile
+           if len(args) == 0 {
+               var router provider.RenderedFile
+               router, err = pm.RenderDialplan()
+               files = append(files, router)
+           } else {
+               files, err = pm.RenderConfig(args[0])
+           }
+           if err != nil {
+               return err
+           }
+
+           for _, f := range files {
+               content := f.Content
+               if !reveal {
+                   content = provider.MaskSecrets(content)
+               }
+               fmt.Printf("; ===== %s =====\n%s\n", f.Name, content)
+           }
+           return nil
+       },
+   }
+   renderCmd.Flags().Bool("reveal", false, "Show SIP passwords and API keys")
+
+   // Diff
+   diffCmd := &cobra.Command{
+       Use:   "diff",
+       Short: "Compare the configuration of all providers with the files on disk",
+       RunE: func(cmd *cobra.Command, args []string) error {
+           reveal, _ := cmd.Flags().GetBool("reveal")
+
+           pm, err := asteriskManager(cmd, nil)
+           if err != nil {
+               return err
+           }
+
+           diffs, err := pm.DiffConfig()
+           if err != nil {
+               return err
+           }
+           if len(diffs) == 0 {
+               fmt.Println("Asterisk configuration is up to date")
+               return nil
+           }
+
+           for _, d := range diffs {
+               if !reveal {
+                   d.Old, d.New = provider.MaskSecrets(d.Old), provider.MaskSecrets(d.New)
+                   if d.Status == provider.DiffChanged && bytes.Equal(d.Old, d.New) {
+                       fmt.Printf("%s: only secrets differ (use --reveal)\n", d.Name)
+                       continue
+                   }
+               }
+               fmt.Print(d.Unified())
+           }
+           return nil
+       },
+   }
+   diffCmd.Flags().Bool("reveal", false, "Show SIP passwords and API keys")
+
+   // Sync
+   syncCmd := &cobra.Command{
+       Use:   "sync",
+       Short: "Regenerate the configuration of all providers and remove orphaned files",
+       Long: `Regenerate the configuration of all active providers, remove files of
+providers that no longer exist, and reload the affected Asterisk modules.
+With --config-dir nothing is reloaded unless --reload is given.`,
+       RunE: func(cmd *cobra.Command, args []string) error {
+           output, _ := cmd.Flags().GetString("output")
+           reload, _ := cmd.Flags().GetBool("reload")
+           if !cmd.Flags().Changed("reload") {
+               reload = !cmd.Flags().Changed("config-dir")
+           }
+
+           ctx, cancel := context.WithCancel(context.Background())
+           defer cancel()
+
+           var client *ami.Client
+           if reload {
+               client = connectAMI(ctx)
+           }
+           pm, err := asteriskManager(cmd, client)
+           if err != nil {
+               return err
+           }
+
+           result, err := pm.SyncConfig(ctx, reload)
+           if err != nil {
+               return err
+           }
+
+           if output == "json" {
+               return printJSON(result)
+           }
+
+           for _, name := range result.Written {
+               fmt.Printf("written  %s\n", name)
+           }
+           for _, name := range result.Removed {
+               fmt.Printf("removed  %s\n", name)
+           }
+           switch {
+           case len(result.Written) == 0 && len(result.Removed) == 0:
+               fmt.Println("Asterisk configuration is up to date")
+           case result.Reload != nil:
+               fmt.Printf("Asterisk reloaded via %s: %s\n", result.Reload.Backend, strings.Join(result.Reload.Commands, ", "))
+           case !reload:
+               fmt.Println("Asterisk not reloaded")
+           }
+           return nil
+       },
+   }
+   syncCmd.Flags().Bool("reload", true, "Reload the affected Asterisk modules (default false with --config-dir)")
+   syncCmd.Flags().StringP("output", "o", "table", "Output format (table, json)")
+
+   cmd.AddCommand(renderCmd, diffCmd, syncCmd)
+   return cmd
+}
+
+// asteriskManager opens a provider manager that writes to --config-dir when
+// given, otherwise to asterisk.config_dir.
+func asteriskManager(cmd *cobra.Command, client *ami.Client) (*provider.Manager, error) {
+   if dir, _ := cmd.Flags().GetString("config-dir"); dir != "" {
+       cfg.Asterisk.ConfigDir = dir
+   }
+
+   db, err := getDB()
+   if err != nil {
+       return nil, err
+   }
+   return newManager(db, client)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+   "encoding/json"
+   "fmt"
+   "os"
+   "strings"
+   "time"
+
+   "github.com/spf13/cobra"
+   "github.com/router-production/internal/models"
+   "github.com/router-production/internal/router"
+)
+
+func callCmd() *cobra.Command {
+   cmd := &cobra.Command{
+       Use:   "call",
+       Short: "Inspect calls",
+   }
+
+   // List calls
+   listCmd := &cobra.Command{
+       Use:   "list",
+       Short: "List call records",
+       RunE: func(cmd *cobra.Command, args []string) error {
+           status, _ := cmd.Flags().GetString("status")
+           from, _ := cmd.Flags().GetString("from")
+           to, _ := cmd.Flags().GetString("to")
+           output, _ := cmd.Flags().GetString("output")
+
+           filter := router.CallFilter{}
+           filter.Provider, _ = cmd.Flags().GetString("provider")
+           filter.DID, _ = cmd.Flags().GetString("did")
+           filter.ANI, _ = cmd.Flags().GetString("ani")
+           filter.DNIS, _ = cmd.Flags().GetString("dnis")
+           filter.SortBy, _ = cmd.Flags().GetString("sort")
+           filter.Order, _ = cmd.Flags().GetString("order")
+           filter.Limit, _ = cmd.Flags().GetInt("limit")
+           filter.Cursor, _ = cmd.Flags().GetString("cursor")
+
+           var err error
+           if filter.Statuses, err = router.ParseCallStates(status); err != nil {
+               return err
+           }
+           if filter.From, err = parseTimeFlag(from); err != nil {
+               return fmt.Errorf("invalid --from: %w", err)
+           }
+           if filter.To, err = parseTimeFlag(to); err != nil {
+               return fmt.Errorf("invalid --to: %w", err)
+           }
+
+           db, err := getDB()
+           if err != nil {
+               return err
+           }
+
+           pm, err := newManager(db, nil)
+           if err != nil {
+               return err
+           }
+           r := router.NewRouter(db, pm, routerConfig(cfg))
+
+           page, err := r.ListCalls(filter)
+           if err != nil {
+               return err
+           }
+
+           if output == "json" {
+               return printJSON(page)
+           }
+
+           printCallTable(page.Calls)
+           if page.NextCursor != "" {
+               fmt.Printf("\nMore results: --cursor %s\n", page.NextCursor)
+           }
+           return nil
+       },
+   }
+
+   listCmd.Flags().String("status", "", "Filter by status (comma separated)")
+   listCmd.Flags().String("provider", "", "Filter by provider name")
+   listCmd.Flags().String("did", "", "Filter by assigned DID")
+   listCmd.Flags().String("ani", "", "Filter by original ANI")
+   listCmd.Flags().String("dnis", "", "Filter by original DNIS")
+   listCmd.Flags().String("from", "", "Start time lower bound (RFC3339 or duration ago, e.g. 1h)")
+   listCmd.Flags().String("to", "", "Start time upper bound (RFC3339 or duration ago)")
+   listCmd.Flags().String("sort", "start_time", "Sort field (start_time, duration, id)")
+   listCmd.Flags().String("order", "desc", "Sort order (asc, desc)")
+   listCmd.Flags().Int("limit", 50, "Maximum number of calls to return")
+   listCmd.Flags().String("cursor", "", "Cursor from a previous page")
+   listCmd.Flags().StringP("output", "o", "table", "Output format (table, json)")
+
+   // Show a single call
+   showCmd := &cobra.Command{
+       Use:   "show <call-id>",
+       Short: "Show a call record",
+       Args:  cobra.ExactArgs(1),
+       RunE: func(cmd *cobra.Command, args []string) error {
+           output, _ := cmd.Flags().GetString("output")
+
+           db, err := getDB()
+           if err != nil {
+               return err
+           }
+
+           pm, err := newManager(db, nil)
+           if err != nil {
+               return err
+           }
+           r := router.NewRouter(db, pm, routerConfig(cfg))
+
+           call, err := r.GetCall(args[0])
+           if err != nil {
+               return err
+           }
+
+           if output == "json" {
+               return printJSON(call)
+           }
+
+           endTime := "-"
+           if call.EndTime != nil {
+               endTime = call.EndTime.Format(time.RFC3339)
+           }
+
+           fmt.Printf("Call ID:        %s\n", call.CallID)
+           fmt.Printf("Status:         %s\n", call.Status)
+           fmt.Printf("Original ANI:   %s\n", call.OriginalANI)
+           fmt.Printf("Original DNIS:  %s\n", call.OriginalDNIS)
+           fmt.Printf("Assigned DID:   %s\n", call.AssignedDID)
+           fmt.Printf("Provider:       %s (id %d)\n", call.ProviderName, call.ProviderID)
+           fmt.Printf("Start Time:     %s\n", call.StartTime.Format(time.RFC3339))
+           fmt.Printf("End Time:       %s\n", endTime)
+           fmt.Printf("Duration:       %ds\n", call.Duration)
+           fmt.Printf("Recording:      %s\n", call.RecordingPath)
+           return nil
+       },
+   }
+
+   showCmd.Flags().StringP("output", "o", "table", "Output format (table, json)")
+
+   cmd.AddCommand(listCmd)
+   cmd.AddCommand(showCmd)
+
+   return cmd
+}
+
+func printCallTable(calls []*models.CallRecord) {
+   fmt.Printf("%-36s %-10s %-15s %-15s %-15s %-15s %-20s %-8s\n",
+       "CALL ID", "STATUS", "ANI", "DNIS", "DID", "PROVIDER", "START", "DURATION")
+   fmt.Println(strings.Repeat("-", 140))
+
+   for _, c := range calls {
+       fmt.Printf("%-36s %-10s %-15s %-15s %-15s %-15s %-20s %-8d\n",
+           c.CallID, c.Status, c.OriginalANI, c.OriginalDNIS, c.AssignedDID,
+           c.ProviderName, c.StartTime.Format("2006-01-02 15:04:05"), c.Duration)
+   }
+}
+
+func printJSON(v interface{}) error {
+   enc := json.NewEncoder(os.Stdout)
+   enc.SetIndent("", "  ")
+   return enc.Encode(v)
+}
+
+// parseTimeFlag accepts an RFC3339 timestamp or a duration meaning "that long ago".
+func parseTimeFlag(v string) (time.Time, error) {
+   if v == "" {
+       return time.Time{}, nil
+   }
+   if d, err := time.ParseDuration(v); err == nil {
+       return time.Now().Add(-d), nil
+   }
+   return time.Parse(time.RFC3339, v)
+}
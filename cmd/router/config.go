@@ -0,0 +1,48 @@
+package main
+
+import (
+   "fmt"
+
+   "github.com/spf13/cobra"
+)
+
+func configCmd() *cobra.Command {
+   cmd := &cobra.Command{
+       Use:   "config",
+       Short: "Inspect the router configuration",
+   }
+
+   // Validate configuration
+   validateCmd := &cobra.Command{
+       Use:   "validate",
+       Short: "Check the effective configuration for errors",
+       RunE: func(cmd *cobra.Command, args []string) error {
+           if err := cfg.Validate(); err != nil {
+               return err
+           }
+
+           fmt.Println("Configuration OK")
+           return nil
+       },
+   }
+
+   // Show configuration
+   showCmd := &cobra.Command{
+       Use:   "show",
+       Short: "Print the effective configuration with secrets masked",
+       RunE: func(cmd *cobra.Command, args []string) error {
+           out, err := cfg.Masked().YAML()
+           if err != nil {
+               return err
+           }
+
+           fmt.Print(out)
+           return nil
+       },
+   }
+
+   cmd.AddCommand(validateCmd)
+   cmd.AddCommand(showCmd)
+
+   return cmd
+}
@@ -1,21 +1,40 @@
 package main
 
 import (
+   "context"
+   "errors"
    "fmt"
    "log"
+   "net"
    "os"
+   "os/signal"
+   "strconv"
+   "sync"
+   "syscall"
+   "time"
    
    "strings"
    "github.com/router-production/internal/models"
    
    "github.com/spf13/cobra"
+   "github.com/router-production/internal/agi"
+   "github.com/router-production/internal/ami"
    "github.com/router-production/internal/api"
+   "github.com/router-production/internal/ari"
+   "github.com/router-production/internal/auth"
+   "github.com/router-production/internal/config"
    "github.com/router-production/internal/database"
    "github.com/router-production/internal/provider"
+   "github.com/router-production/internal/ratelimit"
    "github.com/router-production/internal/router"
+   "github.com/router-production/internal/secrets"
 )
 
 var (
+   configPath string
+   cfg        *config.Config
+   logFile    *os.File
+   
    dbHost string
    dbPort int
    dbUser string
@@ -25,22 +44,29 @@ var (
 
 func main() {
    var rootCmd = &cobra.Command{
-       Use:   "router",
-       Short: "Production S2 Router with Provider Management",
+       Use:               "router",
+       Short:             "Production S2 Router with Provider Management",
+       PersistentPreRunE: loadConfig,
    }
    
    // Global flags
-   rootCmd.PersistentFlags().StringVar(&dbHost, "db-host", "localhost", "Database host")
-   rootCmd.PersistentFlags().IntVar(&dbPort, "db-port", 3306, "Database port")
-   rootCmd.PersistentFlags().StringVar(&dbUser, "db-user", "root", "Database user")
-   rootCmd.PersistentFlags().StringVar(&dbPass, "db-pass", "temppass", "Database password")
-   rootCmd.PersistentFlags().StringVar(&dbName, "db-name", "call_routing", "Database name")
+   rootCmd.PersistentFlags().StringVar(&configPath, "config", config.DefaultPath, "Configuration file")
+   rootCmd.PersistentFlags().StringVar(&dbHost, "db-host", "", "Database host (overrides config)")
+   rootCmd.PersistentFlags().IntVar(&dbPort, "db-port", 0, "Database port (overrides config)")
+   rootCmd.PersistentFlags().StringVar(&dbUser, "db-user", "", "Database user (overrides config)")
+   rootCmd.PersistentFlags().StringVar(&dbPass, "db-pass", "", "Database password (overrides config)")
+   rootCmd.PersistentFlags().StringVar(&dbName, "db-name", "", "Database name (overrides config)")
    
    // Add commands
    rootCmd.AddCommand(serverCmd())
    rootCmd.AddCommand(providerCmd())
    rootCmd.AddCommand(didCmd())
    rootCmd.AddCommand(statsCmd())
+   rootCmd.AddCommand(callCmd())
+   rootCmd.AddCommand(configCmd())
+   rootCmd.AddCommand(apikeyCmd())
+   rootCmd.AddCommand(secretsCmd())
+   rootCmd.AddCommand(asteriskCmd())
    
    if err := rootCmd.Execute(); err != nil {
        fmt.Fprintln(os.Stderr, err)
@@ -48,15 +74,242 @@ func main() {
    }
 }
 
-func getDB() (*database.DB, error) {
-   dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true",
-       dbUser, dbPass, dbHost, dbPort, dbName)
+// loadConfig reads the configuration used by the command into cfg.
+func loadConfig(cmd *cobra.Command, args []string) error {
+   c, err := readConfig(cmd)
+   if err != nil {
+       return err
+   }
+   cfg = c
+   return openLogFile(c)
+}
+
+// readConfig reads the configuration file and environment, then applies
+// any database flags given on the command line.
+func readConfig(cmd *cobra.Command) (*config.Config, error) {
+   c, err := config.Load(configPath, cmd.Flags().Changed("config"))
+   if err != nil {
+       return nil, err
+   }
+   
+   flags := cmd.Flags()
+   if flags.Changed("db-host") {
+       c.Database.Host = dbHost
+   }
+   if flags.Changed("db-port") {
+       c.Database.Port = dbPort
+   }
+   if flags.Changed("db-user") {
+       c.Database.User = dbUser
+   }
+   if flags.Changed("db-pass") {
+       c.Database.Password = dbPass
+   }
+   if flags.Changed("db-name") {
+       c.Database.Name = dbName
+   }
+   return c, nil
+}
+
+// openLogFile sends the log to the configured file. It is reopened on every
+// load so reloads pick up rotated log files.
+func openLogFile(c *config.Config) error {
+   f, err := createLogFile(c)
+   if err != nil {
+       return err
+   }
+   useLogFile(f)
+   return nil
+}
+
+// createLogFile opens the configured log file, or returns nil when logging
+// goes to stderr.
+func createLogFile(c *config.Config) (*os.File, error) {
+   if c.Logging.File == "" {
+       return nil, nil
+   }
+   
+   f, err := os.OpenFile(c.Logging.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+   if err != nil {
+       return nil, fmt.Errorf("failed to open log file: %w", err)
+   }
+   return f, nil
+}
+
+// useLogFile switches the log to f and closes the previous file; nil keeps
+// the current output.
+func useLogFile(f *os.File) {
+   if f == nil {
+       return
+   }
+   log.SetOutput(f)
+   if logFile != nil {
+       logFile.Close()
+   }
+   logFile = f
+}
+
+// routerConfig maps the configuration file settings onto the router.
+func routerConfig(c *config.Config) router.Config {
+   return router.Config{
+       ReturnTimeout:   c.Timeouts.ReturnTimeout.Std(),
+       MaxCallDuration: c.Timeouts.MaxCallDuration.Std(),
+       CleanupInterval: c.Timeouts.CleanupInterval.Std(),
+       RecordingPath:   c.Asterisk.RecordingPath,
+       DefaultProvider: c.Routing.DefaultProvider,
+       RoutingRules:    c.Routing.Rules,
+       
+       ANIRateLimit:       rateLimit(c.RateLimits.PerANI),
+       ProviderRateLimit:  rateLimit(c.RateLimits.PerProvider),
+       ProviderRateLimits: providerRateLimits(c),
+   }
+}
+
+func rateLimit(l config.RateLimit) ratelimit.Limit {
+   return ratelimit.Limit{Rate: l.Rate, Burst: l.Burst}
+}
+
+func providerRateLimits(c *config.Config) map[string]ratelimit.Limit {
+   limits := make(map[string]ratelimit.Limit, len(c.RateLimits.Providers))
+   for name, l := range c.RateLimits.Providers {
+       limits[name] = rateLimit(l)
+   }
+   return limits
+}
+
+// newManager returns a provider manager that reloads Asterisk and reads
+// registration states through client. client may be nil for commands that
+// do not talk to Asterisk or when AMI is not used.
+func newManager(db *database.DB, client *ami.Client) (*provider.Manager, error) {
+   keyring, err := loadKeyring()
+   if err != nil {
+       return nil, err
+   }
+   
+   pcfg := provider.Config{
+       AsteriskConfigDir: cfg.Asterisk.ConfigDir,
+       Keyring:           keyring,
+       Reloader:          newReloader(client),
+       TemplateDir:       cfg.Asterisk.TemplateDir,
+   }
+   if client != nil {
+       pcfg.Registrations = &provider.AMIRegistrations{Client: client}
+   }
+   if cfg.Asterisk.ValidateCommand != "" {
+       pcfg.Validate = provider.CommandValidator(cfg.Asterisk.ValidateCommand)
+   }
+   if cfg.Asterisk.Dialplan.Enabled {
+       pcfg.Dialplan = routerDialplan()
+   }
+   return provider.NewManager(db, pcfg)
+}
+
+// routerDialplan returns the router dialplan settings, pointing Asterisk at
+// this server's API and AGI ports.
+func routerDialplan() *provider.RouterDialplan {
+   d := cfg.Asterisk.Dialplan
+   
+   scheme := "http"
+   if cfg.API.TLS.Enabled() {
+       scheme = "https"
+   }
+   
+   return &provider.RouterDialplan{
+       Mode:            d.ResolvedMode(cfg),
+       IncomingContext: d.IncomingContext,
+       ReturnContext:   d.ReturnContext,
+       AGIAddress:      net.JoinHostPort(d.RouterHost, strconv.Itoa(cfg.AGI.Port)),
+       APIURL:          scheme + "://" + net.JoinHostPort(d.RouterHost, strconv.Itoa(cfg.API.Port)),
+       APIKey:          d.APIKey,
+       ARIApp:          cfg.ARI.App,
+       DialTimeout:     int(d.DialTimeout.Std().Seconds()),
+       FailureCauses:   d.FailureCauses,
+   }
+}
+
+// parseContacts parses --contact values; contacts without a priority are
+// tried in the order given.
+func parseContacts(args []string) ([]models.Contact, error) {
+   contacts := make([]models.Contact, 0, len(args))
+   for i, arg := range args {
+       c, err := provider.ParseContact(arg, i+1)
+       if err != nil {
+           return nil, err
+       }
+       contacts = append(contacts, c)
+   }
+   return contacts, nil
+}
+
+// newAMIClient returns an AMI client when AMI is enabled or selected as the
+// reload backend, otherwise nil. The caller runs it.
+func newAMIClient() *ami.Client {
+   if !cfg.AMI.Enabled && cfg.Asterisk.ReloadBackend != "ami" {
+       return nil
+   }
+   return ami.NewClient(ami.Config{
+       Address:  cfg.AMI.Address,
+       Username: cfg.AMI.Username,
+       Secret:   cfg.AMI.Secret,
+   })
+}
+
+// amiStartupTimeout bounds how long startup waits for the AMI login
+const amiStartupTimeout = 15 * time.Second
+
+// connectAMI runs an AMI client for a one-shot command until ctx is done,
+// waiting up to amiStartupTimeout for the login. It returns nil when AMI is not used.
+func connectAMI(ctx context.Context) *ami.Client {
+   client := newAMIClient()
+   if client == nil {
+       return nil
+   }
+   go client.Run(ctx)
    
-   db, err := database.NewDB(dsn)
+   waitCtx, cancel := context.WithTimeout(ctx, amiStartupTimeout)
+   defer cancel()
+   if err := client.WaitConnected(waitCtx); err != nil {
+       log.Printf("AMI unavailable: %v", err)
+   }
+   return client
+}
+
+// newReloader returns the backend selected by asterisk.reload_backend.
+func newReloader(client *ami.Client) provider.Reloader {
+   local := &provider.ExecReloader{}
+   switch cfg.Asterisk.ReloadBackend {
+   case "exec":
+       return local
+   case "ami":
+       return &provider.AMIReloader{Client: client}
+   }
+   if client == nil {
+       return local
+   }
+   return provider.FallbackReloader{&provider.AMIReloader{Client: client}, local}
+}
+
+// loadKeyring returns the provider password keyring, or nil when no key is configured.
+func loadKeyring() (*secrets.Keyring, error) {
+   switch {
+   case cfg.Secrets.Key != "":
+       return secrets.ParseKeyring(cfg.Secrets.Key)
+   case cfg.Secrets.KeyFile != "":
+       return secrets.LoadKeyring(cfg.Secrets.KeyFile)
+   }
+   return nil, nil
+}
+
+func getDB() (*database.DB, error) {
+   db, err := database.NewDB(cfg.Database.DSN())
    if err != nil {
        return nil, err
    }
    
+   db.SetMaxOpenConns(cfg.Database.MaxOpenConns)
+   db.SetMaxIdleConns(cfg.Database.MaxIdleConns)
+   db.SetConnMaxLifetime(cfg.Database.ConnMaxLifetime.Std())
+   
    // Create tables
    if err := db.CreateTables(); err != nil {
        return nil, err
@@ -67,29 +320,239 @@ func getDB() (*database.DB, error) {
 
 func serverCmd() *cobra.Command {
    var port int
+   var shutdownTimeout, returnTimeout, maxCallDuration, cleanupInterval time.Duration
    
    cmd := &cobra.Command{
        Use:   "server",
        Short: "Start the router server",
        RunE: func(cmd *cobra.Command, args []string) error {
+           // Server flags override the config file, also after a reload
+           applyFlags := func(c *config.Config) {
+               flags := cmd.Flags()
+               if flags.Changed("port") {
+                   c.API.Port = port
+               }
+               if flags.Changed("shutdown-timeout") {
+                   c.API.ShutdownTimeout = config.Duration(shutdownTimeout)
+               }
+               if flags.Changed("return-timeout") {
+                   c.Timeouts.ReturnTimeout = config.Duration(returnTimeout)
+               }
+               if flags.Changed("max-call-duration") {
+                   c.Timeouts.MaxCallDuration = config.Duration(maxCallDuration)
+               }
+               if flags.Changed("cleanup-interval") {
+                   c.Timeouts.CleanupInterval = config.Duration(cleanupInterval)
+               }
+           }
+           applyFlags(cfg)
+           
+           if err := cfg.Validate(); err != nil {
+               return err
+           }
+           
            db, err := getDB()
            if err != nil {
                return err
            }
            
-           // Initialize components
-           pm := provider.NewManager(db)
-           r := router.NewRouter(db, pm)
+           defer db.Close()
+           
+           ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+           defer stop()
+           
+           // Initialize components, sharing one AMI connection between
+           // call tracking and provider reloads
+           amiClient := newAMIClient()
+           pm, err := newManager(db, amiClient)
+           if err != nil {
+               return err
+           }
+           if cfg.Secrets.Key == "" && cfg.Secrets.KeyFile == "" {
+               log.Printf("WARNING: no secrets key configured, provider passwords are stored unencrypted")
+           }
+           r := router.NewRouter(db, pm, routerConfig(cfg))
+           r.Start(ctx)
            
            // Start API server
-           server := api.NewServer(r, pm, port)
+           apiCfg := api.Config{
+               Port:         cfg.API.Port,
+               ReadTimeout:  cfg.API.ReadTimeout.Std(),
+               WriteTimeout: cfg.API.WriteTimeout.Std(),
+               CORSOrigins:  cfg.API.CORSOrigins,
+               
+               CallAllowlist:  cfg.API.CallAllowlist,
+               AdminAllowlist: cfg.API.AdminAllowlist,
+               TrustedProxies: cfg.API.TrustedProxies,
+               IPRateLimit:    rateLimit(cfg.RateLimits.PerIP),
+           }
+           if t := cfg.API.TLS; t.Enabled() {
+               apiCfg.TLS = &api.TLSConfig{
+                   CertFile:          t.CertFile,
+                   KeyFile:           t.KeyFile,
+                   ClientCAFile:      t.ClientCAFile,
+                   RequireClientCert: t.RequireClientCert,
+                   ClientNames:       t.ClientNames,
+               }
+           }
+           if cfg.API.AuthEnabled {
+               apiCfg.Auth = auth.NewStore(db)
+           } else {
+               log.Printf("WARNING: API authentication is disabled")
+           }
+           server, err := api.NewServer(r, pm, apiCfg)
+           if err != nil {
+               r.Close()
+               return err
+           }
+           
+           // The AGI server answers the same nodes as the call endpoints
+           var agiServer *agi.Server
+           if cfg.AGI.Enabled {
+               agiServer, err = agi.NewServer(r, agi.Config{
+                   BindAddress: cfg.AGI.BindAddress,
+                   Port:        cfg.AGI.Port,
+                   Allowlist:   cfg.API.CallAllowlist,
+                   IPRateLimit: rateLimit(cfg.RateLimits.PerIP),
+               })
+               if err != nil {
+                   r.Close()
+                   return err
+               }
+               if len(cfg.API.CallAllowlist) == 0 {
+                   log.Printf("WARNING: AGI server accepts sessions from any address, set api.call_allowlist")
+               }
+           }
+           
+           // Reload on SIGHUP, through the admin API and optionally by polling
+           rl := &reloader{cmd: cmd, applyFlags: applyFlags, cfg: cfg, pm: pm, r: r, server: server, agi: agiServer}
+           server.SetReloadFunc(rl.Reload)
+           
+           hup := make(chan os.Signal, 1)
+           signal.Notify(hup, syscall.SIGHUP)
+           defer signal.Stop(hup)
+           
+           // Asterisk connections and background work, stopped with ctx
+           // and waited for before the database is closed
+           var background sync.WaitGroup
+           
+           if interval := cfg.Reload.PollInterval.Std(); interval > 0 {
+               background.Add(1)
+               go func() {
+                   defer background.Done()
+                   pm.Watch(ctx, interval)
+               }()
+           }
+           
+           // Follow hangups through AMI
+           if amiClient != nil {
+               if cfg.AMI.Enabled {
+                   amiClient.OnEvent(ami.NewTracker(r).HandleEvent)
+               }
+               background.Add(1)
+               go func() {
+                   defer background.Done()
+                   amiClient.Run(ctx)
+               }()
+           }
+           
+           // Route calls handed to the Stasis application
+           if cfg.ARI.Enabled {
+               app := ari.NewApp(r, ari.Config{
+                   URL:      cfg.ARI.URL,
+                   Username: cfg.ARI.Username,
+                   Password: cfg.ARI.Password,
+                   App:      cfg.ARI.App,
+               })
+               background.Add(1)
+               go func() {
+                   defer background.Done()
+                   app.Run(ctx)
+               }()
+           }
+           
+           log.Printf("Starting router server on port %d", cfg.API.Port)
+           errCh := make(chan error, 2)
+           running := 1
+           go func() {
+               errCh <- server.Start()
+           }()
+           
+           if agiServer != nil {
+               running++
+               go func() {
+                   errCh <- agiServer.Start()
+               }()
+           }
+           
+           // Bring the router dialplan in line with this version
+           if cfg.Asterisk.Dialplan.Enabled {
+               background.Add(1)
+               go func() {
+                   defer background.Done()
+                   
+                   // Reloading through AMI needs the login to have finished
+                   if amiClient != nil {
+                       waitCtx, cancel := context.WithTimeout(ctx, amiStartupTimeout)
+                       err := amiClient.WaitConnected(waitCtx)
+                       cancel()
+                       if err != nil {
+                           log.Printf("AMI not connected, applying router dialplan anyway: %v", err)
+                       }
+                   }
+                   
+                   reloadCtx, cancel := context.WithTimeout(ctx, provider.ReloadTimeout)
+                   defer cancel()
+                   result, err := pm.GenerateDialplan(reloadCtx)
+                   switch {
+                   case err != nil:
+                       log.Printf("Failed to apply router dialplan: %v", err)
+                   case result != nil:
+                       log.Printf("Router dialplan updated, Asterisk reloaded via %s", result.Backend)
+                   }
+               }()
+           }
+           
+           var serveErr error
+       wait:
+           for {
+               select {
+               case serveErr = <-errCh:
+                   running--
+                   break wait
+               case <-hup:
+                   if err := rl.Reload(); err != nil {
+                       log.Printf("Reload failed: %v", err)
+                   }
+               case <-ctx.Done():
+                   log.Printf("Shutdown signal received, draining connections")
+                   break wait
+               }
+           }
            
-           log.Printf("Starting router server on port %d", port)
-           return server.Start()
+           // Stop both servers, also when one of them failed
+           servers := []drainTarget{{name: "API", server: server}}
+           if agiServer != nil {
+               servers = append(servers, drainTarget{name: "AGI", server: agiServer})
+           }
+           shutdown(cfg.API.ShutdownTimeout.Std(), servers, stop, &background, r)
+           
+           for ; running > 0; running-- {
+               if err := <-errCh; serveErr == nil {
+                   serveErr = err
+               }
+           }
+           
+           log.Printf("Router server stopped")
+           return serveErr
        },
    }
    
-   cmd.Flags().IntVarP(&port, "port", "p", 8001, "Server port")
+   cmd.Flags().IntVarP(&port, "port", "p", 0, "Server port (overrides config)")
+   cmd.Flags().DurationVar(&shutdownTimeout, "shutdown-timeout", 0, "Maximum time to drain connections on shutdown (overrides config)")
+   cmd.Flags().DurationVar(&returnTimeout, "return-timeout", 0, "Maximum time a call waits for its return leg (overrides config)")
+   cmd.Flags().DurationVar(&maxCallDuration, "max-call-duration", 0, "Maximum tracked call duration (overrides config)")
+   cmd.Flags().DurationVar(&cleanupInterval, "cleanup-interval", 0, "Interval between stale call cleanups (overrides config)")
    
    return cmd
 }
@@ -114,45 +577,119 @@ func providerCmd() *cobra.Command {
            codecs, _ := cmd.Flags().GetStringSlice("codecs")
            maxChannels, _ := cmd.Flags().GetInt("max-channels")
            country, _ := cmd.Flags().GetString("country")
+           returnTimeout, _ := cmd.Flags().GetDuration("return-timeout")
+           maxCallDuration, _ := cmd.Flags().GetDuration("max-call-duration")
+           transport, _ := cmd.Flags().GetString("transport")
+           mediaEncryption, _ := cmd.Flags().GetString("media-encryption")
+           tlsCertFile, _ := cmd.Flags().GetString("tls-cert-file")
+           tlsKeyFile, _ := cmd.Flags().GetString("tls-key-file")
+           tlsCAFile, _ := cmd.Flags().GetString("tls-ca-file")
+           tlsVerifyServer, _ := cmd.Flags().GetBool("tls-verify-server")
+           registration, _ := cmd.Flags().GetString("registration")
+           regExpiration, _ := cmd.Flags().GetDuration("registration-expiration")
+           regRetryInterval, _ := cmd.Flags().GetDuration("registration-retry-interval")
+           regServerURI, _ := cmd.Flags().GetString("registration-server-uri")
+           regClientURI, _ := cmd.Flags().GetString("registration-client-uri")
+           match, _ := cmd.Flags().GetStringSlice("match")
+           template, _ := cmd.Flags().GetString("template")
+           contactArgs, _ := cmd.Flags().GetStringSlice("contact")
+           contacts, err := parseContacts(contactArgs)
+           if err != nil {
+               return err
+           }
+           if transport == "tls" && !cmd.Flags().Changed("port") {
+               port = 5061
+           }
            
            db, err := getDB()
            if err != nil {
                return err
            }
            
-           pm := provider.NewManager(db)
+           ctx, cancel := context.WithCancel(context.Background())
+           defer cancel()
+           
+           pm, err := newManager(db, connectAMI(ctx))
+           if err != nil {
+               return err
+           }
            
            p := &models.Provider{
-               Name:        name,
-               Host:        host,
-               Port:        port,
-               Username:    username,
-               Password:    password,
-               Realm:       realm,
-               Codecs:      codecs,
-               MaxChannels: maxChannels,
-               Country:     country,
-               Active:      true,
-           }
-           
-           if err := pm.AddProvider(p); err != nil {
+               Name:            name,
+               Host:            host,
+               Port:            port,
+               Username:        username,
+               Password:        password,
+               Realm:           realm,
+               Codecs:          codecs,
+               MaxChannels:     maxChannels,
+               Country:         country,
+               Active:          true,
+               ReturnTimeout:   int(returnTimeout / time.Second),
+               MaxCallDuration: int(maxCallDuration / time.Second),
+               Transport:       transport,
+               MediaEncryption: mediaEncryption,
+               TLSCertFile:     tlsCertFile,
+               TLSKeyFile:      tlsKeyFile,
+               TLSCAFile:       tlsCAFile,
+               TLSVerifyServer: tlsVerifyServer,
+               
+               Registration:              registration,
+               RegistrationExpiration:    int(regExpiration / time.Second),
+               RegistrationRetryInterval: int(regRetryInterval / time.Second),
+               RegistrationServerURI:     regServerURI,
+               RegistrationClientURI:     regClientURI,
+               MatchAddresses:            match,
+               Contacts:                  contacts,
+               Template:                  template,
+           }
+           
+           reload, err := pm.AddProvider(p)
+           if errors.Is(err, provider.ErrNotApplied) {
+               fmt.Printf("Provider %s saved, but Asterisk was not updated\n", name)
+           }
+           if err != nil {
                return err
            }
            
            fmt.Printf("Provider %s added successfully\n", name)
+           if reload == nil {
+               fmt.Println("Asterisk configuration unchanged, no reload needed")
+               return nil
+           }
+           fmt.Printf("Asterisk reloaded via %s: %s\n", reload.Backend, strings.Join(reload.Commands, ", "))
+           if reload.Output != "" {
+               fmt.Println(reload.Output)
+           }
            return nil
        },
    }
    
    addCmd.Flags().String("name", "", "Provider name (required)")
    addCmd.Flags().String("host", "", "Provider host/IP (required)")
-   addCmd.Flags().Int("port", 5060, "Provider port")
+   addCmd.Flags().Int("port", 5060, "Provider port (5061 with --transport tls)")
    addCmd.Flags().String("username", "", "SIP username")
    addCmd.Flags().String("password", "", "SIP password")
    addCmd.Flags().String("realm", "", "SIP realm")
    addCmd.Flags().StringSlice("codecs", []string{"ulaw", "alaw"}, "Supported codecs")
    addCmd.Flags().Int("max-channels", 100, "Maximum concurrent channels")
    addCmd.Flags().String("country", "", "Provider country")
+   addCmd.Flags().Duration("return-timeout", 0, "Maximum time a call waits for its return leg (0 uses the global setting)")
+   addCmd.Flags().Duration("max-call-duration", 0, "Maximum tracked call duration (0 uses the global setting)")
+   addCmd.Flags().String("transport", "udp", "SIP transport (udp, tcp, tls, ws, wss)")
+   addCmd.Flags().String("media-encryption", "no", "SRTP mode (no, sdes, dtls)")
+   addCmd.Flags().String("tls-cert-file", "", "TLS certificate, also used for DTLS")
+   addCmd.Flags().String("tls-key-file", "", "TLS private key, also used for DTLS")
+   addCmd.Flags().String("tls-ca-file", "", "CA list used to verify the provider")
+   addCmd.Flags().Bool("tls-verify-server", false, "Verify the provider's TLS certificate")
+   addCmd.Flags().String("registration", "none", "Registration mode (none, outbound)")
+   addCmd.Flags().Duration("registration-expiration", 0, "Requested registration lifetime (0 uses the Asterisk default)")
+   addCmd.Flags().Duration("registration-retry-interval", 0, "Delay before retrying a failed registration (0 uses the Asterisk default)")
+   addCmd.Flags().String("registration-server-uri", "", "Registrar URI (default sip:<host>:<port>)")
+   addCmd.Flags().String("registration-client-uri", "", "Address of record (default sip:<username>@<host>)")
+   addCmd.Flags().StringSlice("match", nil, "Addresses or CIDRs calls are accepted from (default the host)")
+   addCmd.Flags().StringSlice("contact", nil, "Outbound gateways as host[:port][/priority], in priority order (default the host)")
+   addCmd.Flags().String("template", "", "Template set from asterisk.template_dir (default the built-in templates)")
    addCmd.MarkFlagRequired("name")
    addCmd.MarkFlagRequired("host")
    
@@ -166,23 +703,123 @@ func providerCmd() *cobra.Command {
                return err
            }
            
-           pm := provider.NewManager(db)
-           providers := pm.ListProviders()
+           output, _ := cmd.Flags().GetString("output")
+           reveal, _ := cmd.Flags().GetBool("reveal")
+           
+           pm, err := newManager(db, nil)
+           if err != nil {
+               return err
+           }
+           providers := pm.ListProviderViews()
+           
+           // Credentials are only shown on explicit request
+           if reveal {
+               for _, p := range providers {
+                   if creds, err := pm.GetCredentials(p.Name); err == nil {
+                       p.Password = creds.Password
+                   }
+               }
+           }
+           
+           if output == "json" {
+               return printJSON(providers)
+           }
            
-           fmt.Printf("%-15s %-20s %-10s %-10s %-10s\n", "NAME", "HOST", "PORT", "COUNTRY", "ACTIVE")
-           fmt.Println(strings.Repeat("-", 70))
+           fmt.Printf("%-15s %-20s %-10s %-10s %-10s %-15s %-10s\n", "NAME", "HOST", "PORT", "COUNTRY", "ACTIVE", "USERNAME", "PASSWORD")
+           fmt.Println(strings.Repeat("-", 100))
            
            for _, p := range providers {
-               fmt.Printf("%-15s %-20s %-10d %-10s %-10v\n", 
-                   p.Name, p.Host, p.Port, p.Country, p.Active)
+               fmt.Printf("%-15s %-20s %-10d %-10s %-10v %-15s %-10s\n", 
+                   p.Name, p.Host, p.Port, p.Country, p.Active, p.Username, p.Password)
+           }
+           
+           return nil
+       },
+   }
+   
+   listCmd.Flags().StringP("output", "o", "table", "Output format (table, json)")
+   listCmd.Flags().Bool("reveal", false, "Show SIP passwords instead of masking them")
+   
+   // Show or replace match addresses and contacts
+   endpointsCmd := &cobra.Command{
+       Use:   "endpoints <name>",
+       Short: "Show or set a provider's match addresses and outbound contacts",
+       Args:  cobra.ExactArgs(1),
+       RunE: func(cmd *cobra.Command, args []string) error {
+           db, err := getDB()
+           if err != nil {
+               return err
+           }
+           
+           setMatch := cmd.Flags().Changed("match")
+           setContacts := cmd.Flags().Changed("contact")
+           
+           ctx, cancel := context.WithCancel(context.Background())
+           defer cancel()
+           
+           var client *ami.Client
+           if setMatch || setContacts {
+               client = connectAMI(ctx)
+           }
+           pm, err := newManager(db, client)
+           if err != nil {
+               return err
+           }
+           
+           p, err := pm.GetProvider(args[0])
+           if err != nil {
+               return err
            }
            
+           if setMatch || setContacts {
+               var match []string
+               var contacts []models.Contact
+               if setMatch {
+                   match, _ = cmd.Flags().GetStringSlice("match")
+                   match = append([]string{}, match...)
+               }
+               if setContacts {
+                   contactArgs, _ := cmd.Flags().GetStringSlice("contact")
+                   if contacts, err = parseContacts(contactArgs); err != nil {
+                       return err
+                   }
+                   contacts = append([]models.Contact{}, contacts...)
+               }
+               
+               var reload *provider.ReloadResult
+               p, reload, err = pm.SetEndpoints(args[0], match, contacts)
+               if errors.Is(err, provider.ErrNotApplied) {
+                   fmt.Printf("Provider %s saved, but Asterisk was not updated\n", args[0])
+               }
+               if err != nil {
+                   return err
+               }
+               if reload != nil {
+                   fmt.Printf("Asterisk reloaded via %s: %s\n", reload.Backend, strings.Join(reload.Commands, ", "))
+               }
+           }
+           
+           if len(p.MatchAddresses) > 0 {
+               fmt.Printf("Match: %s\n", strings.Join(p.MatchAddresses, ", "))
+           } else {
+               fmt.Printf("Match: %s (host)\n", p.Host)
+           }
+           fmt.Printf("%-10s %-30s %-10s\n", "PRIORITY", "HOST", "PORT")
+           for _, c := range p.Contacts {
+               fmt.Printf("%-10d %-30s %-10d\n", c.Priority, c.Host, c.Port)
+           }
+           if len(p.Contacts) == 0 {
+               fmt.Printf("%-10d %-30s %-10d\n", 1, p.Host+" (host)", p.Port)
+           }
            return nil
        },
    }
+   endpointsCmd.Flags().StringSlice("match", nil, "Replace the match addresses (empty uses the host)")
+   endpointsCmd.Flags().StringSlice("contact", nil, "Replace the contacts, host[:port][/priority] (empty uses the host)")
    
    cmd.AddCommand(addCmd)
    cmd.AddCommand(listCmd)
+   cmd.AddCommand(endpointsCmd)
    
    return cmd
 }
@@ -198,7 +835,7 @@ func didCmd() *cobra.Command {
        Use:   "add",
        Short: "Add DIDs to a provider",
        RunE: func(cmd *cobra.Command, args []string) error {
-           provider, _ := cmd.Flags().GetString("provider")
+           providerName, _ := cmd.Flags().GetString("provider")
            dids, _ := cmd.Flags().GetStringSlice("dids")
            file, _ := cmd.Flags().GetString("file")
            country, _ := cmd.Flags().GetString("country")
@@ -226,13 +863,20 @@ func didCmd() *cobra.Command {
                return err
            }
            
-           pm := provider.NewManager(db)
+           pm, err := newManager(db, nil)
+           if err != nil {
+               return err
+           }
            
-           if err := pm.AddDIDs(provider, cleanDIDs, country); err != nil {
+           err = pm.AddDIDs(providerName, cleanDIDs, country)
+           if errors.Is(err, provider.ErrNotApplied) {
+               fmt.Printf("DIDs added to provider %s, but Asterisk was not updated\n", providerName)
+           }
+           if err != nil {
                return err
            }
            
-           fmt.Printf("Added %d DIDs to provider %s\n", len(cleanDIDs), provider)
+           fmt.Printf("Added %d DIDs to provider %s\n", len(cleanDIDs), providerName)
            return nil
        },
    }
@@ -258,8 +902,15 @@ func statsCmd() *cobra.Command {
                return err
            }
            
-           pm := provider.NewManager(db)
-           r := router.NewRouter(db, pm)
+           // Registration states come from AMI
+           ctx, cancel := context.WithCancel(context.Background())
+           defer cancel()
+           
+           pm, err := newManager(db, connectAMI(ctx))
+           if err != nil {
+               return err
+           }
+           r := router.NewRouter(db, pm, routerConfig(cfg))
            
            stats := r.GetStatistics()
            
@@ -277,13 +928,16 @@ func statsCmd() *cobra.Command {
            if providers, ok := stats["providers"].([]map[string]interface{}); ok && len(providers) > 0 {
                fmt.Printf("=== PROVIDER STATISTICS ===\n")
                for _, pStats := range providers {
-                   if p, ok := pStats["provider"].(*models.Provider); ok {
+                   if p, ok := pStats["provider"].(*models.ProviderView); ok {
                        fmt.Printf("\nProvider: %s\n", p.Name)
                        fmt.Printf("  Total DIDs: %d\n", pStats["total_dids"])
                        fmt.Printf("  Used DIDs: %d\n", pStats["used_dids"])
                        fmt.Printf("  Available DIDs: %d\n", pStats["available_dids"])
                        fmt.Printf("  Calls Today: %d\n", pStats["calls_today"])
                        fmt.Printf("  Active Calls: %d\n", pStats["active_calls"])
+                       if reg, ok := pStats["registration"].(provider.RegistrationStatus); ok {
+                           fmt.Printf("  Registration: %s\n", reg.Status)
+                       }
                    }
                }
            }
@@ -292,6 +946,3 @@ func statsCmd() *cobra.Command {
        },
    }
 }
-
-// Add missing imports
-
@@ -0,0 +1,108 @@
+package main
+
+import (
+   "fmt"
+   "log"
+   "reflect"
+   "sync"
+
+   "github.com/spf13/cobra"
+   "github.com/router-production/internal/agi"
+   "github.com/router-production/internal/api"
+   "github.com/router-production/internal/config"
+   "github.com/router-production/internal/provider"
+   "github.com/router-production/internal/router"
+)
+
+// reloader re-reads the configuration and provider state of a running
+// server, on SIGHUP or through the admin API. The reloaded configuration is
+// handed to each component; the package cfg keeps the startup configuration
+// the server was built from.
+type reloader struct {
+   mu         sync.Mutex
+   cmd        *cobra.Command
+   applyFlags func(*config.Config)
+   // cfg is the configuration in effect, guarded by mu
+   cfg    *config.Config
+   pm     *provider.Manager
+   r      *router.Router
+   server *api.Server
+   // agi is nil when the FastAGI server is disabled
+   agi *agi.Server
+}
+
+// Reload applies the configuration file and provider table. Everything that
+// can fail runs first, so a failed reload leaves the running configuration,
+// router and providers as they were.
+func (rl *reloader) Reload() error {
+   rl.mu.Lock()
+   defer rl.mu.Unlock()
+
+   log.Printf("Reloading configuration and providers")
+
+   updated, err := readConfig(rl.cmd)
+   if err != nil {
+       return fmt.Errorf("failed to reload config: %w", err)
+   }
+   rl.applyFlags(updated)
+
+   if err := updated.Validate(); err != nil {
+       return err
+   }
+   f, err := createLogFile(updated)
+   if err != nil {
+       return err
+   }
+
+   // Providers first so routing rules can refer to newly added ones
+   if err := rl.pm.LoadProviders(); err != nil {
+       if f != nil {
+           f.Close()
+       }
+       return fmt.Errorf("failed to reload providers: %w", err)
+   }
+
+   useLogFile(f)
+   warnRestartRequired(rl.cfg, updated)
+   rl.r.UpdateConfig(routerConfig(updated))
+   rl.server.SetIPRateLimit(rateLimit(updated.RateLimits.PerIP))
+   if rl.agi != nil {
+       rl.agi.SetIPRateLimit(rateLimit(updated.RateLimits.PerIP))
+   }
+   rl.cfg = updated
+
+   log.Printf("Reload complete")
+   return nil
+}
+
+// warnRestartRequired logs settings that changed but only take effect on restart.
+func warnRestartRequired(old, updated *config.Config) {
+   if old.Database != updated.Database {
+       log.Printf("Database settings changed, restart required to apply")
+   }
+   if !reflect.DeepEqual(old.API, updated.API) {
+       log.Printf("API settings changed, restart required to apply")
+   }
+   if old.ARI != updated.ARI {
+       log.Printf("ARI settings changed, restart required to apply")
+   }
+   if old.AMI != updated.AMI {
+       log.Printf("AMI settings changed, restart required to apply")
+   }
+   if old.AGI != updated.AGI {
+       log.Printf("AGI settings changed, restart required to apply")
+   }
+   if old.Asterisk.ConfigDir != updated.Asterisk.ConfigDir {
+       log.Printf("Asterisk config_dir changed, restart required to apply")
+   }
+   if old.Asterisk.ReloadBackend != updated.Asterisk.ReloadBackend || old.Asterisk.ValidateCommand != updated.Asterisk.ValidateCommand ||
+       old.Asterisk.TemplateDir != updated.Asterisk.TemplateDir || !reflect.DeepEqual(old.Asterisk.Dialplan, updated.Asterisk.Dialplan) {
+       log.Printf("Asterisk generator settings changed, restart required to apply")
+   }
+   if old.Secrets != updated.Secrets {
+       log.Printf("Secrets settings changed, restart required to apply")
+   }
+   if old.Reload != updated.Reload {
+       log.Printf("Reload settings changed, restart required to apply")
+   }
+}
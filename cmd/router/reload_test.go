@@ -0,0 +1,166 @@
+package main
+
+import (
+   "bytes"
+   "database/sql/driver"
+   "errors"
+   "log"
+   "os"
+   "path/filepath"
+   "strings"
+   "testing"
+   
+   "github.com/spf13/cobra"
+   "github.com/router-production/internal/api"
+   "github.com/router-production/internal/config"
+   "github.com/router-production/internal/database/dbtest"
+   "github.com/router-production/internal/provider"
+   "github.com/router-production/internal/router"
+)
+
+const testConfig = `
+database:
+  password: secret
+asterisk:
+  config_dir: %s
+routing:
+  default_provider: carrier-a
+`
+
+// newTestReloader returns a reloader over a fake database, reading the
+// config file it also returns.
+func newTestReloader(t *testing.T) (*reloader, *dbtest.DB, string) {
+   t.Helper()
+   
+   dir := t.TempDir()
+   path := filepath.Join(dir, "router.yaml")
+   writeConfig(t, path, "")
+   
+   oldPath := configPath
+   configPath = path
+   t.Cleanup(func() { configPath = oldPath })
+   
+   cmd := &cobra.Command{}
+   cmd.Flags().StringVar(&configPath, "config", path, "")
+   cfg, err := readConfig(cmd)
+   if err != nil {
+       t.Fatal(err)
+   }
+   
+   fake, db := dbtest.New()
+   pm, err := provider.NewManager(db, provider.Config{AsteriskConfigDir: dir})
+   if err != nil {
+       t.Fatal(err)
+   }
+   r := router.NewRouter(db, pm, routerConfig(cfg))
+   server, err := api.NewServer(r, pm, api.Config{})
+   if err != nil {
+       t.Fatal(err)
+   }
+   
+   rl := &reloader{cmd: cmd, applyFlags: func(*config.Config) {}, cfg: cfg, pm: pm, r: r, server: server}
+   return rl, fake, path
+}
+
+func writeConfig(t *testing.T, path, extra string) {
+   t.Helper()
+   data := strings.Replace(testConfig, "%s", filepath.Dir(path), 1) + extra
+   if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+       t.Fatal(err)
+   }
+}
+
+func captureLog(t *testing.T) *bytes.Buffer {
+   t.Helper()
+   var buf bytes.Buffer
+   old := log.Writer()
+   log.SetOutput(&buf)
+   t.Cleanup(func() { log.SetOutput(old) })
+   return &buf
+}
+
+func TestReload(t *testing.T) {
+   rl, fake, path := newTestReloader(t)
+   before := len(fake.Statements("FROM providers"))
+   
+   writeConfig(t, path, "  rules:\n    \"1212\": carrier-b\n")
+   if err := rl.Reload(); err != nil {
+       t.Fatalf("Reload: %v", err)
+   }
+   if got := rl.cfg.Routing.Rules["1212"]; got != "carrier-b" {
+       t.Errorf("rules[1212] = %q after reload, want carrier-b", got)
+   }
+   if got := len(fake.Statements("FROM providers")); got != before+1 {
+       t.Errorf("providers loaded %d times during reload, want 1", got-before)
+   }
+}
+
+func TestReloadKeepsConfigOnFailure(t *testing.T) {
+   tests := []struct {
+       name  string
+       extra string
+       db    error
+       want  string
+   }{
+       {name: "invalid config", extra: "timeouts:\n  return_timeout: -1s\n", want: "return_timeout"},
+       {name: "providers fail to load", extra: "  rules:\n    \"1212\": carrier-b\n", db: errors.New("connection refused"), want: "failed to reload providers"},
+   }
+   
+   for _, tt := range tests {
+       t.Run(tt.name, func(t *testing.T) {
+           rl, fake, path := newTestReloader(t)
+           running := rl.cfg
+           if tt.db != nil {
+               fake.Handle("FROM providers", func([]driver.Value) (*dbtest.Result, error) {
+                   return nil, tt.db
+               })
+           }
+           logPath := filepath.Join(filepath.Dir(path), "router.log")
+           writeConfig(t, path, tt.extra+"logging:\n  file: "+logPath+"\n")
+           out := log.Writer()
+           
+           err := rl.Reload()
+           if err == nil || !strings.Contains(err.Error(), tt.want) {
+               t.Fatalf("Reload error = %v, want it to mention %q", err, tt.want)
+           }
+           if rl.cfg != running {
+               t.Error("running configuration replaced by a failed reload")
+           }
+           if log.Writer() != out {
+               t.Error("log output switched by a failed reload")
+           }
+       })
+   }
+}
+
+func TestWarnRestartRequired(t *testing.T) {
+   tests := []struct {
+       name   string
+       change func(*config.Config)
+       want   string
+   }{
+       {name: "unchanged", change: func(*config.Config) {}},
+       {name: "routing only", change: func(c *config.Config) { c.Routing.DefaultProvider = "carrier-b" }},
+       {name: "database", change: func(c *config.Config) { c.Database.Host = "db2" }, want: "Database settings changed"},
+       {name: "secrets key", change: func(c *config.Config) { c.Secrets.Key = "new-key" }, want: "Secrets settings changed"},
+       {name: "secrets key file", change: func(c *config.Config) { c.Secrets.KeyFile = "/etc/router/new.key" }, want: "Secrets settings changed"},
+   }
+   
+   for _, tt := range tests {
+       t.Run(tt.name, func(t *testing.T) {
+           buf := captureLog(t)
+           old := config.Default()
+           updated := config.Default()
+           tt.change(updated)
+           
+           warnRestartRequired(old, updated)
+           got := buf.String()
+           if tt.want == "" && got != "" {
+               t.Errorf("logged %q, want nothing", got)
+           }
+           if tt.want != "" && !strings.Contains(got, tt.want) {
+               t.Errorf("logged %q, want %q", got, tt.want)
+           }
+       })
+   }
+}
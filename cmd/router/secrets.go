@@ -0,0 +1,66 @@
+package main
+
+import (
+   "fmt"
+
+   "github.com/spf13/cobra"
+   "github.com/router-production/internal/secrets"
+)
+
+func secretsCmd() *cobra.Command {
+   cmd := &cobra.Command{
+       Use:   "secrets",
+       Short: "Manage provider password encryption",
+   }
+
+   // Generate key
+   generateCmd := &cobra.Command{
+       Use:   "generate-key",
+       Short: "Generate a new encryption key",
+       Long: `Generate a new encryption key in key file format. To rotate, put the new
+key first in the key file, keep the old keys below it, then run
+"router secrets rotate".`,
+       RunE: func(cmd *cobra.Command, args []string) error {
+           id, _ := cmd.Flags().GetString("id")
+
+           key, err := secrets.GenerateKey(id)
+           if err != nil {
+               return err
+           }
+
+           fmt.Println(key)
+           return nil
+       },
+   }
+
+   generateCmd.Flags().String("id", "", "Key ID (required)")
+   generateCmd.MarkFlagRequired("id")
+
+   // Rotate
+   rotateCmd := &cobra.Command{
+       Use:   "rotate",
+       Short: "Re-encrypt all provider passwords with the primary key",
+       RunE: func(cmd *cobra.Command, args []string) error {
+           db, err := getDB()
+           if err != nil {
+               return err
+           }
+
+           pm, err := newManager(db, nil)
+           if err != nil {
+               return err
+           }
+
+           count, err := pm.RotateSecrets()
+           if err != nil {
+               return err
+           }
+
+           fmt.Printf("Re-encrypted %d provider passwords\n", count)
+           return nil
+       },
+   }
+
+   cmd.AddCommand(generateCmd, rotateCmd)
+   return cmd
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+   "context"
+   "log"
+   "sync"
+   "time"
+)
+
+// drainTarget is a server that finishes its requests in flight on shutdown.
+type drainTarget struct {
+   name   string
+   server interface{ Shutdown(ctx context.Context) error }
+}
+
+// shutdown stops a running server in dependency order. The API and AGI
+// servers stop taking calls and finish the ones in flight, then stop ends
+// the Asterisk connections and background work, which are waited for, and
+// finally the router waits for its call record writes. The database can be
+// closed once it returns.
+func shutdown(timeout time.Duration, servers []drainTarget, stop func(), background *sync.WaitGroup, r interface{ Close() }) {
+   ctx, cancel := context.WithTimeout(context.Background(), timeout)
+   defer cancel()
+   
+   for _, s := range servers {
+       if err := s.server.Shutdown(ctx); err != nil {
+           log.Printf("%s server shutdown: %v", s.name, err)
+       }
+   }
+   
+   stop()
+   background.Wait()
+   
+   r.Close()
+}
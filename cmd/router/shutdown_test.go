@@ -0,0 +1,208 @@
+package main
+
+import (
+   "context"
+   "database/sql/driver"
+   "encoding/json"
+   "errors"
+   "fmt"
+   "net"
+   "net/http"
+   "sync"
+   "testing"
+   "time"
+   
+   "github.com/router-production/internal/api"
+   "github.com/router-production/internal/database/dbtest"
+   "github.com/router-production/internal/models"
+   "github.com/router-production/internal/provider"
+   "github.com/router-production/internal/router"
+)
+
+// events records the order components were stopped in.
+type events struct {
+   mu   sync.Mutex
+   list []string
+}
+
+func (e *events) add(name string) {
+   e.mu.Lock()
+   defer e.mu.Unlock()
+   e.list = append(e.list, name)
+}
+
+func (e *events) get() []string {
+   e.mu.Lock()
+   defer e.mu.Unlock()
+   return append([]string(nil), e.list...)
+}
+
+type fakeServer struct {
+   name   string
+   events *events
+   ctx    context.Context
+}
+
+func (s fakeServer) Shutdown(context.Context) error {
+   if s.ctx.Err() != nil {
+       s.events.add(s.name + " after stop")
+   }
+   s.events.add(s.name)
+   return nil
+}
+
+type fakeCloser struct {
+   events *events
+}
+
+func (c fakeCloser) Close() {
+   c.events.add("router")
+}
+
+func TestShutdownOrder(t *testing.T) {
+   var ev events
+   ctx, stop := context.WithCancel(context.Background())
+   
+   // Stand-ins for the AMI connection and the provider poller
+   var background sync.WaitGroup
+   for _, name := range []string{"ami", "watch"} {
+       name := name
+       background.Add(1)
+       go func() {
+           defer background.Done()
+           <-ctx.Done()
+           // Still using the database while stopping
+           time.Sleep(10 * time.Millisecond)
+           ev.add(name)
+       }()
+   }
+   
+   servers := []drainTarget{
+       {name: "API", server: fakeServer{name: "api", events: &ev, ctx: ctx}},
+       {name: "AGI", server: fakeServer{name: "agi", events: &ev, ctx: ctx}},
+   }
+   shutdown(time.Second, servers, stop, &background, fakeCloser{events: &ev})
+   
+   got := ev.get()
+   if len(got) != 5 {
+       t.Fatalf("stopped %v, want api, agi, ami, watch and router", got)
+   }
+   if got[0] != "api" || got[1] != "agi" {
+       t.Errorf("servers stopped as %v, want api then agi before anything else", got[:2])
+   }
+   if got[4] != "router" {
+       t.Errorf("router closed at position %d of %v, want last", indexOf(got, "router"), got)
+   }
+}
+
+func indexOf(list []string, s string) int {
+   for i, v := range list {
+       if v == s {
+           return i
+       }
+   }
+   return -1
+}
+
+func TestShutdownDrainsActiveCalls(t *testing.T) {
+   fake, db := dbtest.New()
+   fake.Handle("SELECT d.did FROM dids d", func([]driver.Value) (*dbtest.Result, error) {
+       return dbtest.Rows([]string{"did"}, []driver.Value{"5551001"}), nil
+   })
+   fake.Handle("SELECT p.id, p.name FROM providers p", func([]driver.Value) (*dbtest.Result, error) {
+       return dbtest.Rows([]string{"id", "name"}, []driver.Value{int64(1), "carrier"}), nil
+   })
+   
+   // Hold the call record write until the test lets it finish
+   storing := make(chan struct{})
+   release := make(chan struct{})
+   fake.Handle("INSERT INTO call_records", func([]driver.Value) (*dbtest.Result, error) {
+       close(storing)
+       <-release
+       return dbtest.Affected(1), nil
+   })
+   
+   pm, err := provider.NewManager(db, provider.Config{AsteriskConfigDir: t.TempDir()})
+   if err != nil {
+       t.Fatal(err)
+   }
+   ctx, stop := context.WithCancel(context.Background())
+   defer stop()
+   r := router.NewRouter(db, pm, router.Config{
+       ReturnTimeout:   10 * time.Minute,
+       MaxCallDuration: 2 * time.Hour,
+       CleanupInterval: time.Minute,
+   })
+   r.Start(ctx)
+   
+   server, err := api.NewServer(r, pm, api.Config{})
+   if err != nil {
+       t.Fatal(err)
+   }
+   l, err := net.Listen("tcp", "127.0.0.1:0")
+   if err != nil {
+       t.Fatal(err)
+   }
+   served := make(chan error, 1)
+   go func() { served <- server.Serve(l) }()
+   
+   type result struct {
+       resp *models.CallResponse
+       err  error
+   }
+   answered := make(chan result, 1)
+   go func() {
+       url := fmt.Sprintf("http://%s/api/processIncoming?callid=c1&ani=15551111&dnis=15550001", l.Addr())
+       resp, err := http.Get(url)
+       if err != nil {
+           answered <- result{err: err}
+           return
+       }
+       defer resp.Body.Close()
+       var body models.CallResponse
+       if resp.StatusCode != http.StatusOK {
+           err = fmt.Errorf("status %d", resp.StatusCode)
+       } else {
+           err = json.NewDecoder(resp.Body).Decode(&body)
+       }
+       answered <- result{resp: &body, err: err}
+   }()
+   <-storing
+   
+   var background sync.WaitGroup
+   done := make(chan struct{})
+   go func() {
+       shutdown(5*time.Second, []drainTarget{{name: "API", server: server}}, stop, &background, r)
+       close(done)
+   }()
+   
+   select {
+   case <-done:
+       t.Fatal("shutdown returned while a call was being routed")
+   case <-time.After(50 * time.Millisecond):
+   }
+   if ctx.Err() != nil {
+       t.Error("background work stopped while the API was draining")
+   }
+   
+   close(release)
+   got := <-answered
+   if got.err != nil {
+       t.Fatalf("call in flight during shutdown failed: %v", got.err)
+   }
+   if got.resp.DIDAssigned != "5551001" {
+       t.Errorf("call in flight got DID %q, want 5551001", got.resp.DIDAssigned)
+   }
+   
+   select {
+   case <-done:
+   case <-time.After(5 * time.Second):
+       t.Fatal("shutdown did not return after the call finished")
+   }
+   if err := <-served; err != nil {
+       t.Errorf("Serve: %v", err)
+   }
+   if _, err := r.ProcessIncomingCall("c2", "15551111", "15550001"); !errors.Is(err, router.ErrRouterClosed) {
+       t.Errorf("call after shutdown: error = %v, want %v", err, router.ErrRouterClosed)
+   }
+}
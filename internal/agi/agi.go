@@ -0,0 +1,159 @@
+package agi
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "net"
+    "strconv"
+    "strings"
+)
+
+// ErrHangup is returned for commands sent after the channel hung up.
+var ErrHangup = errors.New("channel hung up")
+
+// Session is one FastAGI connection from Asterisk.
+type Session struct {
+    // Env holds the agi_* variables sent when the session starts
+    Env map[string]string
+    
+    conn net.Conn
+    r    *bufio.Reader
+}
+
+// Reply is Asterisk's answer to a command, e.g. "200 result=1 (data)".
+type Reply struct {
+    Code   int
+    Result string
+    Data   string
+}
+
+func newSession(conn net.Conn) (*Session, error) {
+    s := &Session{
+        Env:  make(map[string]string),
+        conn: conn,
+        r:    bufio.NewReader(conn),
+    }
+    
+    // The environment ends with an empty line
+    for {
+        line, err := s.readLine()
+        if err != nil {
+            return nil, fmt.Errorf("failed to read AGI environment: %w", err)
+        }
+        if line == "" {
+            break
+        }
+        
+        key, value, ok := strings.Cut(line, ":")
+        if !ok {
+            return nil, fmt.Errorf("malformed AGI environment line %q", line)
+        }
+        s.Env[strings.TrimSpace(key)] = strings.TrimSpace(value)
+    }
+    return s, nil
+}
+
+// Script returns the requested script, e.g. "incoming" for
+// agi://router:4573/incoming.
+func (s *Session) Script() string {
+    script := strings.TrimPrefix(s.Env["agi_network_script"], "/")
+    script, _, _ = strings.Cut(script, "?")
+    return script
+}
+
+// Arg returns AGI argument n, counting from 1, or "" if it was not given.
+func (s *Session) Arg(n int) string {
+    return s.Env["agi_arg_"+strconv.Itoa(n)]
+}
+
+// Command sends a command and reads its reply.
+func (s *Session) Command(cmd string) (*Reply, error) {
+    if _, err := fmt.Fprintf(s.conn, "%s\n", cmd); err != nil {
+        return nil, err
+    }
+    
+    for {
+        line, err := s.readLine()
+        if err != nil {
+            return nil, err
+        }
+        
+        // Asterisk announces the hangup before answering the command
+        if line == "HANGUP" {
+            continue
+        }
+        
+        reply, err := parseReply(line)
+        if err != nil {
+            return nil, err
+        }
+        
+        // Usage errors span several lines, ending with "520 End of proper usage."
+        if strings.HasPrefix(line, "520-") {
+            for !strings.HasPrefix(line, "520 ") {
+                if line, err = s.readLine(); err != nil {
+                    return nil, err
+                }
+            }
+        }
+        
+        switch {
+        case reply.Code == 511:
+            return reply, ErrHangup
+        case reply.Code != 200:
+            return reply, fmt.Errorf("AGI command %q failed: %s", cmd, line)
+        }
+        return reply, nil
+    }
+}
+
+// SetVariable sets a channel variable.
+func (s *Session) SetVariable(name, value string) error {
+    _, err := s.Command(fmt.Sprintf("SET VARIABLE %s %s", name, quote(value)))
+    return err
+}
+
+// Verbose logs a message on the Asterisk console at the given verbosity.
+func (s *Session) Verbose(msg string, level int) error {
+    _, err := s.Command(fmt.Sprintf("VERBOSE %s %d", quote(msg), level))
+    return err
+}
+
+func (s *Session) readLine() (string, error) {
+    line, err := s.r.ReadString('\n')
+    if err != nil {
+        return "", err
+    }
+    return strings.TrimRight(line, "\r\n"), nil
+}
+
+// parseReply reads a reply line such as "200 result=1 (timeout) endpos=0".
+func parseReply(line string) (*Reply, error) {
+    if len(line) < 3 {
+        return nil, fmt.Errorf("malformed AGI reply %q", line)
+    }
+    code, err := strconv.Atoi(line[:3])
+    if err != nil {
+        return nil, fmt.Errorf("malformed AGI reply %q", line)
+    }
+    
+    reply := &Reply{Code: code}
+    rest := strings.TrimSpace(line[3:])
+    if strings.HasPrefix(rest, "result=") {
+        rest = strings.TrimPrefix(rest, "result=")
+        reply.Result, rest, _ = strings.Cut(rest, " ")
+        if start := strings.Index(rest, "("); start >= 0 {
+            if end := strings.LastIndex(rest, ")"); end > start {
+                reply.Data = rest[start+1 : end]
+            }
+        }
+    }
+    return reply, nil
+}
+
+// quote makes value a single AGI argument.
+func quote(value string) string {
+    value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", " ", "\r", " ").Replace(value)
+    return `"` + value + `"`
+}
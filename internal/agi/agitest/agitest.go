@@ -0,0 +1,161 @@
+// Package agitest plays the Asterisk side of FastAGI sessions so the AGI
+// server can be exercised over a local socket without a PBX.
+package agitest
+
+import (
+    "bufio"
+    "fmt"
+    "net"
+    "sort"
+    "strings"
+    "time"
+)
+
+// Call describes a session to run against the server.
+type Call struct {
+    // Script is the requested path, e.g. "incoming"
+    Script string
+    // Env adds to or overrides the agi_* environment
+    Env map[string]string
+    // Args are passed as agi_arg_1, agi_arg_2, ...
+    Args []string
+    // HangupAfter makes the channel hang up after this many commands;
+    // 0 keeps it up
+    HangupAfter int
+    // Timeout bounds the session, default 5s
+    Timeout time.Duration
+}
+
+// Result is what the server did during the session.
+type Result struct {
+    // Commands are the raw commands received, in order
+    Commands []string
+    // Variables holds the values set with SET VARIABLE
+    Variables map[string]string
+}
+
+// Listen returns a listener on a free local port for the server under test.
+func Listen() (net.Listener, error) {
+    return net.Listen("tcp", "127.0.0.1:0")
+}
+
+// Run connects to the FastAGI server at addr, sends the environment and
+// answers every command with success until the server ends the session.
+func Run(addr string, call Call) (*Result, error) {
+    timeout := call.Timeout
+    if timeout == 0 {
+        timeout = 5 * time.Second
+    }
+    
+    conn, err := net.DialTimeout("tcp", addr, timeout)
+    if err != nil {
+        return nil, err
+    }
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(timeout))
+    
+    if _, err := conn.Write([]byte(environment(addr, call))); err != nil {
+        return nil, err
+    }
+    
+    result := &Result{Variables: make(map[string]string)}
+    r := bufio.NewReader(conn)
+    for {
+        line, err := r.ReadString('\n')
+        if err != nil {
+            // The server closing the connection ends the session
+            if line == "" {
+                return result, nil
+            }
+            return result, err
+        }
+        
+        cmd := strings.TrimRight(line, "\r\n")
+        result.Commands = append(result.Commands, cmd)
+        
+        reply := "200 result=1\n"
+        if call.HangupAfter > 0 && len(result.Commands) > call.HangupAfter {
+            reply = "HANGUP\n511 Command Not Permitted on a dead channel or intercept routine\n"
+        } else if args := splitArgs(cmd); len(args) == 4 &&
+            strings.EqualFold(args[0], "SET") && strings.EqualFold(args[1], "VARIABLE") {
+            result.Variables[args[2]] = args[3]
+        }
+        
+        if _, err := conn.Write([]byte(reply)); err != nil {
+            return result, err
+        }
+    }
+}
+
+// environment builds the agi_* header Asterisk sends for a FastAGI request.
+func environment(addr string, call Call) string {
+    env := map[string]string{
+        "agi_network":        "yes",
+        "agi_network_script": call.Script,
+        "agi_request":        fmt.Sprintf("agi://%s/%s", addr, call.Script),
+        "agi_channel":        "PJSIP/test-00000001",
+        "agi_language":       "en",
+        "agi_type":           "PJSIP",
+        "agi_uniqueid":       fmt.Sprintf("%d.1", time.Now().Unix()),
+        "agi_version":        "18.0.0",
+        "agi_callerid":       "unknown",
+        "agi_calleridname":   "unknown",
+        "agi_dnid":           "unknown",
+        "agi_context":        "default",
+        "agi_extension":      "s",
+        "agi_priority":       "1",
+    }
+    for i, arg := range call.Args {
+        env[fmt.Sprintf("agi_arg_%d", i+1)] = arg
+    }
+    for k, v := range call.Env {
+        env[k] = v
+    }
+    
+    keys := make([]string, 0, len(env))
+    for k := range env {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    
+    var b strings.Builder
+    for _, k := range keys {
+        fmt.Fprintf(&b, "%s: %s\n", k, env[k])
+    }
+    b.WriteString("\n")
+    return b.String()
+}
+
+// splitArgs splits an AGI command line, honouring double quotes and
+// backslash escapes.
+func splitArgs(cmd string) []string {
+    var args []string
+    var cur strings.Builder
+    inQuotes, escaped, started := false, false, false
+    
+    for _, c := range cmd {
+        switch {
+        case escaped:
+            cur.WriteRune(c)
+            escaped = false
+        case c == '\\':
+            escaped = true
+        case c == '"':
+            inQuotes = !inQuotes
+            started = true
+        case c == ' ' && !inQuotes:
+            if started {
+                args = append(args, cur.String())
+                cur.Reset()
+                started = false
+            }
+        default:
+            cur.WriteRune(c)
+            started = true
+        }
+    }
+    if started {
+        args = append(args, cur.String())
+    }
+    return args
+}
@@ -0,0 +1,324 @@
+package agi
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "net"
+    "strconv"
+    "sync"
+    "time"
+    
+    "github.com/router-production/internal/models"
+    "github.com/router-production/internal/netlist"
+    "github.com/router-production/internal/ratelimit"
+    "github.com/router-production/internal/router"
+)
+
+// sessionTimeout bounds a whole AGI session; routing answers in milliseconds
+const sessionTimeout = 30 * time.Second
+
+// Config holds the FastAGI server settings.
+type Config struct {
+    // BindAddress is the address to listen on; empty listens on all
+    BindAddress string
+    Port        int
+    // Allowlist restricts sessions to these CIDRs; empty allows any address
+    Allowlist []string
+    // IPRateLimit limits sessions per client IP
+    IPRateLimit ratelimit.Limit
+}
+
+// Router routes the calls of AGI sessions; *router.Router implements it.
+type Router interface {
+    ProcessIncomingCall(callID, ani, dnis string) (*models.CallResponse, error)
+    ProcessReturnCall(ani2, did string) (*models.CallResponse, error)
+    EndCall(callID string) (*models.CallRecord, error)
+}
+
+// Server answers FastAGI requests from the dialplan:
+//
+//    AGI(agi://router:4573/incoming)   route an incoming call
+//    AGI(agi://router:4573/return)     restore the original ANI/DNIS
+//    AGI(agi://router:4573/hangup)     end the call and release its DID
+//
+// Results are returned as channel variables.
+type Server struct {
+    router    Router
+    addr      string
+    allowlist []*net.IPNet
+    ipLimiter *ratelimit.Limiter
+    handlers  map[string]func(*Session) error
+    
+    mu       sync.Mutex
+    listener net.Listener
+    conns    map[net.Conn]struct{}
+    closed   bool
+    wg       sync.WaitGroup
+}
+
+func NewServer(r Router, cfg Config) (*Server, error) {
+    allowlist, err := netlist.Parse(cfg.Allowlist)
+    if err != nil {
+        return nil, fmt.Errorf("agi allowlist: %w", err)
+    }
+    
+    s := &Server{
+        router:    r,
+        addr:      net.JoinHostPort(cfg.BindAddress, strconv.Itoa(cfg.Port)),
+        allowlist: allowlist,
+        ipLimiter: ratelimit.New(cfg.IPRateLimit),
+        conns:     make(map[net.Conn]struct{}),
+    }
+    
+    s.handlers = map[string]func(*Session) error{
+        "incoming": s.handleIncoming,
+        "return":   s.handleReturn,
+        "hangup":   s.handleHangup,
+    }
+    return s, nil
+}
+
+// SetIPRateLimit changes the per client IP session limit.
+func (s *Server) SetIPRateLimit(limit ratelimit.Limit) {
+    s.ipLimiter.SetLimits(limit, nil)
+}
+
+// Start listens on the configured address and serves until Shutdown is called.
+func (s *Server) Start() error {
+    l, err := net.Listen("tcp", s.addr)
+    if err != nil {
+        return err
+    }
+    log.Printf("[AGI] Server starting on %s", s.addr)
+    return s.Serve(l)
+}
+
+// Serve accepts sessions on l until Shutdown is called.
+func (s *Server) Serve(l net.Listener) error {
+    s.mu.Lock()
+    if s.closed {
+        s.mu.Unlock()
+        l.Close()
+        return nil
+    }
+    s.listener = l
+    s.mu.Unlock()
+    
+    for {
+        conn, err := l.Accept()
+        if err != nil {
+            s.mu.Lock()
+            closed := s.closed
+            s.mu.Unlock()
+            if closed {
+                return nil
+            }
+            return err
+        }
+        if !s.allowed(conn) {
+            conn.Close()
+            continue
+        }
+        
+        s.mu.Lock()
+        s.conns[conn] = struct{}{}
+        s.wg.Add(1)
+        s.mu.Unlock()
+        
+        go func() {
+            defer s.wg.Done()
+            s.serveConn(conn)
+            
+            s.mu.Lock()
+            delete(s.conns, conn)
+            s.mu.Unlock()
+        }()
+    }
+}
+
+// Shutdown stops accepting sessions and waits for running ones to finish,
+// closing them when ctx expires.
+func (s *Server) Shutdown(ctx context.Context) error {
+    log.Printf("[AGI] Server shutting down")
+    
+    s.mu.Lock()
+    s.closed = true
+    if s.listener != nil {
+        s.listener.Close()
+    }
+    s.mu.Unlock()
+    
+    done := make(chan struct{})
+    go func() {
+        s.wg.Wait()
+        close(done)
+    }()
+    
+    select {
+    case <-done:
+        return nil
+    case <-ctx.Done():
+        s.mu.Lock()
+        for conn := range s.conns {
+            conn.Close()
+        }
+        s.mu.Unlock()
+        <-done
+        return ctx.Err()
+    }
+}
+
+// allowed checks the peer address against the allowlist. An empty allowlist
+// allows everyone.
+func (s *Server) allowed(conn net.Conn) bool {
+    if len(s.allowlist) == 0 {
+        return true
+    }
+    if ip := remoteIP(conn); ip != nil && netlist.Contains(s.allowlist, ip) {
+        return true
+    }
+    log.Printf("[AGI] Rejected session from %s: address not allowed", conn.RemoteAddr())
+    return false
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(sessionTimeout))
+    
+    session, err := newSession(conn)
+    if err != nil {
+        log.Printf("[AGI] Session from %s: %v", conn.RemoteAddr(), err)
+        return
+    }
+    
+    // Rate limited sessions still get an answer so the dialplan hangs up
+    // with the right cause instead of treating the router as down
+    addr := conn.RemoteAddr().String()
+    if ip := remoteIP(conn); ip != nil {
+        addr = ip.String()
+    }
+    if ok, wait := s.ipLimiter.Allow(addr); !ok {
+        log.Printf("[AGI] Rate limited %s from %s", session.Script(), addr)
+        setError(session, &router.RateLimitError{Limit: "ip", Key: addr, RetryAfter: wait})
+        return
+    }
+    
+    handler, ok := s.handlers[session.Script()]
+    if !ok {
+        log.Printf("[AGI] Unknown script %q requested by %s", session.Script(), conn.RemoteAddr())
+        session.Verbose(fmt.Sprintf("router: unknown AGI script %q", session.Script()), 1)
+        return
+    }
+    
+    if err := handler(session); err != nil && !errors.Is(err, ErrHangup) {
+        log.Printf("[AGI] %s on %s: %v", session.Script(), session.Env["agi_channel"], err)
+    }
+}
+
+func (s *Server) handleIncoming(session *Session) error {
+    callID := firstOf(session.Arg(1), session.Env["agi_uniqueid"])
+    ani := session.Env["agi_callerid"]
+    dnis := calledNumber(session)
+    
+    resp, err := s.router.ProcessIncomingCall(callID, ani, dnis)
+    if err != nil {
+        log.Printf("[AGI] ProcessIncoming error: %v", err)
+        return setError(session, err)
+    }
+    
+    return setVariables(session, [][2]string{
+        {"ROUTER_STATUS", resp.Status},
+        {"ROUTER_ERROR", ""},
+        {"DID", resp.DIDAssigned},
+        {"NEXT_HOP", resp.NextHop},
+        {"ANI_TO_SEND", resp.ANIToSend},
+        {"DNIS_TO_SEND", resp.DNISToSend},
+        {"PROVIDER_NAME", resp.ProviderName},
+        {"TRUNK_NAME", resp.TrunkName},
+    })
+}
+
+func (s *Server) handleReturn(session *Session) error {
+    ani2 := session.Env["agi_callerid"]
+    did := firstOf(session.Arg(1), calledNumber(session))
+    
+    resp, err := s.router.ProcessReturnCall(ani2, did)
+    if err != nil {
+        log.Printf("[AGI] ProcessReturn error: %v", err)
+        return setError(session, err)
+    }
+    
+    return setVariables(session, [][2]string{
+        {"ROUTER_STATUS", resp.Status},
+        {"ROUTER_ERROR", ""},
+        {"NEXT_HOP", resp.NextHop},
+        {"ANI_TO_SEND", resp.ANIToSend},
+        {"DNIS_TO_SEND", resp.DNISToSend},
+    })
+}
+
+func (s *Server) handleHangup(session *Session) error {
+    callID := firstOf(session.Arg(1), session.Env["agi_uniqueid"])
+    
+    record, err := s.router.EndCall(callID)
+    if err != nil {
+        // Calls that were never routed hang up too
+        if !errors.Is(err, router.ErrCallNotFound) {
+            log.Printf("[AGI] Hangup error: %v", err)
+        }
+        return setError(session, err)
+    }
+    
+    return setVariables(session, [][2]string{
+        {"ROUTER_STATUS", "success"},
+        {"ROUTER_ERROR", ""},
+        {"CALL_STATUS", string(record.Status)},
+    })
+}
+
+// setError reports a routing failure to the dialplan.
+func setError(session *Session, err error) error {
+    return setVariables(session, [][2]string{
+        {"ROUTER_STATUS", "error"},
+        {"ROUTER_ERROR", router.ErrorCode(err)},
+        {"ROUTER_ERROR_MESSAGE", err.Error()},
+    })
+}
+
+func setVariables(session *Session, vars [][2]string) error {
+    for _, v := range vars {
+        if err := session.SetVariable(v[0], v[1]); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// calledNumber returns the dialed number, falling back to the extension
+// when Asterisk has no DNID.
+func calledNumber(session *Session) string {
+    if dnid := session.Env["agi_dnid"]; dnid != "" && dnid != "unknown" {
+        return dnid
+    }
+    return session.Env["agi_extension"]
+}
+
+// remoteIP returns the peer IP of conn, or nil when it has none.
+func remoteIP(conn net.Conn) net.IP {
+    host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+    if err != nil {
+        return nil
+    }
+    return net.ParseIP(host)
+}
+
+func firstOf(values ...string) string {
+    for _, v := range values {
+        if v != "" {
+            return v
+        }
+    }
+    return ""
+}
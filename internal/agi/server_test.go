@@ -0,0 +1,284 @@
+package agi
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+    
+    "github.com/router-production/internal/agi/agitest"
+    "github.com/router-production/internal/models"
+    "github.com/router-production/internal/provider"
+    "github.com/router-production/internal/ratelimit"
+    "github.com/router-production/internal/router"
+)
+
+// fakeRouter records the calls made by the handlers and answers with the
+// configured responses.
+type fakeRouter struct {
+    mu    sync.Mutex
+    calls []string
+    
+    incoming *models.CallResponse
+    ret      *models.CallResponse
+    ended    *models.CallRecord
+    err      error
+}
+
+func (f *fakeRouter) record(format string, args ...interface{}) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.calls = append(f.calls, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeRouter) recorded() []string {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    return append([]string(nil), f.calls...)
+}
+
+func (f *fakeRouter) ProcessIncomingCall(callID, ani, dnis string) (*models.CallResponse, error) {
+    f.record("incoming %s %s %s", callID, ani, dnis)
+    return f.incoming, f.err
+}
+
+func (f *fakeRouter) ProcessReturnCall(ani2, did string) (*models.CallResponse, error) {
+    f.record("return %s %s", ani2, did)
+    return f.ret, f.err
+}
+
+func (f *fakeRouter) EndCall(callID string) (*models.CallRecord, error) {
+    f.record("hangup %s", callID)
+    return f.ended, f.err
+}
+
+// startServer serves cfg on a local port until the test ends and returns
+// its address.
+func startServer(t *testing.T, r Router, cfg Config) string {
+    t.Helper()
+    s, err := NewServer(r, cfg)
+    if err != nil {
+        t.Fatalf("NewServer: %v", err)
+    }
+    l, err := agitest.Listen()
+    if err != nil {
+        t.Fatalf("Listen: %v", err)
+    }
+    go s.Serve(l)
+    t.Cleanup(func() {
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        s.Shutdown(ctx)
+    })
+    return l.Addr().String()
+}
+
+func checkVariables(t *testing.T, got, want map[string]string) {
+    t.Helper()
+    for name, value := range want {
+        if got[name] != value {
+            t.Errorf("%s = %q, want %q", name, got[name], value)
+        }
+    }
+}
+
+func TestServerScripts(t *testing.T) {
+    fake := &fakeRouter{
+        incoming: &models.CallResponse{
+            Status:       "success",
+            DIDAssigned:  "15550001",
+            NextHop:      "trunk-a",
+            ANIToSend:    "15551111",
+            DNISToSend:   "15550001",
+            ProviderName: "carrier-a",
+            TrunkName:    "carrier-a-trunk",
+        },
+        ret: &models.CallResponse{
+            Status:     "success",
+            NextHop:    "s4-trunk",
+            ANIToSend:  "15551111",
+            DNISToSend: "15552222",
+        },
+        ended: &models.CallRecord{Status: models.CallStateCompleted},
+    }
+    addr := startServer(t, fake, Config{})
+    
+    tests := []struct {
+        name     string
+        call     agitest.Call
+        wantCall string
+        wantVars map[string]string
+    }{
+        {
+            name: "incoming",
+            call: agitest.Call{
+                Script: "incoming",
+                Env:    map[string]string{"agi_uniqueid": "1700000000.1", "agi_callerid": "15551111", "agi_dnid": "15552222"},
+            },
+            wantCall: "incoming 1700000000.1 15551111 15552222",
+            wantVars: map[string]string{
+                "ROUTER_STATUS": "success",
+                "ROUTER_ERROR":  "",
+                "DID":           "15550001",
+                "NEXT_HOP":      "trunk-a",
+                "ANI_TO_SEND":   "15551111",
+                "DNIS_TO_SEND":  "15550001",
+                "PROVIDER_NAME": "carrier-a",
+                "TRUNK_NAME":    "carrier-a-trunk",
+            },
+        },
+        {
+            name: "incoming with call ID argument and no DNID",
+            call: agitest.Call{
+                Script: "incoming",
+                Args:   []string{"call-7"},
+                Env:    map[string]string{"agi_callerid": "15551111", "agi_extension": "15552222"},
+            },
+            wantCall: "incoming call-7 15551111 15552222",
+            wantVars: map[string]string{"ROUTER_STATUS": "success", "DID": "15550001"},
+        },
+        {
+            name: "return",
+            call: agitest.Call{
+                Script: "return",
+                Env:    map[string]string{"agi_callerid": "15559999", "agi_dnid": "15550001"},
+            },
+            wantCall: "return 15559999 15550001",
+            wantVars: map[string]string{
+                "ROUTER_STATUS": "success",
+                "ROUTER_ERROR":  "",
+                "NEXT_HOP":      "s4-trunk",
+                "ANI_TO_SEND":   "15551111",
+                "DNIS_TO_SEND":  "15552222",
+            },
+        },
+        {
+            name:     "hangup",
+            call:     agitest.Call{Script: "hangup", Args: []string{"call-7"}},
+            wantCall: "hangup call-7",
+            wantVars: map[string]string{"ROUTER_STATUS": "success", "CALL_STATUS": string(models.CallStateCompleted)},
+        },
+    }
+    
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            before := len(fake.recorded())
+            result, err := agitest.Run(addr, tt.call)
+            if err != nil {
+                t.Fatalf("Run: %v", err)
+            }
+            
+            calls := fake.recorded()[before:]
+            if len(calls) != 1 || calls[0] != tt.wantCall {
+                t.Errorf("router calls = %q, want %q", calls, tt.wantCall)
+            }
+            checkVariables(t, result.Variables, tt.wantVars)
+        })
+    }
+}
+
+func TestServerRouterErrors(t *testing.T) {
+    tests := []struct {
+        script   string
+        err      error
+        wantCode string
+    }{
+        {"incoming", fmt.Errorf("pool empty: %w", provider.ErrNoDIDAvailable), router.CodeNoDIDAvailable},
+        {"incoming", &router.RateLimitError{Limit: "ani", Key: "15551111"}, router.CodeRateLimited},
+        {"return", router.ErrCallNotFound, router.CodeCallNotFound},
+        {"hangup", router.ErrCallNotFound, router.CodeCallNotFound},
+        {"hangup", fmt.Errorf("database down"), router.CodeInternal},
+    }
+    
+    for _, tt := range tests {
+        t.Run(tt.script+" "+tt.wantCode, func(t *testing.T) {
+            addr := startServer(t, &fakeRouter{err: tt.err}, Config{})
+            result, err := agitest.Run(addr, agitest.Call{Script: tt.script, Args: []string{"call-7"}})
+            if err != nil {
+                t.Fatalf("Run: %v", err)
+            }
+            checkVariables(t, result.Variables, map[string]string{
+                "ROUTER_STATUS":        "error",
+                "ROUTER_ERROR":         tt.wantCode,
+                "ROUTER_ERROR_MESSAGE": tt.err.Error(),
+            })
+        })
+    }
+}
+
+func TestServerUnknownScript(t *testing.T) {
+    fake := &fakeRouter{}
+    addr := startServer(t, fake, Config{})
+    
+    result, err := agitest.Run(addr, agitest.Call{Script: "transfer"})
+    if err != nil {
+        t.Fatalf("Run: %v", err)
+    }
+    if len(result.Commands) != 1 || !strings.HasPrefix(result.Commands[0], "VERBOSE") ||
+        !strings.Contains(result.Commands[0], "transfer") {
+        t.Errorf("commands = %q, want one VERBOSE naming the script", result.Commands)
+    }
+    if len(result.Variables) != 0 || len(fake.recorded()) != 0 {
+        t.Errorf("unknown script set %v and called the router %q", result.Variables, fake.recorded())
+    }
+}
+
+func TestServerCallerHangsUp(t *testing.T) {
+    fake := &fakeRouter{ended: &models.CallRecord{Status: models.CallStateCompleted}}
+    addr := startServer(t, fake, Config{})
+    
+    // The channel goes away after the first SET VARIABLE
+    result, err := agitest.Run(addr, agitest.Call{Script: "hangup", Args: []string{"call-7"}, HangupAfter: 1})
+    if err != nil {
+        t.Fatalf("Run: %v", err)
+    }
+    if len(result.Commands) != 2 {
+        t.Errorf("commands = %q, want the session to stop after the hangup", result.Commands)
+    }
+}
+
+func TestServerAllowlist(t *testing.T) {
+    fake := &fakeRouter{}
+    addr := startServer(t, fake, Config{Allowlist: []string{"192.0.2.0/24"}})
+    
+    result, err := agitest.Run(addr, agitest.Call{Script: "hangup", Args: []string{"call-7"}})
+    if err != nil {
+        t.Fatalf("Run: %v", err)
+    }
+    if len(result.Commands) != 0 || len(fake.recorded()) != 0 {
+        t.Errorf("session from a disallowed address got %q and called the router %q", result.Commands, fake.recorded())
+    }
+    
+    if _, err := NewServer(fake, Config{Allowlist: []string{"10.0.0.0/33"}}); err == nil {
+        t.Errorf("NewServer accepted an invalid allowlist")
+    }
+}
+
+func TestServerIPRateLimit(t *testing.T) {
+    fake := &fakeRouter{ended: &models.CallRecord{Status: models.CallStateCompleted}}
+    addr := startServer(t, fake, Config{
+        Allowlist:   []string{"127.0.0.1"},
+        IPRateLimit: ratelimit.Limit{Rate: 0.001, Burst: 1},
+    })
+    
+    call := agitest.Call{Script: "hangup", Args: []string{"call-7"}}
+    first, err := agitest.Run(addr, call)
+    if err != nil {
+        t.Fatalf("Run: %v", err)
+    }
+    checkVariables(t, first.Variables, map[string]string{"ROUTER_STATUS": "success"})
+    
+    second, err := agitest.Run(addr, call)
+    if err != nil {
+        t.Fatalf("Run: %v", err)
+    }
+    checkVariables(t, second.Variables, map[string]string{
+        "ROUTER_STATUS": "error",
+        "ROUTER_ERROR":  router.CodeRateLimited,
+    })
+    if calls := fake.recorded(); len(calls) != 1 {
+        t.Errorf("router calls = %q, want only the first session", calls)
+    }
+}
@@ -0,0 +1,188 @@
+// Package amitest provides a fake Asterisk Manager Interface server for
+// exercising the AMI client and event tracking without a PBX.
+package amitest
+
+import (
+    "bufio"
+    "net"
+    "sync"
+    "time"
+    
+    "github.com/router-production/internal/ami"
+)
+
+// HandlerFunc answers an action. The first message is the response, any
+// others are sent after it (e.g. list events); ActionID is filled in.
+type HandlerFunc func(action ami.Message) []ami.Message
+
+// Server is a fake AMI listening on a local port.
+type Server struct {
+    username string
+    secret   string
+    listener net.Listener
+    
+    mu       sync.Mutex
+    conns    map[net.Conn]bool // value: logged in
+    actions  []ami.Message
+    handlers map[string]HandlerFunc
+    logins   int
+}
+
+// NewServer starts a fake AMI accepting the given credentials.
+func NewServer(username, secret string) (*Server, error) {
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        return nil, err
+    }
+    
+    s := &Server{
+        username: username,
+        secret:   secret,
+        listener: l,
+        conns:    make(map[net.Conn]bool),
+        handlers: make(map[string]HandlerFunc),
+    }
+    go s.accept()
+    return s, nil
+}
+
+// Addr is the host:port to connect the client to.
+func (s *Server) Addr() string {
+    return s.listener.Addr().String()
+}
+
+// Handle sets the answer to an action, replacing the default error reply.
+func (s *Server) Handle(action string, fn HandlerFunc) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.handlers[action] = fn
+}
+
+// Actions returns every action received so far, in order.
+func (s *Server) Actions() []ami.Message {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return append([]ami.Message(nil), s.actions...)
+}
+
+// Logins returns the number of successful logins, which grows with every
+// reconnect.
+func (s *Server) Logins() int {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.logins
+}
+
+// WaitForLogins waits until at least n logins succeeded.
+func (s *Server) WaitForLogins(n int, timeout time.Duration) bool {
+    deadline := time.Now().Add(timeout)
+    for time.Now().Before(deadline) {
+        if s.Logins() >= n {
+            return true
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    return false
+}
+
+// Emit sends an event to every logged in client.
+func (s *Server) Emit(event ami.Message) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    
+    for conn, loggedIn := range s.conns {
+        if loggedIn {
+            conn.Write([]byte(event.Encode()))
+        }
+    }
+}
+
+// DropConnections closes all client connections, as an Asterisk restart would.
+func (s *Server) DropConnections() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    
+    for conn := range s.conns {
+        conn.Close()
+        delete(s.conns, conn)
+    }
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+    s.listener.Close()
+    s.DropConnections()
+}
+
+func (s *Server) accept() {
+    for {
+        conn, err := s.listener.Accept()
+        if err != nil {
+            return
+        }
+        
+        s.mu.Lock()
+        s.conns[conn] = false
+        s.mu.Unlock()
+        
+        go s.serve(conn)
+    }
+}
+
+func (s *Server) serve(conn net.Conn) {
+    defer func() {
+        conn.Close()
+        s.mu.Lock()
+        delete(s.conns, conn)
+        s.mu.Unlock()
+    }()
+    
+    conn.Write([]byte("Asterisk Call Manager/9.0.0\r\n"))
+    
+    r := bufio.NewReader(conn)
+    for {
+        action, err := ami.ReadMessage(r)
+        if err != nil {
+            return
+        }
+        
+        s.mu.Lock()
+        s.actions = append(s.actions, action)
+        replies := s.reply(conn, action)
+        for _, reply := range replies {
+            if id := action.Get("ActionID"); id != "" {
+                reply["ActionID"] = id
+            }
+            conn.Write([]byte(reply.Encode()))
+        }
+        s.mu.Unlock()
+        
+        if action.Get("Action") == "Logoff" {
+            return
+        }
+    }
+}
+
+// reply builds the answer to an action. Must be called with s.mu held.
+func (s *Server) reply(conn net.Conn, action ami.Message) []ami.Message {
+    name := action.Get("Action")
+    switch name {
+    case "Login":
+        if action.Get("Username") != s.username || action.Get("Secret") != s.secret {
+            return []ami.Message{{"Response": "Error", "Message": "Authentication failed"}}
+        }
+        s.conns[conn] = true
+        s.logins++
+        return []ami.Message{{"Response": "Success", "Message": "Authentication accepted"}}
+    case "Logoff":
+        return []ami.Message{{"Response": "Goodbye", "Message": "Thanks for all the fish."}}
+    }
+    
+    if !s.conns[conn] {
+        return []ami.Message{{"Response": "Error", "Message": "Permission denied"}}
+    }
+    if fn, ok := s.handlers[name]; ok {
+        return fn(action)
+    }
+    return []ami.Message{{"Response": "Error", "Message": "Invalid/unknown command"}}
+}
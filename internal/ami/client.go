@@ -0,0 +1,364 @@
+package ami
+
+import (
+    "bufio"
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "net"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+var (
+    // ErrNotConnected is returned for actions sent while the client is
+    // disconnected from Asterisk.
+    ErrNotConnected = errors.New("not connected to AMI")
+    // ErrLoginFailed is returned when Asterisk rejects the credentials.
+    ErrLoginFailed = errors.New("AMI login failed")
+)
+
+const (
+    defaultMinBackoff    = time.Second
+    defaultMaxBackoff    = 30 * time.Second
+    defaultActionTimeout = 10 * time.Second
+    
+    // Buffered events; the reader blocks when handlers fall this far behind
+    eventQueueSize = 1024
+)
+
+// Config holds the AMI connection settings.
+type Config struct {
+    // Address is the host:port of the manager interface
+    Address  string
+    Username string
+    Secret   string
+    // Events is the event mask requested at login, default "call"
+    Events string
+    // MinBackoff and MaxBackoff bound the delay between reconnects
+    MinBackoff time.Duration
+    MaxBackoff time.Duration
+    // ActionTimeout bounds the wait for a response, default 10s
+    ActionTimeout time.Duration
+}
+
+// Response is the reply to an action. Actions that return a list, such as
+// PJSIPShowRegistrationsOutbound, also carry the listed events.
+type Response struct {
+    Message
+    Events []Message
+}
+
+type pendingAction struct {
+    resp *Response
+    done chan struct{}
+    err  error
+}
+
+// Client keeps a logged in AMI session, reconnecting with backoff, and
+// dispatches events to the registered handlers.
+type Client struct {
+    cfg Config
+    
+    mu       sync.Mutex
+    conn     net.Conn
+    ready    bool
+    pending  map[string]*pendingAction
+    nextID   uint64
+    handlers []func(Message)
+    
+    events chan Message
+}
+
+func NewClient(cfg Config) *Client {
+    if cfg.Events == "" {
+        cfg.Events = "call"
+    }
+    if cfg.MinBackoff <= 0 {
+        cfg.MinBackoff = defaultMinBackoff
+    }
+    if cfg.MaxBackoff < cfg.MinBackoff {
+        cfg.MaxBackoff = defaultMaxBackoff
+    }
+    if cfg.ActionTimeout <= 0 {
+        cfg.ActionTimeout = defaultActionTimeout
+    }
+    
+    return &Client{
+        cfg:     cfg,
+        pending: make(map[string]*pendingAction),
+        events:  make(chan Message, eventQueueSize),
+    }
+}
+
+// OnEvent registers a handler for every event received. Handlers run one
+// at a time, in order, on a single goroutine. Register them before Run.
+func (c *Client) OnEvent(fn func(Message)) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.handlers = append(c.handlers, fn)
+}
+
+// Connected reports whether the client is logged in.
+func (c *Client) Connected() bool {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.ready
+}
+
+// WaitConnected blocks until the client is logged in or ctx is done.
+func (c *Client) WaitConnected(ctx context.Context) error {
+    ticker := time.NewTicker(50 * time.Millisecond)
+    defer ticker.Stop()
+    
+    for !c.Connected() {
+        select {
+        case <-ctx.Done():
+            return fmt.Errorf("%w: %v", ErrNotConnected, ctx.Err())
+        case <-ticker.C:
+        }
+    }
+    return nil
+}
+
+// Run connects and keeps reconnecting until ctx is cancelled.
+func (c *Client) Run(ctx context.Context) {
+    dispatchDone := make(chan struct{})
+    go func() {
+        defer close(dispatchDone)
+        c.dispatch(ctx)
+    }()
+    
+    backoff := c.cfg.MinBackoff
+    for {
+        err := c.session(ctx, func() {
+            // Logged in, start over with a short delay next time
+            backoff = c.cfg.MinBackoff
+        })
+        if ctx.Err() != nil {
+            break
+        }
+        
+        log.Printf("[AMI] Disconnected from %s: %v, reconnecting in %s", c.cfg.Address, err, backoff)
+        select {
+        case <-ctx.Done():
+        case <-time.After(backoff):
+        }
+        if ctx.Err() != nil {
+            break
+        }
+        
+        backoff *= 2
+        if backoff > c.cfg.MaxBackoff {
+            backoff = c.cfg.MaxBackoff
+        }
+    }
+    
+    <-dispatchDone
+    log.Printf("[AMI] Stopped")
+}
+
+// session runs one connection until it fails or ctx is cancelled.
+func (c *Client) session(ctx context.Context, loggedIn func()) error {
+    dialer := net.Dialer{Timeout: c.cfg.ActionTimeout}
+    conn, err := dialer.DialContext(ctx, "tcp", c.cfg.Address)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+    
+    r := bufio.NewReader(conn)
+    
+    // Asterisk greets with "Asterisk Call Manager/x.y.z"
+    conn.SetReadDeadline(time.Now().Add(c.cfg.ActionTimeout))
+    banner, err := r.ReadString('\n')
+    if err != nil {
+        return fmt.Errorf("failed to read banner: %w", err)
+    }
+    if !strings.HasPrefix(banner, "Asterisk Call Manager") {
+        return fmt.Errorf("unexpected banner %q", strings.TrimSpace(banner))
+    }
+    conn.SetReadDeadline(time.Time{})
+    
+    readErr := make(chan error, 1)
+    go func() {
+        readErr <- c.readLoop(ctx, r)
+    }()
+    
+    c.mu.Lock()
+    c.conn = conn
+    c.mu.Unlock()
+    
+    defer func() {
+        c.mu.Lock()
+        c.conn = nil
+        c.ready = false
+        for id, p := range c.pending {
+            p.err = ErrNotConnected
+            close(p.done)
+            delete(c.pending, id)
+        }
+        c.mu.Unlock()
+    }()
+    
+    resp, err := c.Action(ctx, Message{
+        "Action":   "Login",
+        "Username": c.cfg.Username,
+        "Secret":   c.cfg.Secret,
+        "Events":   c.cfg.Events,
+    })
+    if err != nil {
+        return err
+    }
+    if !resp.IsSuccess() {
+        return fmt.Errorf("%w: %s", ErrLoginFailed, resp.Get("Message"))
+    }
+    
+    c.mu.Lock()
+    c.ready = true
+    c.mu.Unlock()
+    
+    log.Printf("[AMI] Logged in to %s as %s", c.cfg.Address, c.cfg.Username)
+    loggedIn()
+    
+    select {
+    case <-ctx.Done():
+        c.send(Message{"Action": "Logoff"})
+        return ctx.Err()
+    case err := <-readErr:
+        return err
+    }
+}
+
+// readLoop routes responses to their actions and queues events.
+func (c *Client) readLoop(ctx context.Context, r *bufio.Reader) error {
+    for {
+        msg, err := ReadMessage(r)
+        if err != nil {
+            return err
+        }
+        
+        if id := msg.Get("ActionID"); id != "" && c.deliver(id, msg) {
+            continue
+        }
+        if msg.Event() != "" {
+            select {
+            case c.events <- msg:
+            case <-ctx.Done():
+                return ctx.Err()
+            }
+        }
+    }
+}
+
+// deliver hands msg to the action waiting for it. It returns false when no
+// action is waiting.
+func (c *Client) deliver(id string, msg Message) bool {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    
+    p, ok := c.pending[id]
+    if !ok {
+        return false
+    }
+    
+    if msg.Event() == "" {
+        p.resp.Message = msg
+        // List actions answer with "EventList: start" and follow up with events
+        if !strings.EqualFold(msg.Get("EventList"), "start") {
+            delete(c.pending, id)
+            close(p.done)
+        }
+        return true
+    }
+    
+    if strings.EqualFold(msg.Get("EventList"), "Complete") {
+        delete(c.pending, id)
+        close(p.done)
+        return true
+    }
+    p.resp.Events = append(p.resp.Events, msg)
+    return true
+}
+
+func (c *Client) dispatch(ctx context.Context) {
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case msg := <-c.events:
+            c.mu.Lock()
+            handlers := c.handlers
+            c.mu.Unlock()
+            
+            for _, h := range handlers {
+                h(msg)
+            }
+        }
+    }
+}
+
+// Action sends an action and waits for its response, and for the events of
+// list actions.
+func (c *Client) Action(ctx context.Context, action Message) (*Response, error) {
+    c.mu.Lock()
+    if c.conn == nil || (!c.ready && action["Action"] != "Login") {
+        c.mu.Unlock()
+        return nil, ErrNotConnected
+    }
+    c.nextID++
+    id := "router-" + strconv.FormatUint(c.nextID, 10)
+    p := &pendingAction{resp: &Response{}, done: make(chan struct{})}
+    c.pending[id] = p
+    c.mu.Unlock()
+    
+    msg := make(Message, len(action)+1)
+    for k, v := range action {
+        msg[k] = v
+    }
+    msg["ActionID"] = id
+    
+    if err := c.send(msg); err != nil {
+        c.forget(id)
+        return nil, err
+    }
+    
+    timer := time.NewTimer(c.cfg.ActionTimeout)
+    defer timer.Stop()
+    
+    select {
+    case <-p.done:
+        if p.err != nil {
+            return nil, p.err
+        }
+        return p.resp, nil
+    case <-ctx.Done():
+        c.forget(id)
+        return nil, ctx.Err()
+    case <-timer.C:
+        c.forget(id)
+        return nil, fmt.Errorf("AMI action %s timed out", action["Action"])
+    }
+}
+
+func (c *Client) forget(id string) {
+    c.mu.Lock()
+    delete(c.pending, id)
+    c.mu.Unlock()
+}
+
+func (c *Client) send(msg Message) error {
+    c.mu.Lock()
+    conn := c.conn
+    c.mu.Unlock()
+    if conn == nil {
+        return ErrNotConnected
+    }
+    
+    conn.SetWriteDeadline(time.Now().Add(c.cfg.ActionTimeout))
+    _, err := conn.Write([]byte(msg.Encode()))
+    return err
+}
@@ -0,0 +1,175 @@
+package ami_test
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "testing"
+    "time"
+    
+    "github.com/router-production/internal/ami"
+    "github.com/router-production/internal/ami/amitest"
+)
+
+func newServer(t *testing.T) *amitest.Server {
+    t.Helper()
+    srv, err := amitest.NewServer("router", "secret")
+    if err != nil {
+        t.Fatalf("NewServer: %v", err)
+    }
+    t.Cleanup(srv.Close)
+    return srv
+}
+
+// runClient runs a new client until the test ends.
+func runClient(t *testing.T, cfg ami.Config) *ami.Client {
+    t.Helper()
+    c := ami.NewClient(cfg)
+    t.Cleanup(runStarted(t, c))
+    return c
+}
+
+// runStarted runs c and returns a function stopping it.
+func runStarted(t *testing.T, c *ami.Client) func() {
+    t.Helper()
+    ctx, cancel := context.WithCancel(context.Background())
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        c.Run(ctx)
+    }()
+    return func() {
+        cancel()
+        <-done
+    }
+}
+
+// countActions returns how many of the actions received are named name.
+func countActions(srv *amitest.Server, name string) int {
+    n := 0
+    for _, a := range srv.Actions() {
+        if a.Get("Action") == name {
+            n++
+        }
+    }
+    return n
+}
+
+func waitConnected(t *testing.T, c *ami.Client) {
+    t.Helper()
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    if err := c.WaitConnected(ctx); err != nil {
+        t.Fatalf("WaitConnected: %v", err)
+    }
+}
+
+func TestClientLogin(t *testing.T) {
+    srv := newServer(t)
+    c := runClient(t, ami.Config{Address: srv.Addr(), Username: "router", Secret: "secret"})
+    waitConnected(t, c)
+    
+    login := srv.Actions()[0]
+    if login.Get("Action") != "Login" || login.Get("Username") != "router" || login.Get("Events") != "call" {
+        t.Errorf("first action = %v, want a login asking for call events", login)
+    }
+    
+    srv.Handle("Ping", func(ami.Message) []ami.Message {
+        return []ami.Message{{"Response": "Success", "Ping": "Pong"}}
+    })
+    resp, err := c.Action(context.Background(), ami.Message{"Action": "Ping"})
+    if err != nil || resp.Get("Ping") != "Pong" {
+        t.Errorf("Ping = %v, %v", resp, err)
+    }
+}
+
+func TestClientLoginFailure(t *testing.T) {
+    srv := newServer(t)
+    c := runClient(t, ami.Config{
+        Address:    srv.Addr(),
+        Username:   "router",
+        Secret:     "wrong",
+        MinBackoff: 10 * time.Millisecond,
+        MaxBackoff: 20 * time.Millisecond,
+    })
+    
+    ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+    defer cancel()
+    if err := c.WaitConnected(ctx); !errors.Is(err, ami.ErrNotConnected) {
+        t.Fatalf("WaitConnected = %v, want ErrNotConnected", err)
+    }
+    if srv.Logins() != 0 {
+        t.Errorf("%d logins succeeded with a wrong secret", srv.Logins())
+    }
+    // Rejected logins are retried
+    if n := countActions(srv, "Login"); n < 2 {
+        t.Errorf("%d login attempts, want retries", n)
+    }
+    if _, err := c.Action(context.Background(), ami.Message{"Action": "Ping"}); !errors.Is(err, ami.ErrNotConnected) {
+        t.Errorf("Action before login = %v, want ErrNotConnected", err)
+    }
+}
+
+func TestClientReconnects(t *testing.T) {
+    srv := newServer(t)
+    c := runClient(t, ami.Config{
+        Address:    srv.Addr(),
+        Username:   "router",
+        Secret:     "secret",
+        MinBackoff: 10 * time.Millisecond,
+    })
+    waitConnected(t, c)
+    
+    srv.DropConnections()
+    if !srv.WaitForLogins(2, 5*time.Second) {
+        t.Fatalf("client did not log in again after the connection dropped")
+    }
+    waitConnected(t, c)
+}
+
+func TestClientBackoffGrows(t *testing.T) {
+    srv := newServer(t)
+    runClient(t, ami.Config{
+        Address:    srv.Addr(),
+        Username:   "router",
+        Secret:     "wrong",
+        MinBackoff: 50 * time.Millisecond,
+        MaxBackoff: 200 * time.Millisecond,
+    })
+    
+    // Delays of 50, 100, 200, 200ms put about 6 attempts in 900ms; a
+    // constant 50ms would give 18
+    time.Sleep(900 * time.Millisecond)
+    if n := countActions(srv, "Login"); n < 3 || n > 10 {
+        t.Errorf("%d login attempts in 900ms, want the delay to double up to the maximum", n)
+    }
+}
+
+func TestClientActionTimeout(t *testing.T) {
+    srv := newServer(t)
+    srv.Handle("Command", func(ami.Message) []ami.Message { return nil })
+    c := runClient(t, ami.Config{
+        Address:       srv.Addr(),
+        Username:      "router",
+        Secret:        "secret",
+        ActionTimeout: 100 * time.Millisecond,
+    })
+    waitConnected(t, c)
+    
+    start := time.Now()
+    _, err := c.Action(context.Background(), ami.Message{"Action": "Command", "Command": "dialplan reload"})
+    if err == nil || !strings.Contains(err.Error(), "timed out") {
+        t.Fatalf("Action = %v, want a timeout", err)
+    }
+    if elapsed := time.Since(start); elapsed > 2*time.Second {
+        t.Errorf("timeout took %s", elapsed)
+    }
+    
+    // The session survives an unanswered action
+    srv.Handle("Ping", func(ami.Message) []ami.Message {
+        return []ami.Message{{"Response": "Success"}}
+    })
+    if _, err := c.Action(context.Background(), ami.Message{"Action": "Ping"}); err != nil {
+        t.Errorf("Action after a timeout: %v", err)
+    }
+}
@@ -0,0 +1,97 @@
+package ami
+
+import (
+    "bufio"
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// Message is an AMI action, response or event: a set of "Key: Value"
+// headers terminated by an empty line.
+type Message map[string]string
+
+// Event returns the event name, or "" for responses.
+func (m Message) Event() string {
+    return m["Event"]
+}
+
+// Get returns a header, matching the key case-insensitively.
+func (m Message) Get(key string) string {
+    if v, ok := m[key]; ok {
+        return v
+    }
+    for k, v := range m {
+        if strings.EqualFold(k, key) {
+            return v
+        }
+    }
+    return ""
+}
+
+// IsSuccess reports whether a response reports success.
+func (m Message) IsSuccess() bool {
+    return strings.EqualFold(m.Get("Response"), "Success")
+}
+
+// Encode writes the message with Action (or Response/Event) first.
+func (m Message) Encode() string {
+    var b strings.Builder
+    
+    first := []string{"Action", "Response", "Event"}
+    for _, k := range first {
+        if v, ok := m[k]; ok {
+            fmt.Fprintf(&b, "%s: %s\r\n", k, v)
+        }
+    }
+    
+    keys := make([]string, 0, len(m))
+    for k := range m {
+        if k != "Action" && k != "Response" && k != "Event" {
+            keys = append(keys, k)
+        }
+    }
+    sort.Strings(keys)
+    for _, k := range keys {
+        fmt.Fprintf(&b, "%s: %s\r\n", k, m[k])
+    }
+    
+    b.WriteString("\r\n")
+    return b.String()
+}
+
+// ReadMessage reads one message. Lines without a colon, such as command
+// output in older Asterisk versions, are kept under the "Output" key.
+func ReadMessage(r *bufio.Reader) (Message, error) {
+    m := make(Message)
+    for {
+        line, err := r.ReadString('\n')
+        if err != nil {
+            return nil, err
+        }
+        line = strings.TrimRight(line, "\r\n")
+        
+        if line == "" {
+            if len(m) == 0 {
+                continue
+            }
+            return m, nil
+        }
+        
+        key, value, ok := strings.Cut(line, ":")
+        if !ok {
+            m.append("Output", line)
+            continue
+        }
+        m.append(strings.TrimSpace(key), strings.TrimSpace(value))
+    }
+}
+
+// append adds a header, joining repeated keys with newlines.
+func (m Message) append(key, value string) {
+    if existing, ok := m[key]; ok {
+        m[key] = existing + "\n" + value
+        return
+    }
+    m[key] = value
+}
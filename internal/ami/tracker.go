@@ -0,0 +1,100 @@
+package ami
+
+import (
+    "log"
+    "sync"
+    
+    "github.com/router-production/internal/models"
+)
+
+// Calls is the call state driven by the tracker; *router.Router implements it.
+type Calls interface {
+    TracksCall(callID string) bool
+    MarkForwarded(callID string) error
+    EndCall(callID string) (*models.CallRecord, error)
+}
+
+// Tracker follows channel events and updates the calls they belong to. A
+// channel belongs to a call when its Uniqueid or Linkedid is the call ID,
+// which holds when the dialplan routes with callid=${UNIQUEID} (the AGI
+// server's default).
+type Tracker struct {
+    calls Calls
+    
+    mu     sync.Mutex
+    linked map[string]string // Uniqueid -> Linkedid of live channels
+}
+
+func NewTracker(calls Calls) *Tracker {
+    return &Tracker{
+        calls:  calls,
+        linked: make(map[string]string),
+    }
+}
+
+// HandleEvent processes one AMI event; register it with Client.OnEvent.
+func (t *Tracker) HandleEvent(msg Message) {
+    switch msg.Event() {
+    case "Newchannel":
+        t.mu.Lock()
+        t.linked[msg.Get("Uniqueid")] = msg.Get("Linkedid")
+        t.mu.Unlock()
+        
+    case "DialEnd":
+        callID := t.callFor(msg)
+        if callID == "" {
+            return
+        }
+        if status := msg.Get("DialStatus"); status != "ANSWER" {
+            log.Printf("[AMI] Call %s dial ended with %s", callID, status)
+            return
+        }
+        t.forwarded(callID)
+        
+    case "BridgeEnter":
+        if callID := t.callFor(msg); callID != "" {
+            t.forwarded(callID)
+        }
+        
+    case "Hangup":
+        uniqueID := msg.Get("Uniqueid")
+        t.mu.Lock()
+        delete(t.linked, uniqueID)
+        t.mu.Unlock()
+        
+        // Only the hangup of the incoming leg ends the call
+        if !t.calls.TracksCall(uniqueID) {
+            return
+        }
+        record, err := t.calls.EndCall(uniqueID)
+        if err != nil {
+            log.Printf("[AMI] Failed to end call %s: %v", uniqueID, err)
+            return
+        }
+        log.Printf("[AMI] Call %s hung up (%s), %s", uniqueID, msg.Get("Cause-txt"), record.Status)
+    }
+}
+
+// callFor returns the tracked call an event's channel belongs to, or "".
+func (t *Tracker) callFor(msg Message) string {
+    uniqueID := msg.Get("Uniqueid")
+    linkedID := msg.Get("Linkedid")
+    if linkedID == "" {
+        t.mu.Lock()
+        linkedID = t.linked[uniqueID]
+        t.mu.Unlock()
+    }
+    
+    for _, id := range []string{uniqueID, linkedID} {
+        if id != "" && t.calls.TracksCall(id) {
+            return id
+        }
+    }
+    return ""
+}
+
+func (t *Tracker) forwarded(callID string) {
+    if err := t.calls.MarkForwarded(callID); err != nil {
+        log.Printf("[AMI] Failed to mark call %s forwarded: %v", callID, err)
+    }
+}
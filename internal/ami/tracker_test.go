@@ -0,0 +1,165 @@
+package ami_test
+
+import (
+    "sync"
+    "testing"
+    "time"
+    
+    "github.com/router-production/internal/ami"
+    "github.com/router-production/internal/models"
+)
+
+// fakeCalls tracks the given call IDs and records what the tracker did.
+type fakeCalls struct {
+    mu        sync.Mutex
+    tracked   map[string]bool
+    forwarded []string
+    ended     []string
+}
+
+func newFakeCalls(ids ...string) *fakeCalls {
+    f := &fakeCalls{tracked: make(map[string]bool)}
+    for _, id := range ids {
+        f.tracked[id] = true
+    }
+    return f
+}
+
+func (f *fakeCalls) TracksCall(callID string) bool {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    return f.tracked[callID]
+}
+
+func (f *fakeCalls) MarkForwarded(callID string) error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.forwarded = append(f.forwarded, callID)
+    return nil
+}
+
+func (f *fakeCalls) EndCall(callID string) (*models.CallRecord, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.ended = append(f.ended, callID)
+    delete(f.tracked, callID)
+    return &models.CallRecord{CallID: callID, Status: models.CallStateCompleted}, nil
+}
+
+func (f *fakeCalls) results() (forwarded, ended []string) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    return append([]string(nil), f.forwarded...), append([]string(nil), f.ended...)
+}
+
+func TestTrackerThroughClient(t *testing.T) {
+    srv := newServer(t)
+    calls := newFakeCalls("1700000000.1")
+    tracker := ami.NewTracker(calls)
+    
+    c := ami.NewClient(ami.Config{Address: srv.Addr(), Username: "router", Secret: "secret"})
+    c.OnEvent(tracker.HandleEvent)
+    stop := runStarted(t, c)
+    defer stop()
+    waitConnected(t, c)
+    
+    srv.Emit(ami.Message{"Event": "Newchannel", "Uniqueid": "1700000000.1", "Linkedid": "1700000000.1"})
+    srv.Emit(ami.Message{"Event": "Newchannel", "Uniqueid": "1700000000.2", "Linkedid": "1700000000.1"})
+    srv.Emit(ami.Message{"Event": "DialEnd", "Uniqueid": "1700000000.1", "DialStatus": "ANSWER"})
+    srv.Emit(ami.Message{"Event": "Hangup", "Uniqueid": "1700000000.2", "Cause-txt": "Normal Clearing"})
+    srv.Emit(ami.Message{"Event": "Hangup", "Uniqueid": "1700000000.1", "Cause-txt": "Normal Clearing"})
+    
+    deadline := time.Now().Add(5 * time.Second)
+    for {
+        forwarded, ended := calls.results()
+        if len(ended) > 0 {
+            if len(forwarded) != 1 || forwarded[0] != "1700000000.1" {
+                t.Errorf("forwarded = %q, want the call once", forwarded)
+            }
+            // The outbound leg hanging up does not end the call
+            if len(ended) != 1 || ended[0] != "1700000000.1" {
+                t.Errorf("ended = %q, want only the incoming leg", ended)
+            }
+            return
+        }
+        if time.Now().After(deadline) {
+            t.Fatalf("call never ended, forwarded %q", forwarded)
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+func TestTrackerEvents(t *testing.T) {
+    const callID = "1700000000.1"
+    
+    tests := []struct {
+        name          string
+        events        []ami.Message
+        wantForwarded []string
+        wantEnded     []string
+    }{
+        {
+            name:          "answered dial",
+            events:        []ami.Message{{"Event": "DialEnd", "Uniqueid": callID, "DialStatus": "ANSWER"}},
+            wantForwarded: []string{callID},
+        },
+        {
+            name:   "busy dial",
+            events: []ami.Message{{"Event": "DialEnd", "Uniqueid": callID, "DialStatus": "BUSY"}},
+        },
+        {
+            name: "outbound leg found through Newchannel",
+            events: []ami.Message{
+                {"Event": "Newchannel", "Uniqueid": "1700000000.2", "Linkedid": callID},
+                {"Event": "BridgeEnter", "Uniqueid": "1700000000.2"},
+            },
+            wantForwarded: []string{callID},
+        },
+        {
+            name:   "untracked channel",
+            events: []ami.Message{{"Event": "DialEnd", "Uniqueid": "other", "DialStatus": "ANSWER"}, {"Event": "Hangup", "Uniqueid": "other"}},
+        },
+        {
+            name:      "hangup of the incoming leg",
+            events:    []ami.Message{{"Event": "Hangup", "Uniqueid": callID}},
+            wantEnded: []string{callID},
+        },
+        {
+            name: "hangup of the outbound leg",
+            events: []ami.Message{
+                {"Event": "Newchannel", "Uniqueid": "1700000000.2", "Linkedid": callID},
+                {"Event": "Hangup", "Uniqueid": "1700000000.2"},
+            },
+        },
+    }
+    
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            calls := newFakeCalls(callID)
+            tracker := ami.NewTracker(calls)
+            for _, e := range tt.events {
+                tracker.HandleEvent(e)
+            }
+            
+            forwarded, ended := calls.results()
+            if !equal(forwarded, tt.wantForwarded) {
+                t.Errorf("forwarded = %q, want %q", forwarded, tt.wantForwarded)
+            }
+            if !equal(ended, tt.wantEnded) {
+                t.Errorf("ended = %q, want %q", ended, tt.wantEnded)
+            }
+        })
+    }
+}
+
+func equal(a, b []string) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+   "log"
+   "net"
+   "net/http"
+   "strings"
+   "sync/atomic"
+
+   "github.com/gorilla/mux"
+   "github.com/router-production/internal/netlist"
+)
+
+// Route groups with their own source allowlist
+const (
+   groupCalls = "calls"
+   groupAdmin = "admin"
+)
+
+// clientIP returns the address of the caller. X-Forwarded-For is only
+// believed when the connection comes from a trusted proxy, and is read from
+// the right so a client cannot spoof its address by prepending entries.
+func (s *Server) clientIP(r *http.Request) net.IP {
+   host, _, err := net.SplitHostPort(r.RemoteAddr)
+   if err != nil {
+       host = r.RemoteAddr
+   }
+   ip := net.ParseIP(host)
+   if ip == nil || !netlist.Contains(s.trustedProxies, ip) {
+       return ip
+   }
+   
+   hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+   for i := len(hops) - 1; i >= 0; i-- {
+       hop := net.ParseIP(strings.TrimSpace(hops[i]))
+       if hop == nil {
+           break
+       }
+       ip = hop
+       if !netlist.Contains(s.trustedProxies, hop) {
+           break
+       }
+   }
+   return ip
+}
+
+// clientAddr is clientIP as a string, falling back to the raw remote address.
+func (s *Server) clientAddr(r *http.Request) string {
+   if ip := s.clientIP(r); ip != nil {
+       return ip.String()
+   }
+   return r.RemoteAddr
+}
+
+// allowFrom rejects requests whose client address is outside nets. An empty
+// allowlist allows everyone.
+func (s *Server) allowFrom(group string, nets []*net.IPNet) mux.MiddlewareFunc {
+   return func(next http.Handler) http.Handler {
+       return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+           if len(nets) == 0 {
+               next.ServeHTTP(w, r)
+               return
+           }
+           
+           ip := s.clientIP(r)
+           if ip == nil || !netlist.Contains(nets, ip) {
+               atomic.AddInt64(s.rejected[group], 1)
+               log.Printf("[API] Rejected %s %s from %s: address not allowed", r.Method, r.URL.Path, s.clientAddr(r))
+               writeError(w, r, http.StatusForbidden, CodeForbidden, "address not allowed")
+               return
+           }
+           
+           next.ServeHTTP(w, r)
+       })
+   }
+}
+
+// RejectedRequests returns how many requests each route group refused
+// because of its source allowlist.
+func (s *Server) RejectedRequests() map[string]int64 {
+   counts := make(map[string]int64, len(s.rejected))
+   for group, n := range s.rejected {
+       counts[group] = atomic.LoadInt64(n)
+   }
+   return counts
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+   "net/http"
+   "net/http/httptest"
+   "testing"
+)
+
+func TestClientIP(t *testing.T) {
+   s, _ := newTestServer(t, nil, Config{TrustedProxies: []string{"10.0.0.1", "10.0.1.0/24", "fd00::/8"}})
+   
+   tests := []struct {
+       name   string
+       remote string
+       xff    []string
+       want   string
+   }{
+       {"direct", "192.0.2.10:5000", nil, "192.0.2.10"},
+       {"spoofed XFF from an untrusted peer", "192.0.2.10:5000", []string{"127.0.0.1"}, "192.0.2.10"},
+       {"trusted proxy", "10.0.0.1:5000", []string{"198.51.100.7"}, "198.51.100.7"},
+       {"trusted proxy without XFF", "10.0.0.1:5000", nil, "10.0.0.1"},
+       {"client prepends a spoofed hop", "10.0.0.1:5000", []string{"127.0.0.1, 198.51.100.7"}, "198.51.100.7"},
+       {"chain of trusted proxies", "10.0.0.1:5000", []string{"198.51.100.7, 10.0.1.5, 10.0.1.6"}, "198.51.100.7"},
+       {"chain over several headers", "10.0.0.1:5000", []string{"127.0.0.1, 198.51.100.7", "10.0.1.5"}, "198.51.100.7"},
+       {"untrusted hop stops the walk", "10.0.0.1:5000", []string{"198.51.100.7, 203.0.113.9, 10.0.1.5"}, "203.0.113.9"},
+       {"only trusted hops", "10.0.0.1:5000", []string{"10.0.1.5, 10.0.1.6"}, "10.0.1.5"},
+       {"malformed rightmost entry", "10.0.0.1:5000", []string{"198.51.100.7, not-an-ip"}, "10.0.0.1"},
+       {"malformed entry behind a trusted hop", "10.0.0.1:5000", []string{"junk, 10.0.1.5"}, "10.0.1.5"},
+       {"port in XFF is not an address", "10.0.0.1:5000", []string{"198.51.100.7:1234"}, "10.0.0.1"},
+       {"IPv6 peer", "[2001:db8::7]:5000", []string{"198.51.100.7"}, "2001:db8::7"},
+       {"IPv6 trusted proxy", "[fd00::1]:5000", []string{"2001:db8::7"}, "2001:db8::7"},
+       {"IPv6 chain", "[fd00::1]:5000", []string{"2001:db8::7, fd12::3"}, "2001:db8::7"},
+       {"remote address without port", "192.0.2.10", nil, "192.0.2.10"},
+   }
+   
+   for _, tt := range tests {
+       t.Run(tt.name, func(t *testing.T) {
+           r := httptest.NewRequest("GET", "/api/health", nil)
+           r.RemoteAddr = tt.remote
+           for _, v := range tt.xff {
+               r.Header.Add("X-Forwarded-For", v)
+           }
+           if got := s.clientAddr(r); got != tt.want {
+               t.Errorf("clientAddr = %s, want %s", got, tt.want)
+           }
+       })
+   }
+}
+
+func TestAllowlistPerGroup(t *testing.T) {
+   s, _ := newTestServer(t, nil, Config{
+       CallAllowlist:  []string{"192.0.2.10", "2001:db8::/32"},
+       AdminAllowlist: []string{"10.9.0.0/16"},
+       TrustedProxies: []string{"10.0.0.1"},
+   })
+   
+   tests := []struct {
+       name   string
+       group  string
+       remote string
+       xff    string
+       want   int
+   }{
+       {"call from allowed host", "calls", "192.0.2.10:5000", "", http.StatusOK},
+       {"call from allowed IPv6 range", "calls", "[2001:db8::5]:5000", "", http.StatusOK},
+       {"call from other host", "calls", "192.0.2.11:5000", "", http.StatusForbidden},
+       {"call spoofing an allowed address", "calls", "192.0.2.11:5000", "192.0.2.10", http.StatusForbidden},
+       {"call through the trusted proxy", "calls", "10.0.0.1:5000", "192.0.2.10", http.StatusOK},
+       {"the proxy itself is not allowed", "calls", "10.0.0.1:5000", "", http.StatusForbidden},
+       {"admin from the call host", "admin", "192.0.2.10:5000", "", http.StatusForbidden},
+       {"admin from admin range", "admin", "10.9.3.4:5000", "", http.StatusOK},
+       {"credentials follow the admin list", "credentials", "192.0.2.10:5000", "", http.StatusForbidden},
+       {"read endpoints are not restricted", "read", "203.0.113.1:5000", "", http.StatusOK},
+   }
+   
+   for _, tt := range tests {
+       t.Run(tt.name, func(t *testing.T) {
+           r := groupRequests[tt.group]()
+           r.RemoteAddr = tt.remote
+           if tt.xff != "" {
+               r.Header.Set("X-Forwarded-For", tt.xff)
+           }
+           if rec := serve(s, r); rec.Code != tt.want {
+               t.Errorf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+           }
+       })
+   }
+   
+   rejected := s.RejectedRequests()
+   if rejected[groupCalls] != 3 || rejected[groupAdmin] != 2 {
+       t.Errorf("rejected = %v, want 3 calls and 2 admin", rejected)
+   }
+}
+
+func TestNewServerRejectsBadLists(t *testing.T) {
+   for name, cfg := range map[string]Config{
+       "call allowlist":  {CallAllowlist: []string{"10.0.0.0/40"}},
+       "admin allowlist": {AdminAllowlist: []string{"admin.example.com"}},
+       "trusted proxies": {TrustedProxies: []string{"10.0.0.1/x"}},
+   } {
+       if _, err := NewServer(fakeRouter{}, newFakeProviders(), cfg); err == nil {
+           t.Errorf("%s: bad entry accepted", name)
+       }
+   }
+}
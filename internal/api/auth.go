@@ -0,0 +1,136 @@
+package api
+
+import (
+   "log"
+   "net/http"
+   "strings"
+
+   "github.com/gorilla/mux"
+   "github.com/router-production/internal/auth"
+   "github.com/router-production/internal/models"
+)
+
+// statusRecorder remembers the status code written by a handler.
+type statusRecorder struct {
+   http.ResponseWriter
+   status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+   rec.status = status
+   rec.ResponseWriter.WriteHeader(status)
+}
+
+// apiKeyFromRequest reads the key from the X-API-Key header, a bearer token,
+// or the key query parameter (for Asterisk CURL() calls from the dialplan).
+func apiKeyFromRequest(r *http.Request) string {
+   if key := r.Header.Get("X-API-Key"); key != "" {
+       return key
+   }
+   if authz := r.Header.Get("Authorization"); strings.HasPrefix(authz, "Bearer ") {
+       return strings.TrimSpace(strings.TrimPrefix(authz, "Bearer "))
+   }
+   return r.URL.Query().Get("key")
+}
+
+// requireScope rejects requests without a valid API key granting scope and
+// audits every request made with a valid key. Without a key, a verified client
+// certificate authenticates with the call scope.
+func (s *Server) requireScope(scope string) mux.MiddlewareFunc {
+   return func(next http.Handler) http.Handler {
+       return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+           if s.auth == nil {
+               next.ServeHTTP(w, r)
+               return
+           }
+           
+           plaintext := apiKeyFromRequest(r)
+           if plaintext == "" {
+               if identity := s.certIdentity(r); identity != "" {
+                   s.serveCert(identity, scope, next, w, r)
+                   return
+               }
+               writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "API key required")
+               return
+           }
+           
+           key, err := s.auth.Authenticate(plaintext)
+           if err != nil {
+               log.Printf("[API] Rejected %s %s from %s: %v", r.Method, r.URL.Path, s.clientAddr(r), err)
+               writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "invalid API key")
+               return
+           }
+           
+           rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+           if auth.HasScope(key, scope) {
+               next.ServeHTTP(rec, r)
+           } else {
+               log.Printf("[API] Key %s lacks scope %s for %s", key.Name, scope, r.URL.Path)
+               writeError(rec, r, http.StatusForbidden, CodeForbidden, "API key not allowed for this endpoint")
+           }
+           
+           s.audit(key, r, rec.status)
+       })
+   }
+}
+
+// certIdentity returns the name of the verified client certificate, or "" when
+// none was verified or its CN and DNS SANs are not among the allowed names.
+func (s *Server) certIdentity(r *http.Request) string {
+   if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+       return ""
+   }
+   cert := r.TLS.VerifiedChains[0][0]
+   
+   names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+   for _, name := range names {
+       if name == "" {
+           continue
+       }
+       if len(s.clientNames) == 0 {
+           return name
+       }
+       for _, allowed := range s.clientNames {
+           if strings.EqualFold(name, allowed) {
+               return name
+           }
+       }
+   }
+   return ""
+}
+
+// serveCert handles a request authenticated by a client certificate, which
+// grants only the call scope, and audits it like a key.
+func (s *Server) serveCert(identity, scope string, next http.Handler, w http.ResponseWriter, r *http.Request) {
+   key := &models.APIKey{Name: "cert:" + identity, Scopes: []string{auth.ScopeCall}}
+   
+   rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+   if auth.HasScope(key, scope) {
+       next.ServeHTTP(rec, r)
+   } else {
+       log.Printf("[API] Certificate %s lacks scope %s for %s", identity, scope, r.URL.Path)
+       writeError(rec, r, http.StatusForbidden, CodeForbidden, "client certificate not allowed for this endpoint")
+   }
+   
+   method, path, remote := r.Method, r.URL.Path, s.clientAddr(r)
+   s.wg.Add(1)
+   go func() {
+       defer s.wg.Done()
+       if err := s.auth.RecordCertUsage(identity, method, path, remote, rec.status); err != nil {
+           log.Printf("[API] %v", err)
+       }
+   }()
+}
+
+// audit records key usage in the background; Shutdown waits for pending writes.
+func (s *Server) audit(key *models.APIKey, r *http.Request, status int) {
+   method, path, remote := r.Method, r.URL.Path, s.clientAddr(r)
+   
+   s.wg.Add(1)
+   go func() {
+       defer s.wg.Done()
+       if err := s.auth.RecordUsage(key.ID, method, path, remote, status); err != nil {
+           log.Printf("[API] %v", err)
+       }
+   }()
+}
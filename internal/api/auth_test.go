@@ -0,0 +1,48 @@
+package api
+
+import (
+   "crypto/tls"
+   "crypto/x509"
+   "crypto/x509/pkix"
+   "net/http/httptest"
+   "testing"
+)
+
+func TestCertIdentity(t *testing.T) {
+   cert := &x509.Certificate{
+       Subject:  pkix.Name{CommonName: "pbx-1"},
+       DNSNames: []string{"pbx-1.voice.internal"},
+   }
+   
+   tests := []struct {
+       name        string
+       clientNames []string
+       state       *tls.ConnectionState
+       want        string
+   }{
+       {"plain http", nil, nil, ""},
+       {"no verified chain", nil, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}, ""},
+       {"any name from the CA", nil, verified(cert), "pbx-1"},
+       {"allowed CN", []string{"PBX-1"}, verified(cert), "pbx-1"},
+       {"allowed SAN", []string{"pbx-1.voice.internal"}, verified(cert), "pbx-1.voice.internal"},
+       {"name not allowed", []string{"pbx-2"}, verified(cert), ""},
+   }
+   
+   for _, tt := range tests {
+       t.Run(tt.name, func(t *testing.T) {
+           s := &Server{clientNames: tt.clientNames}
+           r := httptest.NewRequest("POST", "/api/processIncoming", nil)
+           r.TLS = tt.state
+           if got := s.certIdentity(r); got != tt.want {
+               t.Errorf("certIdentity = %q, want %q", got, tt.want)
+           }
+       })
+   }
+}
+
+func verified(cert *x509.Certificate) *tls.ConnectionState {
+   return &tls.ConnectionState{
+       PeerCertificates: []*x509.Certificate{cert},
+       VerifiedChains:   [][]*x509.Certificate{{cert}},
+   }
+}
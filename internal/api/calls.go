@@ -0,0 +1,87 @@
+package api
+
+import (
+   "encoding/json"
+   "fmt"
+   "net/http"
+   "strconv"
+   "time"
+
+   "github.com/gorilla/mux"
+   "github.com/router-production/internal/router"
+)
+
+func (s *Server) handleListCalls(w http.ResponseWriter, r *http.Request) {
+   q := r.URL.Query()
+
+   filter := router.CallFilter{
+       Provider: q.Get("provider"),
+       DID:      q.Get("did"),
+       ANI:      q.Get("ani"),
+       DNIS:     q.Get("dnis"),
+       SortBy:   q.Get("sort"),
+       Order:    q.Get("order"),
+       Cursor:   q.Get("cursor"),
+   }
+
+   var err error
+   if filter.Statuses, err = router.ParseCallStates(q.Get("status")); err != nil {
+       writeErr(w, r, err)
+       return
+   }
+   if filter.From, err = parseTimeParam(q.Get("from")); err != nil {
+       writeError(w, r, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("invalid from: %v", err))
+       return
+   }
+   if filter.To, err = parseTimeParam(q.Get("to")); err != nil {
+       writeError(w, r, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("invalid to: %v", err))
+       return
+   }
+   if limit := q.Get("limit"); limit != "" {
+       if filter.Limit, err = strconv.Atoi(limit); err != nil {
+           writeError(w, r, http.StatusBadRequest, CodeInvalidRequest, "invalid limit")
+           return
+       }
+   }
+
+   page, err := s.router.ListCalls(filter)
+   if err != nil {
+       writeErr(w, r, err)
+       return
+   }
+
+   w.Header().Set("Content-Type", "application/json")
+   json.NewEncoder(w).Encode(page)
+}
+
+func (s *Server) handleActiveCalls(w http.ResponseWriter, r *http.Request) {
+   calls := s.router.ListActiveCalls()
+
+   w.Header().Set("Content-Type", "application/json")
+   json.NewEncoder(w).Encode(map[string]interface{}{
+       "count": len(calls),
+       "calls": calls,
+   })
+}
+
+func (s *Server) handleGetCall(w http.ResponseWriter, r *http.Request) {
+   call, err := s.router.GetCall(mux.Vars(r)["id"])
+   if err != nil {
+       writeErr(w, r, err)
+       return
+   }
+
+   w.Header().Set("Content-Type", "application/json")
+   json.NewEncoder(w).Encode(call)
+}
+
+// parseTimeParam accepts RFC3339 timestamps or unix seconds.
+func parseTimeParam(v string) (time.Time, error) {
+   if v == "" {
+       return time.Time{}, nil
+   }
+   if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+       return time.Unix(secs, 0), nil
+   }
+   return time.Parse(time.RFC3339, v)
+}
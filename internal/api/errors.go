@@ -0,0 +1,83 @@
+package api
+
+import (
+   "encoding/json"
+   "errors"
+   "net/http"
+
+   "github.com/router-production/internal/router"
+)
+
+// Error codes returned in the error body, in addition to the router's
+// (router.ErrorCode). They are part of the API contract, never rename one.
+const (
+   CodeInvalidRequest = router.CodeInvalidRequest
+   CodeUnauthorized   = "unauthorized"
+   CodeForbidden      = "forbidden"
+   CodeNotFound       = "not_found"
+   CodeNotImplemented = "not_implemented"
+   CodeRateLimited    = router.CodeRateLimited
+)
+
+// ErrorResponse is the body of every error response.
+type ErrorResponse struct {
+   Error ErrorDetail `json:"error"`
+}
+
+type ErrorDetail struct {
+   Code    string `json:"code"`
+   Message string `json:"message"`
+}
+
+// errorStatuses maps router error codes to HTTP statuses; others are 500.
+var errorStatuses = map[string]int{
+   router.CodeInvalidNumber:      http.StatusBadRequest,
+   router.CodeInvalidRequest:     http.StatusBadRequest,
+   router.CodeCallNotFound:       http.StatusNotFound,
+   router.CodeProviderNotFound:   http.StatusNotFound,
+   router.CodeCallIDConflict:     http.StatusConflict,
+   router.CodeCallEnded:          http.StatusConflict,
+   router.CodeRateLimited:        http.StatusTooManyRequests,
+   router.CodeNoDIDAvailable:     http.StatusServiceUnavailable,
+   router.CodeProviderAtCapacity: http.StatusServiceUnavailable,
+   router.CodeShuttingDown:       http.StatusServiceUnavailable,
+   router.CodeInvalidProvider:    http.StatusBadRequest,
+   router.CodeConfigNotApplied:   http.StatusBadGateway,
+}
+
+// classifyError returns the HTTP status and error code for err.
+func classifyError(err error) (int, string) {
+   code := router.ErrorCode(err)
+   if status, ok := errorStatuses[code]; ok {
+       return status, code
+   }
+   return http.StatusInternalServerError, code
+}
+
+// writeError sends an error body with the given status and code, in the
+// format the request asked for.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, msg string) {
+   if format := responseFormat(r); format != FormatJSON {
+       writeFields(w, format, status, []string{"error", "", "", "", "", "", "", code, msg})
+       return
+   }
+   
+   w.Header().Set("Content-Type", "application/json")
+   w.Header().Set("X-Content-Type-Options", "nosniff")
+   w.WriteHeader(status)
+   json.NewEncoder(w).Encode(ErrorResponse{
+       Error: ErrorDetail{Code: code, Message: msg},
+   })
+}
+
+// writeErr classifies err and sends it. Rate limit errors get a Retry-After.
+func writeErr(w http.ResponseWriter, r *http.Request, err error) {
+   var limitErr *router.RateLimitError
+   if errors.As(err, &limitErr) {
+       writeRateLimited(w, r, limitErr.RetryAfter, err.Error())
+       return
+   }
+   
+   status, code := classifyError(err)
+   writeError(w, r, status, code, err.Error())
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+   "encoding/json"
+   "errors"
+   "fmt"
+   "net/http"
+   "net/http/httptest"
+   "testing"
+   "time"
+
+   "github.com/router-production/internal/provider"
+   "github.com/router-production/internal/router"
+)
+
+func TestClassifyError(t *testing.T) {
+   tests := []struct {
+       err    error
+       status int
+       code   string
+   }{
+       {router.ErrInvalidNumber, http.StatusBadRequest, "invalid_number"},
+       {fmt.Errorf("%w: unknown sort field", router.ErrInvalidFilter), http.StatusBadRequest, "invalid_request"},
+       {router.ErrCallNotFound, http.StatusNotFound, "call_not_found"},
+       {fmt.Errorf("lookup: %w", provider.ErrProviderNotFound), http.StatusNotFound, "provider_not_found"},
+       {router.ErrCallIDConflict, http.StatusConflict, "call_id_conflict"},
+       {router.ErrCallEnded, http.StatusConflict, "call_ended"},
+       {&router.RateLimitError{Limit: "ani", Key: "1555"}, http.StatusTooManyRequests, "rate_limited"},
+       {provider.ErrNoDIDAvailable, http.StatusServiceUnavailable, "no_did_available"},
+       {provider.ErrProviderAtCapacity, http.StatusServiceUnavailable, "provider_at_capacity"},
+       {router.ErrRouterClosed, http.StatusServiceUnavailable, "shutting_down"},
+       {provider.ErrInvalidProvider, http.StatusBadRequest, "invalid_provider"},
+       {provider.ErrNotApplied, http.StatusBadGateway, "config_not_applied"},
+       {errors.New("database is gone"), http.StatusInternalServerError, "internal_error"},
+   }
+   
+   for _, tt := range tests {
+       t.Run(tt.code, func(t *testing.T) {
+           status, code := classifyError(tt.err)
+           if status != tt.status || code != tt.code {
+               t.Errorf("classifyError(%v) = %d %s, want %d %s", tt.err, status, code, tt.status, tt.code)
+           }
+       })
+   }
+}
+
+func TestWriteErrRateLimited(t *testing.T) {
+   rec := httptest.NewRecorder()
+   err := fmt.Errorf("call: %w", &router.RateLimitError{Limit: "ani", Key: "1555", RetryAfter: 1500 * time.Millisecond})
+   writeErr(rec, httptest.NewRequest("GET", "/api/processIncoming", nil), err)
+   
+   if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "2" {
+       t.Errorf("status %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+   }
+   var body ErrorResponse
+   if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Error.Code != CodeRateLimited {
+       t.Errorf("body = %+v, %v", body, err)
+   }
+}
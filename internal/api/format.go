@@ -0,0 +1,96 @@
+package api
+
+import (
+   "encoding/json"
+   "mime"
+   "net/http"
+   "net/url"
+   "strings"
+
+   "github.com/router-production/internal/models"
+)
+
+// Response formats for the call endpoints. The text formats exist for
+// Asterisk's CURL(), whose result is easier to split than to parse as JSON.
+const (
+   // FormatJSON is the default
+   FormatJSON = "json"
+   // FormatKV is URL encoded key=value pairs joined with &, readable with
+   // CURLOPT(hashcompat)=yes and HASH() or with CUT()
+   FormatKV = "kv"
+   // FormatText is the values of callResponseFields joined with |, for CUT()
+   FormatText = "text"
+)
+
+// callResponseFields fixes the order of fields in the text formats. New
+// fields may only be appended so CUT() positions stay valid.
+var callResponseFields = []string{
+   "status", "did_assigned", "next_hop", "ani_to_send", "dnis_to_send",
+   "provider_name", "trunk_name", "error_code", "error_message",
+}
+
+const textDelimiter = "|"
+
+// responseFormat picks the format from the format query parameter, then the
+// Accept header. Unknown formats fall back to JSON.
+func responseFormat(r *http.Request) string {
+   switch f := strings.ToLower(r.URL.Query().Get("format")); f {
+   case FormatJSON, FormatKV, FormatText:
+       return f
+   }
+   
+   for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+       mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+       if err != nil {
+           continue
+       }
+       switch mediaType {
+       case "application/json":
+           return FormatJSON
+       case "application/x-www-form-urlencoded":
+           return FormatKV
+       case "text/plain":
+           return FormatText
+       }
+   }
+   return FormatJSON
+}
+
+// writeCallResponse sends a call routing answer in the requested format.
+func writeCallResponse(w http.ResponseWriter, r *http.Request, resp *models.CallResponse) {
+   format := responseFormat(r)
+   if format == FormatJSON {
+       w.Header().Set("Content-Type", "application/json")
+       json.NewEncoder(w).Encode(resp)
+       return
+   }
+   
+   writeFields(w, format, http.StatusOK, []string{
+       resp.Status, resp.DIDAssigned, resp.NextHop, resp.ANIToSend, resp.DNISToSend,
+       resp.ProviderName, resp.TrunkName, "", "",
+   })
+}
+
+// writeFields renders values, ordered as callResponseFields, in a text format.
+func writeFields(w http.ResponseWriter, format string, status int, values []string) {
+   var body string
+   if format == FormatKV {
+       pairs := make([]string, len(values))
+       for i, v := range values {
+           pairs[i] = callResponseFields[i] + "=" + url.QueryEscape(v)
+       }
+       body = strings.Join(pairs, "&")
+   } else {
+       // Keep the delimiter and line breaks out of values so positions hold
+       clean := strings.NewReplacer(textDelimiter, " ", "\r", " ", "\n", " ")
+       for i, v := range values {
+           values[i] = clean.Replace(v)
+       }
+       body = strings.Join(values, textDelimiter)
+   }
+   
+   w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+   w.Header().Set("X-Content-Type-Options", "nosniff")
+   w.WriteHeader(status)
+   w.Write([]byte(body + "\n"))
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+   "net/http"
+   "net/http/httptest"
+   "net/url"
+   "strings"
+   "testing"
+
+   "github.com/router-production/internal/models"
+)
+
+var sampleCallResponse = &models.CallResponse{
+   Status:       "success",
+   DIDAssigned:  "15550001",
+   NextHop:      "trunk-a",
+   ANIToSend:    "15551111",
+   DNISToSend:   "15550001",
+   ProviderName: "carrier-a",
+   TrunkName:    "carrier-a-trunk",
+}
+
+func TestResponseFormat(t *testing.T) {
+   tests := []struct {
+       name   string
+       query  string
+       accept string
+       want   string
+   }{
+       {"default", "", "", FormatJSON},
+       {"query", "format=KV", "", FormatKV},
+       {"query wins over accept", "format=text", "application/json", FormatText},
+       {"unknown query uses accept", "format=xml", "text/plain", FormatText},
+       {"accept kv", "", "application/x-www-form-urlencoded", FormatKV},
+       {"accept list", "", "text/html, text/plain;q=0.9", FormatText},
+       {"unknown accept", "", "*/*", FormatJSON},
+   }
+   
+   for _, tt := range tests {
+       t.Run(tt.name, func(t *testing.T) {
+           r := httptest.NewRequest("GET", "/api/processIncoming?"+tt.query, nil)
+           if tt.accept != "" {
+               r.Header.Set("Accept", tt.accept)
+           }
+           if got := responseFormat(r); got != tt.want {
+               t.Errorf("responseFormat = %q, want %q", got, tt.want)
+           }
+       })
+   }
+}
+
+func TestWriteCallResponseKV(t *testing.T) {
+   w := httptest.NewRecorder()
+   writeCallResponse(w, httptest.NewRequest("GET", "/api/processIncoming?format=kv", nil), sampleCallResponse)
+   
+   if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+       t.Errorf("Content-Type = %q", ct)
+   }
+   values, err := url.ParseQuery(strings.TrimSpace(w.Body.String()))
+   if err != nil {
+       t.Fatalf("body %q is not key=value pairs: %v", w.Body.String(), err)
+   }
+   want := map[string]string{
+       "status":        "success",
+       "did_assigned":  "15550001",
+       "next_hop":      "trunk-a",
+       "provider_name": "carrier-a",
+       "trunk_name":    "carrier-a-trunk",
+       "error_code":    "",
+   }
+   for key, v := range want {
+       if got := values.Get(key); got != v {
+           t.Errorf("%s = %q, want %q", key, got, v)
+       }
+   }
+   if len(values) != len(callResponseFields) {
+       t.Errorf("%d fields, want %d", len(values), len(callResponseFields))
+   }
+}
+
+func TestWriteCallResponseText(t *testing.T) {
+   w := httptest.NewRecorder()
+   writeCallResponse(w, httptest.NewRequest("GET", "/api/processIncoming?format=text", nil), sampleCallResponse)
+   
+   want := "success|15550001|trunk-a|15551111|15550001|carrier-a|carrier-a-trunk||\n"
+   if got := w.Body.String(); got != want {
+       t.Errorf("body = %q, want %q", got, want)
+   }
+}
+
+func TestWriteErrorText(t *testing.T) {
+   tests := []struct {
+       format string
+       want   string
+   }{
+       // The delimiter and line breaks in the message must not shift fields
+       {FormatText, "error|||||||no_did_available|pool a empty try b\n"},
+       {FormatKV, "status=error&did_assigned=&next_hop=&ani_to_send=&dnis_to_send=&provider_name=&trunk_name=&error_code=no_did_available&error_message=pool+a%7Cempty%0Atry+b\n"},
+   }
+   
+   for _, tt := range tests {
+       t.Run(tt.format, func(t *testing.T) {
+           w := httptest.NewRecorder()
+           r := httptest.NewRequest("GET", "/api/processIncoming?format="+tt.format, nil)
+           writeError(w, r, http.StatusServiceUnavailable, "no_did_available", "pool a|empty\ntry b")
+           
+           if w.Code != http.StatusServiceUnavailable {
+               t.Errorf("status = %d", w.Code)
+           }
+           if got := w.Body.String(); got != tt.want {
+               t.Errorf("body = %q, want %q", got, tt.want)
+           }
+       })
+   }
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+   "encoding/json"
+   "log"
+   "net/http"
+
+   "github.com/gorilla/mux"
+   "github.com/router-production/internal/models"
+   "github.com/router-production/internal/provider"
+)
+
+// maxBodyBytes bounds JSON request bodies
+const maxBodyBytes = 64 << 10
+
+// endpointsRequest is the body of PUT /api/providers/{name}/endpoints.
+// Omitted lists are left unchanged; an empty list falls back to the host.
+type endpointsRequest struct {
+   MatchAddresses []string         `json:"match_addresses"`
+   Contacts       []models.Contact `json:"contacts"`
+}
+
+// endpointsResponse reports the updated provider and the Asterisk reload.
+type endpointsResponse struct {
+   Provider *models.ProviderView `json:"provider"`
+   Reload   *provider.ReloadResult `json:"reload"`
+}
+
+func (s *Server) handleSetEndpoints(w http.ResponseWriter, r *http.Request) {
+   name := mux.Vars(r)["name"]
+
+   var req endpointsRequest
+   dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+   dec.DisallowUnknownFields()
+   if err := dec.Decode(&req); err != nil {
+       writeError(w, r, http.StatusBadRequest, CodeInvalidRequest, "invalid JSON body: "+err.Error())
+       return
+   }
+
+   p, reload, err := s.providerManager.SetEndpoints(name, req.MatchAddresses, req.Contacts)
+   if err != nil {
+       log.Printf("[API] SetEndpoints error: %v", err)
+       writeErr(w, r, err)
+       return
+   }
+
+   log.Printf("[API] Endpoints of provider %s updated by %s", name, s.clientAddr(r))
+
+   w.Header().Set("Content-Type", "application/json")
+   json.NewEncoder(w).Encode(endpointsResponse{Provider: p.View(), Reload: reload})
+}
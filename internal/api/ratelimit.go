@@ -0,0 +1,39 @@
+package api
+
+import (
+   "log"
+   "math"
+   "net/http"
+   "strconv"
+   "time"
+
+   "github.com/router-production/internal/ratelimit"
+)
+
+// SetIPRateLimit changes the per client IP limit on the call endpoints.
+func (s *Server) SetIPRateLimit(limit ratelimit.Limit) {
+   s.ipLimiter.SetLimits(limit, nil)
+}
+
+// rateLimitByIP rejects clients sending calls faster than the IP rate limit.
+func (s *Server) rateLimitByIP(next http.Handler) http.Handler {
+   return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+       addr := s.clientAddr(r)
+       if ok, wait := s.ipLimiter.Allow(addr); !ok {
+           log.Printf("[API] Rate limited %s %s from %s", r.Method, r.URL.Path, addr)
+           writeRateLimited(w, r, wait, "Too many requests")
+           return
+       }
+       next.ServeHTTP(w, r)
+   })
+}
+
+// writeRateLimited answers 429 with a Retry-After of at least one second.
+func writeRateLimited(w http.ResponseWriter, r *http.Request, wait time.Duration, msg string) {
+   seconds := int(math.Ceil(wait.Seconds()))
+   if seconds < 1 {
+       seconds = 1
+   }
+   w.Header().Set("Retry-After", strconv.Itoa(seconds))
+   writeError(w, r, http.StatusTooManyRequests, CodeRateLimited, msg)
+}
@@ -1,57 +1,229 @@
 package api
 
 import (
+   "context"
    "encoding/json"
    "fmt"
    "log"
+   "net"
    "net/http"
+   "sync"
    "time"
    
    "github.com/gorilla/mux"
+   "github.com/router-production/internal/auth"
+   "github.com/router-production/internal/models"
+   "github.com/router-production/internal/netlist"
+   "github.com/router-production/internal/ratelimit"
    "github.com/router-production/internal/router"
    "github.com/router-production/internal/provider"
 )
 
+// Config holds the API server settings.
+type Config struct {
+   Port         int
+   ReadTimeout  time.Duration
+   WriteTimeout time.Duration
+   // Auth checks API keys; nil leaves the API open
+   Auth *auth.Store
+   // CORSOrigins are the browser origins allowed to call the API
+   CORSOrigins []string
+   // CallAllowlist and AdminAllowlist restrict the call-processing and
+   // admin endpoints to these CIDRs; empty allows any address
+   CallAllowlist  []string
+   AdminAllowlist []string
+   // TrustedProxies may set X-Forwarded-For
+   TrustedProxies []string
+   // TLS serves HTTPS when set
+   TLS *TLSConfig
+   // IPRateLimit limits call requests per client IP
+   IPRateLimit ratelimit.Limit
+}
+
+// Router routes calls and answers call queries; *router.Router implements it.
+type Router interface {
+   ProcessIncomingCall(callID, ani, dnis string) (*models.CallResponse, error)
+   ProcessReturnCall(ani2, did string) (*models.CallResponse, error)
+   GetStatistics() map[string]interface{}
+   ListCalls(f router.CallFilter) (*router.CallPage, error)
+   GetCall(callID string) (*models.CallRecord, error)
+   ListActiveCalls() []*models.CallRecord
+}
+
+// Providers manages the providers; *provider.Manager implements it.
+type Providers interface {
+   ListProviderViews() []*models.ProviderView
+   GetProviderStats(name string) (map[string]interface{}, error)
+   GetCredentials(name string) (*models.ProviderCredentials, error)
+   SetEndpoints(name string, match []string, contacts []models.Contact) (*models.Provider, *provider.ReloadResult, error)
+}
+
 type Server struct {
-   router          *router.Router
-   providerManager *provider.Manager
+   router          Router
+   providerManager Providers
    port            int
+   srv             *http.Server
+   tls             bool
+   reload          func() error
+   auth            *auth.Store
+   clientNames     []string
+   corsOrigins     []string
+   callAllowlist   []*net.IPNet
+   adminAllowlist  []*net.IPNet
+   trustedProxies  []*net.IPNet
+   rejected        map[string]*int64
+   ipLimiter       *ratelimit.Limiter
+   wg              sync.WaitGroup
 }
 
-func NewServer(r *router.Router, pm *provider.Manager, port int) *Server {
-   return &Server{
+func NewServer(r Router, pm Providers, cfg Config) (*Server, error) {
+   s := &Server{
        router:          r,
        providerManager: pm,
-       port:            port,
+       port:            cfg.Port,
+       auth:            cfg.Auth,
+       corsOrigins:     cfg.CORSOrigins,
+       rejected: map[string]*int64{
+           groupCalls: new(int64),
+           groupAdmin: new(int64),
+       },
+       ipLimiter: ratelimit.New(cfg.IPRateLimit),
+   }
+   
+   var err error
+   if s.callAllowlist, err = netlist.Parse(cfg.CallAllowlist); err != nil {
+       return nil, fmt.Errorf("call allowlist: %w", err)
+   }
+   if s.adminAllowlist, err = netlist.Parse(cfg.AdminAllowlist); err != nil {
+       return nil, fmt.Errorf("admin allowlist: %w", err)
    }
+   if s.trustedProxies, err = netlist.Parse(cfg.TrustedProxies); err != nil {
+       return nil, fmt.Errorf("trusted proxies: %w", err)
+   }
+   
+   s.srv = &http.Server{
+       Handler:      s.routes(),
+       Addr:         fmt.Sprintf(":%d", cfg.Port),
+       WriteTimeout: cfg.WriteTimeout,
+       ReadTimeout:  cfg.ReadTimeout,
+   }
+   
+   if cfg.TLS != nil {
+       certs, err := newCertReloader(*cfg.TLS)
+       if err != nil {
+           return nil, err
+       }
+       s.srv.TLSConfig = certs.tlsConfig()
+       s.clientNames = cfg.TLS.ClientNames
+       s.tls = true
+   }
+   
+   return s, nil
 }
 
+// Start serves the API until Shutdown is called.
 func (s *Server) Start() error {
+   l, err := net.Listen("tcp", s.srv.Addr)
+   if err != nil {
+       return err
+   }
+   if s.tls {
+       log.Printf("[API] Server starting on port %d (HTTPS)", s.port)
+   } else {
+       log.Printf("[API] Server starting on port %d", s.port)
+   }
+   return s.Serve(l)
+}
+
+// Serve answers requests on l until Shutdown is called.
+func (s *Server) Serve(l net.Listener) error {
+   var err error
+   if s.tls {
+       err = s.srv.ServeTLS(l, "", "")
+   } else {
+       err = s.srv.Serve(l)
+   }
+   if err != http.ErrServerClosed {
+       return err
+   }
+   return nil
+}
+
+// SetReloadFunc sets the function run by POST /api/admin/reload.
+func (s *Server) SetReloadFunc(fn func() error) {
+   s.reload = fn
+}
+
+// Shutdown stops accepting connections and waits for in-flight requests to
+// finish, or for ctx to expire.
+func (s *Server) Shutdown(ctx context.Context) error {
+   log.Printf("[API] Server shutting down")
+   err := s.srv.Shutdown(ctx)
+   
+   // Pending audit writes
+   s.wg.Wait()
+   return err
+}
+
+func (s *Server) routes() http.Handler {
    r := mux.NewRouter()
    
    // Middleware
    r.Use(loggingMiddleware)
-   r.Use(corsMiddleware)
+   r.Use(s.corsMiddleware)
+   
+   r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+       writeError(w, r, http.StatusNotFound, CodeNotFound, "no such endpoint")
+   })
+   r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+       writeError(w, r, http.StatusMethodNotAllowed, CodeInvalidRequest, "method not allowed")
+   })
    
-   // Router endpoints
-   r.HandleFunc("/api/processIncoming", s.handleProcessIncoming).Methods("GET", "POST")
-   r.HandleFunc("/api/processReturn", s.handleProcessReturn).Methods("GET", "POST")
-   r.HandleFunc("/api/stats", s.handleStats).Methods("GET")
    r.HandleFunc("/api/health", s.handleHealth).Methods("GET")
    
-   // Provider management endpoints
-   r.HandleFunc("/api/providers", s.handleListProviders).Methods("GET")
-   r.HandleFunc("/api/providers/{name}/stats", s.handleProviderStats).Methods("GET")
+   // Router endpoints, called by the dialplan
+   calls := r.NewRoute().Subrouter()
+   calls.Use(s.allowFrom(groupCalls, s.callAllowlist))
+   calls.Use(s.rateLimitByIP)
+   calls.Use(s.requireScope(auth.ScopeCall))
+   calls.HandleFunc("/api/processIncoming", s.handleProcessIncoming).Methods("GET", "POST")
+   calls.HandleFunc("/api/processReturn", s.handleProcessReturn).Methods("GET", "POST")
    
-   srv := &http.Server{
-       Handler:      r,
-       Addr:         fmt.Sprintf(":%d", s.port),
-       WriteTimeout: 15 * time.Second,
-       ReadTimeout:  15 * time.Second,
+   // Read-only endpoints
+   read := r.NewRoute().Subrouter()
+   read.Use(s.requireScope(auth.ScopeRead))
+   read.HandleFunc("/api/stats", s.handleStats).Methods("GET")
+   
+   // Call query endpoints
+   read.HandleFunc("/api/calls", s.handleListCalls).Methods("GET")
+   read.HandleFunc("/api/calls/active", s.handleActiveCalls).Methods("GET")
+   read.HandleFunc("/api/calls/{id}", s.handleGetCall).Methods("GET")
+   
+   // Provider endpoints
+   read.HandleFunc("/api/providers", s.handleListProviders).Methods("GET")
+   read.HandleFunc("/api/providers/{name}/stats", s.handleProviderStats).Methods("GET")
+   
+   // Admin endpoints
+   admin := r.NewRoute().Subrouter()
+   admin.Use(s.allowFrom(groupAdmin, s.adminAllowlist))
+   admin.Use(s.requireScope(auth.ScopeAdmin))
+   admin.HandleFunc("/api/admin/reload", s.handleReload).Methods("POST")
+   admin.HandleFunc("/api/providers/{name}/endpoints", s.handleSetEndpoints).Methods("PUT")
+   
+   // Credential reveal, needs its own scope and is refused outright when
+   // keys are not checked
+   secrets := r.NewRoute().Subrouter()
+   secrets.Use(s.allowFrom(groupAdmin, s.adminAllowlist))
+   if s.auth != nil {
+       secrets.Use(s.requireScope(auth.ScopeCredentials))
+       secrets.HandleFunc("/api/providers/{name}/credentials", s.handleProviderCredentials).Methods("GET")
+   } else {
+       secrets.HandleFunc("/api/providers/{name}/credentials", func(w http.ResponseWriter, r *http.Request) {
+           writeError(w, r, http.StatusForbidden, CodeForbidden, "revealing credentials requires api.auth_enabled")
+       }).Methods("GET")
    }
    
-   log.Printf("[API] Server starting on port %d", s.port)
-   return srv.ListenAndServe()
+   return r
 }
 
 func loggingMiddleware(next http.Handler) http.Handler {
@@ -61,11 +233,14 @@ func loggingMiddleware(next http.Handler) http.Handler {
    })
 }
 
-func corsMiddleware(next http.Handler) http.Handler {
+func (s *Server) corsMiddleware(next http.Handler) http.Handler {
    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-       w.Header().Set("Access-Control-Allow-Origin", "*")
-       w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-       w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+       if origin := r.Header.Get("Origin"); origin != "" && s.corsAllowed(origin) {
+           w.Header().Set("Access-Control-Allow-Origin", origin)
+           w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, OPTIONS")
+           w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+           w.Header().Add("Vary", "Origin")
+       }
        
        if r.Method == "OPTIONS" {
            w.WriteHeader(http.StatusOK)
@@ -76,25 +251,33 @@ func corsMiddleware(next http.Handler) http.Handler {
    })
 }
 
+func (s *Server) corsAllowed(origin string) bool {
+   for _, allowed := range s.corsOrigins {
+       if allowed == origin || allowed == "*" {
+           return true
+       }
+   }
+   return false
+}
+
 func (s *Server) handleProcessIncoming(w http.ResponseWriter, r *http.Request) {
    callID := r.URL.Query().Get("callid")
    ani := r.URL.Query().Get("ani")
    dnis := r.URL.Query().Get("dnis")
    
    if callID == "" || ani == "" || dnis == "" {
-       http.Error(w, "Missing parameters", http.StatusBadRequest)
+       writeError(w, r, http.StatusBadRequest, CodeInvalidRequest, "callid, ani and dnis are required")
        return
    }
    
    resp, err := s.router.ProcessIncomingCall(callID, ani, dnis)
    if err != nil {
        log.Printf("[API] ProcessIncoming error: %v", err)
-       http.Error(w, err.Error(), http.StatusInternalServerError)
+       writeErr(w, r, err)
        return
    }
    
-   w.Header().Set("Content-Type", "application/json")
-   json.NewEncoder(w).Encode(resp)
+   writeCallResponse(w, r, resp)
 }
 
 func (s *Server) handleProcessReturn(w http.ResponseWriter, r *http.Request) {
@@ -102,23 +285,26 @@ func (s *Server) handleProcessReturn(w http.ResponseWriter, r *http.Request) {
    did := r.URL.Query().Get("did")
    
    if ani2 == "" || did == "" {
-       http.Error(w, "Missing parameters", http.StatusBadRequest)
+       writeError(w, r, http.StatusBadRequest, CodeInvalidRequest, "ani2 and did are required")
        return
    }
    
    resp, err := s.router.ProcessReturnCall(ani2, did)
    if err != nil {
        log.Printf("[API] ProcessReturn error: %v", err)
-       http.Error(w, err.Error(), http.StatusNotFound)
+       writeErr(w, r, err)
        return
    }
    
-   w.Header().Set("Content-Type", "application/json")
-   json.NewEncoder(w).Encode(resp)
+   writeCallResponse(w, r, resp)
 }
 
 func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
    stats := s.router.GetStatistics()
+   stats["rejected_requests"] = s.RejectedRequests()
+   if limits, ok := stats["rate_limits"].(map[string]ratelimit.Stats); ok {
+       limits["ip"] = s.ipLimiter.Stats()
+   }
    
    w.Header().Set("Content-Type", "application/json")
    json.NewEncoder(w).Encode(stats)
@@ -133,7 +319,7 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleListProviders(w http.ResponseWriter, r *http.Request) {
-   providers := s.providerManager.ListProviders()
+   providers := s.providerManager.ListProviderViews()
    
    w.Header().Set("Content-Type", "application/json")
    json.NewEncoder(w).Encode(providers)
@@ -145,10 +331,45 @@ func (s *Server) handleProviderStats(w http.ResponseWriter, r *http.Request) {
    
    stats, err := s.providerManager.GetProviderStats(name)
    if err != nil {
-       http.Error(w, err.Error(), http.StatusNotFound)
+       writeErr(w, r, err)
        return
    }
    
    w.Header().Set("Content-Type", "application/json")
    json.NewEncoder(w).Encode(stats)
 }
+
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+   if s.reload == nil {
+       writeError(w, r, http.StatusNotImplemented, CodeNotImplemented, "reload not supported")
+       return
+   }
+   
+   if err := s.reload(); err != nil {
+       log.Printf("[API] Reload error: %v", err)
+       writeErr(w, r, err)
+       return
+   }
+   
+   w.Header().Set("Content-Type", "application/json")
+   json.NewEncoder(w).Encode(map[string]string{
+       "status": "reloaded",
+       "time":   time.Now().Format(time.RFC3339),
+   })
+}
+
+func (s *Server) handleProviderCredentials(w http.ResponseWriter, r *http.Request) {
+   name := mux.Vars(r)["name"]
+   
+   creds, err := s.providerManager.GetCredentials(name)
+   if err != nil {
+       writeErr(w, r, err)
+       return
+   }
+   
+   log.Printf("[API] Credentials for provider %s revealed to %s", name, s.clientAddr(r))
+   
+   w.Header().Set("Content-Type", "application/json")
+   w.Header().Set("Cache-Control", "no-store")
+   json.NewEncoder(w).Encode(creds)
+}
@@ -0,0 +1,318 @@
+package api
+
+import (
+   "crypto/sha256"
+   "crypto/x509"
+   "crypto/x509/pkix"
+   "database/sql/driver"
+   "encoding/hex"
+   "encoding/json"
+   "net/http"
+   "net/http/httptest"
+   "strings"
+   "testing"
+   "time"
+   
+   "github.com/router-production/internal/auth"
+   "github.com/router-production/internal/database/dbtest"
+   "github.com/router-production/internal/models"
+   "github.com/router-production/internal/provider"
+   "github.com/router-production/internal/router"
+)
+
+// fakeRouter answers every call with sampleCallResponse.
+type fakeRouter struct{}
+
+func (fakeRouter) ProcessIncomingCall(callID, ani, dnis string) (*models.CallResponse, error) {
+   return sampleCallResponse, nil
+}
+
+func (fakeRouter) ProcessReturnCall(ani2, did string) (*models.CallResponse, error) {
+   return sampleCallResponse, nil
+}
+
+func (fakeRouter) GetStatistics() map[string]interface{} {
+   return map[string]interface{}{"active_calls": 0}
+}
+
+func (fakeRouter) ListCalls(f router.CallFilter) (*router.CallPage, error) {
+   return &router.CallPage{}, nil
+}
+
+func (fakeRouter) GetCall(callID string) (*models.CallRecord, error) {
+   return nil, router.ErrCallNotFound
+}
+
+func (fakeRouter) ListActiveCalls() []*models.CallRecord {
+   return nil
+}
+
+// fakeProviders holds a single provider with a SIP password.
+type fakeProviders struct {
+   provider *models.Provider
+}
+
+func newFakeProviders() *fakeProviders {
+   return &fakeProviders{provider: &models.Provider{
+       Name: "carrier", Host: "198.51.100.10", Port: 5060, Username: "trunk", Password: "s3cret-sip", Realm: "carrier.example",
+   }}
+}
+
+func (f *fakeProviders) ListProviderViews() []*models.ProviderView {
+   return []*models.ProviderView{f.provider.View()}
+}
+
+func (f *fakeProviders) GetProviderStats(name string) (map[string]interface{}, error) {
+   if name != f.provider.Name {
+       return nil, provider.ErrProviderNotFound
+   }
+   return map[string]interface{}{"provider": f.provider.View()}, nil
+}
+
+func (f *fakeProviders) GetCredentials(name string) (*models.ProviderCredentials, error) {
+   if name != f.provider.Name {
+       return nil, provider.ErrProviderNotFound
+   }
+   p := f.provider
+   return &models.ProviderCredentials{Name: p.Name, Username: p.Username, Password: p.Password, Realm: p.Realm}, nil
+}
+
+func (f *fakeProviders) SetEndpoints(name string, match []string, contacts []models.Contact) (*models.Provider, *provider.ReloadResult, error) {
+   return f.provider, nil, nil
+}
+
+// Keys known to the fake key store, by scope
+const (
+   callKey  = "rk_call0000000000"
+   readKey  = "rk_read0000000000"
+   adminKey = "rk_admin000000000"
+   credsKey = "rk_creds000000000"
+)
+
+// newKeyStore returns an auth store over a fake api_keys table.
+func newKeyStore(t *testing.T) *auth.Store {
+   t.Helper()
+   keys := map[string][]string{
+       callKey:  {auth.ScopeCall},
+       readKey:  {auth.ScopeRead},
+       adminKey: {auth.ScopeAdmin},
+       credsKey: {auth.ScopeCredentials},
+   }
+   columns := []string{"id", "name", "key_prefix", "key_hash", "scopes", "created_at", "last_used_at", "revoked_at"}
+   
+   fake, db := dbtest.New()
+   fake.Handle("FROM api_keys WHERE key_hash = ?", func(args []driver.Value) (*dbtest.Result, error) {
+       for plaintext, scopes := range keys {
+           if keyHash(plaintext) == args[0] {
+               scopesJSON, _ := json.Marshal(scopes)
+               return dbtest.Rows(columns, []driver.Value{
+                   int64(len(plaintext)), scopes[0], plaintext[:11], args[0], scopesJSON, time.Now(), nil, nil,
+               }), nil
+           }
+       }
+       return dbtest.Rows(columns), nil
+   })
+   return auth.NewStore(db)
+}
+
+func keyHash(plaintext string) string {
+   sum := sha256.Sum256([]byte(plaintext))
+   return hex.EncodeToString(sum[:])
+}
+
+// newTestServer returns a server over fakes; store nil disables API keys.
+func newTestServer(t *testing.T, store *auth.Store, cfg Config) (*Server, *fakeProviders) {
+   t.Helper()
+   providers := newFakeProviders()
+   cfg.Auth = store
+   s, err := NewServer(fakeRouter{}, providers, cfg)
+   if err != nil {
+       t.Fatalf("NewServer: %v", err)
+   }
+   s.SetReloadFunc(func() error { return nil })
+   t.Cleanup(s.wg.Wait)
+   return s, providers
+}
+
+func serve(s *Server, r *http.Request) *httptest.ResponseRecorder {
+   rec := httptest.NewRecorder()
+   s.srv.Handler.ServeHTTP(rec, r)
+   return rec
+}
+
+// Requests reaching each route group
+var groupRequests = map[string]func() *http.Request{
+   "calls": func() *http.Request {
+       return httptest.NewRequest("GET", "/api/processIncoming?callid=c1&ani=15551111&dnis=15550001", nil)
+   },
+   "read": func() *http.Request {
+       return httptest.NewRequest("GET", "/api/providers", nil)
+   },
+   "admin": func() *http.Request {
+       return httptest.NewRequest("POST", "/api/admin/reload", nil)
+   },
+   "credentials": func() *http.Request {
+       return httptest.NewRequest("GET", "/api/providers/carrier/credentials", nil)
+   },
+}
+
+func TestRouteGroupScopes(t *testing.T) {
+   s, _ := newTestServer(t, newKeyStore(t), Config{})
+   
+   tests := []struct {
+       group string
+       key   string
+       want  int
+   }{
+       {"calls", "", http.StatusUnauthorized},
+       {"calls", "rk_unknown0000000", http.StatusUnauthorized},
+       {"calls", readKey, http.StatusForbidden},
+       {"calls", callKey, http.StatusOK},
+       {"calls", adminKey, http.StatusOK},
+       
+       {"read", "", http.StatusUnauthorized},
+       {"read", callKey, http.StatusForbidden},
+       {"read", readKey, http.StatusOK},
+       {"read", adminKey, http.StatusOK},
+       
+       {"admin", "", http.StatusUnauthorized},
+       {"admin", readKey, http.StatusForbidden},
+       {"admin", credsKey, http.StatusForbidden},
+       {"admin", adminKey, http.StatusOK},
+       
+       {"credentials", "", http.StatusUnauthorized},
+       {"credentials", adminKey, http.StatusForbidden},
+       {"credentials", readKey, http.StatusForbidden},
+       {"credentials", credsKey, http.StatusOK},
+   }
+   
+   for _, tt := range tests {
+       t.Run(tt.group+" "+tt.key, func(t *testing.T) {
+           r := groupRequests[tt.group]()
+           if tt.key != "" {
+               r.Header.Set("X-API-Key", tt.key)
+           }
+           if rec := serve(s, r); rec.Code != tt.want {
+               t.Errorf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+           }
+       })
+   }
+}
+
+func TestAPIKeyFromRequest(t *testing.T) {
+   tests := []struct {
+       name   string
+       header string
+       value  string
+       query  string
+       want   string
+   }{
+       {"header", "X-API-Key", callKey, "", callKey},
+       {"bearer", "Authorization", "Bearer " + callKey, "", callKey},
+       {"basic auth is not a key", "Authorization", "Basic dXNlcjpwYXNz", "", ""},
+       {"query", "", "", "key=" + callKey, callKey},
+       {"header wins over query", "X-API-Key", callKey, "key=" + readKey, callKey},
+       {"none", "", "", "", ""},
+   }
+   
+   s, _ := newTestServer(t, newKeyStore(t), Config{})
+   for _, tt := range tests {
+       t.Run(tt.name, func(t *testing.T) {
+           r := httptest.NewRequest("GET", "/api/processIncoming?callid=c1&ani=15551111&dnis=15550001&"+tt.query, nil)
+           if tt.header != "" {
+               r.Header.Set(tt.header, tt.value)
+           }
+           if got := apiKeyFromRequest(r); got != tt.want {
+               t.Errorf("apiKeyFromRequest = %q, want %q", got, tt.want)
+           }
+           
+           want := http.StatusOK
+           if tt.want == "" {
+               want = http.StatusUnauthorized
+           }
+           if rec := serve(s, r); rec.Code != want {
+               t.Errorf("status %d, want %d", rec.Code, want)
+           }
+       })
+   }
+}
+
+func TestClientCertGrantsCallScope(t *testing.T) {
+   s, _ := newTestServer(t, newKeyStore(t), Config{})
+   s.clientNames = []string{"pbx-1"}
+   cert := &x509.Certificate{Subject: pkix.Name{CommonName: "pbx-1"}}
+   
+   tests := []struct {
+       group string
+       cert  *x509.Certificate
+       want  int
+   }{
+       {"calls", cert, http.StatusOK},
+       {"read", cert, http.StatusForbidden},
+       {"admin", cert, http.StatusForbidden},
+       {"credentials", cert, http.StatusForbidden},
+       {"calls", &x509.Certificate{Subject: pkix.Name{CommonName: "pbx-2"}}, http.StatusUnauthorized},
+   }
+   
+   for _, tt := range tests {
+       t.Run(tt.group+" "+tt.cert.Subject.CommonName, func(t *testing.T) {
+           r := groupRequests[tt.group]()
+           r.TLS = verified(tt.cert)
+           if rec := serve(s, r); rec.Code != tt.want {
+               t.Errorf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+           }
+       })
+   }
+}
+
+func TestAuthDisabled(t *testing.T) {
+   s, _ := newTestServer(t, nil, Config{})
+   
+   for group, want := range map[string]int{
+       "calls":       http.StatusOK,
+       "read":        http.StatusOK,
+       "admin":       http.StatusOK,
+       "credentials": http.StatusForbidden,
+   } {
+       if rec := serve(s, groupRequests[group]()); rec.Code != want {
+           t.Errorf("%s: status %d, want %d", group, rec.Code, want)
+       }
+   }
+}
+
+func TestProviderPasswordMasked(t *testing.T) {
+   s, _ := newTestServer(t, newKeyStore(t), Config{})
+   
+   tests := []struct {
+       name       string
+       path       string
+       key        string
+       status     int
+       wantSecret bool
+   }{
+       {"list", "/api/providers", readKey, http.StatusOK, false},
+       {"list as admin", "/api/providers", adminKey, http.StatusOK, false},
+       {"show", "/api/providers/carrier/stats", readKey, http.StatusOK, false},
+       {"credentials as admin", "/api/providers/carrier/credentials", adminKey, http.StatusForbidden, false},
+       {"credentials", "/api/providers/carrier/credentials", credsKey, http.StatusOK, true},
+   }
+   
+   for _, tt := range tests {
+       t.Run(tt.name, func(t *testing.T) {
+           r := httptest.NewRequest("GET", tt.path, nil)
+           r.Header.Set("X-API-Key", tt.key)
+           rec := serve(s, r)
+           body := rec.Body.String()
+           
+           if rec.Code != tt.status {
+               t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, body)
+           }
+           if got := strings.Contains(body, "s3cret-sip"); got != tt.wantSecret {
+               t.Errorf("password revealed = %v, want %v: %s", got, tt.wantSecret, body)
+           }
+           if !tt.wantSecret && tt.status == http.StatusOK && !strings.Contains(body, `"password":"`+models.MaskedSecret+`"`) {
+               t.Errorf("masked password missing: %s", body)
+           }
+       })
+   }
+}
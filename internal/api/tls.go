@@ -0,0 +1,137 @@
+package api
+
+import (
+   "crypto/tls"
+   "crypto/x509"
+   "fmt"
+   "log"
+   "os"
+   "sync"
+   "time"
+)
+
+// TLSConfig enables HTTPS and, with a client CA, certificate authentication
+// of callers.
+type TLSConfig struct {
+   CertFile string
+   KeyFile  string
+   // ClientCAFile verifies client certificates; empty disables mTLS
+   ClientCAFile string
+   // RequireClientCert rejects connections without a valid client
+   // certificate instead of only verifying those presented
+   RequireClientCert bool
+   // ClientNames are the certificate CNs or DNS SANs authenticated with the
+   // call scope; empty accepts every certificate the client CA verifies
+   ClientNames []string
+}
+
+// How often the certificate files are checked for changes
+const tlsReloadCheckInterval = 10 * time.Second
+
+// certReloader serves the certificate and client CA from disk, picking up
+// replaced files without a restart.
+type certReloader struct {
+   cfg TLSConfig
+   
+   mu        sync.Mutex
+   cert      *tls.Certificate
+   clientCAs *x509.CertPool
+   modTime   time.Time
+   checked   time.Time
+}
+
+func newCertReloader(cfg TLSConfig) (*certReloader, error) {
+   cr := &certReloader{cfg: cfg}
+   if err := cr.load(); err != nil {
+       return nil, err
+   }
+   return cr, nil
+}
+
+// load reads the key pair and client CA.
+func (cr *certReloader) load() error {
+   cert, err := tls.LoadX509KeyPair(cr.cfg.CertFile, cr.cfg.KeyFile)
+   if err != nil {
+       return fmt.Errorf("failed to load certificate: %w", err)
+   }
+   
+   var pool *x509.CertPool
+   if cr.cfg.ClientCAFile != "" {
+       pem, err := os.ReadFile(cr.cfg.ClientCAFile)
+       if err != nil {
+           return fmt.Errorf("failed to read client CA: %w", err)
+       }
+       pool = x509.NewCertPool()
+       if !pool.AppendCertsFromPEM(pem) {
+           return fmt.Errorf("no certificates found in %s", cr.cfg.ClientCAFile)
+       }
+   }
+   
+   cr.cert = &cert
+   cr.clientCAs = pool
+   cr.modTime = cr.latestModTime()
+   return nil
+}
+
+// latestModTime returns the newest modification time of the watched files.
+func (cr *certReloader) latestModTime() time.Time {
+   var latest time.Time
+   for _, path := range []string{cr.cfg.CertFile, cr.cfg.KeyFile, cr.cfg.ClientCAFile} {
+       if path == "" {
+           continue
+       }
+       if info, err := os.Stat(path); err == nil && info.ModTime().After(latest) {
+           latest = info.ModTime()
+       }
+   }
+   return latest
+}
+
+// current returns the loaded certificate and CA pool, reloading them when
+// the files changed. A failed reload keeps serving the previous certificate.
+func (cr *certReloader) current() (*tls.Certificate, *x509.CertPool) {
+   cr.mu.Lock()
+   defer cr.mu.Unlock()
+   
+   if time.Since(cr.checked) >= tlsReloadCheckInterval {
+       cr.checked = time.Now()
+       if modTime := cr.latestModTime(); modTime.After(cr.modTime) {
+           if err := cr.load(); err != nil {
+               log.Printf("[API] Certificate reload failed, keeping previous: %v", err)
+               cr.modTime = modTime
+           } else {
+               log.Printf("[API] Reloaded TLS certificate from %s", cr.cfg.CertFile)
+           }
+       }
+   }
+   
+   return cr.cert, cr.clientCAs
+}
+
+// tlsConfig builds the server TLS configuration. Settings are resolved per
+// connection so certificate and CA changes apply to new connections.
+func (cr *certReloader) tlsConfig() *tls.Config {
+   // http.Server only adds h2 to the config it is given, not to the ones
+   // returned per connection, so the protocols are listed here
+   base := &tls.Config{
+       MinVersion: tls.VersionTLS12,
+       NextProtos: []string{"h2", "http/1.1"},
+   }
+   
+   cfg := base.Clone()
+   cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+       cert, clientCAs := cr.current()
+       
+       conn := base.Clone()
+       conn.Certificates = []tls.Certificate{*cert}
+       if clientCAs != nil {
+           conn.ClientCAs = clientCAs
+           conn.ClientAuth = tls.VerifyClientCertIfGiven
+           if cr.cfg.RequireClientCert {
+               conn.ClientAuth = tls.RequireAndVerifyClientCert
+           }
+       }
+       return conn, nil
+   }
+   return cfg
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+   "crypto/ecdsa"
+   "crypto/elliptic"
+   "crypto/rand"
+   "crypto/tls"
+   "crypto/x509"
+   "crypto/x509/pkix"
+   "encoding/pem"
+   "math/big"
+   "net"
+   "net/http"
+   "os"
+   "path/filepath"
+   "testing"
+   "time"
+)
+
+// writeCert writes a self-signed certificate for cn and its key to dir and
+// returns the TLS settings pointing at them, with the certificate as client CA.
+func writeCert(t *testing.T, dir, cn string) TLSConfig {
+   t.Helper()
+   key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+   if err != nil {
+       t.Fatal(err)
+   }
+   tmpl := &x509.Certificate{
+       SerialNumber:          big.NewInt(time.Now().UnixNano()),
+       Subject:               pkix.Name{CommonName: cn},
+       DNSNames:              []string{cn},
+       NotBefore:             time.Now().Add(-time.Hour),
+       NotAfter:              time.Now().Add(time.Hour),
+       IsCA:                  true,
+       BasicConstraintsValid: true,
+       KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+       ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+   }
+   der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+   if err != nil {
+       t.Fatal(err)
+   }
+   keyDER, err := x509.MarshalECPrivateKey(key)
+   if err != nil {
+       t.Fatal(err)
+   }
+   
+   cfg := TLSConfig{
+       CertFile:     filepath.Join(dir, "server.crt"),
+       KeyFile:      filepath.Join(dir, "server.key"),
+       ClientCAFile: filepath.Join(dir, "server.crt"),
+   }
+   certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+   keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+   if err := os.WriteFile(cfg.CertFile, certPEM, 0600); err != nil {
+       t.Fatal(err)
+   }
+   if err := os.WriteFile(cfg.KeyFile, keyPEM, 0600); err != nil {
+       t.Fatal(err)
+   }
+   return cfg
+}
+
+// touch moves the modification time of files forward so a reload sees them as changed.
+func touch(t *testing.T, at time.Time, paths ...string) {
+   t.Helper()
+   for _, path := range paths {
+       if err := os.Chtimes(path, at, at); err != nil {
+           t.Fatal(err)
+       }
+   }
+}
+
+func servedName(t *testing.T, cr *certReloader) string {
+   t.Helper()
+   cert, _ := cr.current()
+   leaf, err := x509.ParseCertificate(cert.Certificate[0])
+   if err != nil {
+       t.Fatal(err)
+   }
+   return leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+   dir := t.TempDir()
+   cfg := writeCert(t, dir, "first")
+   cr, err := newCertReloader(cfg)
+   if err != nil {
+       t.Fatalf("newCertReloader: %v", err)
+   }
+   cr.checked = time.Now()
+   
+   writeCert(t, dir, "second")
+   touch(t, time.Now().Add(time.Minute), cfg.CertFile, cfg.KeyFile)
+   if got := servedName(t, cr); got != "first" {
+       t.Errorf("reloaded within the check interval, serving %q", got)
+   }
+   
+   cr.checked = time.Time{}
+   if got := servedName(t, cr); got != "second" {
+       t.Errorf("serving %q after the files changed, want second", got)
+   }
+   
+   // A broken replacement keeps the previous certificate
+   broken := time.Now().Add(2 * time.Minute)
+   os.WriteFile(cfg.KeyFile, []byte("not a key"), 0600)
+   touch(t, broken, cfg.KeyFile)
+   cr.checked = time.Time{}
+   if got := servedName(t, cr); got != "second" {
+       t.Errorf("serving %q after a failed reload, want second", got)
+   }
+   
+   // and is not retried until the files change again
+   writeCert(t, dir, "third")
+   touch(t, broken, cfg.CertFile, cfg.KeyFile)
+   cr.checked = time.Time{}
+   if got := servedName(t, cr); got != "second" {
+       t.Errorf("serving %q, want second until the files change again", got)
+   }
+   touch(t, broken.Add(time.Minute), cfg.CertFile)
+   cr.checked = time.Time{}
+   if got := servedName(t, cr); got != "third" {
+       t.Errorf("serving %q after the files changed, want third", got)
+   }
+}
+
+func TestTLSConfigPerConnection(t *testing.T) {
+   cfg := writeCert(t, t.TempDir(), "router")
+   cfg.RequireClientCert = true
+   cr, err := newCertReloader(cfg)
+   if err != nil {
+       t.Fatalf("newCertReloader: %v", err)
+   }
+   
+   conn, err := cr.tlsConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+   if err != nil {
+       t.Fatal(err)
+   }
+   if len(conn.Certificates) != 1 || conn.ClientCAs == nil || conn.ClientAuth != tls.RequireAndVerifyClientCert {
+       t.Errorf("per-connection config misses the certificate or client CA: %+v", conn)
+   }
+   if conn.MinVersion != tls.VersionTLS12 || len(conn.NextProtos) == 0 || conn.NextProtos[0] != "h2" {
+       t.Errorf("per-connection config dropped base settings: min %x, protos %q", conn.MinVersion, conn.NextProtos)
+   }
+}
+
+func TestTLSServerNegotiatesHTTP2(t *testing.T) {
+   cr, err := newCertReloader(writeCert(t, t.TempDir(), "router"))
+   if err != nil {
+       t.Fatalf("newCertReloader: %v", err)
+   }
+   
+   ln, err := net.Listen("tcp", "127.0.0.1:0")
+   if err != nil {
+       t.Fatal(err)
+   }
+   srv := &http.Server{Handler: http.NotFoundHandler(), TLSConfig: cr.tlsConfig()}
+   go srv.ServeTLS(ln, "", "")
+   defer srv.Close()
+   
+   conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+       InsecureSkipVerify: true,
+       NextProtos:         []string{"h2", "http/1.1"},
+   })
+   if err != nil {
+       t.Fatalf("handshake: %v", err)
+   }
+   defer conn.Close()
+   if got := conn.ConnectionState().NegotiatedProtocol; got != "h2" {
+       t.Errorf("negotiated %q, want h2", got)
+   }
+}
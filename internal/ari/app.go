@@ -0,0 +1,324 @@
+package ari
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "sync"
+    "time"
+    
+    "github.com/gorilla/websocket"
+    "github.com/router-production/internal/models"
+    "github.com/router-production/internal/router"
+)
+
+const (
+    minBackoff = time.Second
+    maxBackoff = 30 * time.Second
+)
+
+// Event is an ARI websocket event, reduced to the fields the router uses.
+type Event struct {
+    Type     string   `json:"type"`
+    Args     []string `json:"args"`
+    Channel  *Channel `json:"channel"`
+    Cause    int      `json:"cause"`
+    CauseTxt string   `json:"cause_txt"`
+}
+
+// Router routes the calls handled by the app; *router.Router implements it.
+type Router interface {
+    ProcessIncomingCall(callID, ani, dnis string) (*models.CallResponse, error)
+    ProcessReturnCall(ani2, did string) (*models.CallResponse, error)
+    MarkForwarded(callID string) error
+    EndCall(callID string) (*models.CallRecord, error)
+}
+
+// call pairs the two legs of a call handled by the app.
+type call struct {
+    // callID is set for incoming legs; return legs only restore numbers
+    callID   string
+    inbound  string
+    outbound string
+    bridge   string
+}
+
+// App is a Stasis application that takes over call flow from the dialplan.
+// Channels enter it with the leg type as first argument:
+//
+//    Stasis(router,incoming)   route a carrier call to a DID
+//    Stasis(router,return)     restore the original ANI/DNIS toward S4
+//
+// The app originates the outbound leg, bridges it to the inbound one when
+// it answers and hangs up each leg with the other's cause.
+type App struct {
+    client *Client
+    router Router
+    
+    mu    sync.Mutex
+    calls map[string]*call // by channel ID, both legs
+}
+
+func NewApp(r Router, cfg Config) *App {
+    return &App{
+        client: NewClient(cfg),
+        router: r,
+        calls:  make(map[string]*call),
+    }
+}
+
+// Run receives events until ctx is cancelled, reconnecting with backoff.
+func (a *App) Run(ctx context.Context) {
+    backoff := minBackoff
+    for {
+        err := a.listen(ctx, func() {
+            backoff = minBackoff
+        })
+        if ctx.Err() != nil {
+            break
+        }
+        
+        log.Printf("[ARI] Events connection lost: %v, reconnecting in %s", err, backoff)
+        select {
+        case <-ctx.Done():
+        case <-time.After(backoff):
+        }
+        if ctx.Err() != nil {
+            break
+        }
+        
+        backoff *= 2
+        if backoff > maxBackoff {
+            backoff = maxBackoff
+        }
+    }
+    log.Printf("[ARI] Stopped")
+}
+
+// listen reads events from one websocket connection. Events are handled
+// in order, one at a time.
+func (a *App) listen(ctx context.Context, connected func()) error {
+    eventsURL, err := a.client.eventsURL()
+    if err != nil {
+        return err
+    }
+    
+    conn, _, err := websocket.DefaultDialer.DialContext(ctx, eventsURL, nil)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+    
+    log.Printf("[ARI] Stasis application %s connected", a.client.cfg.App)
+    connected()
+    
+    go func() {
+        <-ctx.Done()
+        conn.Close()
+    }()
+    
+    for {
+        var ev Event
+        if err := conn.ReadJSON(&ev); err != nil {
+            return err
+        }
+        a.handle(&ev)
+    }
+}
+
+func (a *App) handle(ev *Event) {
+    if ev.Channel == nil {
+        return
+    }
+    
+    switch ev.Type {
+    case "StasisStart":
+        leg := ""
+        if len(ev.Args) > 0 {
+            leg = ev.Args[0]
+        }
+        
+        switch leg {
+        case "incoming":
+            a.startIncoming(ev.Channel)
+        case "return":
+            a.startReturn(ev.Channel)
+        case "dialed":
+            if len(ev.Args) < 2 {
+                a.hangup(ev.Channel.ID, "failure")
+                return
+            }
+            a.outboundAnswered(ev.Channel, ev.Args[1])
+        default:
+            log.Printf("[ARI] Channel %s entered with unknown leg type %q", ev.Channel.Name, leg)
+            a.hangup(ev.Channel.ID, "failure")
+        }
+        
+    case "ChannelDestroyed":
+        a.legEnded(ev.Channel.ID, ev.Cause)
+    }
+}
+
+func (a *App) startIncoming(ch *Channel) {
+    resp, err := a.router.ProcessIncomingCall(ch.ID, ch.Caller.Number, ch.Dialplan.Exten)
+    if err != nil {
+        log.Printf("[ARI] ProcessIncoming error: %v", err)
+        a.hangup(ch.ID, reasonForError(err))
+        return
+    }
+    a.dial(ch, ch.ID, trunkEndpoint(resp.DNISToSend, resp.NextHop), resp.ANIToSend)
+}
+
+func (a *App) startReturn(ch *Channel) {
+    resp, err := a.router.ProcessReturnCall(ch.Caller.Number, ch.Dialplan.Exten)
+    if err != nil {
+        log.Printf("[ARI] ProcessReturn error: %v", err)
+        a.hangup(ch.ID, reasonForError(err))
+        return
+    }
+    a.dial(ch, "", fmt.Sprintf("PJSIP/%s@%s", resp.DNISToSend, resp.NextHop), resp.ANIToSend)
+}
+
+// trunkEndpoint dials number through the dial-<trunk> context generated for
+// a provider, which tries its contacts in priority order like the dialplan
+// modes do. /n keeps the Local channel in the call, so the app sees the
+// outbound leg hang up.
+func trunkEndpoint(number, trunk string) string {
+    return fmt.Sprintf("Local/%s@dial-%s/n", number, trunk)
+}
+
+// dial originates the outbound leg for an inbound channel.
+func (a *App) dial(inbound *Channel, callID, endpoint, callerID string) {
+    c := &call{callID: callID, inbound: inbound.ID}
+    a.mu.Lock()
+    a.calls[inbound.ID] = c
+    a.mu.Unlock()
+    
+    out, err := a.client.Originate(endpoint, callerID, inbound.ID, "dialed", inbound.ID)
+    if err != nil {
+        log.Printf("[ARI] Failed to dial %s: %v", endpoint, err)
+        a.hangup(inbound.ID, "congestion")
+        return
+    }
+    
+    a.mu.Lock()
+    c.outbound = out.ID
+    a.calls[out.ID] = c
+    a.mu.Unlock()
+    
+    if err := a.client.Ring(inbound.ID); err != nil {
+        log.Printf("[ARI] Failed to ring %s: %v", inbound.ID, err)
+    }
+    
+    log.Printf("[ARI] Dialing %s as %s for %s", endpoint, callerID, inbound.Name)
+}
+
+// outboundAnswered bridges an answered outbound leg with its inbound leg.
+func (a *App) outboundAnswered(out *Channel, inboundID string) {
+    a.mu.Lock()
+    c, ok := a.calls[inboundID]
+    a.mu.Unlock()
+    if !ok {
+        // The inbound leg is already gone
+        a.hangup(out.ID, "normal")
+        return
+    }
+    
+    if err := a.client.Answer(inboundID); err != nil {
+        log.Printf("[ARI] Failed to answer %s: %v", inboundID, err)
+    }
+    
+    bridge, err := a.client.CreateBridge()
+    if err == nil {
+        err = a.client.AddChannels(bridge.ID, inboundID, out.ID)
+    }
+    if err != nil {
+        log.Printf("[ARI] Failed to bridge %s with %s: %v", inboundID, out.ID, err)
+        a.hangup(out.ID, "failure")
+        a.hangup(inboundID, "failure")
+        return
+    }
+    
+    a.mu.Lock()
+    c.bridge = bridge.ID
+    a.mu.Unlock()
+    
+    if c.callID != "" {
+        if err := a.router.MarkForwarded(c.callID); err != nil {
+            log.Printf("[ARI] Failed to mark call %s forwarded: %v", c.callID, err)
+        }
+    }
+}
+
+// legEnded hangs up the other leg with the same cause and, once the
+// inbound leg of an incoming call is gone, ends the call.
+func (a *App) legEnded(channelID string, cause int) {
+    a.mu.Lock()
+    c, ok := a.calls[channelID]
+    if !ok {
+        a.mu.Unlock()
+        return
+    }
+    delete(a.calls, channelID)
+    
+    other := c.inbound
+    if channelID == c.inbound {
+        other = c.outbound
+    }
+    _, otherUp := a.calls[other]
+    bridge := c.bridge
+    a.mu.Unlock()
+    
+    if otherUp {
+        a.hangup(other, reasonForCause(cause))
+    } else if bridge != "" {
+        if err := a.client.DestroyBridge(bridge); err != nil {
+            log.Printf("[ARI] Failed to destroy bridge %s: %v", bridge, err)
+        }
+    }
+    
+    if channelID == c.inbound && c.callID != "" {
+        if _, err := a.router.EndCall(c.callID); err != nil && !errors.Is(err, router.ErrCallNotFound) {
+            log.Printf("[ARI] Failed to end call %s: %v", c.callID, err)
+        }
+    }
+}
+
+func (a *App) hangup(channelID, reason string) {
+    if err := a.client.Hangup(channelID, reason); err != nil {
+        log.Printf("[ARI] Failed to hang up %s: %v", channelID, err)
+    }
+}
+
+// reasonForError picks the hangup reason for a routing failure.
+func reasonForError(err error) string {
+    switch router.ErrorCode(err) {
+    case router.CodeInvalidNumber, router.CodeCallNotFound:
+        return "unallocated"
+    case router.CodeNoDIDAvailable, router.CodeProviderAtCapacity, router.CodeRateLimited, router.CodeShuttingDown:
+        return "congestion"
+    }
+    return "failure"
+}
+
+// reasonForCause maps a Q.850 cause to the ARI hangup reason closest to it.
+func reasonForCause(cause int) string {
+    switch cause {
+    case 0, 16:
+        return "normal"
+    case 1:
+        return "unallocated"
+    case 17:
+        return "busy"
+    case 18, 19:
+        return "no_answer"
+    case 21:
+        return "rejected"
+    case 28:
+        return "number_incomplete"
+    case 34, 38, 41, 42:
+        return "congestion"
+    }
+    return "normal_unspecified"
+}
@@ -0,0 +1,420 @@
+package ari
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+    
+    "github.com/gorilla/websocket"
+    "github.com/router-production/internal/models"
+    "github.com/router-production/internal/provider"
+    "github.com/router-production/internal/router"
+)
+
+// request is a REST call received by the fake ARI.
+type request struct {
+    Method string
+    Path   string
+    Query  url.Values
+}
+
+func (r request) String() string {
+    return r.Method + " " + r.Path
+}
+
+// fakeARI serves the ARI REST calls the app makes and the events websocket.
+type fakeARI struct {
+    t        *testing.T
+    srv      *httptest.Server
+    requests chan request
+    conn     chan *websocket.Conn
+    
+    mu sync.Mutex
+    // failOriginate answers POST /channels with an error
+    failOriginate bool
+    originated    int
+}
+
+func newFakeARI(t *testing.T) *fakeARI {
+    f := &fakeARI{
+        t:        t,
+        requests: make(chan request, 100),
+        conn:     make(chan *websocket.Conn, 1),
+    }
+    f.srv = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+    t.Cleanup(f.srv.Close)
+    return f
+}
+
+func (f *fakeARI) serveHTTP(w http.ResponseWriter, r *http.Request) {
+    path := strings.TrimPrefix(r.URL.Path, "/ari")
+    if path == "/events" {
+        if r.URL.Query().Get("app") != "router" || r.URL.Query().Get("api_key") != "router:secret" {
+            http.Error(w, "forbidden", http.StatusForbidden)
+            return
+        }
+        conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+        if err != nil {
+            return
+        }
+        f.conn <- conn
+        return
+    }
+    
+    if user, pass, ok := r.BasicAuth(); !ok || user != "router" || pass != "secret" {
+        http.Error(w, "unauthorized", http.StatusUnauthorized)
+        return
+    }
+    f.requests <- request{Method: r.Method, Path: path, Query: r.URL.Query()}
+    
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    w.Header().Set("Content-Type", "application/json")
+    switch {
+    case r.Method == "POST" && path == "/channels":
+        if f.failOriginate {
+            w.WriteHeader(http.StatusInternalServerError)
+            fmt.Fprint(w, `{"message":"Allocation failed"}`)
+            return
+        }
+        f.originated++
+        fmt.Fprintf(w, `{"id":"out-%d","name":"PJSIP/trunk-%d"}`, f.originated, f.originated)
+    case r.Method == "POST" && path == "/bridges":
+        fmt.Fprint(w, `{"id":"bridge-1"}`)
+    default:
+        w.WriteHeader(http.StatusNoContent)
+    }
+}
+
+// expect waits for the next REST calls and checks them in order.
+func (f *fakeARI) expect(want ...string) []request {
+    f.t.Helper()
+    var got []request
+    for _, w := range want {
+        select {
+        case r := <-f.requests:
+            if r.String() != w {
+                f.t.Fatalf("ARI request = %s, want %s", r, w)
+            }
+            got = append(got, r)
+        case <-time.After(5 * time.Second):
+            f.t.Fatalf("timed out waiting for %s", w)
+        }
+    }
+    return got
+}
+
+// expectNone checks that no REST call is pending.
+func (f *fakeARI) expectNone() {
+    f.t.Helper()
+    select {
+    case r := <-f.requests:
+        f.t.Errorf("unexpected ARI request %s", r)
+    case <-time.After(50 * time.Millisecond):
+    }
+}
+
+// fakeRouter answers with fixed responses and records the calls made.
+type fakeRouter struct {
+    mu    sync.Mutex
+    calls []string
+    
+    incoming *models.CallResponse
+    ret      *models.CallResponse
+    err      error
+}
+
+func (r *fakeRouter) record(format string, args ...interface{}) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.calls = append(r.calls, fmt.Sprintf(format, args...))
+}
+
+func (r *fakeRouter) recorded() []string {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return append([]string(nil), r.calls...)
+}
+
+func (r *fakeRouter) ProcessIncomingCall(callID, ani, dnis string) (*models.CallResponse, error) {
+    r.record("incoming %s %s %s", callID, ani, dnis)
+    return r.incoming, r.err
+}
+
+func (r *fakeRouter) ProcessReturnCall(ani2, did string) (*models.CallResponse, error) {
+    r.record("return %s %s", ani2, did)
+    return r.ret, r.err
+}
+
+func (r *fakeRouter) MarkForwarded(callID string) error {
+    r.record("forwarded %s", callID)
+    return nil
+}
+
+func (r *fakeRouter) EndCall(callID string) (*models.CallRecord, error) {
+    r.record("end %s", callID)
+    return &models.CallRecord{CallID: callID}, nil
+}
+
+// waitCalls waits until the router received want, in order.
+func (r *fakeRouter) waitCalls(t *testing.T, want ...string) {
+    t.Helper()
+    deadline := time.Now().Add(5 * time.Second)
+    for {
+        got := r.recorded()
+        if strings.Join(got, "\n") == strings.Join(want, "\n") {
+            return
+        }
+        if time.Now().After(deadline) {
+            t.Fatalf("router calls = %q, want %q", got, want)
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+// startApp runs the app against the fake and returns the events connection.
+func startApp(t *testing.T, r Router, f *fakeARI) *websocket.Conn {
+    t.Helper()
+    app := NewApp(r, Config{URL: f.srv.URL + "/ari", Username: "router", Password: "secret", App: "router"})
+    
+    ctx, cancel := context.WithCancel(context.Background())
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        app.Run(ctx)
+    }()
+    t.Cleanup(func() {
+        cancel()
+        <-done
+    })
+    
+    select {
+    case conn := <-f.conn:
+        t.Cleanup(func() { conn.Close() })
+        return conn
+    case <-time.After(5 * time.Second):
+        t.Fatalf("app did not connect to the events websocket")
+        return nil
+    }
+}
+
+func send(t *testing.T, conn *websocket.Conn, ev Event) {
+    t.Helper()
+    if err := conn.WriteJSON(ev); err != nil {
+        t.Fatalf("WriteJSON: %v", err)
+    }
+}
+
+func stasisStart(ch *Channel, args ...string) Event {
+    return Event{Type: "StasisStart", Args: args, Channel: ch}
+}
+
+func destroyed(channelID string, cause int) Event {
+    return Event{Type: "ChannelDestroyed", Channel: &Channel{ID: channelID}, Cause: cause}
+}
+
+var (
+    inboundChannel = &Channel{
+        ID:       "in-1",
+        Name:     "PJSIP/carrier-a-00000001",
+        Caller:   CallerID{Number: "15551111"},
+        Dialplan: Dialplan{Exten: "15552222"},
+    }
+    incomingResponse = &models.CallResponse{
+        Status:     "success",
+        NextHop:    "trunk-a",
+        ANIToSend:  "15551111",
+        DNISToSend: "15550001",
+    }
+)
+
+func TestAppIncomingCall(t *testing.T) {
+    f := newFakeARI(t)
+    r := &fakeRouter{incoming: incomingResponse}
+    conn := startApp(t, r, f)
+    
+    send(t, conn, stasisStart(inboundChannel, "incoming"))
+    got := f.expect("POST /channels", "POST /channels/in-1/ring")
+    
+    originate := got[0].Query
+    checks := map[string]string{
+        "endpoint":   "Local/15550001@dial-trunk-a/n",
+        "callerId":   "15551111",
+        "originator": "in-1",
+        "app":        "router",
+        "appArgs":    "dialed,in-1",
+    }
+    for param, want := range checks {
+        if originate.Get(param) != want {
+            t.Errorf("originate %s = %q, want %q", param, originate.Get(param), want)
+        }
+    }
+    r.waitCalls(t, "incoming in-1 15551111 15552222")
+    
+    // The outbound leg answers and enters the app
+    send(t, conn, stasisStart(&Channel{ID: "out-1"}, "dialed", "in-1"))
+    got = f.expect("POST /channels/in-1/answer", "POST /bridges", "POST /bridges/bridge-1/addChannel")
+    if channels := got[2].Query.Get("channel"); channels != "in-1,out-1" {
+        t.Errorf("bridged channels = %q, want in-1,out-1", channels)
+    }
+    r.waitCalls(t, "incoming in-1 15551111 15552222", "forwarded in-1")
+    
+    // The far end hangs up: the inbound leg follows with the same cause
+    send(t, conn, destroyed("out-1", 16))
+    got = f.expect("DELETE /channels/in-1")
+    if reason := got[0].Query.Get("reason"); reason != "normal" {
+        t.Errorf("inbound hangup reason = %q, want normal", reason)
+    }
+    
+    send(t, conn, destroyed("in-1", 16))
+    f.expect("DELETE /bridges/bridge-1")
+    r.waitCalls(t, "incoming in-1 15551111 15552222", "forwarded in-1", "end in-1")
+}
+
+func TestAppOutboundFailure(t *testing.T) {
+    tests := []struct {
+        name          string
+        failOriginate bool
+        // outboundCause ends the outbound leg before it answers
+        outboundCause int
+        // wantRequests come before the inbound leg is hung up
+        wantRequests  []string
+        wantReason    string
+    }{
+        {
+            name:          "originate rejected",
+            failOriginate: true,
+            wantRequests:  []string{"POST /channels"},
+            wantReason:    "congestion",
+        },
+        {
+            name:          "busy",
+            outboundCause: 17,
+            wantRequests:  []string{"POST /channels", "POST /channels/in-1/ring"},
+            wantReason:    "busy",
+        },
+        {
+            name:          "no answer",
+            outboundCause: 19,
+            wantRequests:  []string{"POST /channels", "POST /channels/in-1/ring"},
+            wantReason:    "no_answer",
+        },
+        {
+            name:          "circuit congestion",
+            outboundCause: 34,
+            wantRequests:  []string{"POST /channels", "POST /channels/in-1/ring"},
+            wantReason:    "congestion",
+        },
+    }
+    
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            f := newFakeARI(t)
+            f.failOriginate = tt.failOriginate
+            r := &fakeRouter{incoming: incomingResponse}
+            conn := startApp(t, r, f)
+            
+            send(t, conn, stasisStart(inboundChannel, "incoming"))
+            f.expect(tt.wantRequests...)
+            if tt.outboundCause != 0 {
+                send(t, conn, destroyed("out-1", tt.outboundCause))
+            }
+            
+            got := f.expect("DELETE /channels/in-1")
+            if reason := got[0].Query.Get("reason"); reason != tt.wantReason {
+                t.Errorf("inbound hangup reason = %q, want %q", reason, tt.wantReason)
+            }
+            
+            // The call ends with its inbound leg and was never forwarded
+            send(t, conn, destroyed("in-1", 16))
+            r.waitCalls(t, "incoming in-1 15551111 15552222", "end in-1")
+            f.expectNone()
+        })
+    }
+}
+
+func TestAppRoutingFailure(t *testing.T) {
+    tests := []struct {
+        err        error
+        wantReason string
+    }{
+        {fmt.Errorf("pool empty: %w", provider.ErrNoDIDAvailable), "congestion"},
+        {router.ErrInvalidNumber, "unallocated"},
+        {fmt.Errorf("database down"), "failure"},
+    }
+    
+    for _, tt := range tests {
+        t.Run(tt.wantReason, func(t *testing.T) {
+            f := newFakeARI(t)
+            conn := startApp(t, &fakeRouter{err: tt.err}, f)
+            
+            send(t, conn, stasisStart(inboundChannel, "incoming"))
+            got := f.expect("DELETE /channels/in-1")
+            if reason := got[0].Query.Get("reason"); reason != tt.wantReason {
+                t.Errorf("hangup reason = %q, want %q", reason, tt.wantReason)
+            }
+        })
+    }
+}
+
+func TestAppReturnLeg(t *testing.T) {
+    f := newFakeARI(t)
+    r := &fakeRouter{ret: &models.CallResponse{
+        Status:     "success",
+        NextHop:    "s4-trunk",
+        ANIToSend:  "15551111",
+        DNISToSend: "15552222",
+    }}
+    conn := startApp(t, r, f)
+    
+    send(t, conn, stasisStart(&Channel{
+        ID:       "ret-1",
+        Caller:   CallerID{Number: "15559999"},
+        Dialplan: Dialplan{Exten: "15550001"},
+    }, "return"))
+    got := f.expect("POST /channels", "POST /channels/ret-1/ring")
+    if endpoint := got[0].Query.Get("endpoint"); endpoint != "PJSIP/15552222@s4-trunk" {
+        t.Errorf("endpoint = %q", endpoint)
+    }
+    if callerID := got[0].Query.Get("callerId"); callerID != "15551111" {
+        t.Errorf("callerId = %q, want the original ANI", callerID)
+    }
+    
+    send(t, conn, stasisStart(&Channel{ID: "out-1"}, "dialed", "ret-1"))
+    f.expect("POST /channels/ret-1/answer", "POST /bridges", "POST /bridges/bridge-1/addChannel")
+    
+    // Return legs only restore numbers: no call is forwarded or ended
+    send(t, conn, destroyed("ret-1", 16))
+    got = f.expect("DELETE /channels/out-1")
+    if reason := got[0].Query.Get("reason"); reason != "normal" {
+        t.Errorf("outbound hangup reason = %q, want normal", reason)
+    }
+    send(t, conn, destroyed("out-1", 16))
+    f.expect("DELETE /bridges/bridge-1")
+    r.waitCalls(t, "return 15559999 15550001")
+}
+
+func TestAppUnknownLeg(t *testing.T) {
+    f := newFakeARI(t)
+    r := &fakeRouter{}
+    conn := startApp(t, r, f)
+    
+    send(t, conn, stasisStart(&Channel{ID: "x-1"}, "transfer"))
+    got := f.expect("DELETE /channels/x-1")
+    if reason := got[0].Query.Get("reason"); reason != "failure" {
+        t.Errorf("hangup reason = %q, want failure", reason)
+    }
+    
+    // An answered outbound leg whose inbound leg is gone is hung up
+    send(t, conn, stasisStart(&Channel{ID: "out-9"}, "dialed", "in-9"))
+    f.expect("DELETE /channels/out-9")
+    if calls := r.recorded(); len(calls) != 0 {
+        t.Errorf("router calls = %q", calls)
+    }
+}
@@ -0,0 +1,180 @@
+package ari
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+)
+
+const requestTimeout = 10 * time.Second
+
+// Config holds the ARI connection settings.
+type Config struct {
+    // URL is the ARI base URL, e.g. http://127.0.0.1:8088/ari
+    URL      string
+    Username string
+    Password string
+    // App is the Stasis application name used in the dialplan, Stasis(<App>,...)
+    App string
+}
+
+// Channel is the ARI channel model, reduced to the fields the router uses.
+type Channel struct {
+    ID       string   `json:"id"`
+    Name     string   `json:"name"`
+    State    string   `json:"state"`
+    Caller   CallerID `json:"caller"`
+    Dialplan Dialplan `json:"dialplan"`
+}
+
+type CallerID struct {
+    Name   string `json:"name"`
+    Number string `json:"number"`
+}
+
+type Dialplan struct {
+    Context  string `json:"context"`
+    Exten    string `json:"exten"`
+    Priority int    `json:"priority"`
+}
+
+type Bridge struct {
+    ID string `json:"id"`
+}
+
+// Client calls the ARI REST API.
+type Client struct {
+    cfg  Config
+    base string
+    http *http.Client
+}
+
+func NewClient(cfg Config) *Client {
+    return &Client{
+        cfg:  cfg,
+        base: strings.TrimRight(cfg.URL, "/"),
+        http: &http.Client{Timeout: requestTimeout},
+    }
+}
+
+// Answer answers a ringing channel.
+func (c *Client) Answer(channelID string) error {
+    return c.do("POST", "/channels/"+url.PathEscape(channelID)+"/answer", nil, nil)
+}
+
+// Ring indicates ringing to a channel while its outbound leg is dialed.
+func (c *Client) Ring(channelID string) error {
+    return c.do("POST", "/channels/"+url.PathEscape(channelID)+"/ring", nil, nil)
+}
+
+// Hangup hangs up a channel with an ARI reason such as "normal" or "busy".
+func (c *Client) Hangup(channelID, reason string) error {
+    params := url.Values{}
+    if reason != "" {
+        params.Set("reason", reason)
+    }
+    return c.do("DELETE", "/channels/"+url.PathEscape(channelID), params, nil)
+}
+
+// Originate dials endpoint and puts the channel into the Stasis app with
+// appArgs once it answers. The originator links the new channel to the
+// inbound one, so both share a Linkedid.
+func (c *Client) Originate(endpoint, callerID, originator string, appArgs ...string) (*Channel, error) {
+    params := url.Values{
+        "endpoint": {endpoint},
+        "app":      {c.cfg.App},
+        "appArgs":  {strings.Join(appArgs, ",")},
+        "callerId": {callerID},
+    }
+    if originator != "" {
+        params.Set("originator", originator)
+    }
+    
+    channel := &Channel{}
+    if err := c.do("POST", "/channels", params, channel); err != nil {
+        return nil, err
+    }
+    return channel, nil
+}
+
+// CreateBridge creates a mixing bridge.
+func (c *Client) CreateBridge() (*Bridge, error) {
+    bridge := &Bridge{}
+    if err := c.do("POST", "/bridges", url.Values{"type": {"mixing"}}, bridge); err != nil {
+        return nil, err
+    }
+    return bridge, nil
+}
+
+// AddChannels puts channels into a bridge.
+func (c *Client) AddChannels(bridgeID string, channelIDs ...string) error {
+    params := url.Values{"channel": {strings.Join(channelIDs, ",")}}
+    return c.do("POST", "/bridges/"+url.PathEscape(bridgeID)+"/addChannel", params, nil)
+}
+
+// DestroyBridge removes a bridge.
+func (c *Client) DestroyBridge(bridgeID string) error {
+    return c.do("DELETE", "/bridges/"+url.PathEscape(bridgeID), nil, nil)
+}
+
+func (c *Client) do(method, path string, params url.Values, out interface{}) error {
+    u := c.base + path
+    if len(params) > 0 {
+        u += "?" + params.Encode()
+    }
+    
+    req, err := http.NewRequest(method, u, nil)
+    if err != nil {
+        return err
+    }
+    req.SetBasicAuth(c.cfg.Username, c.cfg.Password)
+    
+    resp, err := c.http.Do(req)
+    if err != nil {
+        return fmt.Errorf("ARI %s %s: %w", method, path, err)
+    }
+    defer resp.Body.Close()
+    
+    body, _ := io.ReadAll(resp.Body)
+    if resp.StatusCode >= 300 {
+        var ariErr struct {
+            Message string `json:"message"`
+        }
+        json.Unmarshal(body, &ariErr)
+        if ariErr.Message == "" {
+            ariErr.Message = string(bytes.TrimSpace(body))
+        }
+        return fmt.Errorf("ARI %s %s: %s: %s", method, path, resp.Status, ariErr.Message)
+    }
+    
+    if out != nil && len(body) > 0 {
+        if err := json.Unmarshal(body, out); err != nil {
+            return fmt.Errorf("ARI %s %s: invalid response: %w", method, path, err)
+        }
+    }
+    return nil
+}
+
+// eventsURL is the websocket URL for the application's events.
+func (c *Client) eventsURL() (string, error) {
+    u, err := url.Parse(c.base + "/events")
+    if err != nil {
+        return "", err
+    }
+    switch u.Scheme {
+    case "https":
+        u.Scheme = "wss"
+    default:
+        u.Scheme = "ws"
+    }
+    u.RawQuery = url.Values{
+        "app":     {c.cfg.App},
+        "api_key": {c.cfg.Username + ":" + c.cfg.Password},
+    }.Encode()
+    return u.String(), nil
+}
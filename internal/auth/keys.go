@@ -0,0 +1,266 @@
+package auth
+
+import (
+    "crypto/rand"
+    "crypto/sha256"
+    "database/sql"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/router-production/internal/database"
+    "github.com/router-production/internal/models"
+)
+
+// Scopes granted to API keys
+const (
+    // ScopeCall allows the call-processing endpoints used by the dialplan
+    ScopeCall = "call"
+    // ScopeRead allows reading statistics, calls and providers
+    ScopeRead = "read"
+    // ScopeAdmin allows everything except credentials, including provider
+    // management and reloads
+    ScopeAdmin = "admin"
+    // ScopeCredentials allows revealing provider SIP credentials. It must be
+    // granted explicitly, admin does not imply it
+    ScopeCredentials = "credentials"
+)
+
+var AllScopes = []string{ScopeCall, ScopeRead, ScopeAdmin, ScopeCredentials}
+
+const (
+    keyPrefix = "rk_"
+
+    // CacheTTL is how long a successful lookup is trusted before asking the
+    // database again. Revoking a key from another process, e.g. the CLI, takes
+    // up to this long to reach a running server
+    CacheTTL = 5 * time.Second
+)
+
+var (
+    ErrInvalidKey = errors.New("invalid API key")
+    ErrRevokedKey = errors.New("API key has been revoked")
+)
+
+type cachedKey struct {
+    key     *models.APIKey
+    expires time.Time
+}
+
+// Store manages API keys. Only a SHA-256 hash of each key is stored; the
+// plaintext is shown once when the key is created.
+type Store struct {
+    db    *database.DB
+    mu    sync.Mutex
+    cache map[string]cachedKey
+    now   func() time.Time
+}
+
+func NewStore(db *database.DB) *Store {
+    return &Store{
+        db:    db,
+        cache: make(map[string]cachedKey),
+        now:   time.Now,
+    }
+}
+
+// HasScope reports whether the key grants scope. Admin keys grant every
+// scope but credentials.
+func HasScope(key *models.APIKey, scope string) bool {
+    for _, s := range key.Scopes {
+        if s == scope || (s == ScopeAdmin && scope != ScopeCredentials) {
+            return true
+        }
+    }
+    return false
+}
+
+// ValidateScopes checks that every scope is known.
+func ValidateScopes(scopes []string) error {
+    if len(scopes) == 0 {
+        return fmt.Errorf("at least one scope is required")
+    }
+
+    for _, s := range scopes {
+        known := false
+        for _, k := range AllScopes {
+            if s == k {
+                known = true
+            }
+        }
+        if !known {
+            return fmt.Errorf("unknown scope %q (valid: %s)", s, strings.Join(AllScopes, ", "))
+        }
+    }
+    return nil
+}
+
+// Create generates a new key and returns its plaintext value.
+func (s *Store) Create(name string, scopes []string) (string, *models.APIKey, error) {
+    if name == "" {
+        return "", nil, fmt.Errorf("key name is required")
+    }
+    if err := ValidateScopes(scopes); err != nil {
+        return "", nil, err
+    }
+
+    secret := make([]byte, 24)
+    if _, err := rand.Read(secret); err != nil {
+        return "", nil, fmt.Errorf("failed to generate key: %w", err)
+    }
+    plaintext := keyPrefix + hex.EncodeToString(secret)
+
+    key := &models.APIKey{
+        Name:      name,
+        Prefix:    plaintext[:len(keyPrefix)+8],
+        KeyHash:   hashKey(plaintext),
+        Scopes:    scopes,
+        CreatedAt: time.Now(),
+    }
+
+    scopesJSON, _ := json.Marshal(scopes)
+    result, err := s.db.Exec(`
+        INSERT INTO api_keys (name, key_prefix, key_hash, scopes)
+        VALUES (?, ?, ?, ?)
+    `, key.Name, key.Prefix, key.KeyHash, scopesJSON)
+    if err != nil {
+        return "", nil, fmt.Errorf("failed to store API key: %w", err)
+    }
+
+    id, _ := result.LastInsertId()
+    key.ID = int(id)
+
+    return plaintext, key, nil
+}
+
+// List returns all keys, including revoked ones.
+func (s *Store) List() ([]*models.APIKey, error) {
+    rows, err := s.db.Query(`
+        SELECT id, name, key_prefix, key_hash, scopes, created_at, last_used_at, revoked_at
+        FROM api_keys
+        ORDER BY id
+    `)
+    if err != nil {
+        return nil, fmt.Errorf("failed to list API keys: %w", err)
+    }
+    defer rows.Close()
+
+    keys := []*models.APIKey{}
+    for rows.Next() {
+        key, err := scanKey(rows)
+        if err != nil {
+            return nil, err
+        }
+        keys = append(keys, key)
+    }
+    return keys, rows.Err()
+}
+
+// Revoke disables a key given its name or numeric ID.
+func (s *Store) Revoke(nameOrID string) error {
+    query := "UPDATE api_keys SET revoked_at = NOW() WHERE name = ? AND revoked_at IS NULL"
+    var arg interface{} = nameOrID
+    if id, err := strconv.Atoi(nameOrID); err == nil {
+        query = "UPDATE api_keys SET revoked_at = NOW() WHERE id = ? AND revoked_at IS NULL"
+        arg = id
+    }
+
+    result, err := s.db.Exec(query, arg)
+    if err != nil {
+        return fmt.Errorf("failed to revoke API key: %w", err)
+    }
+    if n, _ := result.RowsAffected(); n == 0 {
+        return fmt.Errorf("no active API key %s", nameOrID)
+    }
+
+    s.mu.Lock()
+    s.cache = make(map[string]cachedKey)
+    s.mu.Unlock()
+
+    return nil
+}
+
+// Authenticate resolves a plaintext key to its record.
+func (s *Store) Authenticate(plaintext string) (*models.APIKey, error) {
+    if !strings.HasPrefix(plaintext, keyPrefix) {
+        return nil, ErrInvalidKey
+    }
+    hash := hashKey(plaintext)
+
+    s.mu.Lock()
+    cached, ok := s.cache[hash]
+    s.mu.Unlock()
+    if ok && s.now().Before(cached.expires) {
+        return cached.key, nil
+    }
+
+    row := s.db.QueryRow(`
+        SELECT id, name, key_prefix, key_hash, scopes, created_at, last_used_at, revoked_at
+        FROM api_keys
+        WHERE key_hash = ?
+    `, hash)
+    key, err := scanKey(row)
+    if err == sql.ErrNoRows {
+        return nil, ErrInvalidKey
+    }
+    if err != nil {
+        return nil, err
+    }
+    if key.RevokedAt != nil {
+        return nil, ErrRevokedKey
+    }
+
+    s.mu.Lock()
+    s.cache[hash] = cachedKey{key: key, expires: s.now().Add(CacheTTL)}
+    s.mu.Unlock()
+
+    return key, nil
+}
+
+// RecordUsage writes an audit entry for a request made with a key.
+func (s *Store) RecordUsage(keyID int, method, path, remoteAddr string, status int) error {
+    if _, err := s.db.Exec(`
+        INSERT INTO api_key_audit (api_key_id, method, path, remote_addr, status)
+        VALUES (?, ?, ?, ?, ?)
+    `, keyID, method, path, remoteAddr, status); err != nil {
+        return fmt.Errorf("failed to record API key usage: %w", err)
+    }
+
+    _, err := s.db.Exec("UPDATE api_keys SET last_used_at = NOW() WHERE id = ?", keyID)
+    return err
+}
+
+// RecordCertUsage writes an audit entry for a request authenticated by a
+// client certificate.
+func (s *Store) RecordCertUsage(identity, method, path, remoteAddr string, status int) error {
+    if _, err := s.db.Exec(`
+        INSERT INTO client_cert_audit (identity, method, path, remote_addr, status)
+        VALUES (?, ?, ?, ?, ?)
+    `, identity, method, path, remoteAddr, status); err != nil {
+        return fmt.Errorf("failed to record client certificate usage: %w", err)
+    }
+    return nil
+}
+
+func hashKey(plaintext string) string {
+    sum := sha256.Sum256([]byte(plaintext))
+    return hex.EncodeToString(sum[:])
+}
+
+func scanKey(row interface{ Scan(...interface{}) error }) (*models.APIKey, error) {
+    key := &models.APIKey{}
+    var scopesJSON []byte
+    err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.KeyHash, &scopesJSON,
+        &key.CreatedAt, &key.LastUsedAt, &key.RevokedAt)
+    if err != nil {
+        return nil, err
+    }
+
+    json.Unmarshal(scopesJSON, &key.Scopes)
+    return key, nil
+}
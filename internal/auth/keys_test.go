@@ -0,0 +1,215 @@
+package auth
+
+import (
+    "database/sql/driver"
+    "encoding/json"
+    "errors"
+    "strings"
+    "testing"
+    "time"
+    
+    "github.com/router-production/internal/database/dbtest"
+    "github.com/router-production/internal/models"
+)
+
+func TestHasScope(t *testing.T) {
+    tests := []struct {
+        scopes []string
+        scope  string
+        want   bool
+    }{
+        {[]string{ScopeCall}, ScopeCall, true},
+        {[]string{ScopeCall}, ScopeRead, false},
+        {[]string{ScopeRead}, ScopeAdmin, false},
+        {[]string{ScopeAdmin}, ScopeCall, true},
+        {[]string{ScopeAdmin}, ScopeRead, true},
+        {[]string{ScopeAdmin}, ScopeAdmin, true},
+        {[]string{ScopeAdmin}, ScopeCredentials, false},
+        {[]string{ScopeCredentials}, ScopeCredentials, true},
+        {[]string{ScopeCredentials}, ScopeRead, false},
+        {[]string{ScopeRead, ScopeCredentials}, ScopeCredentials, true},
+        {nil, ScopeCall, false},
+    }
+    
+    for _, tt := range tests {
+        key := &models.APIKey{Scopes: tt.scopes}
+        if got := HasScope(key, tt.scope); got != tt.want {
+            t.Errorf("HasScope(%v, %s) = %v, want %v", tt.scopes, tt.scope, got, tt.want)
+        }
+    }
+}
+
+func TestValidateScopes(t *testing.T) {
+    tests := []struct {
+        scopes  []string
+        wantErr string
+    }{
+        {[]string{ScopeCall}, ""},
+        {AllScopes, ""},
+        {nil, "at least one scope"},
+        {[]string{}, "at least one scope"},
+        {[]string{ScopeRead, "write"}, `unknown scope "write"`},
+        {[]string{"Admin"}, `unknown scope "Admin"`},
+    }
+    
+    for _, tt := range tests {
+        err := ValidateScopes(tt.scopes)
+        if tt.wantErr == "" && err != nil {
+            t.Errorf("ValidateScopes(%v) = %v", tt.scopes, err)
+        }
+        if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+            t.Errorf("ValidateScopes(%v) = %v, want %q", tt.scopes, err, tt.wantErr)
+        }
+    }
+}
+
+// keyColumns are the api_keys columns scanKey reads.
+var keyColumns = []string{"id", "name", "key_prefix", "key_hash", "scopes", "created_at", "last_used_at", "revoked_at"}
+
+// fakeKeys serves api_keys lookups for the given plaintext keys; revoked
+// is consulted on every lookup so tests can revoke keys from "another process".
+func fakeKeys(t *testing.T, keys map[string][]string, revoked map[string]bool) (*dbtest.DB, *Store) {
+    t.Helper()
+    fake, db := dbtest.New()
+    fake.Handle("FROM api_keys WHERE key_hash = ?", func(args []driver.Value) (*dbtest.Result, error) {
+        for plaintext, scopes := range keys {
+            if hashKey(plaintext) != args[0] {
+                continue
+            }
+            scopesJSON, _ := json.Marshal(scopes)
+            var revokedAt interface{}
+            if revoked[plaintext] {
+                revokedAt = time.Now()
+            }
+            return dbtest.Rows(keyColumns, []driver.Value{
+                int64(1), "dialplan", plaintext[:11], hashKey(plaintext), scopesJSON, time.Now(), nil, revokedAt,
+            }), nil
+        }
+        return dbtest.Rows(keyColumns), nil
+    })
+    return fake, NewStore(db)
+}
+
+func TestAuthenticate(t *testing.T) {
+    const good, gone = "rk_0123456789abcdef", "rk_fedcba9876543210"
+    fake, store := fakeKeys(t, map[string][]string{good: {ScopeCall}, gone: {ScopeRead}}, map[string]bool{gone: true})
+    
+    key, err := store.Authenticate(good)
+    if err != nil || key.Name != "dialplan" || !HasScope(key, ScopeCall) {
+        t.Fatalf("Authenticate = %+v, %v", key, err)
+    }
+    
+    tests := []struct {
+        plaintext string
+        want      error
+    }{
+        {"0123456789abcdef", ErrInvalidKey},
+        {"rk_unknown", ErrInvalidKey},
+        {gone, ErrRevokedKey},
+    }
+    for _, tt := range tests {
+        if _, err := store.Authenticate(tt.plaintext); !errors.Is(err, tt.want) {
+            t.Errorf("Authenticate(%q) = %v, want %v", tt.plaintext, err, tt.want)
+        }
+    }
+    
+    // Only the hash reaches the database
+    for _, s := range fake.Statements("FROM api_keys") {
+        if s.Args[0] == good || s.Args[0] == gone {
+            t.Errorf("plaintext key sent to the database: %q", s.Query)
+        }
+    }
+    // Keys without the prefix are refused without a lookup
+    if n := len(fake.Statements("FROM api_keys")); n != 3 {
+        t.Errorf("%d lookups, want 3", n)
+    }
+}
+
+func TestAuthenticateCache(t *testing.T) {
+    const plaintext = "rk_0123456789abcdef"
+    revoked := map[string]bool{}
+    fake, store := fakeKeys(t, map[string][]string{plaintext: {ScopeCall}}, revoked)
+    now := time.Unix(1700000000, 0)
+    store.now = func() time.Time { return now }
+    lookups := func() int { return len(fake.Statements("FROM api_keys")) }
+    
+    if _, err := store.Authenticate(plaintext); err != nil {
+        t.Fatal(err)
+    }
+    
+    // Revoked by another process: still accepted from the cache until it expires
+    revoked[plaintext] = true
+    now = now.Add(CacheTTL - time.Second)
+    if _, err := store.Authenticate(plaintext); err != nil || lookups() != 1 {
+        t.Errorf("cached lookup = %v after %d lookups, want the cached key", err, lookups())
+    }
+    
+    now = now.Add(time.Second)
+    if _, err := store.Authenticate(plaintext); !errors.Is(err, ErrRevokedKey) || lookups() != 2 {
+        t.Errorf("expired cache = %v after %d lookups, want ErrRevokedKey", err, lookups())
+    }
+}
+
+func TestRevokeClearsCache(t *testing.T) {
+    const plaintext = "rk_0123456789abcdef"
+    revoked := map[string]bool{}
+    fake, store := fakeKeys(t, map[string][]string{plaintext: {ScopeAdmin}}, revoked)
+    fake.Handle("UPDATE api_keys SET revoked_at", func(args []driver.Value) (*dbtest.Result, error) {
+        if args[0] != "dialplan" {
+            return dbtest.Affected(0), nil
+        }
+        revoked[plaintext] = true
+        return dbtest.Affected(1), nil
+    })
+    
+    if _, err := store.Authenticate(plaintext); err != nil {
+        t.Fatal(err)
+    }
+    if err := store.Revoke("unknown"); err == nil {
+        t.Errorf("revoking an unknown key succeeded")
+    }
+    if err := store.Revoke("dialplan"); err != nil {
+        t.Fatalf("Revoke: %v", err)
+    }
+    if _, err := store.Authenticate(plaintext); !errors.Is(err, ErrRevokedKey) {
+        t.Errorf("Authenticate after Revoke = %v, want ErrRevokedKey", err)
+    }
+    
+    // Numeric arguments revoke by ID
+    store.Revoke("7")
+    if s := fake.Statements("WHERE id = ?"); len(s) != 1 || s[0].Args[0] != int64(7) {
+        t.Errorf("revoke by ID ran %+v", s)
+    }
+}
+
+func TestCreateStoresHash(t *testing.T) {
+    fake, db := dbtest.New()
+    fake.Handle("INSERT INTO api_keys", func([]driver.Value) (*dbtest.Result, error) {
+        return &dbtest.Result{LastInsertID: 4, RowsAffected: 1}, nil
+    })
+    
+    plaintext, key, err := NewStore(db).Create("dialplan", []string{ScopeCall})
+    if err != nil {
+        t.Fatalf("Create: %v", err)
+    }
+    if !strings.HasPrefix(plaintext, keyPrefix) || key.ID != 4 || !strings.HasPrefix(plaintext, key.Prefix) {
+        t.Errorf("Create = %q, %+v", plaintext, key)
+    }
+    
+    insert := fake.Statements("INSERT INTO api_keys")[0]
+    if insert.Args[2] != hashKey(plaintext) {
+        t.Errorf("stored %v, want the key hash", insert.Args[2])
+    }
+    for _, arg := range insert.Args {
+        if arg == plaintext {
+            t.Errorf("plaintext key stored")
+        }
+    }
+    
+    if _, _, err := NewStore(db).Create("", []string{ScopeCall}); err == nil {
+        t.Errorf("key without a name created")
+    }
+    if _, _, err := NewStore(db).Create("x", []string{"root"}); err == nil {
+        t.Errorf("key with an unknown scope created")
+    }
+}
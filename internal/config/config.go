@@ -0,0 +1,575 @@
+package config
+
+import (
+    "fmt"
+    "net"
+    "os"
+    "reflect"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/go-sql-driver/mysql"
+    "gopkg.in/yaml.v3"
+)
+
+const (
+    // DefaultPath is where the server looks for its configuration file
+    DefaultPath = "/etc/router/router.yaml"
+
+    // EnvPrefix prefixes environment variable overrides, e.g. ROUTER_DATABASE_HOST
+    EnvPrefix = "ROUTER"
+
+    maskedSecret = "********"
+)
+
+type Config struct {
+    Database DatabaseConfig `yaml:"database"`
+    API      APIConfig      `yaml:"api"`
+    AGI      AGIConfig      `yaml:"agi"`
+    AMI      AMIConfig      `yaml:"ami"`
+    ARI      ARIConfig      `yaml:"ari"`
+    Asterisk AsteriskConfig `yaml:"asterisk"`
+    Timeouts TimeoutsConfig `yaml:"timeouts"`
+    Routing  RoutingConfig  `yaml:"routing"`
+    Logging  LoggingConfig  `yaml:"logging"`
+    Reload   ReloadConfig   `yaml:"reload"`
+    Secrets  SecretsConfig  `yaml:"secrets"`
+    // RateLimits can be changed with a reload
+    RateLimits RateLimitsConfig `yaml:"rate_limits"`
+}
+
+type DatabaseConfig struct {
+    Host            string   `yaml:"host"`
+    Port            int      `yaml:"port"`
+    User            string   `yaml:"user"`
+    Password        string   `yaml:"password"`
+    Name            string   `yaml:"name"`
+    MaxOpenConns    int      `yaml:"max_open_conns"`
+    MaxIdleConns    int      `yaml:"max_idle_conns"`
+    ConnMaxLifetime Duration `yaml:"conn_max_lifetime"`
+}
+
+type APIConfig struct {
+    Port            int      `yaml:"port"`
+    ReadTimeout     Duration `yaml:"read_timeout"`
+    WriteTimeout    Duration `yaml:"write_timeout"`
+    ShutdownTimeout Duration `yaml:"shutdown_timeout"`
+    // AuthEnabled requires an API key on every endpoint except /api/health
+    AuthEnabled bool `yaml:"auth_enabled"`
+    // CORSOrigins lists browser origins allowed to call the API
+    CORSOrigins []string `yaml:"cors_origins"`
+    // CallAllowlist restricts processIncoming/processReturn to these CIDRs
+    CallAllowlist []string `yaml:"call_allowlist"`
+    // AdminAllowlist restricts the admin and credential endpoints
+    AdminAllowlist []string `yaml:"admin_allowlist"`
+    // TrustedProxies are allowed to set X-Forwarded-For
+    TrustedProxies []string `yaml:"trusted_proxies"`
+    TLS            TLSConfig `yaml:"tls"`
+}
+
+type TLSConfig struct {
+    // CertFile and KeyFile enable HTTPS; replaced files are picked up
+    // without a restart
+    CertFile string `yaml:"cert_file"`
+    KeyFile  string `yaml:"key_file"`
+    // ClientCAFile verifies client certificates issued by this CA
+    ClientCAFile string `yaml:"client_ca_file"`
+    // RequireClientCert rejects clients without a valid certificate
+    RequireClientCert bool `yaml:"require_client_cert"`
+    // ClientNames are the certificate CNs or DNS SANs that authenticate with
+    // the call scope; empty accepts any certificate from the client CA
+    ClientNames []string `yaml:"client_names"`
+}
+
+// Enabled reports whether HTTPS is configured.
+func (t TLSConfig) Enabled() bool {
+    return t.CertFile != "" || t.KeyFile != ""
+}
+
+type AGIConfig struct {
+    // Enabled runs the FastAGI server next to the HTTP API
+    Enabled bool `yaml:"enabled"`
+    // BindAddress is the address the server listens on; empty listens on
+    // all interfaces. Sessions are restricted by api.call_allowlist and
+    // rate_limits.per_ip like the call endpoints.
+    BindAddress string `yaml:"bind_address"`
+    Port        int    `yaml:"port"`
+}
+
+type AMIConfig struct {
+    // Enabled tracks calls through the Asterisk Manager Interface, ending
+    // them and releasing their DIDs on hangup
+    Enabled  bool   `yaml:"enabled"`
+    Address  string `yaml:"address"`
+    Username string `yaml:"username"`
+    Secret   string `yaml:"secret"`
+}
+
+type ARIConfig struct {
+    // Enabled runs the Stasis application, which routes calls entering
+    // Stasis(<app>,incoming) or Stasis(<app>,return) itself
+    Enabled  bool   `yaml:"enabled"`
+    URL      string `yaml:"url"`
+    Username string `yaml:"username"`
+    Password string `yaml:"password"`
+    App      string `yaml:"app"`
+}
+
+type AsteriskConfig struct {
+    ConfigDir     string `yaml:"config_dir"`
+    RecordingPath string `yaml:"recording_path"`
+    // ReloadBackend applies provider changes: ami, exec (asterisk -rx) or
+    // auto, which uses AMI when enabled and falls back to exec
+    ReloadBackend string `yaml:"reload_backend"`
+    // ValidateCommand is run with the changed files as arguments before each
+    // reload; a non-zero exit rolls them back
+    ValidateCommand string `yaml:"validate_command"`
+    // TemplateDir holds pjsip, extensions, transports and router .conf.tmpl files
+    // replacing the built-in templates, and template sets in subdirectories
+    TemplateDir string `yaml:"template_dir"`
+    // Dialplan generates the router contexts from this configuration
+    Dialplan DialplanConfig `yaml:"dialplan"`
+}
+
+// DialplanConfig controls the router dialplan written to extensions_router.conf.
+type DialplanConfig struct {
+    // Enabled generates the incoming, return and failure contexts; when off
+    // they are maintained by hand
+    Enabled bool `yaml:"enabled"`
+    // Mode is how the dialplan reaches the router: agi, curl or ari. Empty
+    // uses ari or agi when enabled, otherwise curl
+    Mode string `yaml:"mode"`
+    // RouterHost is the address Asterisk reaches the router's API and AGI
+    // server at
+    RouterHost string `yaml:"router_host"`
+    // APIKey is sent by CURL() when api.auth_enabled is set; it needs the
+    // call scope
+    APIKey          string `yaml:"api_key"`
+    // Provider contexts send calls to the provider's DIDs to ReturnContext
+    // and all other calls to IncomingContext
+    IncomingContext string `yaml:"incoming_context"`
+    ReturnContext   string `yaml:"return_context"`
+    // DialTimeout limits how long each Dial() rings; 0 waits until answered
+    DialTimeout Duration `yaml:"dial_timeout"`
+    // FailureCauses maps router error codes to the Q.850 cause failed calls
+    // are hung up with, on top of the built-in ones
+    FailureCauses map[string]int `yaml:"failure_causes"`
+}
+
+// ResolvedMode returns the mode, picking one from the enabled servers when
+// none is set.
+func (d DialplanConfig) ResolvedMode(c *Config) string {
+    switch {
+    case d.Mode != "":
+        return d.Mode
+    case c.ARI.Enabled:
+        return "ari"
+    case c.AGI.Enabled:
+        return "agi"
+    }
+    return "curl"
+}
+
+type TimeoutsConfig struct {
+    ReturnTimeout   Duration `yaml:"return_timeout"`
+    MaxCallDuration Duration `yaml:"max_call_duration"`
+    CleanupInterval Duration `yaml:"cleanup_interval"`
+}
+
+type RoutingConfig struct {
+    // DefaultProvider is used when no rule matches the DNIS
+    DefaultProvider string `yaml:"default_provider"`
+    // Rules maps DNIS prefixes to provider names, longest prefix wins
+    Rules map[string]string `yaml:"rules"`
+}
+
+type LoggingConfig struct {
+    // File receives the log output; empty logs to stderr
+    File string `yaml:"file"`
+}
+
+type ReloadConfig struct {
+    // PollInterval is how often the database is checked for provider and
+    // DID changes; 0 disables polling
+    PollInterval Duration `yaml:"poll_interval"`
+}
+
+type SecretsConfig struct {
+    // KeyFile holds the provider password encryption keys, one <id>:<base64>
+    // per line, primary first
+    KeyFile string `yaml:"key_file"`
+    // Key gives the keys inline, usually through ROUTER_SECRETS_KEY; it
+    // takes precedence over KeyFile
+    Key string `yaml:"key"`
+}
+
+type RateLimitsConfig struct {
+    // PerIP limits call requests per client address
+    PerIP RateLimit `yaml:"per_ip"`
+    // PerANI limits new calls per calling number
+    PerANI RateLimit `yaml:"per_ani"`
+    // PerProvider limits new calls sent to each provider; Providers
+    // overrides it by provider name
+    PerProvider RateLimit            `yaml:"per_provider"`
+    Providers   map[string]RateLimit `yaml:"providers"`
+}
+
+// RateLimit allows Rate calls per second with bursts of Burst; 0 disables it.
+type RateLimit struct {
+    Rate  float64 `yaml:"rate"`
+    Burst int     `yaml:"burst"`
+}
+
+// Duration is a time.Duration written as a string such as "30s" or "10m".
+type Duration time.Duration
+
+func (d Duration) Std() time.Duration {
+    return time.Duration(d)
+}
+
+func (d Duration) String() string {
+    return time.Duration(d).String()
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+    return d.String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+    parsed, err := time.ParseDuration(value.Value)
+    if err != nil {
+        return fmt.Errorf("line %d: invalid duration %q", value.Line, value.Value)
+    }
+    *d = Duration(parsed)
+    return nil
+}
+
+// Default returns the built-in configuration.
+func Default() *Config {
+    return &Config{
+        Database: DatabaseConfig{
+            Host:            "localhost",
+            Port:            3306,
+            User:            "router",
+            Name:            "call_routing",
+            MaxOpenConns:    50,
+            MaxIdleConns:    10,
+            ConnMaxLifetime: Duration(5 * time.Minute),
+        },
+        API: APIConfig{
+            Port:            8001,
+            ReadTimeout:     Duration(15 * time.Second),
+            WriteTimeout:    Duration(15 * time.Second),
+            ShutdownTimeout: Duration(30 * time.Second),
+            AuthEnabled:     true,
+        },
+        AGI: AGIConfig{
+            Port: 4573,
+        },
+        AMI: AMIConfig{
+            Address: "127.0.0.1:5038",
+        },
+        ARI: ARIConfig{
+            URL: "http://127.0.0.1:8088/ari",
+            App: "router",
+        },
+        Asterisk: AsteriskConfig{
+            ConfigDir:     "/etc/asterisk",
+            RecordingPath: "/var/spool/asterisk/recordings",
+            ReloadBackend: "auto",
+            Dialplan: DialplanConfig{
+                RouterHost:      "127.0.0.1",
+                IncomingContext: "from-s3",
+                ReturnContext:   "router-return",
+                DialTimeout:     Duration(60 * time.Second),
+            },
+        },
+        Timeouts: TimeoutsConfig{
+            ReturnTimeout:   Duration(10 * time.Minute),
+            MaxCallDuration: Duration(2 * time.Hour),
+            CleanupInterval: Duration(30 * time.Second),
+        },
+        Routing: RoutingConfig{
+            Rules: make(map[string]string),
+        },
+    }
+}
+
+// Load reads the configuration file at path on top of the defaults, then
+// applies ROUTER_* environment overrides. A missing file is only an error
+// when mustExist is set.
+func Load(path string, mustExist bool) (*Config, error) {
+    cfg := Default()
+
+    data, err := os.ReadFile(path)
+    switch {
+    case err == nil:
+        if err := yaml.Unmarshal(data, cfg); err != nil {
+            return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+        }
+    case os.IsNotExist(err) && !mustExist:
+    default:
+        return nil, fmt.Errorf("failed to read config: %w", err)
+    }
+
+    if err := applyEnv(EnvPrefix, reflect.ValueOf(cfg).Elem()); err != nil {
+        return nil, err
+    }
+
+    return cfg, nil
+}
+
+// Validate reports every problem found in the configuration.
+func (c *Config) Validate() error {
+    var problems []string
+    check := func(ok bool, format string, args ...interface{}) {
+        if !ok {
+            problems = append(problems, fmt.Sprintf(format, args...))
+        }
+    }
+
+    check(c.Database.Host != "", "database.host is required")
+    check(validPort(c.Database.Port), "database.port %d is out of range", c.Database.Port)
+    check(c.Database.User != "", "database.user is required")
+    check(c.Database.Password != "", "database.password is required")
+    check(c.Database.Name != "", "database.name is required")
+    check(c.Database.MaxOpenConns > 0, "database.max_open_conns must be positive")
+
+    check(validPort(c.API.Port), "api.port %d is out of range", c.API.Port)
+    check(c.API.ShutdownTimeout > 0, "api.shutdown_timeout must be positive")
+    if c.API.TLS.Enabled() {
+        check(c.API.TLS.CertFile != "" && c.API.TLS.KeyFile != "", "api.tls needs both cert_file and key_file")
+    }
+    check(c.API.TLS.Enabled() || c.API.TLS.ClientCAFile == "", "api.tls.client_ca_file requires cert_file and key_file")
+    check(c.API.TLS.ClientCAFile != "" || !c.API.TLS.RequireClientCert, "api.tls.require_client_cert requires client_ca_file")
+    check(c.API.TLS.ClientCAFile != "" || len(c.API.TLS.ClientNames) == 0, "api.tls.client_names requires client_ca_file")
+    for _, entry := range c.API.CallAllowlist {
+        check(validNetwork(entry), "api.call_allowlist: invalid CIDR %q", entry)
+    }
+    for _, entry := range c.API.AdminAllowlist {
+        check(validNetwork(entry), "api.admin_allowlist: invalid CIDR %q", entry)
+    }
+    for _, entry := range c.API.TrustedProxies {
+        check(validNetwork(entry), "api.trusted_proxies: invalid CIDR %q", entry)
+    }
+
+    check(!c.AGI.Enabled || validPort(c.AGI.Port), "agi.port %d is out of range", c.AGI.Port)
+    check(c.AGI.BindAddress == "" || net.ParseIP(c.AGI.BindAddress) != nil, "agi.bind_address %q is not an IP address", c.AGI.BindAddress)
+    
+    if c.AMI.Enabled {
+        check(c.AMI.Address != "", "ami.address is required")
+        check(c.AMI.Username != "", "ami.username is required")
+    }
+    
+    if c.ARI.Enabled {
+        check(c.ARI.URL != "", "ari.url is required")
+        check(c.ARI.Username != "", "ari.username is required")
+        check(c.ARI.App != "", "ari.app is required")
+    }
+    
+    check(c.Asterisk.ConfigDir != "", "asterisk.config_dir is required")
+    switch c.Asterisk.ReloadBackend {
+    case "auto", "exec":
+    case "ami":
+        check(c.AMI.Address != "", "ami.address is required for asterisk.reload_backend ami")
+        check(c.AMI.Username != "", "ami.username is required for asterisk.reload_backend ami")
+    default:
+        check(false, "asterisk.reload_backend must be auto, ami or exec, got %q", c.Asterisk.ReloadBackend)
+    }
+    if d := c.Asterisk.Dialplan; d.Enabled {
+        switch d.ResolvedMode(c) {
+        case "agi":
+            check(c.AGI.Enabled, "agi.enabled is required for asterisk.dialplan.mode agi")
+        case "ari":
+            check(c.ARI.Enabled, "ari.enabled is required for asterisk.dialplan.mode ari")
+        case "curl":
+        default:
+            check(false, "asterisk.dialplan.mode must be agi, curl or ari, got %q", d.Mode)
+        }
+        check(d.RouterHost != "", "asterisk.dialplan.router_host is required")
+        check(d.IncomingContext != "", "asterisk.dialplan.incoming_context is required")
+        check(d.ReturnContext != "", "asterisk.dialplan.return_context is required")
+        check(d.IncomingContext != d.ReturnContext, "asterisk.dialplan.incoming_context and return_context must differ")
+        check(d.DialTimeout >= 0, "asterisk.dialplan.dial_timeout must not be negative")
+        for code, cause := range d.FailureCauses {
+            check(cause > 0 && cause < 128, "asterisk.dialplan.failure_causes[%s]: cause %d is out of range", code, cause)
+        }
+    }
+
+    check(c.Timeouts.ReturnTimeout > 0, "timeouts.return_timeout must be positive")
+    check(c.Timeouts.MaxCallDuration > 0, "timeouts.max_call_duration must be positive")
+    check(c.Timeouts.CleanupInterval > 0, "timeouts.cleanup_interval must be positive")
+    check(c.Reload.PollInterval >= 0, "reload.poll_interval must not be negative")
+
+    for prefix, provider := range c.Routing.Rules {
+        check(provider != "", "routing.rules[%s] has no provider", prefix)
+    }
+    
+    checkLimit := func(name string, l RateLimit) {
+        check(l.Rate >= 0 && l.Burst >= 0, "%s must not be negative", name)
+    }
+    checkLimit("rate_limits.per_ip", c.RateLimits.PerIP)
+    checkLimit("rate_limits.per_ani", c.RateLimits.PerANI)
+    checkLimit("rate_limits.per_provider", c.RateLimits.PerProvider)
+    for name, l := range c.RateLimits.Providers {
+        checkLimit("rate_limits.providers."+name, l)
+    }
+
+    if len(problems) > 0 {
+        return fmt.Errorf("invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+    }
+    return nil
+}
+
+// Masked returns a copy of the configuration with secrets hidden, for display.
+func (c *Config) Masked() *Config {
+    m := *c
+    if m.Database.Password != "" {
+        m.Database.Password = maskedSecret
+    }
+    if m.ARI.Password != "" {
+        m.ARI.Password = maskedSecret
+    }
+    if m.AMI.Secret != "" {
+        m.AMI.Secret = maskedSecret
+    }
+    if m.Secrets.Key != "" {
+        m.Secrets.Key = maskedSecret
+    }
+    if m.Asterisk.Dialplan.APIKey != "" {
+        m.Asterisk.Dialplan.APIKey = maskedSecret
+    }
+    return &m
+}
+
+// YAML renders the configuration as a YAML document.
+func (c *Config) YAML() (string, error) {
+    data, err := yaml.Marshal(c)
+    if err != nil {
+        return "", err
+    }
+    return string(data), nil
+}
+
+// DSN returns the MySQL data source name for the database settings. The
+// driver's defaults are kept, so credentials may contain any character.
+func (d DatabaseConfig) DSN() string {
+    c := mysql.NewConfig()
+    c.User = d.User
+    c.Passwd = d.Password
+    c.Net = "tcp"
+    c.Addr = net.JoinHostPort(d.Host, strconv.Itoa(d.Port))
+    c.DBName = d.Name
+    c.ParseTime = true
+    return c.FormatDSN()
+}
+
+func validPort(port int) bool {
+    return port > 0 && port < 65536
+}
+
+// validNetwork accepts a CIDR or a single address.
+func validNetwork(entry string) bool {
+    if _, _, err := net.ParseCIDR(entry); err == nil {
+        return true
+    }
+    return net.ParseIP(entry) != nil
+}
+
+var durationType = reflect.TypeOf(Duration(0))
+
+// applyEnv overrides fields from environment variables named after their
+// yaml keys, e.g. database.password -> ROUTER_DATABASE_PASSWORD. Maps are
+// given as comma separated key=value pairs, slices as comma separated values.
+func applyEnv(prefix string, v reflect.Value) error {
+    t := v.Type()
+    for i := 0; i < t.NumField(); i++ {
+        name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+        if name == "" || name == "-" {
+            continue
+        }
+
+        key := prefix + "_" + strings.ToUpper(name)
+        field := v.Field(i)
+
+        if field.Kind() == reflect.Struct {
+            if err := applyEnv(key, field); err != nil {
+                return err
+            }
+            continue
+        }
+
+        raw, ok := os.LookupEnv(key)
+        if !ok {
+            continue
+        }
+        if err := setField(field, raw); err != nil {
+            return fmt.Errorf("invalid %s: %w", key, err)
+        }
+    }
+    return nil
+}
+
+func setField(field reflect.Value, raw string) error {
+    if field.Type() == durationType {
+        d, err := time.ParseDuration(raw)
+        if err != nil {
+            return err
+        }
+        field.SetInt(int64(d))
+        return nil
+    }
+
+    switch field.Kind() {
+    case reflect.String:
+        field.SetString(raw)
+    case reflect.Float64:
+        f, err := strconv.ParseFloat(raw, 64)
+        if err != nil {
+            return err
+        }
+        field.SetFloat(f)
+    case reflect.Int, reflect.Int64:
+        n, err := strconv.ParseInt(raw, 10, 64)
+        if err != nil {
+            return err
+        }
+        field.SetInt(n)
+    case reflect.Bool:
+        b, err := strconv.ParseBool(raw)
+        if err != nil {
+            return err
+        }
+        field.SetBool(b)
+    case reflect.Slice:
+        values := splitList(raw)
+        field.Set(reflect.ValueOf(values))
+    case reflect.Map:
+        if field.Type() != reflect.TypeOf(map[string]string{}) {
+            return fmt.Errorf("unsupported field type %s", field.Type())
+        }
+        m := make(map[string]string)
+        for _, pair := range splitList(raw) {
+            k, v, ok := strings.Cut(pair, "=")
+            if !ok {
+                return fmt.Errorf("expected key=value, got %q", pair)
+            }
+            m[strings.TrimSpace(k)] = strings.TrimSpace(v)
+        }
+        field.Set(reflect.ValueOf(m))
+    default:
+        return fmt.Errorf("unsupported field type %s", field.Type())
+    }
+    return nil
+}
+
+func splitList(raw string) []string {
+    values := []string{}
+    for _, part := range strings.Split(raw, ",") {
+        if part = strings.TrimSpace(part); part != "" {
+            values = append(values, part)
+        }
+    }
+    return values
+}
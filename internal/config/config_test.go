@@ -0,0 +1,280 @@
+package config
+
+import (
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+    
+    "github.com/go-sql-driver/mysql"
+)
+
+func writeConfig(t *testing.T, content string) string {
+    t.Helper()
+    path := filepath.Join(t.TempDir(), "router.yaml")
+    if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+        t.Fatal(err)
+    }
+    return path
+}
+
+// validConfig returns the defaults plus the settings that have none.
+func validConfig() *Config {
+    c := Default()
+    c.Database.Password = "secret"
+    return c
+}
+
+func TestLoadFileOverDefaults(t *testing.T) {
+    path := writeConfig(t, `
+database:
+  host: db.internal
+  password: from-file
+api:
+  port: 9000
+  read_timeout: 5s
+routing:
+  rules:
+    "1212": carrier-a
+`)
+
+    cfg, err := Load(path, true)
+    if err != nil {
+        t.Fatalf("Load: %v", err)
+    }
+    if cfg.Database.Host != "db.internal" || cfg.Database.Password != "from-file" {
+        t.Errorf("database = %+v", cfg.Database)
+    }
+    if cfg.API.Port != 9000 || cfg.API.ReadTimeout.Std() != 5*time.Second {
+        t.Errorf("api port %d, read_timeout %s", cfg.API.Port, cfg.API.ReadTimeout)
+    }
+    // Unset keys keep their defaults
+    if cfg.Database.Port != 3306 || cfg.API.WriteTimeout.Std() != 15*time.Second {
+        t.Errorf("defaults lost: database.port %d, api.write_timeout %s", cfg.Database.Port, cfg.API.WriteTimeout)
+    }
+    if cfg.Routing.Rules["1212"] != "carrier-a" {
+        t.Errorf("routing.rules = %v", cfg.Routing.Rules)
+    }
+}
+
+func TestLoadMissingFile(t *testing.T) {
+    missing := filepath.Join(t.TempDir(), "missing.yaml")
+
+    cfg, err := Load(missing, false)
+    if err != nil {
+        t.Fatalf("Load optional file: %v", err)
+    }
+    if !reflect.DeepEqual(cfg, Default()) {
+        t.Errorf("missing optional file did not give the defaults")
+    }
+
+    if _, err := Load(missing, true); err == nil {
+        t.Errorf("Load required missing file succeeded")
+    }
+}
+
+func TestLoadEnvOverrides(t *testing.T) {
+    path := writeConfig(t, "database:\n  password: from-file\n")
+
+    t.Setenv("ROUTER_DATABASE_PASSWORD", "from-env")
+    t.Setenv("ROUTER_DATABASE_PORT", "3307")
+    t.Setenv("ROUTER_API_AUTH_ENABLED", "false")
+    t.Setenv("ROUTER_API_SHUTDOWN_TIMEOUT", "45s")
+    t.Setenv("ROUTER_API_CALL_ALLOWLIST", "10.0.0.0/8, 192.0.2.1,")
+    t.Setenv("ROUTER_API_TLS_CERT_FILE", "/etc/router/tls.crt")
+    t.Setenv("ROUTER_ROUTING_RULES", "1212=carrier-a, 44 = carrier-b")
+    t.Setenv("ROUTER_RATE_LIMITS_PER_IP_RATE", "2.5")
+    t.Setenv("ROUTER_ASTERISK_DIALPLAN_API_KEY", "dialplan-key")
+
+    cfg, err := Load(path, true)
+    if err != nil {
+        t.Fatalf("Load: %v", err)
+    }
+
+    checks := []struct {
+        name string
+        got  interface{}
+        want interface{}
+    }{
+        {"database.password", cfg.Database.Password, "from-env"},
+        {"database.port", cfg.Database.Port, 3307},
+        {"api.auth_enabled", cfg.API.AuthEnabled, false},
+        {"api.shutdown_timeout", cfg.API.ShutdownTimeout.Std(), 45 * time.Second},
+        {"api.call_allowlist", cfg.API.CallAllowlist, []string{"10.0.0.0/8", "192.0.2.1"}},
+        {"api.tls.cert_file", cfg.API.TLS.CertFile, "/etc/router/tls.crt"},
+        {"routing.rules", cfg.Routing.Rules, map[string]string{"1212": "carrier-a", "44": "carrier-b"}},
+        {"rate_limits.per_ip.rate", cfg.RateLimits.PerIP.Rate, 2.5},
+        {"asterisk.dialplan.api_key", cfg.Asterisk.Dialplan.APIKey, "dialplan-key"},
+    }
+    for _, c := range checks {
+        if !reflect.DeepEqual(c.got, c.want) {
+            t.Errorf("%s = %#v, want %#v", c.name, c.got, c.want)
+        }
+    }
+}
+
+func TestLoadInvalidEnv(t *testing.T) {
+    tests := []struct {
+        key   string
+        value string
+    }{
+        {"ROUTER_DATABASE_PORT", "mysql"},
+        {"ROUTER_API_AUTH_ENABLED", "maybe"},
+        {"ROUTER_API_READ_TIMEOUT", "15"},
+        {"ROUTER_ROUTING_RULES", "1212"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.key, func(t *testing.T) {
+            t.Setenv(tt.key, tt.value)
+            _, err := Load(filepath.Join(t.TempDir(), "missing.yaml"), false)
+            if err == nil || !strings.Contains(err.Error(), tt.key) {
+                t.Errorf("err = %v, want an error naming %s", err, tt.key)
+            }
+        })
+    }
+}
+
+func TestLoadInvalidDuration(t *testing.T) {
+    path := writeConfig(t, "api:\n  read_timeout: soon\n")
+    if _, err := Load(path, true); err == nil || !strings.Contains(err.Error(), "invalid duration") {
+        t.Errorf("err = %v, want invalid duration", err)
+    }
+}
+
+func TestValidate(t *testing.T) {
+    tests := []struct {
+        name   string
+        modify func(c *Config)
+        // want are substrings of the expected problems; none means valid
+        want []string
+    }{
+        {"valid", func(c *Config) {}, nil},
+        {"missing database password", func(c *Config) { c.Database.Password = "" }, []string{"database.password is required"}},
+        {"several problems at once", func(c *Config) {
+            c.Database.Host = ""
+            c.API.Port = 70000
+        }, []string{"database.host is required", "api.port 70000 is out of range"}},
+        {"bad allowlist entry", func(c *Config) { c.API.CallAllowlist = []string{"10.0.0.0/33"} }, []string{`invalid CIDR "10.0.0.0/33"`}},
+        {"single address allowlist", func(c *Config) { c.API.AdminAllowlist = []string{"192.0.2.10"} }, nil},
+        {"tls without key", func(c *Config) { c.API.TLS.CertFile = "cert.pem" }, []string{"needs both cert_file and key_file"}},
+        {"client cert without CA", func(c *Config) {
+            c.API.TLS.CertFile, c.API.TLS.KeyFile = "cert.pem", "key.pem"
+            c.API.TLS.RequireClientCert = true
+        }, []string{"require_client_cert requires client_ca_file"}},
+        {"client names without CA", func(c *Config) {
+            c.API.TLS.CertFile, c.API.TLS.KeyFile = "cert.pem", "key.pem"
+            c.API.TLS.ClientNames = []string{"pbx-1"}
+        }, []string{"client_names requires client_ca_file"}},
+        {"agi bind address not an IP", func(c *Config) { c.AGI.BindAddress = "router.local" }, []string{`agi.bind_address "router.local"`}},
+        {"ami without user", func(c *Config) { c.AMI.Enabled = true }, []string{"ami.username is required"}},
+        {"unknown reload backend", func(c *Config) { c.Asterisk.ReloadBackend = "ssh" }, []string{"reload_backend must be auto, ami or exec"}},
+        {"rule without provider", func(c *Config) { c.Routing.Rules["1"] = "" }, []string{"routing.rules[1] has no provider"}},
+        {"negative rate limit", func(c *Config) { c.RateLimits.PerANI.Rate = -1 }, []string{"rate_limits.per_ani must not be negative"}},
+        {"dialplan agi without agi server", func(c *Config) {
+            c.Asterisk.Dialplan.Enabled = true
+            c.Asterisk.Dialplan.Mode = "agi"
+        }, []string{"agi.enabled is required"}},
+        {"dialplan same contexts", func(c *Config) {
+            c.Asterisk.Dialplan.Enabled = true
+            c.Asterisk.Dialplan.ReturnContext = c.Asterisk.Dialplan.IncomingContext
+        }, []string{"incoming_context and return_context must differ"}},
+        {"dialplan cause out of range", func(c *Config) {
+            c.Asterisk.Dialplan.Enabled = true
+            c.Asterisk.Dialplan.FailureCauses = map[string]int{"no_did_available": 200}
+        }, []string{"failure_causes[no_did_available]"}},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            c := validConfig()
+            tt.modify(c)
+            err := c.Validate()
+
+            if len(tt.want) == 0 {
+                if err != nil {
+                    t.Errorf("Validate: %v", err)
+                }
+                return
+            }
+            if err == nil {
+                t.Fatalf("Validate succeeded, want %v", tt.want)
+            }
+            for _, want := range tt.want {
+                if !strings.Contains(err.Error(), want) {
+                    t.Errorf("error %q does not mention %q", err, want)
+                }
+            }
+        })
+    }
+}
+
+func TestDialplanResolvedMode(t *testing.T) {
+    tests := []struct {
+        name string
+        mode string
+        agi  bool
+        ari  bool
+        want string
+    }{
+        {"explicit", "curl", true, true, "curl"},
+        {"ari first", "", true, true, "ari"},
+        {"agi", "", true, false, "agi"},
+        {"curl fallback", "", false, false, "curl"},
+    }
+
+    for _, tt := range tests {
+        c := validConfig()
+        c.AGI.Enabled, c.ARI.Enabled = tt.agi, tt.ari
+        c.Asterisk.Dialplan.Mode = tt.mode
+        if got := c.Asterisk.Dialplan.ResolvedMode(c); got != tt.want {
+            t.Errorf("%s: ResolvedMode = %q, want %q", tt.name, got, tt.want)
+        }
+    }
+}
+
+func TestMasked(t *testing.T) {
+    c := validConfig()
+    c.AMI.Secret = "ami"
+    c.Secrets.Key = "k1:abc"
+    c.Asterisk.Dialplan.APIKey = "key"
+
+    m := c.Masked()
+    for name, got := range map[string]string{
+        "database.password":         m.Database.Password,
+        "ami.secret":                m.AMI.Secret,
+        "secrets.key":               m.Secrets.Key,
+        "asterisk.dialplan.api_key": m.Asterisk.Dialplan.APIKey,
+    } {
+        if got != maskedSecret {
+            t.Errorf("%s = %q, want it masked", name, got)
+        }
+    }
+    // Unset secrets stay empty so the output shows they are missing
+    if m.ARI.Password != "" {
+        t.Errorf("ari.password = %q, want empty", m.ARI.Password)
+    }
+    if c.Database.Password != "secret" {
+        t.Errorf("Masked changed the original configuration")
+    }
+}
+
+func TestDSN(t *testing.T) {
+    d := DatabaseConfig{Host: "db.internal", Port: 3306, User: "router", Password: "p@ss/w:rd?", Name: "router"}
+    
+    parsed, err := mysql.ParseDSN(d.DSN())
+    if err != nil {
+        t.Fatalf("ParseDSN(%q): %v", d.DSN(), err)
+    }
+    if parsed.User != d.User || parsed.Passwd != d.Password || parsed.Addr != "db.internal:3306" ||
+        parsed.DBName != d.Name || !parsed.ParseTime {
+        t.Errorf("DSN %q parses to %+v", d.DSN(), parsed)
+    }
+    
+    d.Host = "::1"
+    if parsed, err := mysql.ParseDSN(d.DSN()); err != nil || parsed.Addr != "[::1]:3306" {
+        t.Errorf("IPv6 DSN %q = %v, %v", d.DSN(), parsed, err)
+    }
+}
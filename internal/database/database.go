@@ -47,6 +47,21 @@ func (db *DB) CreateTables() error {
             max_channels INT DEFAULT 100,
             active BOOLEAN DEFAULT TRUE,
             country VARCHAR(50),
+            return_timeout INT DEFAULT 0,
+            max_call_duration INT DEFAULT 0,
+            media_encryption VARCHAR(20) NOT NULL DEFAULT '',
+            tls_cert_file VARCHAR(255) NOT NULL DEFAULT '',
+            tls_key_file VARCHAR(255) NOT NULL DEFAULT '',
+            tls_ca_file VARCHAR(255) NOT NULL DEFAULT '',
+            tls_verify_server BOOLEAN NOT NULL DEFAULT FALSE,
+            registration VARCHAR(20) NOT NULL DEFAULT 'none',
+            registration_expiration INT NOT NULL DEFAULT 0,
+            registration_retry_interval INT NOT NULL DEFAULT 0,
+            registration_server_uri VARCHAR(255) NOT NULL DEFAULT '',
+            registration_client_uri VARCHAR(255) NOT NULL DEFAULT '',
+            match_addresses JSON,
+            contacts JSON,
+            template VARCHAR(100) NOT NULL DEFAULT '',
             created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
             updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
             INDEX idx_name (name),
@@ -97,6 +112,42 @@ func (db *DB) CreateTables() error {
             created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
             FOREIGN KEY (provider_id) REFERENCES providers(id) ON DELETE CASCADE
         )`,
+        
+        `CREATE TABLE IF NOT EXISTS api_keys (
+            id INT AUTO_INCREMENT PRIMARY KEY,
+            name VARCHAR(100) UNIQUE NOT NULL,
+            key_prefix VARCHAR(20) NOT NULL,
+            key_hash CHAR(64) UNIQUE NOT NULL,
+            scopes JSON,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            last_used_at TIMESTAMP NULL,
+            revoked_at TIMESTAMP NULL
+        )`,
+        
+        `CREATE TABLE IF NOT EXISTS api_key_audit (
+            id BIGINT AUTO_INCREMENT PRIMARY KEY,
+            api_key_id INT NOT NULL,
+            method VARCHAR(10),
+            path VARCHAR(255),
+            remote_addr VARCHAR(100),
+            status INT,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            INDEX idx_api_key (api_key_id),
+            INDEX idx_created_at (created_at),
+            FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+        )`,
+        
+        `CREATE TABLE IF NOT EXISTS client_cert_audit (
+            id BIGINT AUTO_INCREMENT PRIMARY KEY,
+            identity VARCHAR(255) NOT NULL,
+            method VARCHAR(10),
+            path VARCHAR(255),
+            remote_addr VARCHAR(100),
+            status INT,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            INDEX idx_identity (identity),
+            INDEX idx_created_at (created_at)
+        )`,
     }
     
     for _, query := range queries {
@@ -105,5 +156,53 @@ func (db *DB) CreateTables() error {
         }
     }
     
+    return db.addMissingColumns()
+}
+
+// columnMigrations lists columns added after a table was first created, so
+// databases created by older versions are brought up to date.
+var columnMigrations = []struct {
+    table      string
+    column     string
+    definition string
+}{
+    {"providers", "return_timeout", "INT DEFAULT 0"},
+    {"providers", "max_call_duration", "INT DEFAULT 0"},
+    {"providers", "media_encryption", "VARCHAR(20) NOT NULL DEFAULT ''"},
+    {"providers", "tls_cert_file", "VARCHAR(255) NOT NULL DEFAULT ''"},
+    {"providers", "tls_key_file", "VARCHAR(255) NOT NULL DEFAULT ''"},
+    {"providers", "tls_ca_file", "VARCHAR(255) NOT NULL DEFAULT ''"},
+    {"providers", "tls_verify_server", "BOOLEAN NOT NULL DEFAULT FALSE"},
+    {"providers", "registration", "VARCHAR(20) NOT NULL DEFAULT 'none'"},
+    {"providers", "registration_expiration", "INT NOT NULL DEFAULT 0"},
+    {"providers", "registration_retry_interval", "INT NOT NULL DEFAULT 0"},
+    {"providers", "registration_server_uri", "VARCHAR(255) NOT NULL DEFAULT ''"},
+    {"providers", "registration_client_uri", "VARCHAR(255) NOT NULL DEFAULT ''"},
+    {"providers", "match_addresses", "JSON"},
+    {"providers", "contacts", "JSON"},
+    {"providers", "template", "VARCHAR(100) NOT NULL DEFAULT ''"},
+}
+
+func (db *DB) addMissingColumns() error {
+    for _, m := range columnMigrations {
+        var count int
+        err := db.QueryRow(`
+            SELECT COUNT(*) FROM information_schema.columns
+            WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?
+        `, m.table, m.column).Scan(&count)
+        if err != nil {
+            return fmt.Errorf("failed to check column %s.%s: %w", m.table, m.column, err)
+        }
+        
+        if count > 0 {
+            continue
+        }
+        
+        query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", m.table, m.column, m.definition)
+        if _, err := db.Exec(query); err != nil {
+            return fmt.Errorf("failed to add column %s.%s: %w", m.table, m.column, err)
+        }
+    }
+    
     return nil
 }
@@ -0,0 +1,240 @@
+// Package dbtest provides a fake database/sql driver answering queries from
+// handlers, for exercising code written against MySQL without a server.
+package dbtest
+
+import (
+    "context"
+    "database/sql"
+    "database/sql/driver"
+    "fmt"
+    "io"
+    "strings"
+    "sync"
+    
+    "github.com/router-production/internal/database"
+)
+
+// Result is the answer to a statement: rows for queries, counts for execs.
+type Result struct {
+    Columns      []string
+    Rows         [][]driver.Value
+    LastInsertID int64
+    RowsAffected int64
+}
+
+// Rows returns a query result with the given columns and rows.
+func Rows(columns []string, rows ...[]driver.Value) *Result {
+    return &Result{Columns: columns, Rows: rows}
+}
+
+// Affected returns an exec result changing n rows.
+func Affected(n int64) *Result {
+    return &Result{RowsAffected: n}
+}
+
+// HandlerFunc answers a statement matched by Handle.
+type HandlerFunc func(args []driver.Value) (*Result, error)
+
+// Statement is a statement the fake database received.
+type Statement struct {
+    Query string
+    Args  []driver.Value
+}
+
+type handler struct {
+    fragment string
+    fn       HandlerFunc
+}
+
+// DB is a fake database. Statements go to the last handler whose fragment
+// they contain; others return no rows and change nothing.
+type DB struct {
+    mu         sync.Mutex
+    handlers   []handler
+    statements []Statement
+}
+
+// New returns a fake database and a database.DB connected to it.
+func New() (*DB, *database.DB) {
+    f := &DB{}
+    return f, &database.DB{DB: sql.OpenDB(f)}
+}
+
+// Handle answers statements containing fragment, which is matched after
+// collapsing whitespace, e.g. "UPDATE dids SET in_use = 0".
+func (f *DB) Handle(fragment string, fn HandlerFunc) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.handlers = append(f.handlers, handler{fragment: normalize(fragment), fn: fn})
+}
+
+// Statements returns the statements received that contain fragment.
+func (f *DB) Statements(fragment string) []Statement {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    
+    fragment = normalize(fragment)
+    var matched []Statement
+    for _, s := range f.statements {
+        if strings.Contains(s.Query, fragment) {
+            matched = append(matched, s)
+        }
+    }
+    return matched
+}
+
+func (f *DB) run(query string, args []driver.NamedValue) (*Result, error) {
+    values := make([]driver.Value, len(args))
+    for i, a := range args {
+        values[i] = a.Value
+    }
+    query = normalize(query)
+    
+    f.mu.Lock()
+    f.statements = append(f.statements, Statement{Query: query, Args: values})
+    var fn HandlerFunc
+    for i := len(f.handlers) - 1; i >= 0; i-- {
+        if strings.Contains(query, f.handlers[i].fragment) {
+            fn = f.handlers[i].fn
+            break
+        }
+    }
+    f.mu.Unlock()
+    
+    if fn == nil {
+        return &Result{}, nil
+    }
+    result, err := fn(values)
+    if result == nil {
+        result = &Result{}
+    }
+    return result, err
+}
+
+func normalize(query string) string {
+    return strings.Join(strings.Fields(query), " ")
+}
+
+// Connect implements driver.Connector.
+func (f *DB) Connect(context.Context) (driver.Conn, error) {
+    return &conn{db: f}, nil
+}
+
+// Driver implements driver.Connector.
+func (f *DB) Driver() driver.Driver {
+    return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+    return nil, fmt.Errorf("dbtest: use dbtest.New")
+}
+
+type conn struct {
+    db *DB
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+    return &stmt{conn: c, query: query}, nil
+}
+
+func (c *conn) Close() error {
+    return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+    return tx{}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+    r, err := c.db.run(query, args)
+    if err != nil {
+        return nil, err
+    }
+    return result{r}, nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+    r, err := c.db.run(query, args)
+    if err != nil {
+        return nil, err
+    }
+    return &rows{result: r}, nil
+}
+
+type stmt struct {
+    conn  *conn
+    query string
+}
+
+func (s *stmt) Close() error {
+    return nil
+}
+
+func (s *stmt) NumInput() int {
+    return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+    return s.conn.ExecContext(context.Background(), s.query, named(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+    return s.conn.QueryContext(context.Background(), s.query, named(args))
+}
+
+func named(args []driver.Value) []driver.NamedValue {
+    values := make([]driver.NamedValue, len(args))
+    for i, v := range args {
+        values[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+    }
+    return values
+}
+
+type tx struct{}
+
+func (tx) Commit() error {
+    return nil
+}
+
+func (tx) Rollback() error {
+    return nil
+}
+
+type result struct {
+    r *Result
+}
+
+func (r result) LastInsertId() (int64, error) {
+    return r.r.LastInsertID, nil
+}
+
+func (r result) RowsAffected() (int64, error) {
+    return r.r.RowsAffected, nil
+}
+
+type rows struct {
+    result *Result
+    next   int
+}
+
+func (r *rows) Columns() []string {
+    if r.result.Columns == nil && len(r.result.Rows) > 0 {
+        return make([]string, len(r.result.Rows[0]))
+    }
+    return r.result.Columns
+}
+
+func (r *rows) Close() error {
+    return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+    if r.next >= len(r.result.Rows) {
+        return io.EOF
+    }
+    copy(dest, r.result.Rows[r.next])
+    r.next++
+    return nil
+}
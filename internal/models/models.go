@@ -5,20 +5,81 @@ import (
 )
 
 type Provider struct {
-    ID          int       `json:"id" db:"id"`
-    Name        string    `json:"name" db:"name"`
-    Host        string    `json:"host" db:"host"`
-    Port        int       `json:"port" db:"port"`
-    Username    string    `json:"username" db:"username"`
-    Password    string    `json:"password" db:"password"`
-    Realm       string    `json:"realm" db:"realm"`
-    Transport   string    `json:"transport" db:"transport"`
-    Codecs      []string  `json:"codecs" db:"codecs"`
-    MaxChannels int       `json:"max_channels" db:"max_channels"`
-    Active      bool      `json:"active" db:"active"`
-    Country     string    `json:"country" db:"country"`
-    CreatedAt   time.Time `json:"created_at" db:"created_at"`
-    UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+    ID              int       `json:"id" db:"id"`
+    Name            string    `json:"name" db:"name"`
+    Host            string    `json:"host" db:"host"`
+    Port            int       `json:"port" db:"port"`
+    Username        string    `json:"username" db:"username"`
+    // Password never leaves through JSON; use the credentials endpoint
+    Password        string    `json:"-" db:"password"`
+    Realm           string    `json:"realm" db:"realm"`
+    Transport       string    `json:"transport" db:"transport"`
+    Codecs          []string  `json:"codecs" db:"codecs"`
+    MaxChannels     int       `json:"max_channels" db:"max_channels"`
+    Active          bool      `json:"active" db:"active"`
+    Country         string    `json:"country" db:"country"`
+    // Call timeouts in seconds; 0 uses the router's global setting
+    ReturnTimeout   int       `json:"return_timeout" db:"return_timeout"`
+    MaxCallDuration int       `json:"max_call_duration" db:"max_call_duration"`
+    // SRTP mode for the endpoint: no, sdes or dtls; empty means no
+    MediaEncryption string    `json:"media_encryption" db:"media_encryption"`
+    // TLS transport settings; the certificate and key also serve DTLS
+    TLSCertFile     string    `json:"tls_cert_file" db:"tls_cert_file"`
+    TLSKeyFile      string    `json:"tls_key_file" db:"tls_key_file"`
+    TLSCAFile       string    `json:"tls_ca_file" db:"tls_ca_file"`
+    TLSVerifyServer bool      `json:"tls_verify_server" db:"tls_verify_server"`
+    // Registration is none or outbound; outbound sends REGISTER to the provider
+    Registration              string `json:"registration" db:"registration"`
+    // Registration timings in seconds; 0 uses the Asterisk default
+    RegistrationExpiration    int    `json:"registration_expiration" db:"registration_expiration"`
+    RegistrationRetryInterval int    `json:"registration_retry_interval" db:"registration_retry_interval"`
+    // Registration URIs; empty derives them from host, port and username
+    RegistrationServerURI     string `json:"registration_server_uri" db:"registration_server_uri"`
+    RegistrationClientURI     string `json:"registration_client_uri" db:"registration_client_uri"`
+    // Signalling addresses or CIDRs calls are accepted from; empty matches Host
+    MatchAddresses []string  `json:"match_addresses" db:"match_addresses"`
+    // Outbound gateways, tried in priority order; empty uses Host and Port
+    Contacts       []Contact `json:"contacts" db:"contacts"`
+    // Template selects a template set from the template directory; empty uses the default
+    Template       string    `json:"template" db:"template"`
+    CreatedAt       time.Time `json:"created_at" db:"created_at"`
+    UpdatedAt       time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Contact is an outbound gateway of a provider. Lower priorities are tried first.
+type Contact struct {
+    Host     string `json:"host"`
+    Port     int    `json:"port"`
+    Priority int    `json:"priority"`
+}
+
+// MaskedSecret replaces secrets in API and CLI output.
+const MaskedSecret = "********"
+
+// ProviderView is a provider as exposed through the API and CLI, with the
+// SIP password masked. Use ProviderCredentials for the privileged reveal.
+type ProviderView struct {
+    Provider
+    // Password shadows the provider's, which is cleared and never encoded
+    Password string `json:"password"`
+}
+
+// ProviderCredentials holds a provider's SIP authentication secrets.
+type ProviderCredentials struct {
+    Name     string `json:"name"`
+    Username string `json:"username"`
+    Password string `json:"password"`
+    Realm    string `json:"realm"`
+}
+
+// View returns the provider with its secrets masked.
+func (p *Provider) View() *ProviderView {
+    v := &ProviderView{Provider: *p}
+    v.Provider.Password = ""
+    if p.Password != "" {
+        v.Password = MaskedSecret
+    }
+    return v
 }
 
 type DID struct {
@@ -67,3 +128,14 @@ type CallResponse struct {
     ProviderName string `json:"provider_name"`
     TrunkName    string `json:"trunk_name"`
 }
+
+type APIKey struct {
+    ID         int        `json:"id" db:"id"`
+    Name       string     `json:"name" db:"name"`
+    Prefix     string     `json:"prefix" db:"key_prefix"`
+    KeyHash    string     `json:"-" db:"key_hash"`
+    Scopes     []string   `json:"scopes" db:"scopes"`
+    CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+    LastUsedAt *time.Time `json:"last_used_at" db:"last_used_at"`
+    RevokedAt  *time.Time `json:"revoked_at" db:"revoked_at"`
+}
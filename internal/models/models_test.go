@@ -0,0 +1,40 @@
+package models
+
+import (
+    "encoding/json"
+    "strings"
+    "testing"
+)
+
+func TestProviderView(t *testing.T) {
+    p := &Provider{Name: "carrier", Host: "198.51.100.10", Username: "trunk", Password: "s3cret-sip"}
+    
+    v := p.View()
+    if v.Password != MaskedSecret || v.Provider.Password != "" || v.Name != "carrier" || v.Username != "trunk" {
+        t.Errorf("View = %+v", v)
+    }
+    if p.Password != "s3cret-sip" {
+        t.Errorf("View changed the provider's password")
+    }
+    
+    out, err := json.Marshal(v)
+    if err != nil {
+        t.Fatal(err)
+    }
+    body := string(out)
+    if strings.Contains(body, "s3cret-sip") || strings.Count(body, `"password"`) != 1 ||
+        !strings.Contains(body, `"password":"`+MaskedSecret+`"`) || !strings.Contains(body, `"host":"198.51.100.10"`) {
+        t.Errorf("view JSON = %s", body)
+    }
+    
+    // The provider itself never encodes its password
+    if out, _ := json.Marshal(p); strings.Contains(string(out), "s3cret-sip") {
+        t.Errorf("provider JSON reveals the password: %s", out)
+    }
+    
+    // No password is shown as none, not as a mask
+    empty := (&Provider{Name: "open"}).View()
+    if empty.Password != "" {
+        t.Errorf("empty password masked as %q", empty.Password)
+    }
+}
@@ -0,0 +1,46 @@
+// Package netlist parses the address lists used to restrict who may reach the
+// router's HTTP API and FastAGI server.
+package netlist
+
+import (
+    "fmt"
+    "net"
+    "strings"
+)
+
+// Parse parses CIDRs, accepting bare addresses as single hosts.
+func Parse(entries []string) ([]*net.IPNet, error) {
+    nets := make([]*net.IPNet, 0, len(entries))
+    for _, entry := range entries {
+        entry = strings.TrimSpace(entry)
+        if !strings.Contains(entry, "/") {
+            ip := net.ParseIP(entry)
+            if ip == nil {
+                return nil, fmt.Errorf("invalid address %q", entry)
+            }
+            bits := 128
+            if ip.To4() != nil {
+                ip, bits = ip.To4(), 32
+            }
+            nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+            continue
+        }
+        
+        _, n, err := net.ParseCIDR(entry)
+        if err != nil {
+            return nil, fmt.Errorf("invalid CIDR %q", entry)
+        }
+        nets = append(nets, n)
+    }
+    return nets, nil
+}
+
+// Contains reports whether ip is in any of nets.
+func Contains(nets []*net.IPNet, ip net.IP) bool {
+    for _, n := range nets {
+        if n.Contains(ip) {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,55 @@
+package netlist
+
+import (
+    "net"
+    "testing"
+)
+
+func TestParse(t *testing.T) {
+    tests := []struct {
+        name    string
+        entries []string
+        in      []string
+        out     []string
+        wantErr bool
+    }{
+        {name: "empty", entries: nil, out: []string{"10.0.0.1"}},
+        {name: "bare IPv4", entries: []string{"10.0.0.1"}, in: []string{"10.0.0.1", "::ffff:10.0.0.1"}, out: []string{"10.0.0.2"}},
+        {name: "IPv4 CIDR", entries: []string{"10.1.0.0/16"}, in: []string{"10.1.0.1", "10.1.255.254"}, out: []string{"10.2.0.1"}},
+        {name: "CIDR with host bits", entries: []string{"10.1.2.3/24"}, in: []string{"10.1.2.200"}, out: []string{"10.1.3.1"}},
+        {name: "bare IPv6", entries: []string{"2001:db8::1"}, in: []string{"2001:db8::1"}, out: []string{"2001:db8::2"}},
+        {name: "IPv6 CIDR", entries: []string{"2001:db8::/32"}, in: []string{"2001:db8:ffff::1"}, out: []string{"2001:db9::1", "10.0.0.1"}},
+        {name: "whitespace", entries: []string{" 192.0.2.1 ", "\t198.51.100.0/24"}, in: []string{"192.0.2.1", "198.51.100.7"}},
+        {name: "mixed", entries: []string{"127.0.0.1", "::1", "10.0.0.0/8"}, in: []string{"127.0.0.1", "::1", "10.9.9.9"}, out: []string{"127.0.0.2"}},
+        {name: "hostname", entries: []string{"pbx.example.com"}, wantErr: true},
+        {name: "bad CIDR", entries: []string{"10.0.0.0/33"}, wantErr: true},
+        {name: "bad IPv6 CIDR", entries: []string{"2001:db8::/129"}, wantErr: true},
+        {name: "empty entry", entries: []string{""}, wantErr: true},
+        {name: "one bad among good", entries: []string{"10.0.0.1", "10.0.0.300"}, wantErr: true},
+    }
+    
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            nets, err := Parse(tt.entries)
+            if tt.wantErr {
+                if err == nil {
+                    t.Errorf("Parse(%q) accepted", tt.entries)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("Parse(%q): %v", tt.entries, err)
+            }
+            for _, ip := range tt.in {
+                if !Contains(nets, net.ParseIP(ip)) {
+                    t.Errorf("%s not contained", ip)
+                }
+            }
+            for _, ip := range tt.out {
+                if Contains(nets, net.ParseIP(ip)) {
+                    t.Errorf("%s contained", ip)
+                }
+            }
+        })
+    }
+}
@@ -1,38 +1,67 @@
 package provider
 
 import (
+    "bytes"
+    "context"
+    "errors"
     "fmt"
+    "log"
     "os"
-    "os/exec"  // Add this import
     "path/filepath"
     "strings"
     "text/template"
     
     "github.com/router-production/internal/models"
+    "github.com/router-production/internal/secrets"
 )
 
 type AsteriskConfigGenerator struct {
     configPath string
-    templates  map[string]*template.Template
+    // templates holds the template sets by name; "" is the default set
+    templates  map[string]map[string]*template.Template
+    keyring    *secrets.Keyring
+    reloader   Reloader
+    validate   ValidateFunc
+    // dialplan is nil when the router dialplan is maintained by hand
+    dialplan   *RouterDialplan
+    // dids returns a provider's DIDs; the caller of Render guards them
+    dids       func(provider string) []string
 }
 
-func NewAsteriskConfigGenerator() *AsteriskConfigGenerator {
+// NewAsteriskConfigGenerator returns a generator writing to configPath.
+// Templates in templateDir replace the built-in ones; every template is
+// checked before the generator is returned. The router dialplan is only
+// generated when dialplan is set.
+func NewAsteriskConfigGenerator(configPath string, keyring *secrets.Keyring, reloader Reloader, validate ValidateFunc, templateDir string, dialplan *RouterDialplan) (*AsteriskConfigGenerator, error) {
+    if reloader == nil {
+        reloader = &ExecReloader{}
+    }
+    if dialplan != nil {
+        if err := validateDialplan(dialplan); err != nil {
+            return nil, err
+        }
+    }
     g := &AsteriskConfigGenerator{
-        configPath: "/etc/asterisk",
-        templates:  make(map[string]*template.Template),
+        configPath: configPath,
+        keyring:    keyring,
+        reloader:   reloader,
+        validate:   validate,
+        dialplan:   dialplan,
     }
     
-    g.loadTemplates()
-    return g
+    if err := g.loadTemplates(templateDir); err != nil {
+        return nil, err
+    }
+    return g, nil
 }
 
-func (g *AsteriskConfigGenerator) loadTemplates() {
+func (g *AsteriskConfigGenerator) loadTemplates(dir string) error {
     // PJSIP endpoint template
     pjsipTemplate := `
 ;========== Provider: {{.Name}} ==========
 [trunk-{{.Name}}]
 type=endpoint
-transport=transport-udp
+transport=transport-{{.Transport}}
 context=from-provider-{{.Name}}
 disallow=all
 {{range .Codecs}}allow={{.}}
@@ -44,104 +73,298 @@ force_rport=yes
 rewrite_contact=yes
 rtp_symmetric=yes
 {{if .MaxChannels}}max_audio_streams={{.MaxChannels}}{{end}}
-
+{{if and .MediaEncryption (ne .MediaEncryption "no")}}media_encryption={{.MediaEncryption}}
+{{end}}{{if eq .MediaEncryption "dtls"}}dtls_verify=fingerprint
+dtls_setup=actpass
+{{if .TLSCertFile}}dtls_cert_file={{.TLSCertFile}}
+dtls_private_key={{.TLSKeyFile}}
+{{else}}dtls_auto_generate_cert=yes
+{{end}}{{if .TLSCAFile}}dtls_ca_file={{.TLSCAFile}}
+{{end}}{{end}}
 [trunk-{{.Name}}-aor]
 type=aor
-contact=sip:{{.Host}}:{{.Port}}
-qualify_frequency=30
+{{range contacts .}}contact=sip:{{hostPort .Host .Port}}{{uriTransport $}}
+{{end}}qualify_frequency=30
 max_contacts=1
 
 [trunk-{{.Name}}-identify]
 type=identify
 endpoint=trunk-{{.Name}}
-match={{.Host}}
-
+{{range matchAddresses .}}match={{.}}
+{{end}}
 {{if .Username}}
 [trunk-{{.Name}}-auth]
 type=auth
 auth_type=userpass
 username={{.Username}}
-password={{.Password}}
+password={{decrypt .Password .Name}}
 {{if .Realm}}realm={{.Realm}}{{end}}
 {{end}}
+{{if eq .Registration "outbound"}}
+[trunk-{{.Name}}-reg]
+type=registration
+transport=transport-{{.Transport}}
+outbound_auth=trunk-{{.Name}}-auth
+server_uri={{registrationServerURI .}}
+client_uri={{registrationClientURI .}}
+contact_user={{.Username}}
+{{if .RegistrationExpiration}}expiration={{.RegistrationExpiration}}
+{{end}}{{if .RegistrationRetryInterval}}retry_interval={{.RegistrationRetryInterval}}
+{{end}}line=yes
+endpoint=trunk-{{.Name}}
+{{end}}
 `
     
-    g.templates["pjsip"] = template.Must(template.New("pjsip").Parse(pjsipTemplate))
-    
     // Extensions context template
     extensionsTemplate := `
 [from-provider-{{.Name}}]
-; Incoming calls from provider {{.Name}}
-exten => _X.,1,NoOp(Call from provider {{.Name}})
-exten => _X.,n,Goto(from-s3,${EXTEN},1)
+; Incoming calls from provider {{.Name}}{{with returnDIDs .}}; calls to its DIDs are return calls
+{{range .}}exten => {{.}},1,Goto({{returnContext}},${EXTEN},1)
+{{end}}{{else}}
+{{end}}exten => _X.,1,NoOp(Call from provider {{.Name}})
+exten => _X.,n,Goto({{providerContext}},${EXTEN},1)
+
+[dial-trunk-{{.Name}}]
+; Gosub(dial-trunk-{{.Name}},s,1(<number>[,<timeout>])) tries the contacts in priority
+; order, moving on only while they are unreachable
+exten => s,1,NoOp(Dial ${ARG1} via provider {{.Name}})
+{{range contacts .}}same => n,Dial(PJSIP/trunk-{{$.Name}}/sip:${ARG1}@{{hostPort .Host .Port}}{{uriTransport $}},${ARG2})
+same => n,GotoIf($["${DIALSTATUS}" != "CHANUNAVAIL" & "${DIALSTATUS}" != "CONGESTION"]?done)
+{{end}}same => n(done),Return()
+; Local/<number>@dial-trunk-{{.Name}}/n does the same for calls originated over ARI
+exten => _[+0-9].,1,Gosub(s,1(${EXTEN}))
+same => n,Hangup(${HANGUPCAUSE})
 `
     
-    g.templates["extensions"] = template.Must(template.New("extensions").Parse(extensionsTemplate))
+    
+    // Transports used by the provider endpoints and not defined in pjsip.conf
+    transportsTemplate := `; Transports used by provider endpoints, generated by the router.
+; Define a section in pjsip.conf instead to manage it yourself.
+{{range .}}
+[{{.Name}}]
+type=transport
+protocol={{.Protocol}}
+bind={{.Bind}}
+{{if eq .Protocol "tls"}}method=tlsv1_2
+{{if .CertFile}}cert_file={{.CertFile}}
+priv_key_file={{.KeyFile}}
+{{end}}{{if .CAFile}}ca_list_file={{.CAFile}}
+{{end}}verify_server={{if .VerifyServer}}yes{{else}}no{{end}}
+{{end}}{{end}}`
+    
+    // Router contexts calling the router for the incoming and return legs
+    routerTemplate := `; Router dialplan, generated by the router ({{.Mode}} mode).
+; [{{.IncomingContext}}] routes calls to a provider DID, [{{.ReturnContext}}] sends calls
+; coming back on a DID to S4 with their original numbers. Provider contexts send calls to
+; their DIDs to [{{.ReturnContext}}] and all other calls to [{{.IncomingContext}}].
+
+[{{.IncomingContext}}]
+exten => _[+0-9].,1,NoOp(Router: incoming call from ${CALLERID(num)} to ${EXTEN})
+{{if eq .Mode "ari"}}same => n,Stasis({{.ARIApp}},incoming)
+same => n,Hangup()
+{{else}}{{if eq .Mode "agi"}}same => n,AGI({{.AGIURL}}/incoming,${UNIQUEID})
+{{else}}{{template "timeouts"}}same => n,Set(ROUTER_RESPONSE=${CURL({{.APIURL}}/api/processIncoming?format=text{{if .APIKey}}&key={{.APIKey}}{{end}}&callid=${UNIQUEID}&ani=${URIENCODE(${CALLERID(num)})}&dnis=${URIENCODE(${EXTEN})})})
+{{template "parse"}}{{end}}same => n,GotoIf($["${ROUTER_STATUS}" != "success"]?router-failed,s,1)
+{{if eq .Mode "agi"}}same => n,Set(CHANNEL(hangup_handler_push)=router-hangup,s,1)
+{{end}}same => n,Set(CALLERID(num)=${ANI_TO_SEND})
+same => n,Gosub(dial-${NEXT_HOP},s,1(${DNIS_TO_SEND},{{if .DialTimeout}}{{.DialTimeout}}{{end}}))
+same => n,Hangup()
+{{end}}
+[{{.ReturnContext}}]
+exten => _[+0-9].,1,NoOp(Router: return call from ${CALLERID(num)} on DID ${EXTEN})
+{{if eq .Mode "ari"}}same => n,Stasis({{.ARIApp}},return)
+same => n,Hangup()
+{{else}}{{if eq .Mode "agi"}}same => n,AGI({{.AGIURL}}/return,${EXTEN})
+{{else}}{{template "timeouts"}}same => n,Set(ROUTER_RESPONSE=${CURL({{.APIURL}}/api/processReturn?format=text{{if .APIKey}}&key={{.APIKey}}{{end}}&ani2=${URIENCODE(${CALLERID(num)})}&did=${URIENCODE(${EXTEN})})})
+{{template "parse"}}{{end}}same => n,GotoIf($["${ROUTER_STATUS}" != "success"]?router-failed,s,1)
+same => n,Set(CALLERID(num)=${ANI_TO_SEND})
+same => n,Dial(PJSIP/${DNIS_TO_SEND}@${NEXT_HOP}{{if .DialTimeout}},{{.DialTimeout}}{{end}})
+same => n,Hangup()
+
+[router-failed]
+; Hangs up calls the router could not route, with the cause for its error code
+exten => s,1,NoOp(Router: ${ROUTER_ERROR} ${ROUTER_ERROR_MESSAGE})
+{{range .Failures}}same => n,ExecIf($["${ROUTER_ERROR}" = "{{.Code}}"]?Hangup({{.Cause}}))
+{{end}}same => n,Hangup({{.DefaultCause}})
+{{end}}{{if eq .Mode "agi"}}
+[router-hangup]
+; Hangup handler ending the call in the router
+exten => s,1,AGI({{.AGIURL}}/hangup,${UNIQUEID})
+same => n,Return()
+{{end}}
+{{- define "timeouts"}}same => n,Set(CURLOPT(conntimeout)=3)
+same => n,Set(CURLOPT(httptimeout)=10)
+{{end}}
+{{- define "parse"}}same => n,Set(ROUTER_STATUS=${CUT(ROUTER_RESPONSE,|,1)})
+same => n,Set(DID=${CUT(ROUTER_RESPONSE,|,2)})
+same => n,Set(NEXT_HOP=${CUT(ROUTER_RESPONSE,|,3)})
+same => n,Set(ANI_TO_SEND=${CUT(ROUTER_RESPONSE,|,4)})
+same => n,Set(DNIS_TO_SEND=${CUT(ROUTER_RESPONSE,|,5)})
+same => n,Set(PROVIDER_NAME=${CUT(ROUTER_RESPONSE,|,6)})
+same => n,Set(TRUNK_NAME=${CUT(ROUTER_RESPONSE,|,7)})
+same => n,Set(ROUTER_ERROR=${CUT(ROUTER_RESPONSE,|,8)})
+same => n,Set(ROUTER_ERROR_MESSAGE=${CUT(ROUTER_RESPONSE,|,9-)})
+{{end}}`
+    
+    builtins := map[string]string{
+        templatePJSIP:      pjsipTemplate,
+        templateExtensions: extensionsTemplate,
+        templateTransports: transportsTemplate,
+        templateRouter:     routerTemplate,
+    }
+    
+    funcs := g.templateFuncs()
+    defaults := make(map[string]*template.Template, len(builtins))
+    for kind, text := range builtins {
+        defaults[kind] = template.Must(template.New(kind).Funcs(funcs).Parse(text))
+    }
+    g.templates = map[string]map[string]*template.Template{"": defaults}
+    
+    if dir != "" {
+        if err := g.loadTemplateDir(dir); err != nil {
+            return err
+        }
+    }
+    return g.checkTemplates()
 }
 
-func (g *AsteriskConfigGenerator) GenerateProviderConfig(p *models.Provider) error {
-    // Generate PJSIP config
-    pjsipFile := filepath.Join(g.configPath, fmt.Sprintf("pjsip_provider_%s.conf", p.Name))
-    if err := g.generateConfig(pjsipFile, "pjsip", p); err != nil {
-        return err
+// writeProviderConfig writes the provider's PJSIP and dialplan files. Files
+// are replaced atomically with the previous version kept as .bak; apply then
+// reloads only the Asterisk modules whose configuration changed, and restores
+// the files if validation or the reload fails.
+//
+// providers are all configured providers, whose transports are regenerated
+// along with p's files, as is the router dialplan when it is generated.
+func (g *AsteriskConfigGenerator) writeProviderConfig(p *models.Provider, providers []*models.Provider) (*configWrite, error) {
+    w := &configWrite{}
+    
+    files, err := g.Render(p)
+    if err != nil {
+        return nil, err
+    }
+    transports, err := g.renderTransports(providers)
+    if err != nil {
+        return nil, err
+    }
+    files = append(files, transports)
+    if g.dialplan != nil {
+        router, err := g.RenderDialplan()
+        if err != nil {
+            return nil, err
+        }
+        files = append(files, router)
     }
     
-    // Generate extensions config
-    extFile := filepath.Join(g.configPath, fmt.Sprintf("extensions_provider_%s.conf", p.Name))
-    if err := g.generateConfig(extFile, "extensions", p); err != nil {
-        return err
+    for _, f := range files {
+        if _, err := w.write(filepath.Join(g.configPath, f.Name), f.Content); err != nil {
+            return nil, g.abort(w, err)
+        }
     }
     
-    // Update main configs to include provider configs
-    g.updateMainConfigs(p.Name)
+    includes := providerIncludes(p.Name)
+    includes["pjsip.conf"] = append([]string{"#include " + transportsFile}, includes["pjsip.conf"]...)
+    if g.dialplan != nil {
+        includes["extensions.conf"] = append([]string{"#include " + routerDialplanFile}, includes["extensions.conf"]...)
+    }
+    for main, lines := range includes {
+        for _, include := range lines {
+            if _, err := g.addIncludeIfNotExists(w, filepath.Join(g.configPath, main), include); err != nil {
+                return nil, g.abort(w, err)
+            }
+        }
+    }
     
-    // Reload Asterisk
-    if err := g.reloadAsterisk(); err != nil {
-        return fmt.Errorf("failed to reload asterisk: %w", err)
+    return w, nil
+}
+
+// Render returns the provider's generated files without writing them.
+func (g *AsteriskConfigGenerator) Render(p *models.Provider) ([]RenderedFile, error) {
+    if !g.HasTemplate(p.Template) {
+        return nil, fmt.Errorf("%w: provider %s: unknown template %q", ErrInvalidProvider, p.Name, p.Template)
     }
     
-    return nil
+    var files []RenderedFile
+    for _, kind := range []string{templatePJSIP, templateExtensions} {
+        name := fmt.Sprintf("%s_provider_%s.conf", kind, p.Name)
+        
+        var buf bytes.Buffer
+        if err := g.template(p.Template, kind).Execute(&buf, p); err != nil {
+            return nil, fmt.Errorf("failed to render %s: %w", name, err)
+        }
+        files = append(files, RenderedFile{Name: name, Content: buf.Bytes()})
+    }
+    return files, nil
 }
 
-func (g *AsteriskConfigGenerator) generateConfig(filename, templateName string, p *models.Provider) error {
-    file, err := os.Create(filename)
-    if err != nil {
-        return err
+// apply validates the written files and reloads the modules they belong to,
+// rolling everything back if Asterisk rejects them. When the reload backend
+// cannot reach Asterisk the files stay and the error wraps ErrNotApplied.
+// The result is nil when nothing had to be reloaded. Callers serialize writes and apply so a
+// rollback never restores over another change.
+func (g *AsteriskConfigGenerator) apply(ctx context.Context, w *configWrite, reload bool) (*ReloadResult, error) {
+    targets := w.targets()
+    if len(targets) == 0 {
+        return nil, nil
+    }
+    
+    if g.validate != nil {
+        if err := g.validate(ctx, w.files()); err != nil {
+            return nil, g.abort(w, fmt.Errorf("%w: %v", ErrInvalidConfig, err))
+        }
+    }
+    
+    if !reload {
+        return nil, nil
     }
-    defer file.Close()
     
-    return g.templates[templateName].Execute(file, p)
+    result, err := g.reloader.Reload(ctx, targets...)
+    if errors.Is(err, ErrReloaderUnavailable) {
+        // Asterisk never read the files, so nothing says they are bad: keep
+        // them for the next reload or restart instead of undoing the change
+        return result, fmt.Errorf("%w: %v, files kept for the next reload", ErrNotApplied, err)
+    }
+    if err != nil {
+        if rbErr := w.rollback(); rbErr != nil {
+            log.Printf("[ROUTER] %v", rbErr)
+        } else if result != nil && len(result.Commands) > 0 {
+            // Targets reload in order, so the applied ones come first
+            applied := targets[:len(result.Commands)]
+            if _, rlErr := g.reloader.Reload(ctx, applied...); rlErr != nil {
+                log.Printf("[ROUTER] Failed to reload restored config: %v", rlErr)
+            }
+        }
+        return result, fmt.Errorf("failed to reload asterisk via %s, previous config restored: %w", g.reloader.Name(), err)
+    }
+    return result, nil
 }
 
-func (g *AsteriskConfigGenerator) updateMainConfigs(providerName string) {
-    // Add include to pjsip.conf if not exists
-    pjsipInclude := fmt.Sprintf("#include pjsip_provider_%s.conf", providerName)
-    g.addIncludeIfNotExists("/etc/asterisk/pjsip.conf", pjsipInclude)
-    
-    // Add include to extensions.conf if not exists
-    extInclude := fmt.Sprintf("#include extensions_provider_%s.conf", providerName)
-    g.addIncludeIfNotExists("/etc/asterisk/extensions.conf", extInclude)
+// abort rolls back w and returns err.
+func (g *AsteriskConfigGenerator) abort(w *configWrite, err error) error {
+    if rbErr := w.rollback(); rbErr != nil {
+        log.Printf("[ROUTER] %v", rbErr)
+    }
+    return err
 }
 
-func (g *AsteriskConfigGenerator) addIncludeIfNotExists(filename, include string) {
+// providerIncludes maps the main config files to the include lines for a provider.
+func providerIncludes(name string) map[string][]string {
+    return map[string][]string{
+        "pjsip.conf":      {fmt.Sprintf("#include pjsip_provider_%s.conf", name)},
+        "extensions.conf": {fmt.Sprintf("#include extensions_provider_%s.conf", name)},
+    }
+}
+
+// addIncludeIfNotExists appends include to filename and reports whether it
+// had to be added. A missing main config is left alone.
+func (g *AsteriskConfigGenerator) addIncludeIfNotExists(w *configWrite, filename, include string) (bool, error) {
     content, err := os.ReadFile(filename)
     if err != nil {
-        return
+        return false, nil
     }
     
-    if !strings.Contains(string(content), include) {
-        file, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0644)
-        if err != nil {
-            return
-        }
-        defer file.Close()
-        
-        file.WriteString("\n" + include + "\n")
+    if strings.Contains(string(content), include) {
+        return false, nil
     }
-}
-
-// Fixed reloadAsterisk function
-func (g *AsteriskConfigGenerator) reloadAsterisk() error {
-    cmd := exec.Command("asterisk", "-rx", "core reload")
-    return cmd.Run()
+    
+    return w.write(filename, append(content, []byte("\n"+include+"\n")...))
 }
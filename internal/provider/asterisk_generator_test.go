@@ -0,0 +1,192 @@
+package provider
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    
+    "github.com/router-production/internal/models"
+)
+
+// fakeReloader records the targets it is asked to reload and fails the
+// first call with err.
+type fakeReloader struct {
+    calls   [][]string
+    err     error
+    applied int // commands reported as carried out before err
+}
+
+func (f *fakeReloader) Name() string {
+    return "fake"
+}
+
+func (f *fakeReloader) Reload(ctx context.Context, targets ...string) (*ReloadResult, error) {
+    f.calls = append(f.calls, targets)
+    result := &ReloadResult{Backend: f.Name()}
+    if len(f.calls) == 1 && f.err != nil {
+        for _, target := range targets[:f.applied] {
+            result.Commands = append(result.Commands, reloadCommands[target])
+        }
+        return result, f.err
+    }
+    for _, target := range targets {
+        result.Commands = append(result.Commands, reloadCommands[target])
+    }
+    return result, nil
+}
+
+func newTestGenerator(t *testing.T, reloader Reloader, validate ValidateFunc) (*AsteriskConfigGenerator, string) {
+    t.Helper()
+    dir := t.TempDir()
+    for _, main := range []string{"pjsip.conf", "extensions.conf"} {
+        if err := os.WriteFile(filepath.Join(dir, main), []byte("; "+main+"\n"), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    g, err := NewAsteriskConfigGenerator(dir, nil, reloader, validate, "", nil)
+    if err != nil {
+        t.Fatalf("NewAsteriskConfigGenerator: %v", err)
+    }
+    return g, dir
+}
+
+func testProvider(name string) *models.Provider {
+    return &models.Provider{
+        Name:         name,
+        Host:         "198.51.100.10",
+        Port:         5060,
+        Transport:    "udp",
+        Codecs:       []string{"ulaw"},
+        Active:       true,
+        Registration: RegistrationNone,
+    }
+}
+
+// generate writes and applies p's configuration.
+func generate(g *AsteriskConfigGenerator, p *models.Provider) (*ReloadResult, error) {
+    w, err := g.writeProviderConfig(p, []*models.Provider{p})
+    if err != nil {
+        return nil, err
+    }
+    return g.apply(context.Background(), w, true)
+}
+
+func readFile(t *testing.T, path string) string {
+    t.Helper()
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return ""
+    }
+    return string(data)
+}
+
+func TestApplyReloadsChangedModules(t *testing.T) {
+    reloader := &fakeReloader{}
+    g, dir := newTestGenerator(t, reloader, nil)
+    p := testProvider("carrier-a")
+    
+    result, err := generate(g, p)
+    if err != nil {
+        t.Fatalf("generate: %v", err)
+    }
+    if len(result.Commands) != 2 {
+        t.Errorf("commands = %q, want PJSIP and dialplan reloads", result.Commands)
+    }
+    if !strings.Contains(readFile(t, filepath.Join(dir, "pjsip.conf")), "#include pjsip_provider_carrier-a.conf") {
+        t.Errorf("pjsip.conf does not include the provider")
+    }
+    
+    // Unchanged configuration is not reloaded again
+    if result, err := generate(g, p); err != nil || result != nil {
+        t.Errorf("second generate = %v, %v, want no reload", result, err)
+    }
+    if len(reloader.calls) != 1 {
+        t.Errorf("reloaded %d times, want once", len(reloader.calls))
+    }
+}
+
+func TestApplyRollsBackRejectedConfig(t *testing.T) {
+    rejected := fmt.Errorf("module reload res_pjsip.so: The module 'res_pjsip.so' reported a reload failure.")
+    
+    tests := []struct {
+        name     string
+        validate ValidateFunc
+        reloader *fakeReloader
+        wantErr  error
+        // wantReloads counts the first reload and the reload of restored files
+        wantReloads int
+    }{
+        {
+            name:     "validation fails",
+            validate: func(ctx context.Context, files []string) error { return fmt.Errorf("syntax error") },
+            reloader: &fakeReloader{},
+            wantErr:  ErrInvalidConfig,
+        },
+        {
+            name:        "reload rejected",
+            reloader:    &fakeReloader{err: rejected},
+            wantReloads: 1,
+        },
+        {
+            name:        "dialplan reload rejected after pjsip",
+            reloader:    &fakeReloader{err: rejected, applied: 1},
+            wantReloads: 2,
+        },
+    }
+    
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            g, dir := newTestGenerator(t, tt.reloader, tt.validate)
+            pjsip := readFile(t, filepath.Join(dir, "pjsip.conf"))
+            
+            _, err := generate(g, testProvider("carrier-a"))
+            if err == nil {
+                t.Fatalf("generate succeeded")
+            }
+            if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+                t.Errorf("err = %v, want %v", err, tt.wantErr)
+            }
+            if errors.Is(err, ErrNotApplied) {
+                t.Errorf("rejected config reported as not applied: %v", err)
+            }
+            
+            // Everything written is undone
+            if _, err := os.Stat(filepath.Join(dir, "pjsip_provider_carrier-a.conf")); !os.IsNotExist(err) {
+                t.Errorf("provider file left behind: %v", err)
+            }
+            if got := readFile(t, filepath.Join(dir, "pjsip.conf")); got != pjsip {
+                t.Errorf("pjsip.conf = %q, want it restored", got)
+            }
+            if len(tt.reloader.calls) != tt.wantReloads {
+                t.Errorf("reload calls = %q, want %d", tt.reloader.calls, tt.wantReloads)
+            }
+            // Only the modules that took the bad config reload the restored one
+            if tt.wantReloads == 2 && strings.Join(tt.reloader.calls[1], ",") != ReloadPJSIP {
+                t.Errorf("restored reload = %q, want pjsip only", tt.reloader.calls[1])
+            }
+        })
+    }
+}
+
+func TestApplyKeepsFilesWhenAsteriskUnreachable(t *testing.T) {
+    reloader := &fakeReloader{err: fmt.Errorf("%w: not connected to AMI", ErrReloaderUnavailable)}
+    g, dir := newTestGenerator(t, reloader, nil)
+    
+    _, err := generate(g, testProvider("carrier-a"))
+    if !errors.Is(err, ErrNotApplied) {
+        t.Fatalf("err = %v, want ErrNotApplied", err)
+    }
+    if !strings.Contains(readFile(t, filepath.Join(dir, "pjsip_provider_carrier-a.conf")), "carrier-a") {
+        t.Errorf("provider file removed although Asterisk never read it")
+    }
+    if !strings.Contains(readFile(t, filepath.Join(dir, "pjsip.conf")), "#include pjsip_provider_carrier-a.conf") {
+        t.Errorf("include removed although Asterisk never read it")
+    }
+    if len(reloader.calls) != 1 {
+        t.Errorf("reload calls = %q, want no reload of restored files", reloader.calls)
+    }
+}
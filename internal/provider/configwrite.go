@@ -0,0 +1,196 @@
+package provider
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+)
+
+// ValidateFunc checks freshly written configuration files before Asterisk
+// reloads them. Returning an error rolls the files back.
+type ValidateFunc func(ctx context.Context, files []string) error
+
+// CommandValidator runs command with the changed files appended as
+// arguments; a non-zero exit status rejects them.
+func CommandValidator(command string) ValidateFunc {
+    args := strings.Fields(command)
+    return func(ctx context.Context, files []string) error {
+        if len(args) == 0 {
+            return nil
+        }
+        out, err := exec.CommandContext(ctx, args[0], append(args[1:], files...)...).CombinedOutput()
+        if err != nil {
+            return fmt.Errorf("%s: %v %s", args[0], err, strings.TrimSpace(string(out)))
+        }
+        return nil
+    }
+}
+
+// fileChange remembers how to undo one written file
+type fileChange struct {
+    path    string
+    backup  string // empty when the file did not exist before
+    removed bool
+}
+
+// configWrite collects the files written for one provider so they can be
+// rolled back together.
+type configWrite struct {
+    changes []fileChange
+}
+
+// write replaces path with data unless it already holds it, keeping the
+// previous version in path.bak. It reports whether the file changed.
+func (w *configWrite) write(path string, data []byte) (bool, error) {
+    existing, err := os.ReadFile(path)
+    switch {
+    case err == nil && bytes.Equal(existing, data):
+        return false, nil
+    case err != nil && !errors.Is(err, os.ErrNotExist):
+        return false, err
+    }
+    
+    // A file changed twice keeps the backup of its first change, which
+    // holds the version to restore
+    if w.changed(path) {
+        return true, writeAtomic(path, data)
+    }
+    
+    change := fileChange{path: path}
+    if err == nil {
+        change.backup = path + ".bak"
+        if err := writeAtomic(change.backup, existing); err != nil {
+            return false, fmt.Errorf("failed to back up %s: %w", path, err)
+        }
+    }
+    
+    if err := writeAtomic(path, data); err != nil {
+        return false, err
+    }
+    w.changes = append(w.changes, change)
+    return true, nil
+}
+
+// remove deletes path, keeping it in path.bak.
+func (w *configWrite) remove(path string) error {
+    existing, err := os.ReadFile(path)
+    if err != nil {
+        return err
+    }
+    
+    change := fileChange{path: path, backup: path + ".bak", removed: true}
+    if err := writeAtomic(change.backup, existing); err != nil {
+        return fmt.Errorf("failed to back up %s: %w", path, err)
+    }
+    if err := os.Remove(path); err != nil {
+        return err
+    }
+    w.changes = append(w.changes, change)
+    return nil
+}
+
+// changed reports whether path was already written or removed.
+func (w *configWrite) changed(path string) bool {
+    for _, c := range w.changes {
+        if c.path == path {
+            return true
+        }
+    }
+    return false
+}
+
+// files returns the paths written so far, leaving out removed files.
+func (w *configWrite) files() []string {
+    var files []string
+    for _, c := range w.changes {
+        if !c.removed {
+            files = append(files, c.path)
+        }
+    }
+    return files
+}
+
+// targets returns the reload targets affected by the changes, PJSIP first.
+func (w *configWrite) targets() []string {
+    var pjsip, dialplan bool
+    for _, c := range w.changes {
+        if strings.HasPrefix(filepath.Base(c.path), "pjsip") {
+            pjsip = true
+        } else {
+            dialplan = true
+        }
+    }
+    
+    var targets []string
+    if pjsip {
+        targets = append(targets, ReloadPJSIP)
+    }
+    if dialplan {
+        targets = append(targets, ReloadDialplan)
+    }
+    return targets
+}
+
+// rollback restores every written file from its backup, newest first, and
+// removes files that did not exist before.
+func (w *configWrite) rollback() error {
+    var failed []string
+    for i := len(w.changes) - 1; i >= 0; i-- {
+        c := w.changes[i]
+        
+        var err error
+        if c.backup == "" {
+            err = os.Remove(c.path)
+        } else {
+            var data []byte
+            if data, err = os.ReadFile(c.backup); err == nil {
+                err = writeAtomic(c.path, data)
+            }
+        }
+        if err != nil {
+            failed = append(failed, fmt.Sprintf("%s: %v", c.path, err))
+        }
+    }
+    w.changes = nil
+    
+    if len(failed) > 0 {
+        return fmt.Errorf("rollback failed: %s", strings.Join(failed, "; "))
+    }
+    return nil
+}
+
+// writeAtomic writes data to a temporary file next to path and renames it
+// into place, so readers never see a partially written file.
+func writeAtomic(path string, data []byte) error {
+    dir, base := filepath.Split(path)
+    if dir == "" {
+        dir = "."
+    }
+    
+    tmp, err := os.CreateTemp(dir, "."+base+".tmp-*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+    
+    if _, err := tmp.Write(data); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Sync(); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    if err := os.Chmod(tmp.Name(), 0644); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,117 @@
+package provider
+
+import (
+    "context"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestConfigWriteRollback(t *testing.T) {
+    dir := t.TempDir()
+    existing := filepath.Join(dir, "pjsip.conf")
+    doomed := filepath.Join(dir, "pjsip_provider_old.conf")
+    created := filepath.Join(dir, "extensions_provider_new.conf")
+    for path, content := range map[string]string{existing: "original\n", doomed: "old provider\n"} {
+        if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    
+    w := &configWrite{}
+    if changed, err := w.write(existing, []byte("original\n")); err != nil || changed {
+        t.Errorf("writing the same content = %v, %v, want unchanged", changed, err)
+    }
+    if changed, err := w.write(existing, []byte("first\n")); err != nil || !changed {
+        t.Fatalf("write = %v, %v", changed, err)
+    }
+    // A second change must not replace the backup of the original
+    if _, err := w.write(existing, []byte("second\n")); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := w.write(created, []byte("new\n")); err != nil {
+        t.Fatal(err)
+    }
+    if err := w.remove(doomed); err != nil {
+        t.Fatal(err)
+    }
+    
+    if got := readFile(t, existing); got != "second\n" {
+        t.Errorf("pjsip.conf = %q", got)
+    }
+    if got := readFile(t, existing+".bak"); got != "original\n" {
+        t.Errorf("backup = %q, want the original", got)
+    }
+    if files := w.files(); len(files) != 2 || files[0] != existing || files[1] != created {
+        t.Errorf("files = %q, want the written files only", files)
+    }
+    if targets := strings.Join(w.targets(), ","); targets != ReloadPJSIP+","+ReloadDialplan {
+        t.Errorf("targets = %q", targets)
+    }
+    
+    if err := w.rollback(); err != nil {
+        t.Fatalf("rollback: %v", err)
+    }
+    if got := readFile(t, existing); got != "original\n" {
+        t.Errorf("pjsip.conf after rollback = %q, want the original", got)
+    }
+    if got := readFile(t, doomed); got != "old provider\n" {
+        t.Errorf("removed file after rollback = %q", got)
+    }
+    if _, err := os.Stat(created); !os.IsNotExist(err) {
+        t.Errorf("created file still exists after rollback")
+    }
+    if len(w.changes) != 0 || len(w.targets()) != 0 {
+        t.Errorf("changes left after rollback: %v", w.changes)
+    }
+}
+
+func TestConfigWriteRollbackMissingBackup(t *testing.T) {
+    dir := t.TempDir()
+    path := filepath.Join(dir, "pjsip.conf")
+    os.WriteFile(path, []byte("original\n"), 0644)
+    
+    w := &configWrite{}
+    if _, err := w.write(path, []byte("changed\n")); err != nil {
+        t.Fatal(err)
+    }
+    os.Remove(path + ".bak")
+    
+    if err := w.rollback(); err == nil || !strings.Contains(err.Error(), path) {
+        t.Errorf("rollback = %v, want an error naming %s", err, path)
+    }
+}
+
+func TestWriteAtomic(t *testing.T) {
+    dir := t.TempDir()
+    path := filepath.Join(dir, "pjsip.conf")
+    
+    if err := writeAtomic(path, []byte("data\n")); err != nil {
+        t.Fatalf("writeAtomic: %v", err)
+    }
+    info, err := os.Stat(path)
+    if err != nil || info.Mode().Perm() != 0644 {
+        t.Errorf("stat = %v, %v, want mode 0644", info, err)
+    }
+    
+    // No temporary files are left next to the target
+    entries, _ := os.ReadDir(dir)
+    if len(entries) != 1 {
+        t.Errorf("directory holds %d entries, want only the file", len(entries))
+    }
+}
+
+func TestCommandValidator(t *testing.T) {
+    files := []string{"pjsip.conf"}
+    if err := CommandValidator("true")(context.Background(), files); err != nil {
+        t.Errorf("passing command: %v", err)
+    }
+    if err := CommandValidator("")(context.Background(), files); err != nil {
+        t.Errorf("empty command: %v", err)
+    }
+    err := CommandValidator("false")(context.Background(), files)
+    if err == nil {
+        t.Errorf("failing command accepted the files")
+    }
+}
@@ -0,0 +1,226 @@
+package provider
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "path/filepath"
+    "sort"
+    
+    "github.com/router-production/internal/models"
+)
+
+// routerDialplanFile holds the router contexts generated from RouterDialplan
+const routerDialplanFile = "extensions_router.conf"
+
+// Ways the generated dialplan reaches the router
+const (
+    DialplanAGI  = "agi"
+    DialplanCurl = "curl"
+    DialplanARI  = "ari"
+)
+
+// legacyProviderContext is where provider contexts send calls when the
+// router dialplan is maintained by hand
+const legacyProviderContext = "from-s3"
+
+// defaultFailureCauses are the Q.850 causes calls are hung up with for each
+// router error code, matching the hangup reasons of the ARI application.
+var defaultFailureCauses = map[string]int{
+    "invalid_number":       1,  // unallocated number
+    "call_not_found":       1,
+    "no_did_available":     34, // no circuit available
+    "provider_at_capacity": 34,
+    "rate_limited":         34,
+    "shutting_down":        34,
+}
+
+// defaultFailureCause is used for error codes without a cause, and when the
+// router could not be reached at all
+const defaultFailureCause = 41 // temporary failure
+
+// RouterDialplan configures the generated router dialplan: the incoming
+// context calling processIncoming and dialing the returned trunk, the return
+// context calling processReturn and dialing S4, and the failure handling.
+// Provider contexts send calls to the provider's DIDs to the return context
+// and all other calls to the incoming context.
+type RouterDialplan struct {
+    // Mode is agi, curl or ari
+    Mode            string
+    IncomingContext string
+    ReturnContext   string
+    // AGIAddress is the host:port of the router's FastAGI server
+    AGIAddress string
+    // APIURL is the base URL of the router's HTTP API, for CURL()
+    APIURL string
+    // APIKey is sent with CURL() requests when the API requires a key
+    APIKey string
+    // ARIApp is the Stasis application name
+    ARIApp string
+    // DialTimeout is the Dial() timeout in seconds; 0 waits until answered
+    DialTimeout int
+    // FailureCauses override the hangup cause for router error codes
+    FailureCauses map[string]int
+}
+
+// failureCause is a router error code and the cause it hangs up with.
+type failureCause struct {
+    Code  string
+    Cause int
+}
+
+// dialplanData is what the router template is executed with.
+type dialplanData struct {
+    *RouterDialplan
+    AGIURL       string
+    Failures     []failureCause
+    DefaultCause int
+}
+
+// sampleDialplan is rendered in every mode when the templates are loaded.
+var sampleDialplan = &RouterDialplan{
+    Mode:            DialplanCurl,
+    IncomingContext: "from-s3",
+    ReturnContext:   "router-return",
+    AGIAddress:      "127.0.0.1:4573",
+    APIURL:          "http://127.0.0.1:8001",
+    APIKey:          "example",
+    ARIApp:          "router",
+    DialTimeout:     60,
+}
+
+// validateDialplan checks the settings the templates rely on.
+func validateDialplan(d *RouterDialplan) error {
+    switch d.Mode {
+    case DialplanAGI:
+        if d.AGIAddress == "" {
+            return fmt.Errorf("router dialplan: agi mode needs the AGI address")
+        }
+    case DialplanCurl:
+        if d.APIURL == "" {
+            return fmt.Errorf("router dialplan: curl mode needs the API URL")
+        }
+    case DialplanARI:
+        if d.ARIApp == "" {
+            return fmt.Errorf("router dialplan: ari mode needs the Stasis application")
+        }
+    default:
+        return fmt.Errorf("router dialplan: unsupported mode %q (agi, curl or ari)", d.Mode)
+    }
+    
+    if d.IncomingContext == "" || d.ReturnContext == "" {
+        return fmt.Errorf("router dialplan: incoming and return contexts are required")
+    }
+    if d.IncomingContext == d.ReturnContext {
+        return fmt.Errorf("router dialplan: incoming and return contexts must differ")
+    }
+    return nil
+}
+
+// newDialplanData merges the failure causes and sorts them by error code.
+func newDialplanData(d *RouterDialplan) *dialplanData {
+    causes := make(map[string]int, len(defaultFailureCauses)+len(d.FailureCauses))
+    for code, cause := range defaultFailureCauses {
+        causes[code] = cause
+    }
+    for code, cause := range d.FailureCauses {
+        causes[code] = cause
+    }
+    
+    data := &dialplanData{
+        RouterDialplan: d,
+        AGIURL:         "agi://" + d.AGIAddress,
+        DefaultCause:   defaultFailureCause,
+    }
+    for code, cause := range causes {
+        data.Failures = append(data.Failures, failureCause{Code: code, Cause: cause})
+    }
+    sort.Slice(data.Failures, func(i, j int) bool {
+        return data.Failures[i].Code < data.Failures[j].Code
+    })
+    return data
+}
+
+// providerContext is where the provider contexts send calls arriving from
+// providers: the generated incoming context, or the hand-maintained from-s3.
+func (g *AsteriskConfigGenerator) providerContext() string {
+    if g.dialplan != nil {
+        return g.dialplan.IncomingContext
+    }
+    return legacyProviderContext
+}
+
+// returnContext is where provider contexts send calls to the router's DIDs.
+// It is empty when the router dialplan is maintained by hand.
+func (g *AsteriskConfigGenerator) returnContext() string {
+    if g.dialplan != nil {
+        return g.dialplan.ReturnContext
+    }
+    return ""
+}
+
+// returnDIDs returns p's DIDs, sorted and without duplicates, when calls to
+// them are sent to the generated return context.
+func (g *AsteriskConfigGenerator) returnDIDs(p *models.Provider) []string {
+    if g.dialplan == nil || g.dids == nil {
+        return nil
+    }
+    
+    dids := append([]string(nil), g.dids(p.Name)...)
+    sort.Strings(dids)
+    unique := dids[:0]
+    for i, did := range dids {
+        if i == 0 || did != dids[i-1] {
+            unique = append(unique, did)
+        }
+    }
+    return unique
+}
+
+// RenderDialplan returns the router dialplan without writing it. It fails
+// when the router dialplan is not generated.
+func (g *AsteriskConfigGenerator) RenderDialplan() (RenderedFile, error) {
+    if g.dialplan == nil {
+        return RenderedFile{}, fmt.Errorf("router dialplan generation is disabled")
+    }
+    
+    var buf bytes.Buffer
+    if err := g.template("", templateRouter).Execute(&buf, newDialplanData(g.dialplan)); err != nil {
+        return RenderedFile{}, fmt.Errorf("failed to render %s: %w", routerDialplanFile, err)
+    }
+    return RenderedFile{Name: routerDialplanFile, Content: buf.Bytes()}, nil
+}
+
+// writeDialplan writes the router dialplan and its include, to be applied
+// with apply. It returns nil when the router dialplan is not generated.
+func (g *AsteriskConfigGenerator) writeDialplan() (*configWrite, error) {
+    if g.dialplan == nil {
+        return nil, nil
+    }
+    
+    f, err := g.RenderDialplan()
+    if err != nil {
+        return nil, err
+    }
+    
+    w := &configWrite{}
+    if _, err := w.write(filepath.Join(g.configPath, f.Name), f.Content); err != nil {
+        return nil, g.abort(w, err)
+    }
+    if _, err := g.addIncludeIfNotExists(w, filepath.Join(g.configPath, "extensions.conf"), "#include "+routerDialplanFile); err != nil {
+        return nil, g.abort(w, err)
+    }
+    return w, nil
+}
+
+// checkDialplanTemplate renders the router template in every mode.
+func (g *AsteriskConfigGenerator) checkDialplanTemplate() error {
+    for _, mode := range []string{DialplanAGI, DialplanCurl, DialplanARI} {
+        d := *sampleDialplan
+        d.Mode = mode
+        if err := g.template("", templateRouter).Execute(io.Discard, newDialplanData(&d)); err != nil {
+            return fmt.Errorf("template %s (%s mode): %w", templateRouter, mode, err)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,93 @@
+package provider
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestProviderContextRouting(t *testing.T) {
+    dids := map[string][]string{"carrier": {"+15550002", "15550001", "+15550002"}}
+    dialplan := *sampleDialplan
+    
+    tests := []struct {
+        name     string
+        dialplan *RouterDialplan
+        want     string
+    }{
+        {
+            name: "hand-maintained dialplan",
+            want: "[from-provider-carrier]\n; Incoming calls from provider carrier\n" +
+                "exten => _X.,1,NoOp(Call from provider carrier)\n" +
+                "exten => _X.,n,Goto(from-s3,${EXTEN},1)\n",
+        },
+        {
+            name:     "generated dialplan",
+            dialplan: &dialplan,
+            want: "[from-provider-carrier]\n; Incoming calls from provider carrier; calls to its DIDs are return calls\n" +
+                "exten => +15550002,1,Goto(router-return,${EXTEN},1)\n" +
+                "exten => 15550001,1,Goto(router-return,${EXTEN},1)\n" +
+                "exten => _X.,1,NoOp(Call from provider carrier)\n" +
+                "exten => _X.,n,Goto(from-s3,${EXTEN},1)\n",
+        },
+    }
+    
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            g, err := NewAsteriskConfigGenerator(t.TempDir(), nil, &fakeReloader{}, nil, "", tt.dialplan)
+            if err != nil {
+                t.Fatalf("NewAsteriskConfigGenerator: %v", err)
+            }
+            g.dids = func(provider string) []string {
+                return dids[provider]
+            }
+            
+            files, err := g.Render(testProvider("carrier"))
+            if err != nil {
+                t.Fatalf("Render: %v", err)
+            }
+            if got := string(files[1].Content); !strings.Contains(got, tt.want) {
+                t.Errorf("extensions =\n%s\nwant\n%s", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestProviderWithoutDIDs(t *testing.T) {
+    dialplan := *sampleDialplan
+    g, err := NewAsteriskConfigGenerator(t.TempDir(), nil, &fakeReloader{}, nil, "", &dialplan)
+    if err != nil {
+        t.Fatalf("NewAsteriskConfigGenerator: %v", err)
+    }
+    g.dids = func(string) []string { return nil }
+    
+    files, err := g.Render(testProvider("carrier"))
+    if err != nil {
+        t.Fatalf("Render: %v", err)
+    }
+    if got := string(files[1].Content); strings.Contains(got, "router-return") {
+        t.Errorf("provider without DIDs routes to the return context:\n%s", got)
+    }
+}
+
+func TestValidDID(t *testing.T) {
+    tests := []struct {
+        did  string
+        want bool
+    }{
+        {"15550001", true},
+        {"+4930123456", true},
+        {"123", true},
+        {"12", false},
+        {"", false},
+        {"+", false},
+        {"1555 0001", false},
+        {"1555,1,Hangup()", false},
+        {"_X.", false},
+        {"123456789012345678901", false},
+    }
+    for _, tt := range tests {
+        if got := validDID(tt.did); got != tt.want {
+            t.Errorf("validDID(%q) = %v, want %v", tt.did, got, tt.want)
+        }
+    }
+}
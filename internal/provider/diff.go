@@ -0,0 +1,144 @@
+package provider
+
+import (
+    "fmt"
+    "strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// diffLine is one line of an edit script: ' ' kept, '-' removed, '+' added
+type diffLine struct {
+    op   byte
+    text string
+}
+
+// Unified formats the difference as a unified diff.
+func (d FileDiff) Unified() string {
+    oldName, newName := "a/"+d.Name, "b/"+d.Name
+    switch d.Status {
+    case DiffAdded:
+        oldName = "/dev/null"
+    case DiffRemoved:
+        newName = "/dev/null"
+    }
+    
+    var b strings.Builder
+    fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+    
+    script := editScript(splitLines(d.Old), splitLines(d.New))
+    var changes []int
+    for i, l := range script {
+        if l.op != ' ' {
+            changes = append(changes, i)
+        }
+    }
+    
+    // Changes separated by at most 2*diffContext kept lines share a hunk
+    for k := 0; k < len(changes); {
+        first, last := changes[k], changes[k]
+        for k++; k < len(changes) && changes[k]-last-1 <= 2*diffContext; k++ {
+            last = changes[k]
+        }
+        writeHunk(&b, script, max(first-diffContext, 0), min(last+1+diffContext, len(script)))
+    }
+    return b.String()
+}
+
+// writeHunk writes script[from:to] with its @@ header.
+func writeHunk(b *strings.Builder, script []diffLine, from, to int) {
+    oldStart, newStart := 1, 1
+    for _, l := range script[:from] {
+        if l.op != '+' {
+            oldStart++
+        }
+        if l.op != '-' {
+            newStart++
+        }
+    }
+    
+    var oldLines, newLines int
+    for _, l := range script[from:to] {
+        if l.op != '+' {
+            oldLines++
+        }
+        if l.op != '-' {
+            newLines++
+        }
+    }
+    if oldLines == 0 {
+        oldStart--
+    }
+    if newLines == 0 {
+        newStart--
+    }
+    
+    fmt.Fprintf(b, "@@ -%d,%d +%d,%d @@\n", oldStart, oldLines, newStart, newLines)
+    for _, l := range script[from:to] {
+        fmt.Fprintf(b, "%c%s\n", l.op, l.text)
+    }
+}
+
+// editScript returns the shortest line edit turning a into b, from the
+// longest common subsequence. Config files are small enough for O(n*m).
+func editScript(a, b []string) []diffLine {
+    lcs := make([][]int, len(a)+1)
+    for i := range lcs {
+        lcs[i] = make([]int, len(b)+1)
+    }
+    for i := len(a) - 1; i >= 0; i-- {
+        for j := len(b) - 1; j >= 0; j-- {
+            if a[i] == b[j] {
+                lcs[i][j] = lcs[i+1][j+1] + 1
+            } else {
+                lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+            }
+        }
+    }
+    
+    var script []diffLine
+    i, j := 0, 0
+    for i < len(a) && j < len(b) {
+        switch {
+        case a[i] == b[j]:
+            script = append(script, diffLine{' ', a[i]})
+            i++
+            j++
+        case lcs[i+1][j] >= lcs[i][j+1]:
+            script = append(script, diffLine{'-', a[i]})
+            i++
+        default:
+            script = append(script, diffLine{'+', b[j]})
+            j++
+        }
+    }
+    for ; i < len(a); i++ {
+        script = append(script, diffLine{'-', a[i]})
+    }
+    for ; j < len(b); j++ {
+        script = append(script, diffLine{'+', b[j]})
+    }
+    return script
+}
+
+func splitLines(data []byte) []string {
+    if len(data) == 0 {
+        return nil
+    }
+    return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func max(a, b int) int {
+    if a > b {
+        return a
+    }
+    return b
+}
+
+func min(a, b int) int {
+    if a < b {
+        return a
+    }
+    return b
+}
@@ -0,0 +1,156 @@
+package provider
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    
+    "github.com/router-production/internal/models"
+)
+
+// numbered returns lines "line 1" to "line n", newline terminated.
+func numbered(n int) []string {
+    lines := make([]string, n)
+    for i := range lines {
+        lines[i] = fmt.Sprintf("line %d", i+1)
+    }
+    return lines
+}
+
+func joinLines(lines []string) []byte {
+    return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+func TestUnified(t *testing.T) {
+    long := numbered(20)
+    farApart := append([]string(nil), long...)
+    farApart[1] = "changed 2"
+    farApart[17] = "changed 18"
+    close := append([]string(nil), long...)
+    close[4] = "changed 5"
+    close[9] = "changed 10"
+    
+    tests := []struct {
+        name string
+        diff FileDiff
+        want string
+    }{
+        {
+            name: "added",
+            diff: FileDiff{Name: "pjsip_provider_a.conf", Status: DiffAdded, New: []byte("[a]\ntype=endpoint\n")},
+            want: "--- /dev/null\n+++ b/pjsip_provider_a.conf\n@@ -0,0 +1,2 @@\n+[a]\n+type=endpoint\n",
+        },
+        {
+            name: "removed",
+            diff: FileDiff{Name: "pjsip_provider_a.conf", Status: DiffRemoved, Old: []byte("[a]\n")},
+            want: "--- a/pjsip_provider_a.conf\n+++ /dev/null\n@@ -1,1 +0,0 @@\n-[a]\n",
+        },
+        {
+            name: "changed line with context",
+            diff: FileDiff{Name: "pjsip.conf", Status: DiffChanged,
+                Old: joinLines(numbered(8)),
+                New: joinLines(append(append(numbered(4), "line 5 edited"), numbered(8)[5:]...))},
+            want: "--- a/pjsip.conf\n+++ b/pjsip.conf\n@@ -2,7 +2,7 @@\n line 2\n line 3\n line 4\n-line 5\n+line 5 edited\n line 6\n line 7\n line 8\n",
+        },
+        {
+            name: "appended include",
+            diff: FileDiff{Name: "pjsip.conf", Status: DiffChanged,
+                Old: []byte("[global]\n"),
+                New: []byte("[global]\n\n#include pjsip_provider_a.conf\n")},
+            want: "--- a/pjsip.conf\n+++ b/pjsip.conf\n@@ -1,1 +1,3 @@\n [global]\n+\n+#include pjsip_provider_a.conf\n",
+        },
+        {
+            name: "distant changes get their own hunks",
+            diff: FileDiff{Name: "f", Status: DiffChanged, Old: joinLines(long), New: joinLines(farApart)},
+            want: "--- a/f\n+++ b/f\n" +
+                "@@ -1,5 +1,5 @@\n line 1\n-line 2\n+changed 2\n line 3\n line 4\n line 5\n" +
+                "@@ -15,6 +15,6 @@\n line 15\n line 16\n line 17\n-line 18\n+changed 18\n line 19\n line 20\n",
+        },
+        {
+            name: "close changes share a hunk",
+            diff: FileDiff{Name: "f", Status: DiffChanged, Old: joinLines(long), New: joinLines(close)},
+            want: "--- a/f\n+++ b/f\n" +
+                "@@ -2,12 +2,12 @@\n line 2\n line 3\n line 4\n-line 5\n+changed 5\n line 6\n line 7\n line 8\n line 9\n-line 10\n+changed 10\n line 11\n line 12\n line 13\n",
+        },
+    }
+    
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := tt.diff.Unified(); got != tt.want {
+                t.Errorf("Unified =\n%s\nwant\n%s", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestMaskSecrets(t *testing.T) {
+    in := "[a-auth]\npassword=hunter2\n  password = s3cret\nexten => _X.,1,Set(R=${CURL(http://router/api/processIncoming?key=rk_abc&ani=1)})\n"
+    want := "[a-auth]\npassword=" + models.MaskedSecret + "\n  password =" + models.MaskedSecret +
+        "\nexten => _X.,1,Set(R=${CURL(http://router/api/processIncoming?key=" + models.MaskedSecret + "&ani=1)})\n"
+    if got := string(MaskSecrets([]byte(in))); got != want {
+        t.Errorf("MaskSecrets =\n%s\nwant\n%s", got, want)
+    }
+}
+
+func TestDiffAndSync(t *testing.T) {
+    reloader := &fakeReloader{}
+    g, dir := newTestGenerator(t, reloader, nil)
+    a, b := testProvider("carrier-a"), testProvider("carrier-b")
+    
+    // carrier-b is configured on disk but no longer exists
+    if _, err := generate(g, b); err != nil {
+        t.Fatalf("generate: %v", err)
+    }
+    
+    diffs, err := g.Diff([]*models.Provider{a})
+    if err != nil {
+        t.Fatalf("Diff: %v", err)
+    }
+    statuses := make(map[string]string)
+    for _, d := range diffs {
+        statuses[d.Name] = d.Status
+    }
+    want := map[string]string{
+        "pjsip_provider_carrier-a.conf":      DiffAdded,
+        "extensions_provider_carrier-a.conf": DiffAdded,
+        "pjsip_provider_carrier-b.conf":      DiffRemoved,
+        "extensions_provider_carrier-b.conf": DiffRemoved,
+        "pjsip.conf":                         DiffChanged,
+        "extensions.conf":                    DiffChanged,
+    }
+    for name, status := range want {
+        if statuses[name] != status {
+            t.Errorf("%s = %q, want %q", name, statuses[name], status)
+        }
+    }
+    
+    result, w, err := g.writeSync([]*models.Provider{a})
+    if err != nil {
+        t.Fatalf("writeSync: %v", err)
+    }
+    if len(result.Removed) != 2 || len(w.targets()) != 2 {
+        t.Errorf("removed %q, targets %q", result.Removed, w.targets())
+    }
+    pjsip := readFile(t, filepath.Join(dir, "pjsip.conf"))
+    if strings.Contains(pjsip, "carrier-b") || !strings.Contains(pjsip, "#include pjsip_provider_carrier-a.conf") {
+        t.Errorf("pjsip.conf after sync:\n%s", pjsip)
+    }
+    
+    // Once synced there is nothing left to change
+    if diffs, err := g.Diff([]*models.Provider{a}); err != nil || len(diffs) != 0 {
+        t.Errorf("Diff after sync = %v, %v", diffs, err)
+    }
+    
+    // Rolling the sync back restores carrier-b
+    if err := w.rollback(); err != nil {
+        t.Fatalf("rollback: %v", err)
+    }
+    if _, err := os.Stat(filepath.Join(dir, "pjsip_provider_carrier-b.conf")); err != nil {
+        t.Errorf("removed file not restored: %v", err)
+    }
+    if _, err := os.Stat(filepath.Join(dir, "pjsip_provider_carrier-a.conf")); !os.IsNotExist(err) {
+        t.Errorf("new file not removed: %v", err)
+    }
+}
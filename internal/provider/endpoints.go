@@ -0,0 +1,106 @@
+package provider
+
+import (
+    "fmt"
+    "net"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    
+    "github.com/router-production/internal/models"
+)
+
+// hostnamePattern accepts DNS names in match lists
+var hostnamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9.-]*[A-Za-z0-9])?$`)
+
+// ParseContact parses a contact given as host[:port][/priority]. Without a
+// priority the contact gets position, so contacts listed first are tried first.
+func ParseContact(s string, position int) (models.Contact, error) {
+    c := models.Contact{Priority: position}
+    
+    address := s
+    if i := strings.LastIndex(s, "/"); i >= 0 {
+        priority, err := strconv.Atoi(s[i+1:])
+        if err != nil {
+            return c, fmt.Errorf("%w: contact %q: invalid priority", ErrInvalidProvider, s)
+        }
+        c.Priority = priority
+        address = s[:i]
+    }
+    
+    c.Host = strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+    if host, port, err := net.SplitHostPort(address); err == nil {
+        c.Host = host
+        if c.Port, err = strconv.Atoi(port); err != nil {
+            return c, fmt.Errorf("%w: contact %q: invalid port", ErrInvalidProvider, s)
+        }
+    }
+    return c, nil
+}
+
+// normalizeEndpoints validates the match list and contacts of a provider,
+// fills in contact ports and sorts contacts by priority.
+func normalizeEndpoints(p *models.Provider) error {
+    for _, match := range p.MatchAddresses {
+        if !validMatch(match) {
+            return fmt.Errorf("%w: invalid match address %q", ErrInvalidProvider, match)
+        }
+    }
+    
+    for i := range p.Contacts {
+        c := &p.Contacts[i]
+        if c.Host == "" || strings.ContainsAny(c.Host, " ,;") {
+            return fmt.Errorf("%w: invalid contact host %q", ErrInvalidProvider, c.Host)
+        }
+        if c.Port == 0 {
+            c.Port = p.Port
+        }
+        if c.Port < 1 || c.Port > 65535 {
+            return fmt.Errorf("%w: contact %s: port %d is out of range", ErrInvalidProvider, c.Host, c.Port)
+        }
+        if c.Priority < 0 {
+            return fmt.Errorf("%w: contact %s: priority must not be negative", ErrInvalidProvider, c.Host)
+        }
+    }
+    sort.SliceStable(p.Contacts, func(i, j int) bool {
+        return p.Contacts[i].Priority < p.Contacts[j].Priority
+    })
+    return nil
+}
+
+func validMatch(match string) bool {
+    if net.ParseIP(match) != nil {
+        return true
+    }
+    if _, _, err := net.ParseCIDR(match); err == nil {
+        return true
+    }
+    return hostnamePattern.MatchString(match)
+}
+
+// matchAddresses returns the addresses the identify section matches.
+func matchAddresses(p *models.Provider) []string {
+    if len(p.MatchAddresses) > 0 {
+        return p.MatchAddresses
+    }
+    return []string{p.Host}
+}
+
+// providerContacts returns the outbound contacts in priority order.
+func providerContacts(p *models.Provider) []models.Contact {
+    if len(p.Contacts) == 0 {
+        return []models.Contact{{Host: p.Host, Port: p.Port, Priority: 1}}
+    }
+    
+    contacts := append([]models.Contact(nil), p.Contacts...)
+    sort.SliceStable(contacts, func(i, j int) bool {
+        return contacts[i].Priority < contacts[j].Priority
+    })
+    return contacts
+}
+
+// hostPort joins a host and port for a SIP URI, bracketing IPv6 addresses.
+func hostPort(host string, port int) string {
+    return net.JoinHostPort(host, strconv.Itoa(port))
+}
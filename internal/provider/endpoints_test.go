@@ -0,0 +1,131 @@
+package provider
+
+import (
+    "errors"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    
+    "github.com/router-production/internal/models"
+)
+
+func TestParseContact(t *testing.T) {
+    tests := []struct {
+        in      string
+        want    models.Contact
+        wantErr bool
+    }{
+        {in: "gw1.example.com", want: models.Contact{Host: "gw1.example.com", Priority: 3}},
+        {in: "gw1.example.com:5080", want: models.Contact{Host: "gw1.example.com", Port: 5080, Priority: 3}},
+        {in: "198.51.100.10/1", want: models.Contact{Host: "198.51.100.10", Priority: 1}},
+        {in: "198.51.100.10:5060/10", want: models.Contact{Host: "198.51.100.10", Port: 5060, Priority: 10}},
+        {in: "[2001:db8::1]:5060/2", want: models.Contact{Host: "2001:db8::1", Port: 5060, Priority: 2}},
+        {in: "[2001:db8::1]", want: models.Contact{Host: "2001:db8::1", Priority: 3}},
+        {in: "2001:db8::1", want: models.Contact{Host: "2001:db8::1", Priority: 3}},
+        {in: "gw1.example.com/high", wantErr: true},
+        {in: "gw1.example.com/", wantErr: true},
+        {in: "gw1.example.com:sip", wantErr: true},
+    }
+    
+    for _, tt := range tests {
+        t.Run(tt.in, func(t *testing.T) {
+            got, err := ParseContact(tt.in, 3)
+            if tt.wantErr {
+                if !errors.Is(err, ErrInvalidProvider) {
+                    t.Errorf("err = %v, want ErrInvalidProvider", err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("ParseContact: %v", err)
+            }
+            if got != tt.want {
+                t.Errorf("got %+v, want %+v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestNormalizeEndpoints(t *testing.T) {
+    tests := []struct {
+        name     string
+        matches  []string
+        contacts []models.Contact
+        want     []models.Contact
+        wantErr  string
+    }{
+        {
+            name:    "match addresses",
+            matches: []string{"198.51.100.10", "203.0.113.0/24", "2001:db8::/32", "sip.example.com"},
+        },
+        {
+            name:     "ports default and contacts sort by priority",
+            contacts: []models.Contact{{Host: "b", Priority: 2}, {Host: "a", Port: 5080, Priority: 1}, {Host: "c", Priority: 2}},
+            want:     []models.Contact{{Host: "a", Port: 5080, Priority: 1}, {Host: "b", Port: 5060, Priority: 2}, {Host: "c", Port: 5060, Priority: 2}},
+        },
+        {name: "bad match", matches: []string{"10.0.0.0/33"}, wantErr: "invalid match address"},
+        {name: "match with spaces", matches: []string{"a b"}, wantErr: "invalid match address"},
+        {name: "empty host", contacts: []models.Contact{{Port: 5060}}, wantErr: "invalid contact host"},
+        {name: "host list", contacts: []models.Contact{{Host: "a,b"}}, wantErr: "invalid contact host"},
+        {name: "port out of range", contacts: []models.Contact{{Host: "a", Port: 70000}}, wantErr: "out of range"},
+        {name: "negative priority", contacts: []models.Contact{{Host: "a", Priority: -1}}, wantErr: "must not be negative"},
+    }
+    
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            p := testProvider("carrier")
+            p.MatchAddresses = tt.matches
+            p.Contacts = tt.contacts
+            
+            err := normalizeEndpoints(p)
+            if tt.wantErr != "" {
+                if !errors.Is(err, ErrInvalidProvider) || !strings.Contains(err.Error(), tt.wantErr) {
+                    t.Errorf("err = %v, want %q", err, tt.wantErr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("normalizeEndpoints: %v", err)
+            }
+            if len(tt.want) > 0 && !reflect.DeepEqual(p.Contacts, tt.want) {
+                t.Errorf("contacts = %+v, want %+v", p.Contacts, tt.want)
+            }
+        })
+    }
+}
+
+func TestEndpointConfig(t *testing.T) {
+    g, dir := newTestGenerator(t, &fakeReloader{}, nil)
+    p := testProvider("carrier")
+    p.MatchAddresses = []string{"198.51.100.0/24", "2001:db8::1"}
+    p.Contacts = []models.Contact{{Host: "2001:db8::2", Port: 5060, Priority: 2}, {Host: "198.51.100.11", Port: 5080, Priority: 1}}
+    
+    if _, err := generate(g, p); err != nil {
+        t.Fatalf("generate: %v", err)
+    }
+    
+    pjsip := readFile(t, filepath.Join(dir, "pjsip_provider_carrier.conf"))
+    for _, want := range []string{"match=198.51.100.0/24\n", "match=2001:db8::1\n"} {
+        if !strings.Contains(pjsip, want) {
+            t.Errorf("pjsip config misses %q:\n%s", want, pjsip)
+        }
+    }
+    if strings.Contains(pjsip, "match=198.51.100.10\n") {
+        t.Errorf("provider host matched despite explicit match addresses:\n%s", pjsip)
+    }
+    
+    ext := readFile(t, filepath.Join(dir, "extensions_provider_carrier.conf"))
+    first := strings.Index(ext, "@198.51.100.11:5080")
+    second := strings.Index(ext, "@[2001:db8::2]:5060")
+    if first < 0 || second < 0 || first > second {
+        t.Errorf("contacts not dialed in priority order:\n%s", ext)
+    }
+    
+    // Calls originated over ARI enter the same failover through a Local channel
+    want := "[dial-trunk-carrier]"
+    local := "exten => _[+0-9].,1,Gosub(s,1(${EXTEN}))\n"
+    if i := strings.Index(ext, want); i < 0 || !strings.Contains(ext[i:], local) {
+        t.Errorf("%s has no extension for Local channels:\n%s", want, ext)
+    }
+}
@@ -1,8 +1,10 @@
 package provider
 
 import (
+    "context"
     "database/sql"
     "encoding/json"
+    "errors"
     "fmt"
     "log"
     "strings"
@@ -11,31 +13,116 @@ import (
     
     "github.com/router-production/internal/database"
     "github.com/router-production/internal/models"
+    "github.com/router-production/internal/secrets"
 )
 
+var (
+    // ErrProviderNotFound is returned for unknown or inactive providers.
+    ErrProviderNotFound = errors.New("provider not found")
+    // ErrNoDIDAvailable is returned when every DID of the eligible providers is in use.
+    ErrNoDIDAvailable = errors.New("no DID available")
+    // ErrProviderAtCapacity is returned when a provider already carries max_channels calls.
+    ErrProviderAtCapacity = errors.New("provider at capacity")
+    // ErrNotApplied is returned when a provider was saved but Asterisk did
+    // not pick up its configuration, and when written configuration could
+    // not be reloaded because Asterisk was unreachable.
+    ErrNotApplied = errors.New("provider saved but asterisk configuration not applied")
+    // ErrInvalidDID is returned for DIDs that are not digits with an optional leading +.
+    ErrInvalidDID = errors.New("invalid DID")
+    // ErrInvalidProvider is returned for provider settings that cannot be applied.
+    ErrInvalidProvider = errors.New("invalid provider")
+    // ErrInvalidConfig is returned when the validation hook rejects generated configuration.
+    ErrInvalidConfig = errors.New("asterisk configuration rejected")
+)
+
+// ReloadTimeout bounds how long a config change waits for Asterisk to reload
+const ReloadTimeout = 30 * time.Second
+
+// Config holds the provider manager settings.
+type Config struct {
+    // AsteriskConfigDir is where provider PJSIP and dialplan files are written
+    AsteriskConfigDir string
+    // Keyring encrypts provider passwords at rest; nil stores them in clear
+    Keyring *secrets.Keyring
+    // Reloader applies regenerated configuration; nil runs the asterisk CLI
+    Reloader Reloader
+    // Validate checks written files before the reload; nil skips validation
+    Validate ValidateFunc
+    // Registrations reports outbound registration states; nil leaves them unknown
+    Registrations RegistrationSource
+    // TemplateDir holds templates replacing the built-in ones; empty uses the built-ins
+    TemplateDir string
+    // Dialplan generates the router contexts; nil leaves them to a hand-maintained dialplan
+    Dialplan *RouterDialplan
+}
+
 type Manager struct {
     db            *database.DB
     providers     map[string]*models.Provider
     providerDIDs  map[string][]string
     mu            sync.RWMutex
+    // configMu serializes writing and applying Asterisk configuration; it is
+    // held through validation and reload, which m.mu is not
+    configMu      sync.Mutex
     asteriskGen   *AsteriskConfigGenerator
+    keyring       *secrets.Keyring
+    registrations RegistrationSource
 }
 
-func NewManager(db *database.DB) *Manager {
+// NewManager loads the providers. It fails if the Asterisk templates do not
+// parse or render.
+func NewManager(db *database.DB, cfg Config) (*Manager, error) {
+    gen, err := NewAsteriskConfigGenerator(cfg.AsteriskConfigDir, cfg.Keyring, cfg.Reloader, cfg.Validate, cfg.TemplateDir, cfg.Dialplan)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load asterisk templates: %w", err)
+    }
+    
     m := &Manager{
         db:           db,
         providers:    make(map[string]*models.Provider),
         providerDIDs: make(map[string][]string),
-        asteriskGen:  NewAsteriskConfigGenerator(),
+        asteriskGen:  gen,
+        keyring:      cfg.Keyring,
+        
+        registrations: cfg.Registrations,
     }
+    gen.dids = m.didsOf
     
     // Load existing providers
     m.LoadProviders()
     
-    return m
+    for _, p := range m.providers {
+        if !gen.HasTemplate(p.Template) {
+            log.Printf("Warning: provider %s uses unknown template %q", p.Name, p.Template)
+        }
+    }
+    
+    return m, nil
+}
+
+// AddProvider stores the provider and applies its Asterisk configuration.
+// The reload result is nil when no reload was needed. If the provider was
+// stored but could not be applied, the error wraps ErrNotApplied.
+func (m *Manager) AddProvider(p *models.Provider) (*ReloadResult, error) {
+    m.configMu.Lock()
+    defer m.configMu.Unlock()
+    
+    if err := m.saveProvider(p); err != nil {
+        return nil, err
+    }
+    
+    reload, err := m.applyProviderConfig(p)
+    if err != nil {
+        log.Printf("Warning: Failed to apply Asterisk config for %s: %v", p.Name, err)
+        return reload, notApplied(p.Name, err)
+    }
+    
+    log.Printf("Provider %s added successfully", p.Name)
+    return reload, nil
 }
 
-func (m *Manager) AddProvider(p *models.Provider) error {
+// saveProvider validates and stores the provider, in the database and in memory.
+func (m *Manager) saveProvider(p *models.Provider) error {
     m.mu.Lock()
     defer m.mu.Unlock()
     fmt.Println(time.Now())
@@ -46,29 +133,71 @@ func (m *Manager) AddProvider(p *models.Provider) error {
         return fmt.Errorf("provider name and host are required")
     }
     
-    if p.Port == 0 {
-        p.Port = 5060
-    }
-    
     if p.Transport == "" {
         p.Transport = "udp"
     }
+    if err := validateTransport(p); err != nil {
+        return err
+    }
+    if p.Registration == "" {
+        p.Registration = RegistrationNone
+    }
+    if err := validateRegistration(p); err != nil {
+        return err
+    }
+    if err := normalizeEndpoints(p); err != nil {
+        return err
+    }
+    if !m.asteriskGen.HasTemplate(p.Template) {
+        return fmt.Errorf("%w: unknown template %q", ErrInvalidProvider, p.Template)
+    }
+    
+    if p.Port == 0 {
+        p.Port = 5060
+        if p.Transport == "tls" {
+            p.Port = 5061
+        }
+    }
     
     if len(p.Codecs) == 0 {
         p.Codecs = []string{"ulaw", "alaw"}
     }
     
+    // Only the encrypted password is stored and kept in memory
+    if m.keyring != nil && !secrets.IsEncrypted(p.Password) {
+        encrypted, err := m.keyring.Encrypt(p.Password, p.Name)
+        if err != nil {
+            return fmt.Errorf("failed to encrypt password: %w", err)
+        }
+        p.Password = encrypted
+    }
+    
     // Store in database
     codecsJSON, _ := json.Marshal(p.Codecs)
+    matchJSON, _ := json.Marshal(p.MatchAddresses)
+    contactsJSON, _ := json.Marshal(p.Contacts)
     result, err := m.db.Exec(`
-        INSERT INTO providers (name, host, port, username, password, realm, transport, codecs, max_channels, active, country)
-        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        INSERT INTO providers (name, host, port, username, password, realm, transport, codecs, max_channels, active, country,
+            return_timeout, max_call_duration, media_encryption, tls_cert_file, tls_key_file, tls_ca_file, tls_verify_server,
+            registration, registration_expiration, registration_retry_interval, registration_server_uri, registration_client_uri,
+            match_addresses, contacts, template)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
         ON DUPLICATE KEY UPDATE
         host=VALUES(host), port=VALUES(port), username=VALUES(username), 
         password=VALUES(password), realm=VALUES(realm), transport=VALUES(transport),
         codecs=VALUES(codecs), max_channels=VALUES(max_channels), 
-        active=VALUES(active), country=VALUES(country), updated_at=NOW()
-    `, p.Name, p.Host, p.Port, p.Username, p.Password, p.Realm, p.Transport, codecsJSON, p.MaxChannels, p.Active, p.Country)
+        active=VALUES(active), country=VALUES(country),
+        return_timeout=VALUES(return_timeout), max_call_duration=VALUES(max_call_duration),
+        media_encryption=VALUES(media_encryption), tls_cert_file=VALUES(tls_cert_file), tls_key_file=VALUES(tls_key_file),
+        tls_ca_file=VALUES(tls_ca_file), tls_verify_server=VALUES(tls_verify_server),
+        registration=VALUES(registration), registration_expiration=VALUES(registration_expiration),
+        registration_retry_interval=VALUES(registration_retry_interval),
+        registration_server_uri=VALUES(registration_server_uri), registration_client_uri=VALUES(registration_client_uri),
+        match_addresses=VALUES(match_addresses), contacts=VALUES(contacts), template=VALUES(template), updated_at=NOW()
+    `, p.Name, p.Host, p.Port, p.Username, p.Password, p.Realm, p.Transport, codecsJSON, p.MaxChannels, p.Active, p.Country,
+        p.ReturnTimeout, p.MaxCallDuration, p.MediaEncryption, p.TLSCertFile, p.TLSKeyFile, p.TLSCAFile, p.TLSVerifyServer,
+        p.Registration, p.RegistrationExpiration, p.RegistrationRetryInterval, p.RegistrationServerURI, p.RegistrationClientURI,
+        matchJSON, contactsJSON, p.Template)
     
     if err != nil {
         return fmt.Errorf("failed to add provider: %w", err)
@@ -79,23 +208,125 @@ func (m *Manager) AddProvider(p *models.Provider) error {
     
     // Store in memory
     m.providers[p.Name] = p
+    return nil
+}
+
+// notApplied wraps the error of applying a stored provider's configuration.
+func notApplied(name string, err error) error {
+    if errors.Is(err, ErrNotApplied) {
+        return fmt.Errorf("%s: %w", name, err)
+    }
+    return fmt.Errorf("%w: %s: %v", ErrNotApplied, name, err)
+}
+
+// applyProviderConfig renders and writes p's Asterisk configuration under
+// m.mu, then validates and reloads it with the lock released so calls are
+// not held up by a slow reload. The caller holds m.configMu.
+func (m *Manager) applyProviderConfig(p *models.Provider) (*ReloadResult, error) {
+    m.mu.RLock()
+    w, err := m.asteriskGen.writeProviderConfig(p, m.providerList())
+    m.mu.RUnlock()
+    if err != nil {
+        return nil, err
+    }
     
-    // Generate Asterisk configuration
-    if err := m.asteriskGen.GenerateProviderConfig(p); err != nil {
-        log.Printf("Warning: Failed to generate Asterisk config for %s: %v", p.Name, err)
+    ctx, cancel := context.WithTimeout(context.Background(), ReloadTimeout)
+    defer cancel()
+    return m.asteriskGen.apply(ctx, w, true)
+}
+
+// SetEndpoints replaces a provider's inbound match addresses and outbound
+// contacts; a nil list is left unchanged. The Asterisk configuration is
+// applied as in AddProvider.
+func (m *Manager) SetEndpoints(name string, match []string, contacts []models.Contact) (*models.Provider, *ReloadResult, error) {
+    m.configMu.Lock()
+    defer m.configMu.Unlock()
+    
+    p, err := m.saveEndpoints(name, match, contacts)
+    if err != nil {
+        return nil, nil, err
     }
     
-    log.Printf("Provider %s added successfully", p.Name)
-    return nil
+    reload, err := m.applyProviderConfig(p)
+    if err != nil {
+        log.Printf("Warning: Failed to apply Asterisk config for %s: %v", name, err)
+        return p, reload, notApplied(name, err)
+    }
+    
+    log.Printf("Provider %s endpoints updated", name)
+    return p, reload, nil
 }
 
+// saveEndpoints stores a provider's new endpoints and returns the updated copy.
+func (m *Manager) saveEndpoints(name string, match []string, contacts []models.Contact) (*models.Provider, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    
+    current, exists := m.providers[name]
+    if !exists {
+        return nil, fmt.Errorf("%w: %s", ErrProviderNotFound, name)
+    }
+    
+    p := *current
+    if match != nil {
+        p.MatchAddresses = match
+    }
+    if contacts != nil {
+        p.Contacts = contacts
+    }
+    if err := normalizeEndpoints(&p); err != nil {
+        return nil, err
+    }
+    
+    matchJSON, _ := json.Marshal(p.MatchAddresses)
+    contactsJSON, _ := json.Marshal(p.Contacts)
+    _, err := m.db.Exec(`
+        UPDATE providers SET match_addresses = ?, contacts = ?, updated_at = NOW()
+        WHERE name = ?
+    `, matchJSON, contactsJSON, p.Name)
+    if err != nil {
+        return nil, fmt.Errorf("failed to update provider: %w", err)
+    }
+    m.providers[name] = &p
+    return &p, nil
+}
+
+// AddDIDs assigns DIDs to a provider. When the router dialplan is generated
+// the provider's configuration is applied as in AddProvider, so calls to the
+// new DIDs reach the return context.
 func (m *Manager) AddDIDs(providerName string, dids []string, country string) error {
+    m.configMu.Lock()
+    defer m.configMu.Unlock()
+    
+    p, err := m.saveDIDs(providerName, dids, country)
+    if err != nil {
+        return err
+    }
+    
+    if m.asteriskGen.dialplan != nil {
+        if _, err := m.applyProviderConfig(p); err != nil {
+            log.Printf("Warning: Failed to apply Asterisk config for %s: %v", providerName, err)
+            return notApplied(providerName, err)
+        }
+    }
+    
+    log.Printf("Added %d DIDs to provider %s", len(dids), providerName)
+    return nil
+}
+
+// saveDIDs validates and stores the DIDs and returns their provider.
+func (m *Manager) saveDIDs(providerName string, dids []string, country string) (*models.Provider, error) {
     m.mu.Lock()
     defer m.mu.Unlock()
     
     provider, exists := m.providers[providerName]
     if !exists {
-        return fmt.Errorf("provider %s not found", providerName)
+        return nil, fmt.Errorf("%w: %s", ErrProviderNotFound, providerName)
+    }
+    for _, did := range dids {
+        if !validDID(did) {
+            return nil, fmt.Errorf("%w: %q", ErrInvalidDID, did)
+        }
     }
     
     // Prepare bulk insert
@@ -115,14 +346,31 @@ func (m *Manager) AddDIDs(providerName string, dids []string, country string) er
     `, strings.Join(values, ","))
     
     if _, err := m.db.Exec(query, args...); err != nil {
-        return fmt.Errorf("failed to add DIDs: %w", err)
+        return nil, fmt.Errorf("failed to add DIDs: %w", err)
     }
     
     // Update memory cache
     m.providerDIDs[providerName] = append(m.providerDIDs[providerName], dids...)
-    
-    log.Printf("Added %d DIDs to provider %s", len(dids), providerName)
-    return nil
+    return provider, nil
+}
+
+// didsOf returns a provider's DIDs for its dialplan; the caller holds m.mu.
+func (m *Manager) didsOf(providerName string) []string {
+    return m.providerDIDs[providerName]
+}
+
+// validDID accepts digits with an optional leading +, as the router does.
+func validDID(did string) bool {
+    digits := strings.TrimPrefix(did, "+")
+    if len(digits) < 3 || len(digits) > 20 {
+        return false
+    }
+    for _, c := range digits {
+        if c < '0' || c > '9' {
+            return false
+        }
+    }
+    return true
 }
 
 func (m *Manager) GetAvailableDID(providerName string) (string, error) {
@@ -133,12 +381,17 @@ func (m *Manager) GetAvailableDID(providerName string) (string, error) {
     var args []interface{}
     
     if providerName != "" {
+        if _, exists := m.providers[providerName]; !exists {
+            return "", fmt.Errorf("%w: %s", ErrProviderNotFound, providerName)
+        }
+        
         // Get DID from specific provider
         query = `
             SELECT d.did 
             FROM dids d
             JOIN providers p ON d.provider_id = p.id
             WHERE d.in_use = 0 AND p.name = ? AND p.active = 1
+            AND ` + belowCapacity + `
             ORDER BY RAND() 
             LIMIT 1
             FOR UPDATE
@@ -151,6 +404,7 @@ func (m *Manager) GetAvailableDID(providerName string) (string, error) {
             FROM dids d
             JOIN providers p ON d.provider_id = p.id
             WHERE d.in_use = 0 AND p.active = 1
+            AND ` + belowCapacity + `
             ORDER BY RAND() 
             LIMIT 1
             FOR UPDATE
@@ -159,17 +413,54 @@ func (m *Manager) GetAvailableDID(providerName string) (string, error) {
     
     var did string
     err := m.db.QueryRow(query, args...).Scan(&did)
+    if err == sql.ErrNoRows {
+        if providerName != "" && m.atCapacity(providerName) {
+            return "", fmt.Errorf("%w: %s", ErrProviderAtCapacity, providerName)
+        }
+        if providerName != "" {
+            return "", fmt.Errorf("%w from provider %s", ErrNoDIDAvailable, providerName)
+        }
+        return "", ErrNoDIDAvailable
+    }
     if err != nil {
-        return "", fmt.Errorf("no available DIDs: %w", err)
+        return "", fmt.Errorf("failed to get available DID: %w", err)
     }
     
     return did, nil
 }
 
+// belowCapacity limits DID selection to providers carrying fewer calls than
+// their max_channels; 0 means unlimited.
+const belowCapacity = `(p.max_channels = 0 OR
+    (SELECT COUNT(*) FROM dids busy WHERE busy.provider_id = p.id AND busy.in_use = 1) < p.max_channels)`
+
+// atCapacity reports whether a provider is carrying max_channels calls.
+func (m *Manager) atCapacity(providerName string) bool {
+    provider := m.providers[providerName]
+    if provider.MaxChannels <= 0 {
+        return false
+    }
+    
+    var inUse int
+    if err := m.db.QueryRow(
+        "SELECT COUNT(*) FROM dids WHERE provider_id = ? AND in_use = 1", provider.ID,
+    ).Scan(&inUse); err != nil {
+        return false
+    }
+    return inUse >= provider.MaxChannels
+}
+
+// LoadProviders reads the active providers and their DIDs from the database
+// and swaps them in as a whole, so providers deactivated since the last load
+// are dropped and readers never see a partially loaded set.
 func (m *Manager) LoadProviders() error {
     rows, err := m.db.Query(`
         SELECT id, name, host, port, username, password, realm, transport, 
-               codecs, max_channels, active, country
+               codecs, max_channels, active, country, return_timeout, max_call_duration,
+               media_encryption, tls_cert_file, tls_key_file, tls_ca_file, tls_verify_server,
+               registration, registration_expiration, registration_retry_interval,
+               registration_server_uri, registration_client_uri, match_addresses, contacts,
+               template
         FROM providers
         WHERE active = 1
     `)
@@ -178,16 +469,18 @@ func (m *Manager) LoadProviders() error {
     }
     defer rows.Close()
     
-    m.mu.Lock()
-    defer m.mu.Unlock()
-    
+    providers := make(map[string]*models.Provider)
     for rows.Next() {
         p := &models.Provider{}
-        var codecsJSON []byte
+        var codecsJSON, matchJSON, contactsJSON []byte
         
         err := rows.Scan(&p.ID, &p.Name, &p.Host, &p.Port, &p.Username, 
             &p.Password, &p.Realm, &p.Transport, &codecsJSON, 
-            &p.MaxChannels, &p.Active, &p.Country)
+            &p.MaxChannels, &p.Active, &p.Country, &p.ReturnTimeout, &p.MaxCallDuration,
+            &p.MediaEncryption, &p.TLSCertFile, &p.TLSKeyFile, &p.TLSCAFile, &p.TLSVerifyServer,
+            &p.Registration, &p.RegistrationExpiration, &p.RegistrationRetryInterval,
+            &p.RegistrationServerURI, &p.RegistrationClientURI, &matchJSON, &contactsJSON,
+            &p.Template)
         
         if err != nil {
             log.Printf("Error loading provider: %v", err)
@@ -195,20 +488,37 @@ func (m *Manager) LoadProviders() error {
         }
         
         json.Unmarshal(codecsJSON, &p.Codecs)
-        m.providers[p.Name] = p
-        
-        // Load DIDs for this provider
-        m.loadProviderDIDs(p.Name, p.ID)
+        json.Unmarshal(matchJSON, &p.MatchAddresses)
+        json.Unmarshal(contactsJSON, &p.Contacts)
+        providers[p.Name] = p
     }
+    if err := rows.Err(); err != nil {
+        return err
+    }
+    
+    // Load DIDs for each provider
+    providerDIDs := make(map[string][]string, len(providers))
+    for name, p := range providers {
+        dids, err := m.loadProviderDIDs(p.ID)
+        if err != nil {
+            return fmt.Errorf("failed to load DIDs for %s: %w", name, err)
+        }
+        providerDIDs[name] = dids
+    }
+    
+    m.mu.Lock()
+    m.providers = providers
+    m.providerDIDs = providerDIDs
+    m.mu.Unlock()
     
-    log.Printf("Loaded %d providers", len(m.providers))
+    log.Printf("Loaded %d providers", len(providers))
     return nil
 }
 
-func (m *Manager) loadProviderDIDs(providerName string, providerID int) {
+func (m *Manager) loadProviderDIDs(providerID int) ([]string, error) {
     rows, err := m.db.Query("SELECT did FROM dids WHERE provider_id = ?", providerID)
     if err != nil {
-        return
+        return nil, err
     }
     defer rows.Close()
     
@@ -220,7 +530,62 @@ func (m *Manager) loadProviderDIDs(providerName string, providerID int) {
         }
     }
     
-    m.providerDIDs[providerName] = dids
+    return dids, rows.Err()
+}
+
+// Watch polls the providers and dids tables every interval and reloads when
+// they change, e.g. after `router provider add` from another process. It
+// returns when ctx is cancelled.
+func (m *Manager) Watch(ctx context.Context, interval time.Duration) {
+    last, err := m.fingerprint()
+    if err != nil {
+        log.Printf("Provider watch: %v", err)
+    }
+    
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+        
+        current, err := m.fingerprint()
+        if err != nil {
+            log.Printf("Provider watch: %v", err)
+            continue
+        }
+        if current == last {
+            continue
+        }
+        
+        log.Printf("Provider changes detected, reloading")
+        if err := m.LoadProviders(); err != nil {
+            log.Printf("Failed to reload providers: %v", err)
+            continue
+        }
+        last = current
+    }
+}
+
+// fingerprint summarises the provider and DID assignments so changes can be
+// detected cheaply. DID usage flags are left out since they change per call.
+func (m *Manager) fingerprint() (string, error) {
+    var providerCount, providerUpdated, didCount, didChecksum string
+    err := m.db.QueryRow(`
+        SELECT
+            (SELECT COUNT(*) FROM providers),
+            (SELECT COALESCE(UNIX_TIMESTAMP(MAX(updated_at)), 0) FROM providers),
+            (SELECT COUNT(*) FROM dids),
+            (SELECT COALESCE(SUM(CRC32(CONCAT(did, ':', provider_id))), 0) FROM dids)
+    `).Scan(&providerCount, &providerUpdated, &didCount, &didChecksum)
+    if err != nil {
+        return "", fmt.Errorf("failed to check for provider changes: %w", err)
+    }
+    
+    return strings.Join([]string{providerCount, providerUpdated, didCount, didChecksum}, "/"), nil
 }
 
 func (m *Manager) GetProvider(name string) (*models.Provider, error) {
@@ -229,12 +594,118 @@ func (m *Manager) GetProvider(name string) (*models.Provider, error) {
     
     provider, exists := m.providers[name]
     if !exists {
-        return nil, fmt.Errorf("provider %s not found", name)
+        return nil, fmt.Errorf("%w: %s", ErrProviderNotFound, name)
     }
     
     return provider, nil
 }
 
+// GetCredentials returns the SIP credentials of a provider. Callers are
+// responsible for only exposing them to privileged users.
+func (m *Manager) GetCredentials(name string) (*models.ProviderCredentials, error) {
+    provider, err := m.GetProvider(name)
+    if err != nil {
+        return nil, err
+    }
+    
+    password, err := decryptSecret(m.keyring, provider.Password, provider.Name)
+    if err != nil {
+        return nil, err
+    }
+    
+    return &models.ProviderCredentials{
+        Name:     provider.Name,
+        Username: provider.Username,
+        Password: password,
+        Realm:    provider.Realm,
+    }, nil
+}
+
+// RotateSecrets re-encrypts every stored provider password, including those
+// of inactive providers, with the keyring's primary key. Passwords stored
+// before encryption was enabled are encrypted too. It returns the number of
+// rows updated.
+func (m *Manager) RotateSecrets() (int, error) {
+    if m.keyring == nil {
+        return 0, fmt.Errorf("no encryption key configured")
+    }
+    
+    tx, err := m.db.Begin()
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback()
+    
+    rows, err := tx.Query("SELECT id, name, COALESCE(password, '') FROM providers FOR UPDATE")
+    if err != nil {
+        return 0, fmt.Errorf("failed to read providers: %w", err)
+    }
+    
+    type row struct {
+        id       int
+        name     string
+        password string
+    }
+    var pending []row
+    for rows.Next() {
+        var r row
+        if err := rows.Scan(&r.id, &r.name, &r.password); err != nil {
+            rows.Close()
+            return 0, err
+        }
+        if m.keyring.NeedsRotation(r.password) {
+            pending = append(pending, r)
+        }
+    }
+    rows.Close()
+    
+    for _, r := range pending {
+        plaintext, err := m.keyring.Decrypt(r.password, r.name)
+        if err != nil {
+            return 0, fmt.Errorf("provider %s: %w", r.name, err)
+        }
+        
+        encrypted, err := m.keyring.Encrypt(plaintext, r.name)
+        if err != nil {
+            return 0, fmt.Errorf("provider %s: %w", r.name, err)
+        }
+        
+        if _, err := tx.Exec("UPDATE providers SET password = ? WHERE id = ?", encrypted, r.id); err != nil {
+            return 0, fmt.Errorf("failed to update provider %s: %w", r.name, err)
+        }
+    }
+    
+    if err := tx.Commit(); err != nil {
+        return 0, err
+    }
+    
+    return len(pending), nil
+}
+
+// decryptSecret opens a stored secret, failing clearly when it is encrypted
+// but no keyring was configured.
+func decryptSecret(keyring *secrets.Keyring, value, context string) (string, error) {
+    if keyring == nil {
+        if secrets.IsEncrypted(value) {
+            return "", fmt.Errorf("secret for %s is encrypted but no encryption key is configured", context)
+        }
+        return value, nil
+    }
+    return keyring.Decrypt(value, context)
+}
+
+// ListProviderViews returns all providers with secrets masked.
+func (m *Manager) ListProviderViews() []*models.ProviderView {
+    providers := m.ListProviders()
+    
+    views := make([]*models.ProviderView, 0, len(providers))
+    for _, p := range providers {
+        views = append(views, p.View())
+    }
+    
+    return views
+}
+
 func (m *Manager) ListProviders() []*models.Provider {
     m.mu.RLock()
     defer m.mu.RUnlock()
@@ -247,6 +718,76 @@ func (m *Manager) ListProviders() []*models.Provider {
     return providers
 }
 
+// RenderConfig returns the Asterisk files generated for a provider without
+// writing them.
+func (m *Manager) RenderConfig(name string) ([]RenderedFile, error) {
+    p, err := m.GetProvider(name)
+    if err != nil {
+        return nil, err
+    }
+    
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    return m.asteriskGen.Render(p)
+}
+
+// RenderDialplan returns the generated router dialplan without writing it.
+func (m *Manager) RenderDialplan() (RenderedFile, error) {
+    return m.asteriskGen.RenderDialplan()
+}
+
+// GenerateDialplan writes the router dialplan and reloads it when it
+// changed, so the dialplan always matches the running router. It does
+// nothing when the router dialplan is not generated.
+func (m *Manager) GenerateDialplan(ctx context.Context) (*ReloadResult, error) {
+    m.configMu.Lock()
+    defer m.configMu.Unlock()
+    
+    w, err := m.asteriskGen.writeDialplan()
+    if err != nil || w == nil {
+        return nil, err
+    }
+    return m.asteriskGen.apply(ctx, w, true)
+}
+
+// DiffConfig compares the configuration of all active providers with the
+// files in the Asterisk config directory.
+func (m *Manager) DiffConfig() ([]FileDiff, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    
+    return m.asteriskGen.Diff(m.providerList())
+}
+
+// SyncConfig regenerates the configuration of all active providers and
+// removes files of providers that no longer exist or are inactive.
+func (m *Manager) SyncConfig(ctx context.Context, reload bool) (*SyncResult, error) {
+    m.configMu.Lock()
+    defer m.configMu.Unlock()
+    
+    m.mu.RLock()
+    result, w, err := m.asteriskGen.writeSync(m.providerList())
+    m.mu.RUnlock()
+    if err != nil {
+        return nil, err
+    }
+    
+    result.Reload, err = m.asteriskGen.apply(ctx, w, reload)
+    if err != nil {
+        return nil, err
+    }
+    return result, nil
+}
+
+// providerList returns the loaded providers; the caller holds m.mu.
+func (m *Manager) providerList() []*models.Provider {
+    providers := make([]*models.Provider, 0, len(m.providers))
+    for _, p := range m.providers {
+        providers = append(providers, p)
+    }
+    return providers
+}
+
 func (m *Manager) GetProviderStats(name string) (map[string]interface{}, error) {
     stats := make(map[string]interface{})
     
@@ -256,7 +797,7 @@ func (m *Manager) GetProviderStats(name string) (map[string]interface{}, error)
         return nil, err
     }
     
-    stats["provider"] = provider
+    stats["provider"] = provider.View()
     
     // Get DID counts
     var totalDIDs, usedDIDs int
@@ -284,5 +825,9 @@ func (m *Manager) GetProviderStats(name string) (map[string]interface{}, error)
         stats["active_calls"] = activeCalls
     }
     
+    if provider.Registration == RegistrationOutbound {
+        stats["registration"] = m.registrationStatus(provider)
+    }
+    
     return stats, nil
 }
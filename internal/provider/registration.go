@@ -0,0 +1,154 @@
+package provider
+
+import (
+    "context"
+    "fmt"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    
+    "github.com/router-production/internal/ami"
+    "github.com/router-production/internal/models"
+)
+
+// Registration modes
+const (
+    RegistrationNone     = "none"
+    RegistrationOutbound = "outbound"
+)
+
+// RegistrationUnknown is reported when Asterisk could not be asked
+const RegistrationUnknown = "Unknown"
+
+// registrationCacheTTL lets statistics for many providers share one query
+const registrationCacheTTL = 2 * time.Second
+
+// RegistrationStatus is the state of a provider's outbound registration.
+type RegistrationStatus struct {
+    Status  string `json:"status"`
+    // NextReg is the number of seconds until the next REGISTER
+    NextReg int    `json:"next_reg,omitempty"`
+    Error   string `json:"error,omitempty"`
+}
+
+// RegistrationSource reports outbound registrations keyed by their
+// registration section name.
+type RegistrationSource interface {
+    Registrations(ctx context.Context) (map[string]RegistrationStatus, error)
+}
+
+// AMIRegistrations reads registration states with PJSIPShowRegistrationsOutbound.
+type AMIRegistrations struct {
+    Client *ami.Client
+    
+    mu      sync.Mutex
+    fetched time.Time
+    cached  map[string]RegistrationStatus
+}
+
+func (a *AMIRegistrations) Registrations(ctx context.Context) (map[string]RegistrationStatus, error) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    
+    if a.cached != nil && time.Since(a.fetched) < registrationCacheTTL {
+        return a.cached, nil
+    }
+    
+    resp, err := a.Client.Action(ctx, ami.Message{"Action": "PJSIPShowRegistrationsOutbound"})
+    if err != nil {
+        return nil, err
+    }
+    if !resp.IsSuccess() {
+        return nil, fmt.Errorf("PJSIPShowRegistrationsOutbound: %s", resp.Get("Message"))
+    }
+    
+    registrations := make(map[string]RegistrationStatus)
+    for _, event := range resp.Events {
+        if event.Event() != "OutboundRegistrationDetail" {
+            continue
+        }
+        nextReg, _ := strconv.Atoi(event.Get("NextReg"))
+        registrations[event.Get("ObjectName")] = RegistrationStatus{
+            Status:  event.Get("Status"),
+            NextReg: nextReg,
+        }
+    }
+    
+    a.cached = registrations
+    a.fetched = time.Now()
+    return registrations, nil
+}
+
+// registrationName is the registration section generated for a provider
+func registrationName(providerName string) string {
+    return "trunk-" + providerName + "-reg"
+}
+
+// validateRegistration checks the registration settings of a provider.
+func validateRegistration(p *models.Provider) error {
+    switch p.Registration {
+    case RegistrationNone:
+        return nil
+    case RegistrationOutbound:
+    default:
+        return fmt.Errorf("%w: unsupported registration %q (none or outbound)", ErrInvalidProvider, p.Registration)
+    }
+    
+    if p.Username == "" {
+        return fmt.Errorf("%w: outbound registration requires a username", ErrInvalidProvider)
+    }
+    if p.RegistrationExpiration < 0 || p.RegistrationRetryInterval < 0 {
+        return fmt.Errorf("%w: registration expiration and retry interval must not be negative", ErrInvalidProvider)
+    }
+    for _, uri := range []string{p.RegistrationServerURI, p.RegistrationClientURI} {
+        if uri != "" && !strings.HasPrefix(uri, "sip:") && !strings.HasPrefix(uri, "sips:") {
+            return fmt.Errorf("%w: registration URI %q must start with sip: or sips:", ErrInvalidProvider, uri)
+        }
+    }
+    return nil
+}
+
+// registrationServerURI returns the registrar URI, defaulting to the provider address.
+func registrationServerURI(p *models.Provider) string {
+    if p.RegistrationServerURI != "" {
+        return p.RegistrationServerURI
+    }
+    return fmt.Sprintf("sip:%s%s", hostPort(p.Host, p.Port), uriTransport(p))
+}
+
+// registrationClientURI returns the address of record, defaulting to username@host.
+func registrationClientURI(p *models.Provider) string {
+    if p.RegistrationClientURI != "" {
+        return p.RegistrationClientURI
+    }
+    return fmt.Sprintf("sip:%s@%s%s", p.Username, p.Host, uriTransport(p))
+}
+
+// uriTransport is the transport URI parameter, omitted for UDP
+func uriTransport(p *models.Provider) string {
+    if p.Transport == "" || p.Transport == "udp" {
+        return ""
+    }
+    return ";transport=" + p.Transport
+}
+
+// registrationStatus asks Asterisk for the state of the provider's registration.
+func (m *Manager) registrationStatus(p *models.Provider) RegistrationStatus {
+    if m.registrations == nil {
+        return RegistrationStatus{Status: RegistrationUnknown, Error: "AMI not configured"}
+    }
+    
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    registrations, err := m.registrations.Registrations(ctx)
+    if err != nil {
+        return RegistrationStatus{Status: RegistrationUnknown, Error: err.Error()}
+    }
+    status, ok := registrations[registrationName(p.Name)]
+    if !ok {
+        return RegistrationStatus{Status: RegistrationUnknown, Error: "registration not loaded in Asterisk"}
+    }
+    return status
+}
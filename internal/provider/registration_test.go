@@ -0,0 +1,256 @@
+package provider
+
+import (
+    "context"
+    "errors"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+    
+    "github.com/router-production/internal/ami"
+    "github.com/router-production/internal/ami/amitest"
+    "github.com/router-production/internal/models"
+)
+
+func TestValidateRegistration(t *testing.T) {
+    tests := []struct {
+        name    string
+        modify  func(p *models.Provider)
+        wantErr string
+    }{
+        {name: "none", modify: func(p *models.Provider) {}},
+        {name: "none ignores other settings", modify: func(p *models.Provider) { p.RegistrationServerURI = "registrar" }},
+        {name: "outbound", modify: func(p *models.Provider) { p.Registration = RegistrationOutbound }},
+        {name: "outbound with URIs", modify: func(p *models.Provider) {
+            p.Registration = RegistrationOutbound
+            p.RegistrationServerURI = "sips:registrar.example.com"
+            p.RegistrationClientURI = "sip:router@example.com"
+        }},
+        {name: "unknown mode", modify: func(p *models.Provider) { p.Registration = "inbound" }, wantErr: `unsupported registration "inbound"`},
+        {name: "empty mode", modify: func(p *models.Provider) { p.Registration = "" }, wantErr: `unsupported registration ""`},
+        {name: "no username", modify: func(p *models.Provider) {
+            p.Registration = RegistrationOutbound
+            p.Username = ""
+        }, wantErr: "requires a username"},
+        {name: "negative expiration", modify: func(p *models.Provider) {
+            p.Registration = RegistrationOutbound
+            p.RegistrationExpiration = -1
+        }, wantErr: "must not be negative"},
+        {name: "negative retry interval", modify: func(p *models.Provider) {
+            p.Registration = RegistrationOutbound
+            p.RegistrationRetryInterval = -60
+        }, wantErr: "must not be negative"},
+        {name: "server URI without scheme", modify: func(p *models.Provider) {
+            p.Registration = RegistrationOutbound
+            p.RegistrationServerURI = "registrar.example.com"
+        }, wantErr: `registration URI "registrar.example.com"`},
+        {name: "client URI with other scheme", modify: func(p *models.Provider) {
+            p.Registration = RegistrationOutbound
+            p.RegistrationClientURI = "tel:+12125550100"
+        }, wantErr: `registration URI "tel:+12125550100"`},
+    }
+    
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            p := testProvider("carrier")
+            p.Username = "router"
+            tt.modify(p)
+            
+            err := validateRegistration(p)
+            if tt.wantErr == "" {
+                if err != nil {
+                    t.Errorf("validateRegistration: %v", err)
+                }
+                return
+            }
+            if !errors.Is(err, ErrInvalidProvider) || !strings.Contains(err.Error(), tt.wantErr) {
+                t.Errorf("err = %v, want ErrInvalidProvider mentioning %q", err, tt.wantErr)
+            }
+        })
+    }
+}
+
+func TestRegistrationURIs(t *testing.T) {
+    tests := []struct {
+        name       string
+        transport  string
+        port       int
+        server     string
+        client     string
+        wantServer string
+        wantClient string
+    }{
+        {
+            name: "udp", transport: "udp", port: 5060,
+            wantServer: "sip:198.51.100.10:5060",
+            wantClient: "sip:router@198.51.100.10",
+        },
+        {
+            name: "no transport", port: 5060,
+            wantServer: "sip:198.51.100.10:5060",
+            wantClient: "sip:router@198.51.100.10",
+        },
+        {
+            name: "tcp", transport: "tcp", port: 5080,
+            wantServer: "sip:198.51.100.10:5080;transport=tcp",
+            wantClient: "sip:router@198.51.100.10;transport=tcp",
+        },
+        {
+            name: "tls", transport: "tls", port: 5061,
+            wantServer: "sip:198.51.100.10:5061;transport=tls",
+            wantClient: "sip:router@198.51.100.10;transport=tls",
+        },
+        {
+            name: "configured URIs", transport: "tls", port: 5061,
+            server:     "sips:registrar.example.com",
+            client:     "sips:trunk42@example.com",
+            wantServer: "sips:registrar.example.com",
+            wantClient: "sips:trunk42@example.com",
+        },
+    }
+    
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            p := testProvider("carrier")
+            p.Username = "router"
+            p.Transport = tt.transport
+            p.Port = tt.port
+            p.RegistrationServerURI = tt.server
+            p.RegistrationClientURI = tt.client
+            
+            if got := registrationServerURI(p); got != tt.wantServer {
+                t.Errorf("server URI = %q, want %q", got, tt.wantServer)
+            }
+            if got := registrationClientURI(p); got != tt.wantClient {
+                t.Errorf("client URI = %q, want %q", got, tt.wantClient)
+            }
+        })
+    }
+}
+
+// registrationServer answers PJSIPShowRegistrationsOutbound with details.
+func registrationServer(t *testing.T, details ...ami.Message) (*amitest.Server, *ami.Client) {
+    t.Helper()
+    srv, err := amitest.NewServer("router", "secret")
+    if err != nil {
+        t.Fatalf("NewServer: %v", err)
+    }
+    t.Cleanup(srv.Close)
+    srv.Handle("PJSIPShowRegistrationsOutbound", func(action ami.Message) []ami.Message {
+        reply := []ami.Message{{"Response": "Success", "EventList": "start", "Message": "Following are Events for each Outbound registration"}}
+        for _, d := range details {
+            event := ami.Message{"Event": "OutboundRegistrationDetail"}
+            for k, v := range d {
+                event[k] = v
+            }
+            reply = append(reply, event)
+        }
+        // Auth sections of the registrations are listed too
+        reply = append(reply, ami.Message{"Event": "AuthDetail", "ObjectName": "trunk-a-auth"})
+        return append(reply, ami.Message{"Event": "OutboundRegistrationDetailComplete", "EventList": "Complete"})
+    })
+    return srv, connectAMI(t, srv)
+}
+
+// registrationQueries counts the registration queries srv received.
+func registrationQueries(srv *amitest.Server) int {
+    n := 0
+    for _, action := range srv.Actions() {
+        if action.Get("Action") == "PJSIPShowRegistrationsOutbound" {
+            n++
+        }
+    }
+    return n
+}
+
+func TestAMIRegistrations(t *testing.T) {
+    srv, client := registrationServer(t,
+        ami.Message{"ObjectName": "trunk-a-reg", "Status": "Registered", "NextReg": "3245"},
+        ami.Message{"ObjectName": "trunk-b-reg", "Status": "Rejected", "NextReg": "0"},
+        ami.Message{"ObjectName": "trunk-c-reg", "Status": "Unregistered"},
+    )
+    a := &AMIRegistrations{Client: client}
+    
+    got, err := a.Registrations(context.Background())
+    if err != nil {
+        t.Fatalf("Registrations: %v", err)
+    }
+    want := map[string]RegistrationStatus{
+        "trunk-a-reg": {Status: "Registered", NextReg: 3245},
+        "trunk-b-reg": {Status: "Rejected"},
+        "trunk-c-reg": {Status: "Unregistered"},
+    }
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("registrations = %+v, want %+v", got, want)
+    }
+    
+    // Statistics for several providers share one query
+    if _, err := a.Registrations(context.Background()); err != nil {
+        t.Fatalf("Registrations: %v", err)
+    }
+    if n := registrationQueries(srv); n != 1 {
+        t.Errorf("queried Asterisk %d times within the cache TTL, want 1", n)
+    }
+    
+    a.fetched = time.Now().Add(-registrationCacheTTL)
+    if _, err := a.Registrations(context.Background()); err != nil {
+        t.Fatalf("Registrations: %v", err)
+    }
+    if n := registrationQueries(srv); n != 2 {
+        t.Errorf("queried Asterisk %d times after the cache expired, want 2", n)
+    }
+}
+
+func TestAMIRegistrationsRejected(t *testing.T) {
+    srv, client := registrationServer(t)
+    srv.Handle("PJSIPShowRegistrationsOutbound", func(ami.Message) []ami.Message {
+        return []ami.Message{{"Response": "Error", "Message": "Permission denied"}}
+    })
+    a := &AMIRegistrations{Client: client}
+    
+    _, err := a.Registrations(context.Background())
+    if err == nil || !strings.Contains(err.Error(), "PJSIPShowRegistrationsOutbound: Permission denied") {
+        t.Fatalf("err = %v, want the AMI error", err)
+    }
+    
+    // Failures are not cached
+    a.Registrations(context.Background())
+    if n := registrationQueries(srv); n != 2 {
+        t.Errorf("queried Asterisk %d times after a failure, want 2", n)
+    }
+}
+
+// staticRegistrations is a RegistrationSource with fixed answers.
+type staticRegistrations struct {
+    registrations map[string]RegistrationStatus
+    err           error
+}
+
+func (s staticRegistrations) Registrations(context.Context) (map[string]RegistrationStatus, error) {
+    return s.registrations, s.err
+}
+
+func TestRegistrationStatus(t *testing.T) {
+    loaded := map[string]RegistrationStatus{"trunk-carrier-reg": {Status: "Registered", NextReg: 60}}
+    
+    tests := []struct {
+        name   string
+        source RegistrationSource
+        want   RegistrationStatus
+    }{
+        {"no AMI", nil, RegistrationStatus{Status: RegistrationUnknown, Error: "AMI not configured"}},
+        {"AMI error", staticRegistrations{err: errors.New("AMI not connected")}, RegistrationStatus{Status: RegistrationUnknown, Error: "AMI not connected"}},
+        {"not loaded", staticRegistrations{registrations: map[string]RegistrationStatus{}}, RegistrationStatus{Status: RegistrationUnknown, Error: "registration not loaded in Asterisk"}},
+        {"registered", staticRegistrations{registrations: loaded}, RegistrationStatus{Status: "Registered", NextReg: 60}},
+    }
+    
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            m := &Manager{registrations: tt.source}
+            if got := m.registrationStatus(testProvider("carrier")); got != tt.want {
+                t.Errorf("registrationStatus = %+v, want %+v", got, tt.want)
+            }
+        })
+    }
+}
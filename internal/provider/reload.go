@@ -0,0 +1,181 @@
+package provider
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "os/exec"
+    "strings"
+    
+    "github.com/router-production/internal/ami"
+)
+
+// Reload targets, reloaded only when their configuration changed
+const (
+    ReloadPJSIP    = "pjsip"
+    ReloadDialplan = "dialplan"
+)
+
+var reloadCommands = map[string]string{
+    ReloadPJSIP:    "module reload res_pjsip.so",
+    ReloadDialplan: "dialplan reload",
+}
+
+// reloadFailures are what the reload commands print, while still succeeding,
+// when Asterisk did not reload the module: the "module reload" results of
+// ast_module_reload in main/cli.c. "dialplan reload" reports failures only
+// through its response.
+var reloadFailures = map[string][]string{
+    ReloadPJSIP: {
+        "' was not found.",
+        "' reported a reload failure.",
+        "' was not properly initialized.",
+        "' does not support reloads",
+        "A module reload request is already in progress",
+    },
+}
+
+// reloadFailure returns the line of output reporting that target was not
+// reloaded, or "".
+func reloadFailure(target, output string) string {
+    for _, line := range strings.Split(output, "\n") {
+        for _, failure := range reloadFailures[target] {
+            if strings.Contains(line, failure) {
+                return strings.TrimSpace(line)
+            }
+        }
+    }
+    return ""
+}
+
+// ErrReloaderUnavailable is wrapped by reload errors caused by not reaching
+// Asterisk at all, as opposed to Asterisk rejecting the reload.
+var ErrReloaderUnavailable = errors.New("reload backend unavailable")
+
+// ReloadResult describes a reload that was carried out.
+type ReloadResult struct {
+    Backend  string   `json:"backend"`
+    Commands []string `json:"commands"`
+    Output   string   `json:"output,omitempty"`
+}
+
+// Reloader makes Asterisk pick up regenerated configuration.
+type Reloader interface {
+    // Name identifies the backend in results and logs
+    Name() string
+    Reload(ctx context.Context, targets ...string) (*ReloadResult, error)
+}
+
+// ExecReloader runs the asterisk CLI on the local machine.
+type ExecReloader struct {
+    // Binary defaults to "asterisk"
+    Binary string
+}
+
+func (e *ExecReloader) Name() string {
+    return "exec"
+}
+
+func (e *ExecReloader) Reload(ctx context.Context, targets ...string) (*ReloadResult, error) {
+    binary := e.Binary
+    if binary == "" {
+        binary = "asterisk"
+    }
+    
+    result := &ReloadResult{Backend: e.Name()}
+    for _, target := range targets {
+        command := reloadCommands[target]
+        out, err := exec.CommandContext(ctx, binary, "-rx", command).CombinedOutput()
+        output := strings.TrimSpace(string(out))
+        
+        switch {
+        case errors.Is(err, exec.ErrNotFound), strings.Contains(output, "Unable to connect to remote asterisk"):
+            return result, fmt.Errorf("%w: %s: %v %s", ErrReloaderUnavailable, binary, err, output)
+        case err != nil:
+            return result, fmt.Errorf("%s: %v %s", command, err, output)
+        }
+        if failure := reloadFailure(target, output); failure != "" {
+            return result, fmt.Errorf("%s: %s", command, failure)
+        }
+        
+        result.Commands = append(result.Commands, command)
+        result.Output = joinOutput(result.Output, output)
+    }
+    return result, nil
+}
+
+// AMIReloader reloads through the manager interface, which also works when
+// Asterisk runs on another host or container.
+type AMIReloader struct {
+    Client *ami.Client
+}
+
+func (a *AMIReloader) Name() string {
+    return "ami"
+}
+
+func (a *AMIReloader) Reload(ctx context.Context, targets ...string) (*ReloadResult, error) {
+    result := &ReloadResult{Backend: a.Name()}
+    if a.Client == nil || !a.Client.Connected() {
+        return result, fmt.Errorf("%w: %v", ErrReloaderUnavailable, ami.ErrNotConnected)
+    }
+    
+    for _, target := range targets {
+        command := reloadCommands[target]
+        resp, err := a.Client.Action(ctx, ami.Message{"Action": "Command", "Command": command})
+        if err != nil {
+            return result, fmt.Errorf("%w: %v", ErrReloaderUnavailable, err)
+        }
+        
+        // Asterisk answers "Response: Error" when the command is unknown or
+        // fails; older versions answer successful commands with "Follows"
+        output := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(resp.Get("Output")), "--END COMMAND--"))
+        if !resp.IsSuccess() && !strings.EqualFold(resp.Get("Response"), "Follows") {
+            if output == "" {
+                output = resp.Get("Message")
+            }
+            return result, fmt.Errorf("%s: %s", command, output)
+        }
+        if failure := reloadFailure(target, output); failure != "" {
+            return result, fmt.Errorf("%s: %s", command, failure)
+        }
+        
+        result.Commands = append(result.Commands, command)
+        result.Output = joinOutput(result.Output, output)
+    }
+    return result, nil
+}
+
+// FallbackReloader tries each backend in turn, moving on only when one
+// cannot reach Asterisk.
+type FallbackReloader []Reloader
+
+func (f FallbackReloader) Name() string {
+    names := make([]string, len(f))
+    for i, r := range f {
+        names[i] = r.Name()
+    }
+    return strings.Join(names, ",")
+}
+
+func (f FallbackReloader) Reload(ctx context.Context, targets ...string) (*ReloadResult, error) {
+    var result *ReloadResult
+    var err error
+    for _, r := range f {
+        result, err = r.Reload(ctx, targets...)
+        if !errors.Is(err, ErrReloaderUnavailable) {
+            return result, err
+        }
+    }
+    return result, err
+}
+
+func joinOutput(existing, output string) string {
+    if existing == "" {
+        return output
+    }
+    if output == "" {
+        return existing
+    }
+    return existing + "\n" + output
+}
@@ -0,0 +1,160 @@
+package provider
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "testing"
+    "time"
+    
+    "github.com/router-production/internal/ami"
+    "github.com/router-production/internal/ami/amitest"
+)
+
+func TestReloadFailure(t *testing.T) {
+    tests := []struct {
+        name   string
+        target string
+        output string
+        want   string
+    }{
+        {"reloaded", ReloadPJSIP, "Module 'res_pjsip.so' reloaded successfully.", ""},
+        {"no output", ReloadPJSIP, "", ""},
+        {"not found", ReloadPJSIP, "The module 'res_pjsip.so' was not found.", "The module 'res_pjsip.so' was not found."},
+        {"reload failure", ReloadPJSIP, "The module 'res_pjsip.so' reported a reload failure.", "The module 'res_pjsip.so' reported a reload failure."},
+        {"busy", ReloadPJSIP, "A module reload request is already in progress; please be patient", "A module reload request is already in progress; please be patient"},
+        {"failure on a later line", ReloadPJSIP, "  -- Reloading\nThe module 'res_pjsip.so' was not properly initialized.  Before reloading...", "The module 'res_pjsip.so' was not properly initialized.  Before reloading..."},
+        // Words alone do not mean the reload failed
+        {"unrelated failed", ReloadPJSIP, "Module 'res_pjsip.so' reloaded successfully. 0 contacts failed qualify", ""},
+        {"dialplan", ReloadDialplan, "Dialplan reloaded.", ""},
+        {"dialplan mentions not found", ReloadDialplan, "Dialplan reloaded. Include 'from-s3' not found yet", ""},
+    }
+    
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := reloadFailure(tt.target, tt.output); got != tt.want {
+                t.Errorf("reloadFailure = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+// commandServer answers each AMI Command with the reply for it.
+func commandServer(t *testing.T, replies map[string]ami.Message) *ami.Client {
+    t.Helper()
+    srv, err := amitest.NewServer("router", "secret")
+    if err != nil {
+        t.Fatalf("NewServer: %v", err)
+    }
+    t.Cleanup(srv.Close)
+    srv.Handle("Command", func(action ami.Message) []ami.Message {
+        reply, ok := replies[action.Get("Command")]
+        if !ok {
+            return []ami.Message{{"Response": "Error", "Message": "Command output follows", "Output": "No such command"}}
+        }
+        copied := ami.Message{}
+        for k, v := range reply {
+            copied[k] = v
+        }
+        return []ami.Message{copied}
+    })
+    return connectAMI(t, srv)
+}
+
+// connectAMI returns a client logged in to srv.
+func connectAMI(t *testing.T, srv *amitest.Server) *ami.Client {
+    t.Helper()
+    client := ami.NewClient(ami.Config{Address: srv.Addr(), Username: "router", Secret: "secret"})
+    ctx, cancel := context.WithCancel(context.Background())
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        client.Run(ctx)
+    }()
+    t.Cleanup(func() {
+        cancel()
+        <-done
+    })
+    
+    waitCtx, waitCancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer waitCancel()
+    if err := client.WaitConnected(waitCtx); err != nil {
+        t.Fatalf("WaitConnected: %v", err)
+    }
+    return client
+}
+
+func TestAMIReloader(t *testing.T) {
+    success := func(output string) ami.Message {
+        return ami.Message{"Response": "Success", "Message": "Command output follows", "Output": output}
+    }
+    
+    tests := []struct {
+        name         string
+        replies      map[string]ami.Message
+        wantCommands int
+        wantErr      string
+    }{
+        {
+            name: "both reloaded",
+            replies: map[string]ami.Message{
+                "module reload res_pjsip.so": success("Module 'res_pjsip.so' reloaded successfully."),
+                "dialplan reload":            success("Dialplan reloaded."),
+            },
+            wantCommands: 2,
+        },
+        {
+            name: "older follows response",
+            replies: map[string]ami.Message{
+                "module reload res_pjsip.so": {"Response": "Follows", "Output": "--END COMMAND--"},
+                "dialplan reload":            {"Response": "Follows", "Output": "Dialplan reloaded.\n--END COMMAND--"},
+            },
+            wantCommands: 2,
+        },
+        {
+            name: "module not found",
+            replies: map[string]ami.Message{
+                "module reload res_pjsip.so": success("The module 'res_pjsip.so' was not found."),
+            },
+            wantErr: "was not found",
+        },
+        {
+            name: "command rejected",
+            replies: map[string]ami.Message{
+                "module reload res_pjsip.so": success(""),
+            },
+            wantCommands: 1,
+            wantErr:      "dialplan reload: No such command",
+        },
+    }
+    
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            r := &AMIReloader{Client: commandServer(t, tt.replies)}
+            result, err := r.Reload(context.Background(), ReloadPJSIP, ReloadDialplan)
+            
+            if tt.wantErr == "" && err != nil {
+                t.Fatalf("Reload: %v", err)
+            }
+            if tt.wantErr != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                    t.Fatalf("err = %v, want %q", err, tt.wantErr)
+                }
+                // Asterisk answered, so this is not an unreachable backend
+                if errors.Is(err, ErrReloaderUnavailable) {
+                    t.Errorf("rejected reload wraps ErrReloaderUnavailable")
+                }
+            }
+            if len(result.Commands) != tt.wantCommands {
+                t.Errorf("commands = %q, want %d", result.Commands, tt.wantCommands)
+            }
+        })
+    }
+}
+
+func TestAMIReloaderNotConnected(t *testing.T) {
+    r := &AMIReloader{Client: ami.NewClient(ami.Config{Address: "127.0.0.1:1"})}
+    if _, err := r.Reload(context.Background(), ReloadDialplan); !errors.Is(err, ErrReloaderUnavailable) {
+        t.Errorf("err = %v, want ErrReloaderUnavailable", err)
+    }
+}
@@ -0,0 +1,213 @@
+package provider
+
+import (
+    "bytes"
+    "errors"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strings"
+    
+    "github.com/router-production/internal/models"
+)
+
+// Diff statuses
+const (
+    DiffAdded   = "added"
+    DiffChanged = "changed"
+    DiffRemoved = "removed"
+)
+
+// providerFilePatterns match the files the generator owns in the config directory
+var providerFilePatterns = []string{"pjsip_provider_*.conf", "extensions_provider_*.conf"}
+
+// includeLine matches a provider include in pjsip.conf or extensions.conf
+var includeLine = regexp.MustCompile(`^#include (pjsip|extensions)_provider_(.+)\.conf$`)
+
+// secretLine matches configuration lines carrying a password
+var secretLine = regexp.MustCompile(`(?m)^(\s*password\s*=).*$`)
+
+// secretParam matches the API key passed to the router in CURL() URLs
+var secretParam = regexp.MustCompile(`([?&]key=)[^&)]*`)
+
+// RenderedFile is one generated Asterisk configuration file.
+type RenderedFile struct {
+    Name    string
+    Content []byte
+}
+
+// FileDiff is a difference between the desired configuration and the file
+// in the config directory.
+type FileDiff struct {
+    Name   string
+    Status string // added, changed or removed
+    Old    []byte
+    New    []byte
+}
+
+// SyncResult reports what Sync changed.
+type SyncResult struct {
+    Written []string      `json:"written"`
+    Removed []string      `json:"removed"`
+    Reload  *ReloadResult `json:"reload,omitempty"`
+}
+
+// MaskSecrets replaces password and API key values so generated files can be printed.
+func MaskSecrets(data []byte) []byte {
+    data = secretLine.ReplaceAll(data, []byte("${1}"+models.MaskedSecret))
+    return secretParam.ReplaceAll(data, []byte("${1}"+models.MaskedSecret))
+}
+
+// desiredState returns the content every generated or main config file
+// should have for providers, and the provider files no provider owns.
+func (g *AsteriskConfigGenerator) desiredState(providers []*models.Provider) (map[string][]byte, []string, error) {
+    desired := make(map[string][]byte)
+    names := make(map[string]bool, len(providers))
+    for _, p := range providers {
+        files, err := g.Render(p)
+        if err != nil {
+            return nil, nil, err
+        }
+        for _, f := range files {
+            desired[f.Name] = f.Content
+        }
+        names[p.Name] = true
+    }
+    transports, err := g.renderTransports(providers)
+    if err != nil {
+        return nil, nil, err
+    }
+    desired[transports.Name] = transports.Content
+    if g.dialplan != nil {
+        router, err := g.RenderDialplan()
+        if err != nil {
+            return nil, nil, err
+        }
+        desired[router.Name] = router.Content
+    }
+    
+    // Main configs keep their own content, minus includes of unknown
+    // providers and plus the missing ones
+    for _, main := range []string{"pjsip.conf", "extensions.conf"} {
+        content, err := os.ReadFile(filepath.Join(g.configPath, main))
+        if errors.Is(err, os.ErrNotExist) {
+            continue
+        }
+        if err != nil {
+            return nil, nil, err
+        }
+        
+        var lines []string
+        for _, line := range strings.SplitAfter(string(content), "\n") {
+            m := includeLine.FindStringSubmatch(strings.TrimSpace(line))
+            if m != nil && !names[m[2]] {
+                continue
+            }
+            lines = append(lines, line)
+        }
+        updated := strings.Join(lines, "")
+        
+        var includes []string
+        if main == "pjsip.conf" {
+            includes = append(includes, "#include "+transportsFile)
+        }
+        if main == "extensions.conf" && g.dialplan != nil {
+            includes = append(includes, "#include "+routerDialplanFile)
+        }
+        for _, p := range providers {
+            includes = append(includes, providerIncludes(p.Name)[main]...)
+        }
+        for _, include := range includes {
+            if !strings.Contains(updated, include) {
+                updated += "\n" + include + "\n"
+            }
+        }
+        desired[main] = []byte(updated)
+    }
+    
+    var orphans []string
+    for _, pattern := range providerFilePatterns {
+        matches, err := filepath.Glob(filepath.Join(g.configPath, pattern))
+        if err != nil {
+            return nil, nil, err
+        }
+        for _, path := range matches {
+            if _, ok := desired[filepath.Base(path)]; !ok {
+                orphans = append(orphans, filepath.Base(path))
+            }
+        }
+    }
+    sort.Strings(orphans)
+    
+    return desired, orphans, nil
+}
+
+// Diff compares the desired configuration for providers with the files in
+// the config directory, sorted by file name.
+func (g *AsteriskConfigGenerator) Diff(providers []*models.Provider) ([]FileDiff, error) {
+    desired, orphans, err := g.desiredState(providers)
+    if err != nil {
+        return nil, err
+    }
+    
+    var diffs []FileDiff
+    for name, content := range desired {
+        existing, err := os.ReadFile(filepath.Join(g.configPath, name))
+        switch {
+        case errors.Is(err, os.ErrNotExist):
+            diffs = append(diffs, FileDiff{Name: name, Status: DiffAdded, New: content})
+        case err != nil:
+            return nil, err
+        case !bytes.Equal(existing, content):
+            diffs = append(diffs, FileDiff{Name: name, Status: DiffChanged, Old: existing, New: content})
+        }
+    }
+    for _, name := range orphans {
+        existing, err := os.ReadFile(filepath.Join(g.configPath, name))
+        if err != nil {
+            return nil, err
+        }
+        diffs = append(diffs, FileDiff{Name: name, Status: DiffRemoved, Old: existing})
+    }
+    
+    sort.Slice(diffs, func(i, j int) bool {
+        return diffs[i].Name < diffs[j].Name
+    })
+    return diffs, nil
+}
+
+// writeSync regenerates the files of all providers and removes orphaned
+// provider files. apply then validates and, when asked to, reloads the
+// affected modules; any failure restores the previous files.
+func (g *AsteriskConfigGenerator) writeSync(providers []*models.Provider) (*SyncResult, *configWrite, error) {
+    desired, orphans, err := g.desiredState(providers)
+    if err != nil {
+        return nil, nil, err
+    }
+    
+    names := make([]string, 0, len(desired))
+    for name := range desired {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    
+    w := &configWrite{}
+    result := &SyncResult{Written: []string{}, Removed: []string{}}
+    for _, name := range names {
+        changed, err := w.write(filepath.Join(g.configPath, name), desired[name])
+        if err != nil {
+            return nil, nil, g.abort(w, err)
+        }
+        if changed {
+            result.Written = append(result.Written, name)
+        }
+    }
+    for _, name := range orphans {
+        if err := w.remove(filepath.Join(g.configPath, name)); err != nil {
+            return nil, nil, g.abort(w, err)
+        }
+        result.Removed = append(result.Removed, name)
+    }
+    return result, w, nil
+}
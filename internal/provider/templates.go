@@ -0,0 +1,181 @@
+package provider
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strings"
+    "text/template"
+    
+    "github.com/router-production/internal/models"
+)
+
+// Template kinds, loaded from <kind>.conf.tmpl in a template directory
+const (
+    templatePJSIP      = "pjsip"
+    templateExtensions = "extensions"
+    templateTransports = "transports"
+    templateRouter     = "router"
+)
+
+// templateSetPattern restricts template set names to safe directory names
+var templateSetPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// sampleProvider takes every branch of the built-in templates, so template
+// errors surface when the templates are loaded instead of on first use.
+var sampleProvider = &models.Provider{
+    Name:                   "example",
+    Host:                   "192.0.2.1",
+    Port:                   5061,
+    Username:               "example",
+    Password:               "example",
+    Realm:                  "example.com",
+    Transport:              "tls",
+    Codecs:                 []string{"ulaw", "alaw"},
+    MaxChannels:            10,
+    MediaEncryption:        "dtls",
+    TLSCertFile:            "/etc/asterisk/keys/example.crt",
+    TLSKeyFile:             "/etc/asterisk/keys/example.key",
+    TLSCAFile:              "/etc/asterisk/keys/ca.crt",
+    Registration:           RegistrationOutbound,
+    RegistrationExpiration: 300,
+    MatchAddresses:         []string{"192.0.2.0/24"},
+    Contacts: []models.Contact{
+        {Host: "192.0.2.1", Port: 5061, Priority: 1},
+        {Host: "192.0.2.2", Port: 5061, Priority: 2},
+    },
+}
+
+var sampleTransports = []transportSection{
+    {Name: "transport-udp", Protocol: "udp", Bind: "0.0.0.0:5060"},
+    {Name: "transport-tls", Protocol: "tls", Bind: "0.0.0.0:5061", CertFile: "/etc/asterisk/keys/example.crt",
+        KeyFile: "/etc/asterisk/keys/example.key", CAFile: "/etc/asterisk/keys/ca.crt", VerifyServer: true},
+}
+
+// templateFuncs are the helpers available to every template.
+func (g *AsteriskConfigGenerator) templateFuncs() template.FuncMap {
+    return template.FuncMap{
+        // Passwords stay encrypted until the auth section is rendered
+        "decrypt": func(value, providerName string) (string, error) {
+            return decryptSecret(g.keyring, value, providerName)
+        },
+        "registrationServerURI": registrationServerURI,
+        "registrationClientURI": registrationClientURI,
+        "contacts":              providerContacts,
+        "matchAddresses":        matchAddresses,
+        "uriTransport":          uriTransport,
+        "hostPort":              hostPort,
+        "providerContext":       g.providerContext,
+        "returnContext":         g.returnContext,
+        "returnDIDs":            g.returnDIDs,
+        
+        // General purpose helpers for site templates
+        "join":      strings.Join,
+        "lower":     strings.ToLower,
+        "upper":     strings.ToUpper,
+        "replace":   strings.ReplaceAll,
+        "contains":  strings.Contains,
+        "hasPrefix": strings.HasPrefix,
+        "default": func(fallback, value interface{}) interface{} {
+            if value == nil || value == "" || value == 0 || value == false {
+                return fallback
+            }
+            return value
+        },
+    }
+}
+
+// loadTemplateDir overrides the built-in templates with the files at the
+// top of dir, and loads every subdirectory as a template set providers can
+// select. Sets fall back to the default templates for missing files.
+func (g *AsteriskConfigGenerator) loadTemplateDir(dir string) error {
+    if err := g.parseTemplateSet(dir, "", templatePJSIP, templateExtensions, templateTransports, templateRouter); err != nil {
+        return err
+    }
+    
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        return fmt.Errorf("failed to read template dir: %w", err)
+    }
+    for _, e := range entries {
+        if !e.IsDir() || !templateSetPattern.MatchString(e.Name()) {
+            continue
+        }
+        if err := g.parseTemplateSet(filepath.Join(dir, e.Name()), e.Name(), templatePJSIP, templateExtensions); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (g *AsteriskConfigGenerator) parseTemplateSet(dir, set string, kinds ...string) error {
+    if g.templates[set] == nil {
+        g.templates[set] = make(map[string]*template.Template)
+    }
+    
+    for _, kind := range kinds {
+        path := filepath.Join(dir, kind+".conf.tmpl")
+        text, err := os.ReadFile(path)
+        if errors.Is(err, os.ErrNotExist) {
+            continue
+        }
+        if err != nil {
+            return fmt.Errorf("failed to read template: %w", err)
+        }
+        
+        t, err := template.New(kind).Funcs(g.templateFuncs()).Parse(string(text))
+        if err != nil {
+            return fmt.Errorf("template %s: %w", path, err)
+        }
+        g.templates[set][kind] = t
+    }
+    return nil
+}
+
+// checkTemplates renders every template against sample data.
+func (g *AsteriskConfigGenerator) checkTemplates() error {
+    for set, kinds := range g.templates {
+        for kind := range kinds {
+            if kind == templateRouter {
+                if err := g.checkDialplanTemplate(); err != nil {
+                    return err
+                }
+                continue
+            }
+            
+            var data interface{} = sampleProvider
+            if kind == templateTransports {
+                data = sampleTransports
+            }
+            if err := g.template(set, kind).Execute(io.Discard, data); err != nil {
+                return fmt.Errorf("template %s: %w", templateLabel(set, kind), err)
+            }
+        }
+    }
+    return nil
+}
+
+// template returns the template of a kind from set, falling back to the
+// default set.
+func (g *AsteriskConfigGenerator) template(set, kind string) *template.Template {
+    if t, ok := g.templates[set][kind]; ok {
+        return t
+    }
+    return g.templates[""][kind]
+}
+
+// HasTemplate reports whether a template set exists; "" is the default set.
+func (g *AsteriskConfigGenerator) HasTemplate(set string) bool {
+    _, ok := g.templates[set]
+    return ok
+}
+
+func templateLabel(set, kind string) string {
+    if set == "" {
+        return kind
+    }
+    return set + "/" + kind
+}
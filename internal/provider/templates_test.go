@@ -0,0 +1,145 @@
+package provider
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// writeTemplates creates the template files, keyed by path relative to the
+// returned directory.
+func writeTemplates(t *testing.T, files map[string]string) string {
+    t.Helper()
+    dir := t.TempDir()
+    for name, text := range files {
+        path := filepath.Join(dir, name)
+        if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+            t.Fatal(err)
+        }
+        if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return dir
+}
+
+// renderWith renders a provider using template set with the templates in dir.
+func renderWith(t *testing.T, dir, set string) (pjsip, extensions string) {
+    t.Helper()
+    g, err := NewAsteriskConfigGenerator(t.TempDir(), nil, &fakeReloader{}, nil, dir, nil)
+    if err != nil {
+        t.Fatalf("NewAsteriskConfigGenerator: %v", err)
+    }
+    p := testProvider("carrier")
+    p.Template = set
+    files, err := g.Render(p)
+    if err != nil {
+        t.Fatalf("Render: %v", err)
+    }
+    return string(files[0].Content), string(files[1].Content)
+}
+
+func TestTemplateDirOverride(t *testing.T) {
+    dir := writeTemplates(t, map[string]string{
+        "pjsip.conf.tmpl": "; site endpoint for {{.Name}} at {{hostPort .Host .Port}}\n",
+    })
+    
+    pjsip, extensions := renderWith(t, dir, "")
+    if want := "; site endpoint for carrier at 198.51.100.10:5060\n"; pjsip != want {
+        t.Errorf("pjsip = %q, want the override %q", pjsip, want)
+    }
+    // No extensions.conf.tmpl in the directory, so the built-in one is used
+    if !strings.Contains(extensions, "[dial-trunk-carrier]") {
+        t.Errorf("extensions did not fall back to the built-in template:\n%s", extensions)
+    }
+}
+
+func TestTemplateSets(t *testing.T) {
+    dir := writeTemplates(t, map[string]string{
+        "pjsip.conf.tmpl":           "; site default {{.Name}}\n",
+        "acme/extensions.conf.tmpl": "; acme dialplan {{.Name}}\n",
+        "not.a-set/pjsip.conf.tmpl": "; ignored\n",
+    })
+    
+    pjsip, extensions := renderWith(t, dir, "acme")
+    if pjsip != "; site default carrier\n" {
+        t.Errorf("pjsip = %q, want the default set's override", pjsip)
+    }
+    if extensions != "; acme dialplan carrier\n" {
+        t.Errorf("extensions = %q, want the acme template", extensions)
+    }
+    
+    g, err := NewAsteriskConfigGenerator(t.TempDir(), nil, &fakeReloader{}, nil, dir, nil)
+    if err != nil {
+        t.Fatalf("NewAsteriskConfigGenerator: %v", err)
+    }
+    if g.HasTemplate("not.a-set") {
+        t.Error("directory with an invalid set name loaded as a template set")
+    }
+    p := testProvider("carrier")
+    p.Template = "missing"
+    if _, err := g.Render(p); !errors.Is(err, ErrInvalidProvider) {
+        t.Errorf("Render with unknown set: err = %v, want ErrInvalidProvider", err)
+    }
+}
+
+func TestTemplateDirErrors(t *testing.T) {
+    tests := []struct {
+        name    string
+        files   map[string]string
+        missing bool
+        wantErr string
+    }{
+        {
+            name:    "syntax error",
+            files:   map[string]string{"pjsip.conf.tmpl": "[trunk-{{.Name}\n"},
+            wantErr: "pjsip.conf.tmpl",
+        },
+        {
+            name:    "unknown function",
+            files:   map[string]string{"extensions.conf.tmpl": "{{frobnicate .Name}}\n"},
+            wantErr: `function "frobnicate" not defined`,
+        },
+        {
+            name:    "unknown provider field",
+            files:   map[string]string{"pjsip.conf.tmpl": "{{.Hostname}}\n"},
+            wantErr: "template pjsip:",
+        },
+        {
+            name:    "unknown transport field",
+            files:   map[string]string{"transports.conf.tmpl": "{{range .}}{{.Port}}{{end}}\n"},
+            wantErr: "template transports:",
+        },
+        {
+            name:    "error in a template set",
+            files:   map[string]string{"acme/pjsip.conf.tmpl": "{{.Hostname}}\n"},
+            wantErr: "template acme/pjsip:",
+        },
+        {
+            name:    "missing directory",
+            missing: true,
+            wantErr: "failed to read template dir",
+        },
+    }
+    
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            dir := writeTemplates(t, tt.files)
+            if tt.missing {
+                dir = filepath.Join(dir, "missing")
+            }
+            
+            // Template errors stop the server at startup rather than on the
+            // first provider change
+            _, err := NewManager(nil, Config{AsteriskConfigDir: t.TempDir(), TemplateDir: dir})
+            if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                t.Fatalf("err = %v, want %q", err, tt.wantErr)
+            }
+            if !strings.Contains(err.Error(), "failed to load asterisk templates") {
+                t.Errorf("err = %v, want it to name the templates", err)
+            }
+        })
+    }
+}
@@ -0,0 +1,218 @@
+package provider
+
+import (
+    "bufio"
+    "bytes"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    
+    "github.com/router-production/internal/models"
+)
+
+// transportsFile holds the transport sections generated for providers
+const transportsFile = "pjsip_transports.conf"
+
+// transportProtocols lists the supported transports in the order their
+// sections are generated, with the address each one binds to
+var transportProtocols = []struct {
+    protocol string
+    bind     string
+}{
+    {"udp", "0.0.0.0:5060"},
+    {"tcp", "0.0.0.0:5060"},
+    {"tls", "0.0.0.0:5061"},
+    // WebSocket transports are served by the Asterisk HTTP server
+    {"ws", "0.0.0.0"},
+    {"wss", "0.0.0.0"},
+}
+
+// transportSection is a [transport-<protocol>] section needed by providers.
+type transportSection struct {
+    Name         string
+    Protocol     string
+    Bind         string
+    CertFile     string
+    KeyFile      string
+    CAFile       string
+    VerifyServer bool
+    // owner is the provider the TLS settings were taken from
+    owner string
+}
+
+func transportName(protocol string) string {
+    return "transport-" + protocol
+}
+
+// validateTransport checks the transport and media encryption of a provider.
+func validateTransport(p *models.Provider) error {
+    known := false
+    for _, t := range transportProtocols {
+        known = known || t.protocol == p.Transport
+    }
+    if !known {
+        return fmt.Errorf("%w: unsupported transport %q (udp, tcp, tls, ws or wss)", ErrInvalidProvider, p.Transport)
+    }
+    
+    switch p.MediaEncryption {
+    case "", "no", "dtls":
+    case "sdes":
+        // SDES sends the SRTP keys in the SDP, which must not travel in clear
+        if p.Transport != "tls" && p.Transport != "wss" {
+            return fmt.Errorf("%w: media_encryption sdes requires the tls or wss transport", ErrInvalidProvider)
+        }
+    default:
+        return fmt.Errorf("%w: unsupported media_encryption %q (no, sdes or dtls)", ErrInvalidProvider, p.MediaEncryption)
+    }
+    
+    if (p.TLSCertFile == "") != (p.TLSKeyFile == "") {
+        return fmt.Errorf("%w: tls_cert_file and tls_key_file must be set together", ErrInvalidProvider)
+    }
+    return nil
+}
+
+// hasTLSSettings reports whether the provider sets any TLS transport option.
+func hasTLSSettings(p *models.Provider) bool {
+    return p.TLSCertFile != "" || p.TLSKeyFile != "" || p.TLSCAFile != "" || p.TLSVerifyServer
+}
+
+// transportSections returns the sections the providers' transports need.
+// Providers sharing the TLS transport must agree on its settings.
+func transportSections(providers []*models.Provider) ([]transportSection, error) {
+    used := make(map[string]bool)
+    var tls *models.Provider
+    for _, p := range providers {
+        used[p.Transport] = true
+        if p.Transport != "tls" || !hasTLSSettings(p) {
+            continue
+        }
+        
+        if tls != nil && (tls.TLSCertFile != p.TLSCertFile || tls.TLSKeyFile != p.TLSKeyFile ||
+            tls.TLSCAFile != p.TLSCAFile || tls.TLSVerifyServer != p.TLSVerifyServer) {
+            return nil, fmt.Errorf("providers %s and %s need different settings for %s", tls.Name, p.Name, transportName("tls"))
+        }
+        tls = p
+    }
+    
+    var sections []transportSection
+    for _, t := range transportProtocols {
+        if !used[t.protocol] {
+            continue
+        }
+        
+        section := transportSection{Name: transportName(t.protocol), Protocol: t.protocol, Bind: t.bind}
+        if t.protocol == "tls" && tls != nil {
+            section.CertFile = tls.TLSCertFile
+            section.KeyFile = tls.TLSKeyFile
+            section.CAFile = tls.TLSCAFile
+            section.VerifyServer = tls.TLSVerifyServer
+            section.owner = tls.Name
+        }
+        sections = append(sections, section)
+    }
+    return sections, nil
+}
+
+// renderTransports renders the transport sections the providers need that
+// pjsip.conf does not already define. Sections found in pjsip.conf are
+// checked against what the providers expect instead.
+func (g *AsteriskConfigGenerator) renderTransports(providers []*models.Provider) (RenderedFile, error) {
+    file := RenderedFile{Name: transportsFile}
+    
+    sections, err := transportSections(providers)
+    if err != nil {
+        return file, err
+    }
+    
+    existing, err := readSections(filepath.Join(g.configPath, "pjsip.conf"))
+    if err != nil {
+        return file, err
+    }
+    
+    var generate []transportSection
+    for _, s := range sections {
+        values, ok := existing[s.Name]
+        if !ok {
+            generate = append(generate, s)
+            continue
+        }
+        if err := verifyTransport(s, values); err != nil {
+            return file, err
+        }
+    }
+    
+    var buf bytes.Buffer
+    if err := g.template("", templateTransports).Execute(&buf, generate); err != nil {
+        return file, fmt.Errorf("failed to render %s: %w", transportsFile, err)
+    }
+    file.Content = buf.Bytes()
+    return file, nil
+}
+
+// verifyTransport checks a transport section defined outside the generator.
+func verifyTransport(s transportSection, values map[string]string) error {
+    if protocol := values["protocol"]; protocol != s.Protocol {
+        return fmt.Errorf("pjsip.conf: [%s] has protocol=%s, providers need %s", s.Name, protocol, s.Protocol)
+    }
+    if s.owner == "" {
+        return nil
+    }
+    
+    verify := "no"
+    if s.VerifyServer {
+        verify = "yes"
+    }
+    expected := map[string]string{
+        "cert_file":     s.CertFile,
+        "priv_key_file": s.KeyFile,
+        "ca_list_file":  s.CAFile,
+        "verify_server": verify,
+    }
+    for key, want := range expected {
+        got := values[key]
+        if key == "verify_server" && got == "" {
+            got = "no"
+        }
+        if got != want {
+            return fmt.Errorf("pjsip.conf: [%s] has %s=%s, provider %s needs %q", s.Name, key, got, s.owner, want)
+        }
+    }
+    return nil
+}
+
+// readSections parses the sections of an Asterisk config file into their
+// key/value options. A missing file has no sections.
+func readSections(filename string) (map[string]map[string]string, error) {
+    data, err := os.ReadFile(filename)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    
+    sections := make(map[string]map[string]string)
+    var current map[string]string
+    scanner := bufio.NewScanner(bytes.NewReader(data))
+    for scanner.Scan() {
+        line := scanner.Text()
+        if i := strings.Index(line, ";"); i >= 0 {
+            line = line[:i]
+        }
+        line = strings.TrimSpace(line)
+        
+        switch {
+        case strings.HasPrefix(line, "[") && strings.Contains(line, "]"):
+            name := line[1:strings.Index(line, "]")]
+            current = make(map[string]string)
+            sections[name] = current
+        case current != nil && strings.Contains(line, "="):
+            parts := strings.SplitN(line, "=", 2)
+            key := strings.TrimSpace(parts[0])
+            current[key] = strings.TrimSpace(strings.TrimPrefix(parts[1], ">"))
+        }
+    }
+    return sections, scanner.Err()
+}
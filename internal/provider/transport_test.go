@@ -0,0 +1,231 @@
+package provider
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    
+    "github.com/router-production/internal/models"
+)
+
+func TestValidateTransport(t *testing.T) {
+    tests := []struct {
+        name       string
+        transport  string
+        encryption string
+        cert, key  string
+        wantErr    string
+    }{
+        {name: "udp", transport: "udp"},
+        {name: "dtls over udp", transport: "udp", encryption: "dtls"},
+        {name: "sdes over tls", transport: "tls", encryption: "sdes"},
+        {name: "sdes over wss", transport: "wss", encryption: "sdes"},
+        {name: "sdes over udp", transport: "udp", encryption: "sdes", wantErr: "sdes requires the tls or wss transport"},
+        {name: "sdes over tcp", transport: "tcp", encryption: "sdes", wantErr: "sdes requires the tls or wss transport"},
+        {name: "sdes over ws", transport: "ws", encryption: "sdes", wantErr: "sdes requires the tls or wss transport"},
+        {name: "unknown transport", transport: "sctp", wantErr: `unsupported transport "sctp"`},
+        {name: "unknown encryption", transport: "tls", encryption: "zrtp", wantErr: `unsupported media_encryption "zrtp"`},
+        {name: "cert without key", transport: "tls", cert: "/etc/asterisk/keys/router.pem", wantErr: "must be set together"},
+        {name: "key without cert", transport: "tls", key: "/etc/asterisk/keys/router.key", wantErr: "must be set together"},
+    }
+    
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            p := testProvider("carrier")
+            p.Transport = tt.transport
+            p.MediaEncryption = tt.encryption
+            p.TLSCertFile = tt.cert
+            p.TLSKeyFile = tt.key
+            
+            err := validateTransport(p)
+            if tt.wantErr == "" {
+                if err != nil {
+                    t.Errorf("validateTransport: %v", err)
+                }
+                return
+            }
+            if !errors.Is(err, ErrInvalidProvider) || !strings.Contains(err.Error(), tt.wantErr) {
+                t.Errorf("err = %v, want ErrInvalidProvider mentioning %q", err, tt.wantErr)
+            }
+        })
+    }
+}
+
+// tlsProvider returns a provider on the TLS transport using cert.
+func tlsProvider(name, cert string) *models.Provider {
+    p := testProvider(name)
+    p.Transport = "tls"
+    p.Port = 5061
+    if cert != "" {
+        p.TLSCertFile = cert
+        p.TLSKeyFile = strings.TrimSuffix(cert, ".pem") + ".key"
+    }
+    return p
+}
+
+func TestTransportSections(t *testing.T) {
+    verifying := tlsProvider("verifying", "/keys/a.pem")
+    verifying.TLSVerifyServer = true
+    withCA := tlsProvider("with-ca", "/keys/a.pem")
+    withCA.TLSCAFile = "/keys/ca.pem"
+    tcp := testProvider("b")
+    tcp.Transport = "tcp"
+    
+    tests := []struct {
+        name      string
+        providers []*models.Provider
+        want      []string
+        wantOwner string
+        wantErr   string
+    }{
+        {
+            name:      "udp and tcp",
+            providers: []*models.Provider{testProvider("a"), tcp},
+            want:      []string{"transport-udp", "transport-tcp"},
+        },
+        {
+            name:      "shared transport generated once",
+            providers: []*models.Provider{testProvider("a"), testProvider("b")},
+            want:      []string{"transport-udp"},
+        },
+        {
+            name:      "tls with the same settings",
+            providers: []*models.Provider{tlsProvider("a", "/keys/a.pem"), tlsProvider("b", "/keys/a.pem")},
+            want:      []string{"transport-tls"},
+            wantOwner: "b",
+        },
+        {
+            name:      "tls provider without settings",
+            providers: []*models.Provider{tlsProvider("a", "/keys/a.pem"), tlsProvider("b", "")},
+            want:      []string{"transport-tls"},
+            wantOwner: "a",
+        },
+        {
+            name:      "different certificates",
+            providers: []*models.Provider{tlsProvider("a", "/keys/a.pem"), tlsProvider("b", "/keys/b.pem")},
+            wantErr:   "providers a and b need different settings for transport-tls",
+        },
+        {
+            name:      "different server verification",
+            providers: []*models.Provider{tlsProvider("a", "/keys/a.pem"), verifying},
+            wantErr:   "providers a and verifying need different settings",
+        },
+        {
+            name:      "different CA",
+            providers: []*models.Provider{tlsProvider("a", "/keys/a.pem"), withCA},
+            wantErr:   "providers a and with-ca need different settings",
+        },
+    }
+    
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            sections, err := transportSections(tt.providers)
+            if tt.wantErr != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                    t.Errorf("err = %v, want %q", err, tt.wantErr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("transportSections: %v", err)
+            }
+            
+            var names []string
+            for _, s := range sections {
+                names = append(names, s.Name)
+                if s.Protocol == "tls" && s.owner != tt.wantOwner {
+                    t.Errorf("TLS settings taken from %q, want %q", s.owner, tt.wantOwner)
+                }
+            }
+            if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+                t.Errorf("sections = %v, want %v", names, tt.want)
+            }
+        })
+    }
+}
+
+func TestRenderTransportsExistingSections(t *testing.T) {
+    verifying := tlsProvider("carrier", "/keys/a.pem")
+    verifying.TLSVerifyServer = true
+    
+    tests := []struct {
+        name         string
+        pjsip        string
+        provider     *models.Provider
+        wantSections []string
+        wantErr      string
+    }{
+        {
+            name:         "not in pjsip.conf",
+            pjsip:        "[global]\ntype=global\n",
+            provider:     testProvider("carrier"),
+            wantSections: []string{"[transport-udp]"},
+        },
+        {
+            name:     "defined in pjsip.conf",
+            pjsip:    "[transport-udp]\ntype=transport\nprotocol=udp ; public interface\nbind=0.0.0.0:5060\n",
+            provider: testProvider("carrier"),
+        },
+        {
+            name:     "mismatched protocol",
+            pjsip:    "[transport-udp]\ntype=transport\nprotocol=tcp\n",
+            provider: testProvider("carrier"),
+            wantErr:  "[transport-udp] has protocol=tcp, providers need udp",
+        },
+        {
+            name:     "matching certificate",
+            pjsip:    "[transport-tls]\ntype=transport\nprotocol=tls\ncert_file=/keys/a.pem\npriv_key_file => /keys/a.key\nca_list_file=\n",
+            provider: tlsProvider("carrier", "/keys/a.pem"),
+        },
+        {
+            name:     "mismatched certificate",
+            pjsip:    "[transport-tls]\ntype=transport\nprotocol=tls\ncert_file=/keys/other.pem\npriv_key_file=/keys/a.key\n",
+            provider: tlsProvider("carrier", "/keys/a.pem"),
+            wantErr:  `[transport-tls] has cert_file=/keys/other.pem, provider carrier needs "/keys/a.pem"`,
+        },
+        {
+            name:     "server verification off",
+            pjsip:    "[transport-tls]\ntype=transport\nprotocol=tls\ncert_file=/keys/a.pem\npriv_key_file=/keys/a.key\n",
+            provider: verifying,
+            wantErr:  `has verify_server=no, provider carrier needs "yes"`,
+        },
+        {
+            name:     "tls section without provider settings",
+            pjsip:    "[transport-tls]\ntype=transport\nprotocol=tls\ncert_file=/keys/site.pem\n",
+            provider: tlsProvider("carrier", ""),
+        },
+    }
+    
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            g, dir := newTestGenerator(t, &fakeReloader{}, nil)
+            if err := os.WriteFile(filepath.Join(dir, "pjsip.conf"), []byte(tt.pjsip), 0644); err != nil {
+                t.Fatal(err)
+            }
+            
+            file, err := g.renderTransports([]*models.Provider{tt.provider})
+            if tt.wantErr != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                    t.Errorf("err = %v, want %q", err, tt.wantErr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("renderTransports: %v", err)
+            }
+            
+            content := string(file.Content)
+            for _, section := range []string{"[transport-udp]", "[transport-tls]"} {
+                want := false
+                for _, s := range tt.wantSections {
+                    want = want || s == section
+                }
+                if got := strings.Contains(content, section); got != want {
+                    t.Errorf("%s generated = %v, want %v:\n%s", section, got, want, content)
+                }
+            }
+        })
+    }
+}
@@ -0,0 +1,161 @@
+package ratelimit
+
+import (
+    "math"
+    "sync"
+    "time"
+)
+
+// Idle buckets are dropped this often once they have refilled completely
+const sweepInterval = time.Minute
+
+// Limit allows Rate events per second with bursts of up to Burst. A zero
+// Rate disables limiting.
+type Limit struct {
+    Rate  float64 `json:"rate"`
+    Burst int     `json:"burst"`
+}
+
+// Enabled reports whether the limit restricts anything.
+func (l Limit) Enabled() bool {
+    return l.Rate > 0
+}
+
+// burst is the bucket size, at least one event.
+func (l Limit) burst() float64 {
+    if l.Burst > 0 {
+        return float64(l.Burst)
+    }
+    return math.Max(1, math.Ceil(l.Rate))
+}
+
+type bucket struct {
+    tokens float64
+    last   time.Time
+}
+
+// Limiter keeps a token bucket per key, e.g. per client IP or provider.
+type Limiter struct {
+    mu        sync.Mutex
+    limit     Limit
+    overrides map[string]Limit
+    buckets   map[string]*bucket
+    allowed   int64
+    rejected  int64
+    lastSweep time.Time
+    // now is the clock, replaced in tests
+    now func() time.Time
+}
+
+// Stats describes a limiter's settings and counters.
+type Stats struct {
+    Limit
+    Overrides   map[string]Limit `json:"overrides,omitempty"`
+    Allowed     int64            `json:"allowed"`
+    Rejected    int64            `json:"rejected"`
+    TrackedKeys int              `json:"tracked_keys"`
+}
+
+func New(limit Limit) *Limiter {
+    return &Limiter{
+        limit:     limit,
+        buckets:   make(map[string]*bucket),
+        lastSweep: time.Now(),
+        now:       time.Now,
+    }
+}
+
+// SetLimits replaces the default limit and the per-key overrides. Existing
+// buckets keep their tokens, capped at the new burst.
+func (l *Limiter) SetLimits(limit Limit, overrides map[string]Limit) {
+    copied := make(map[string]Limit, len(overrides))
+    for key, o := range overrides {
+        copied[key] = o
+    }
+    
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    
+    l.limit = limit
+    l.overrides = copied
+    for key, b := range l.buckets {
+        b.tokens = math.Min(b.tokens, l.limitFor(key).burst())
+    }
+}
+
+func (l *Limiter) limitFor(key string) Limit {
+    if o, ok := l.overrides[key]; ok {
+        return o
+    }
+    return l.limit
+}
+
+// Allow takes a token for key. When none is left it returns false and how
+// long until one is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    
+    limit := l.limitFor(key)
+    if !limit.Enabled() {
+        l.allowed++
+        return true, 0
+    }
+    
+    now := l.now()
+    l.sweep(now)
+    
+    b, ok := l.buckets[key]
+    if !ok {
+        b = &bucket{tokens: limit.burst(), last: now}
+        l.buckets[key] = b
+    }
+    
+    // Refill for the time since the last request
+    b.tokens = math.Min(limit.burst(), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+    b.last = now
+    
+    if b.tokens >= 1 {
+        b.tokens--
+        l.allowed++
+        return true, 0
+    }
+    
+    l.rejected++
+    wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+    return false, wait
+}
+
+// sweep forgets buckets that have been idle long enough to be full again.
+func (l *Limiter) sweep(now time.Time) {
+    if now.Sub(l.lastSweep) < sweepInterval {
+        return
+    }
+    l.lastSweep = now
+    
+    for key, b := range l.buckets {
+        limit := l.limitFor(key)
+        if !limit.Enabled() || b.tokens+now.Sub(b.last).Seconds()*limit.Rate >= limit.burst() {
+            delete(l.buckets, key)
+        }
+    }
+}
+
+func (l *Limiter) Stats() Stats {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    
+    stats := Stats{
+        Limit:       l.limit,
+        Allowed:     l.allowed,
+        Rejected:    l.rejected,
+        TrackedKeys: len(l.buckets),
+    }
+    if len(l.overrides) > 0 {
+        stats.Overrides = make(map[string]Limit, len(l.overrides))
+        for key, o := range l.overrides {
+            stats.Overrides[key] = o
+        }
+    }
+    return stats
+}
@@ -0,0 +1,142 @@
+package ratelimit
+
+import (
+    "testing"
+    "time"
+)
+
+// fakeClock is a clock moved by hand.
+type fakeClock struct {
+    t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter(limit Limit) (*Limiter, *fakeClock) {
+    clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+    l := New(limit)
+    l.now = clock.now
+    l.lastSweep = clock.t
+    return l, clock
+}
+
+func TestLimitBurst(t *testing.T) {
+    tests := []struct {
+        limit Limit
+        want  float64
+    }{
+        {Limit{Rate: 5, Burst: 10}, 10},
+        {Limit{Rate: 2.5}, 3},
+        {Limit{Rate: 0.1}, 1},
+    }
+
+    for _, tt := range tests {
+        if got := tt.limit.burst(); got != tt.want {
+            t.Errorf("%+v burst = %v, want %v", tt.limit, got, tt.want)
+        }
+    }
+}
+
+func TestAllowBurstThenRefill(t *testing.T) {
+    l, clock := newTestLimiter(Limit{Rate: 2, Burst: 3})
+
+    for i := 0; i < 3; i++ {
+        if ok, _ := l.Allow("10.0.0.1"); !ok {
+            t.Fatalf("request %d within the burst was rejected", i+1)
+        }
+    }
+
+    ok, wait := l.Allow("10.0.0.1")
+    if ok {
+        t.Fatalf("request beyond the burst was allowed")
+    }
+    if wait != 500*time.Millisecond {
+        t.Errorf("wait = %s, want 500ms", wait)
+    }
+
+    // Other keys have their own bucket
+    if ok, _ := l.Allow("10.0.0.2"); !ok {
+        t.Errorf("another key was rejected")
+    }
+
+    clock.advance(500 * time.Millisecond)
+    if ok, _ := l.Allow("10.0.0.1"); !ok {
+        t.Errorf("request after the wait was rejected")
+    }
+    if ok, _ := l.Allow("10.0.0.1"); ok {
+        t.Errorf("second request after one token refilled was allowed")
+    }
+
+    // Refill stops at the burst
+    clock.advance(time.Hour)
+    allowed := 0
+    for i := 0; i < 5; i++ {
+        if ok, _ := l.Allow("10.0.0.1"); ok {
+            allowed++
+        }
+    }
+    if allowed != 3 {
+        t.Errorf("allowed %d after a long idle time, want the burst of 3", allowed)
+    }
+
+    stats := l.Stats()
+    if stats.Allowed != 8 || stats.Rejected != 4 {
+        t.Errorf("stats allowed %d rejected %d, want 8 and 4", stats.Allowed, stats.Rejected)
+    }
+}
+
+func TestAllowDisabled(t *testing.T) {
+    l, _ := newTestLimiter(Limit{})
+
+    for i := 0; i < 100; i++ {
+        if ok, _ := l.Allow("10.0.0.1"); !ok {
+            t.Fatalf("disabled limiter rejected request %d", i+1)
+        }
+    }
+    if stats := l.Stats(); stats.TrackedKeys != 0 {
+        t.Errorf("disabled limiter tracks %d keys", stats.TrackedKeys)
+    }
+}
+
+func TestSetLimitsOverrides(t *testing.T) {
+    l, _ := newTestLimiter(Limit{Rate: 1, Burst: 5})
+
+    for i := 0; i < 2; i++ {
+        l.Allow("carrier-a")
+    }
+
+    // Existing buckets are capped at the new burst
+    l.SetLimits(Limit{Rate: 1, Burst: 1}, map[string]Limit{"carrier-b": {}})
+    if ok, _ := l.Allow("carrier-a"); !ok {
+        t.Errorf("first request after lowering the burst was rejected")
+    }
+    if ok, _ := l.Allow("carrier-a"); ok {
+        t.Errorf("request beyond the lowered burst was allowed")
+    }
+
+    // A zero override disables the limit for that key only
+    for i := 0; i < 10; i++ {
+        if ok, _ := l.Allow("carrier-b"); !ok {
+            t.Fatalf("key with a disabled override was rejected")
+        }
+    }
+
+    stats := l.Stats()
+    if _, ok := stats.Overrides["carrier-b"]; !ok || stats.Limit.Burst != 1 {
+        t.Errorf("stats = %+v", stats)
+    }
+}
+
+func TestSweepDropsFullBuckets(t *testing.T) {
+    l, clock := newTestLimiter(Limit{Rate: 1, Burst: 2})
+
+    l.Allow("idle")
+    clock.advance(sweepInterval)
+    l.Allow("busy")
+
+    if stats := l.Stats(); stats.TrackedKeys != 1 {
+        t.Errorf("tracked keys = %d, want only the busy one", stats.TrackedKeys)
+    }
+}
@@ -0,0 +1,314 @@
+package router
+
+import (
+    "database/sql"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/router-production/internal/models"
+)
+
+const (
+    defaultCallLimit = 50
+    maxCallLimit     = 500
+)
+
+// sortable call_records columns, keyed by the name accepted from callers
+var callSortColumns = map[string]string{
+    "start_time": "start_time",
+    "duration":   "duration",
+    "id":         "id",
+}
+
+// CallFilter selects call records for ListCalls. Zero values are ignored.
+type CallFilter struct {
+    Statuses []models.CallState
+    Provider string
+    DID      string
+    ANI      string
+    DNIS     string
+    From     time.Time
+    To       time.Time
+    SortBy   string // start_time, duration or id
+    Order    string // asc or desc
+    Limit    int
+    Cursor   string
+}
+
+// CallPage is one page of ListCalls results.
+type CallPage struct {
+    Calls      []*models.CallRecord `json:"calls"`
+    NextCursor string               `json:"next_cursor,omitempty"`
+}
+
+// callCursor marks the last row of a page so the next page can continue
+// after it without using OFFSET.
+type callCursor struct {
+    SortBy string `json:"s"`
+    Order  string `json:"o"`
+    Value  string `json:"v"`
+    ID     int64  `json:"id"`
+}
+
+const callColumns = `
+    id, call_id, COALESCE(original_ani, ''), COALESCE(original_dnis, ''),
+    COALESCE(assigned_did, ''), COALESCE(provider_id, 0), COALESCE(provider_name, ''),
+    COALESCE(status, ''), start_time, end_time, COALESCE(duration, 0),
+    COALESCE(recording_path, '')
+`
+
+func scanCall(row interface{ Scan(...interface{}) error }) (*models.CallRecord, error) {
+    record := &models.CallRecord{}
+    err := row.Scan(
+        &record.ID, &record.CallID, &record.OriginalANI, &record.OriginalDNIS,
+        &record.AssignedDID, &record.ProviderID, &record.ProviderName,
+        &record.Status, &record.StartTime, &record.EndTime, &record.Duration,
+        &record.RecordingPath,
+    )
+    return record, err
+}
+
+// ParseCallStates parses a comma separated list of call states.
+func ParseCallStates(s string) ([]models.CallState, error) {
+    var states []models.CallState
+    for _, part := range strings.Split(s, ",") {
+        part = strings.ToUpper(strings.TrimSpace(part))
+        if part == "" {
+            continue
+        }
+
+        state := models.CallState(part)
+        switch state {
+        case models.CallStateActive, models.CallStateForwarded, models.CallStateReturned,
+            models.CallStateCompleted, models.CallStateFailed:
+            states = append(states, state)
+        default:
+            return nil, fmt.Errorf("%w: unknown call status %q", ErrInvalidFilter, part)
+        }
+    }
+    return states, nil
+}
+
+// ListCalls returns call records matching the filter, one page at a time.
+func (r *Router) ListCalls(f CallFilter) (*CallPage, error) {
+    query, args, err := buildCallQuery(&f)
+    if err != nil {
+        return nil, err
+    }
+
+    rows, err := r.db.Query(query, args...)
+    if err != nil {
+        return nil, fmt.Errorf("failed to query calls: %w", err)
+    }
+    defer rows.Close()
+
+    page := &CallPage{Calls: make([]*models.CallRecord, 0, f.Limit)}
+    for rows.Next() {
+        record, err := scanCall(rows)
+        if err != nil {
+            return nil, fmt.Errorf("failed to read call: %w", err)
+        }
+        page.Calls = append(page.Calls, record)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("failed to query calls: %w", err)
+    }
+
+    if len(page.Calls) > f.Limit {
+        page.Calls = page.Calls[:f.Limit]
+        last := page.Calls[len(page.Calls)-1]
+        page.NextCursor = encodeCallCursor(callCursor{
+            SortBy: f.SortBy,
+            Order:  f.Order,
+            Value:  sortValue(f.SortBy, last),
+            ID:     last.ID,
+        })
+    }
+
+    return page, nil
+}
+
+// buildCallQuery fills in the filter defaults and returns the page query
+// for it, which fetches one row more than the limit.
+func buildCallQuery(f *CallFilter) (string, []interface{}, error) {
+    if f.SortBy == "" {
+        f.SortBy = "start_time"
+    }
+    column, ok := callSortColumns[f.SortBy]
+    if !ok {
+        return "", nil, fmt.Errorf("%w: unknown sort field %q", ErrInvalidFilter, f.SortBy)
+    }
+
+    f.Order = strings.ToLower(f.Order)
+    if f.Order == "" {
+        f.Order = "desc"
+    }
+    if f.Order != "asc" && f.Order != "desc" {
+        return "", nil, fmt.Errorf("%w: unknown sort order %q", ErrInvalidFilter, f.Order)
+    }
+
+    if f.Limit <= 0 {
+        f.Limit = defaultCallLimit
+    }
+    if f.Limit > maxCallLimit {
+        f.Limit = maxCallLimit
+    }
+
+    where := []string{"1 = 1"}
+    args := []interface{}{}
+
+    if len(f.Statuses) > 0 {
+        placeholders := make([]string, len(f.Statuses))
+        for i, s := range f.Statuses {
+            placeholders[i] = "?"
+            args = append(args, s)
+        }
+        where = append(where, fmt.Sprintf("status IN (%s)", strings.Join(placeholders, ", ")))
+    }
+    if f.Provider != "" {
+        where = append(where, "provider_name = ?")
+        args = append(args, f.Provider)
+    }
+    if f.DID != "" {
+        where = append(where, "assigned_did = ?")
+        args = append(args, f.DID)
+    }
+    if f.ANI != "" {
+        where = append(where, "original_ani = ?")
+        args = append(args, f.ANI)
+    }
+    if f.DNIS != "" {
+        where = append(where, "original_dnis = ?")
+        args = append(args, f.DNIS)
+    }
+    if !f.From.IsZero() {
+        where = append(where, "start_time >= ?")
+        args = append(args, f.From)
+    }
+    if !f.To.IsZero() {
+        where = append(where, "start_time < ?")
+        args = append(args, f.To)
+    }
+
+    if f.Cursor != "" {
+        c, err := decodeCallCursor(f.Cursor)
+        if err != nil {
+            return "", nil, err
+        }
+        if c.SortBy != f.SortBy || c.Order != f.Order {
+            return "", nil, fmt.Errorf("%w: cursor does not match sort %s %s", ErrInvalidFilter, f.SortBy, f.Order)
+        }
+
+        value, err := cursorValue(f.SortBy, c.Value)
+        if err != nil {
+            return "", nil, err
+        }
+
+        op := "<"
+        if f.Order == "asc" {
+            op = ">"
+        }
+        if column == "id" {
+            where = append(where, fmt.Sprintf("id %s ?", op))
+            args = append(args, c.ID)
+        } else {
+            where = append(where, fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", column, op, column, op))
+            args = append(args, value, value, c.ID)
+        }
+    }
+
+    query := fmt.Sprintf(`
+        SELECT %s
+        FROM call_records
+        WHERE %s
+        ORDER BY %s %s, id %s
+        LIMIT ?
+    `, callColumns, strings.Join(where, " AND "), column, f.Order, f.Order)
+
+    // Fetch one extra row to know whether another page exists
+    args = append(args, f.Limit+1)
+
+    return query, args, nil
+}
+
+// GetCall returns a single call record by call ID.
+func (r *Router) GetCall(callID string) (*models.CallRecord, error) {
+    row := r.db.QueryRow(fmt.Sprintf("SELECT %s FROM call_records WHERE call_id = ?", callColumns), callID)
+    record, err := scanCall(row)
+    if err == sql.ErrNoRows {
+        return nil, fmt.Errorf("%w: %s", ErrCallNotFound, callID)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to query call: %w", err)
+    }
+    return record, nil
+}
+
+// ListActiveCalls returns a snapshot of the calls currently tracked in memory,
+// oldest first.
+func (r *Router) ListActiveCalls() []*models.CallRecord {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+
+    calls := make([]*models.CallRecord, 0, len(r.activeCallsMap))
+    for _, record := range r.activeCallsMap {
+        c := *record
+        calls = append(calls, &c)
+    }
+
+    sort.Slice(calls, func(i, j int) bool {
+        return calls[i].StartTime.Before(calls[j].StartTime)
+    })
+
+    return calls
+}
+
+func sortValue(sortBy string, record *models.CallRecord) string {
+    switch sortBy {
+    case "start_time":
+        return record.StartTime.UTC().Format(time.RFC3339Nano)
+    case "duration":
+        return strconv.Itoa(record.Duration)
+    }
+    return ""
+}
+
+func cursorValue(sortBy, value string) (interface{}, error) {
+    switch sortBy {
+    case "start_time":
+        t, err := time.Parse(time.RFC3339Nano, value)
+        if err != nil {
+            return nil, fmt.Errorf("%w: bad cursor", ErrInvalidFilter)
+        }
+        return t, nil
+    case "duration":
+        d, err := strconv.Atoi(value)
+        if err != nil {
+            return nil, fmt.Errorf("%w: bad cursor", ErrInvalidFilter)
+        }
+        return d, nil
+    }
+    return nil, nil
+}
+
+func encodeCallCursor(c callCursor) string {
+    data, _ := json.Marshal(c)
+    return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCallCursor(s string) (callCursor, error) {
+    var c callCursor
+    data, err := base64.RawURLEncoding.DecodeString(s)
+    if err != nil {
+        return c, fmt.Errorf("%w: bad cursor", ErrInvalidFilter)
+    }
+    if err := json.Unmarshal(data, &c); err != nil {
+        return c, fmt.Errorf("%w: bad cursor", ErrInvalidFilter)
+    }
+    return c, nil
+}
@@ -0,0 +1,213 @@
+package router
+
+import (
+    "errors"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/router-production/internal/models"
+)
+
+// compact collapses the query whitespace so fragments can be matched.
+func compact(query string) string {
+    return strings.Join(strings.Fields(query), " ")
+}
+
+func TestBuildCallQueryDefaults(t *testing.T) {
+    tests := []struct {
+        name      string
+        filter    CallFilter
+        wantLimit int
+        wantOrder string
+    }{
+        {"defaults", CallFilter{}, defaultCallLimit, "ORDER BY start_time desc, id desc"},
+        {"limit above max", CallFilter{Limit: 10000}, maxCallLimit, "ORDER BY start_time desc, id desc"},
+        {"ascending duration", CallFilter{SortBy: "duration", Order: "ASC", Limit: 5}, 5, "ORDER BY duration asc, id asc"},
+        {"by id", CallFilter{SortBy: "id"}, defaultCallLimit, "ORDER BY id desc, id desc"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            f := tt.filter
+            query, args, err := buildCallQuery(&f)
+            if err != nil {
+                t.Fatalf("buildCallQuery: %v", err)
+            }
+            if f.Limit != tt.wantLimit {
+                t.Errorf("limit = %d, want %d", f.Limit, tt.wantLimit)
+            }
+            if !strings.Contains(compact(query), tt.wantOrder) {
+                t.Errorf("query %q does not contain %q", compact(query), tt.wantOrder)
+            }
+            // One extra row tells whether there is another page
+            if got := args[len(args)-1]; got != tt.wantLimit+1 {
+                t.Errorf("LIMIT arg = %v, want %d", got, tt.wantLimit+1)
+            }
+        })
+    }
+}
+
+func TestBuildCallQueryFilters(t *testing.T) {
+    from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    to := from.Add(24 * time.Hour)
+
+    tests := []struct {
+        name      string
+        filter    CallFilter
+        wantWhere string
+        wantArgs  []interface{}
+    }{
+        {
+            name:      "no filters",
+            filter:    CallFilter{},
+            wantWhere: "WHERE 1 = 1 ORDER BY",
+            wantArgs:  []interface{}{},
+        },
+        {
+            name:      "statuses",
+            filter:    CallFilter{Statuses: []models.CallState{models.CallStateActive, models.CallStateFailed}},
+            wantWhere: "WHERE 1 = 1 AND status IN (?, ?) ORDER BY",
+            wantArgs:  []interface{}{models.CallStateActive, models.CallStateFailed},
+        },
+        {
+            name:      "numbers and provider",
+            filter:    CallFilter{Provider: "carrier-a", DID: "15550001", ANI: "15551111", DNIS: "15552222"},
+            wantWhere: "WHERE 1 = 1 AND provider_name = ? AND assigned_did = ? AND original_ani = ? AND original_dnis = ? ORDER BY",
+            wantArgs:  []interface{}{"carrier-a", "15550001", "15551111", "15552222"},
+        },
+        {
+            name:      "time range",
+            filter:    CallFilter{From: from, To: to},
+            wantWhere: "WHERE 1 = 1 AND start_time >= ? AND start_time < ? ORDER BY",
+            wantArgs:  []interface{}{from, to},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            f := tt.filter
+            query, args, err := buildCallQuery(&f)
+            if err != nil {
+                t.Fatalf("buildCallQuery: %v", err)
+            }
+            if !strings.Contains(compact(query), tt.wantWhere) {
+                t.Errorf("query %q does not contain %q", compact(query), tt.wantWhere)
+            }
+            if got := args[:len(args)-1]; !reflect.DeepEqual(got, tt.wantArgs) {
+                t.Errorf("args = %v, want %v", got, tt.wantArgs)
+            }
+        })
+    }
+}
+
+func TestBuildCallQueryInvalid(t *testing.T) {
+    tests := []struct {
+        name   string
+        filter CallFilter
+    }{
+        {"unknown sort", CallFilter{SortBy: "ani"}},
+        {"unknown order", CallFilter{Order: "sideways"}},
+        {"malformed cursor", CallFilter{Cursor: "not a cursor!"}},
+        {"cursor not json", CallFilter{Cursor: "bm90IGpzb24"}},
+        {"cursor for another sort", CallFilter{
+            SortBy: "duration",
+            Cursor: encodeCallCursor(callCursor{SortBy: "start_time", Order: "desc", Value: "2024-01-01T00:00:00Z", ID: 1}),
+        }},
+        {"cursor for another order", CallFilter{
+            Order:  "asc",
+            Cursor: encodeCallCursor(callCursor{SortBy: "start_time", Order: "desc", Value: "2024-01-01T00:00:00Z", ID: 1}),
+        }},
+        {"cursor value of wrong type", CallFilter{
+            SortBy: "duration",
+            Cursor: encodeCallCursor(callCursor{SortBy: "duration", Order: "desc", Value: "long", ID: 1}),
+        }},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            f := tt.filter
+            if _, _, err := buildCallQuery(&f); !errors.Is(err, ErrInvalidFilter) {
+                t.Errorf("err = %v, want ErrInvalidFilter", err)
+            }
+        })
+    }
+}
+
+func TestCallCursorContinuesAfterLastRow(t *testing.T) {
+    start := time.Date(2024, 3, 5, 10, 30, 0, 123456789, time.FixedZone("EST", -5*3600))
+    last := &models.CallRecord{ID: 42, StartTime: start, Duration: 95}
+
+    tests := []struct {
+        sortBy    string
+        order     string
+        wantWhere string
+        wantArgs  []interface{}
+    }{
+        {"start_time", "desc", "(start_time < ? OR (start_time = ? AND id < ?))", []interface{}{start.UTC(), start.UTC(), int64(42)}},
+        {"start_time", "asc", "(start_time > ? OR (start_time = ? AND id > ?))", []interface{}{start.UTC(), start.UTC(), int64(42)}},
+        {"duration", "desc", "(duration < ? OR (duration = ? AND id < ?))", []interface{}{95, 95, int64(42)}},
+        {"id", "asc", "AND id > ? ORDER BY", []interface{}{int64(42)}},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.sortBy+" "+tt.order, func(t *testing.T) {
+            cursor := encodeCallCursor(callCursor{
+                SortBy: tt.sortBy,
+                Order:  tt.order,
+                Value:  sortValue(tt.sortBy, last),
+                ID:     last.ID,
+            })
+
+            f := CallFilter{SortBy: tt.sortBy, Order: tt.order, Cursor: cursor}
+            query, args, err := buildCallQuery(&f)
+            if err != nil {
+                t.Fatalf("buildCallQuery: %v", err)
+            }
+            if !strings.Contains(compact(query), tt.wantWhere) {
+                t.Errorf("query %q does not contain %q", compact(query), tt.wantWhere)
+            }
+            if got := args[:len(args)-1]; !reflect.DeepEqual(got, tt.wantArgs) {
+                t.Errorf("args = %#v, want %#v", got, tt.wantArgs)
+            }
+        })
+    }
+}
+
+func TestCallCursorRoundTrip(t *testing.T) {
+    want := callCursor{SortBy: "duration", Order: "asc", Value: "17", ID: 9}
+    got, err := decodeCallCursor(encodeCallCursor(want))
+    if err != nil {
+        t.Fatalf("decodeCallCursor: %v", err)
+    }
+    if got != want {
+        t.Errorf("cursor = %+v, want %+v", got, want)
+    }
+}
+
+func TestParseCallStates(t *testing.T) {
+    tests := []struct {
+        input   string
+        want    []models.CallState
+        wantErr bool
+    }{
+        {"", nil, false},
+        {"active", []models.CallState{models.CallStateActive}, false},
+        {" completed , FAILED,", []models.CallState{models.CallStateCompleted, models.CallStateFailed}, false},
+        {"active,ringing", nil, true},
+    }
+
+    for _, tt := range tests {
+        got, err := ParseCallStates(tt.input)
+        if tt.wantErr {
+            if !errors.Is(err, ErrInvalidFilter) {
+                t.Errorf("ParseCallStates(%q) err = %v, want ErrInvalidFilter", tt.input, err)
+            }
+            continue
+        }
+        if err != nil || !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("ParseCallStates(%q) = %v, %v, want %v", tt.input, got, err, tt.want)
+        }
+    }
+}
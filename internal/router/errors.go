@@ -0,0 +1,53 @@
+package router
+
+import (
+    "errors"
+    
+    "github.com/router-production/internal/provider"
+)
+
+// Error codes tell the dialplan why routing failed, whether it asked over
+// HTTP or AGI. They are part of the API contract, never rename one.
+const (
+    CodeInvalidNumber      = "invalid_number"
+    CodeInvalidRequest     = "invalid_request"
+    CodeCallNotFound       = "call_not_found"
+    CodeProviderNotFound   = "provider_not_found"
+    CodeCallIDConflict     = "call_id_conflict"
+    CodeCallEnded          = "call_ended"
+    CodeRateLimited        = "rate_limited"
+    CodeNoDIDAvailable     = "no_did_available"
+    CodeProviderAtCapacity = "provider_at_capacity"
+    CodeShuttingDown       = "shutting_down"
+    CodeInvalidProvider    = "invalid_provider"
+    CodeConfigNotApplied   = "config_not_applied"
+    CodeInternal           = "internal_error"
+)
+
+var errorCodes = []struct {
+    err  error
+    code string
+}{
+    {ErrInvalidNumber, CodeInvalidNumber},
+    {ErrInvalidFilter, CodeInvalidRequest},
+    {ErrCallNotFound, CodeCallNotFound},
+    {provider.ErrProviderNotFound, CodeProviderNotFound},
+    {ErrCallIDConflict, CodeCallIDConflict},
+    {ErrCallEnded, CodeCallEnded},
+    {ErrRateLimited, CodeRateLimited},
+    {provider.ErrNoDIDAvailable, CodeNoDIDAvailable},
+    {provider.ErrProviderAtCapacity, CodeProviderAtCapacity},
+    {ErrRouterClosed, CodeShuttingDown},
+    {provider.ErrInvalidProvider, CodeInvalidProvider},
+    {provider.ErrNotApplied, CodeConfigNotApplied},
+}
+
+// ErrorCode returns the error code for an error returned by the router.
+func ErrorCode(err error) string {
+    for _, e := range errorCodes {
+        if errors.Is(err, e.err) {
+            return e.code
+        }
+    }
+    return CodeInternal
+}
@@ -1,6 +1,9 @@
 package router
 
 import (
+    "context"
+    "database/sql"
+    "errors"
     "fmt"
     "log"
     "strings"
@@ -10,43 +13,195 @@ import (
     "github.com/router-production/internal/database"
     "github.com/router-production/internal/models"
     "github.com/router-production/internal/provider"
+    "github.com/router-production/internal/ratelimit"
 )
 
+var (
+    // ErrCallIDConflict is returned when a call ID is reused with different call parameters.
+    ErrCallIDConflict = errors.New("call ID already used for a different call")
+    // ErrCallEnded is returned when an incoming request repeats the ID of a finished call.
+    ErrCallEnded = errors.New("call already ended")
+    // ErrRouterClosed is returned for calls arriving after Close.
+    ErrRouterClosed = errors.New("router is shutting down")
+    // ErrRateLimited is wrapped by RateLimitError.
+    ErrRateLimited = errors.New("rate limit exceeded")
+    // ErrCallNotFound is returned when no live call matches a call ID or DID.
+    ErrCallNotFound = errors.New("call not found")
+    // ErrInvalidNumber is returned for a DNIS or DID that is not a phone number.
+    ErrInvalidNumber = errors.New("invalid number")
+    // ErrInvalidFilter is returned for call list filters that cannot be applied.
+    ErrInvalidFilter = errors.New("invalid call filter")
+)
+
+// RateLimitError is returned when a call exceeds the ANI or provider rate limit,
+// or a client its per IP limit.
+type RateLimitError struct {
+    // Limit is "ani", "provider" or "ip"
+    Limit      string
+    Key        string
+    RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+    return fmt.Sprintf("%s rate limit exceeded for %s", e.Limit, e.Key)
+}
+
+func (e *RateLimitError) Unwrap() error {
+    return ErrRateLimited
+}
+
+// Config holds the routing and call timing settings. Providers can override
+// ReturnTimeout and MaxCallDuration with their own values.
+type Config struct {
+    // ReturnTimeout is how long a forwarded call may wait for its return leg
+    ReturnTimeout time.Duration
+    // MaxCallDuration is how long a call is tracked before it is considered over
+    MaxCallDuration time.Duration
+    // CleanupInterval is how often stale calls are expired
+    CleanupInterval time.Duration
+    // RecordingPath is the directory call recordings are written to
+    RecordingPath string
+    // DefaultProvider is used when no routing rule matches the DNIS
+    DefaultProvider string
+    // RoutingRules maps DNIS prefixes to provider names
+    RoutingRules map[string]string
+    // ANIRateLimit limits new calls per calling number
+    ANIRateLimit ratelimit.Limit
+    // ProviderRateLimit limits new calls per provider; ProviderRateLimits
+    // overrides it for individual providers
+    ProviderRateLimit  ratelimit.Limit
+    ProviderRateLimits map[string]ratelimit.Limit
+}
+
 type Router struct {
     db              *database.DB
     providerManager *provider.Manager
+    config          Config
     mu              sync.RWMutex
     activeCallsMap  map[string]*models.CallRecord
     didToCallMap    map[string]string
     recordingPath   string
-    routingRules    map[string]string // country -> provider mapping
+    routingRules    map[string]string // DNIS prefix -> provider mapping
+    aniLimiter      *ratelimit.Limiter
+    providerLimiter *ratelimit.Limiter
+    
+    // Lifecycle, see Start and Close
+    started bool
+    closed  bool
+    cancel  context.CancelFunc
+    wg      sync.WaitGroup
 }
 
-func NewRouter(db *database.DB, pm *provider.Manager) *Router {
+// NewRouter creates a router. It does not touch call state until Start is
+// called, so short-lived users such as CLI commands can query through it
+// without background work.
+func NewRouter(db *database.DB, pm *provider.Manager, cfg Config) *Router {
     r := &Router{
         db:              db,
         providerManager: pm,
         activeCallsMap:  make(map[string]*models.CallRecord),
         didToCallMap:    make(map[string]string),
-        recordingPath:   "/var/spool/asterisk/recordings",
-        routingRules:    make(map[string]string),
+        aniLimiter:      ratelimit.New(cfg.ANIRateLimit),
+        providerLimiter: ratelimit.New(cfg.ProviderRateLimit),
+    }
+    
+    r.applyConfig(cfg)
+    return r
+}
+
+// UpdateConfig replaces the routing rules and timeouts. Calls already in
+// progress keep running and are expired under the new timeouts.
+func (r *Router) UpdateConfig(cfg Config) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    
+    r.applyConfig(cfg)
+    log.Printf("[ROUTER] Configuration updated - %d routing rules", len(r.routingRules))
+}
+
+func (r *Router) applyConfig(cfg Config) {
+    rules := make(map[string]string, len(cfg.RoutingRules))
+    for prefix, providerName := range cfg.RoutingRules {
+        rules[prefix] = providerName
     }
     
+    r.config = cfg
+    r.recordingPath = cfg.RecordingPath
+    r.routingRules = rules
+    r.aniLimiter.SetLimits(cfg.ANIRateLimit, nil)
+    r.providerLimiter.SetLimits(cfg.ProviderRateLimit, cfg.ProviderRateLimits)
+}
+
+func (r *Router) currentConfig() Config {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    return r.config
+}
+
+// Start restores active calls from the database and runs the cleanup
+// routine until ctx is cancelled or Close is called.
+func (r *Router) Start(ctx context.Context) {
+    ctx, cancel := context.WithCancel(ctx)
+    
+    r.mu.Lock()
+    r.started = true
+    r.cancel = cancel
+    r.mu.Unlock()
+    
     // Restore active calls
     r.restoreActiveCalls()
     
     // Start cleanup routine
-    go r.cleanupRoutine()
+    r.wg.Add(1)
+    go func() {
+        defer r.wg.Done()
+        r.cleanupRoutine(ctx)
+    }()
+}
+
+// Close stops the cleanup routine and rejects new calls. It returns once
+// call record writes already in progress have completed.
+func (r *Router) Close() {
+    r.mu.Lock()
+    r.closed = true
+    cancel := r.cancel
+    r.mu.Unlock()
     
-    return r
+    if cancel != nil {
+        cancel()
+    }
+    r.wg.Wait()
+    
+    log.Printf("[ROUTER] Stopped")
 }
 
 func (r *Router) ProcessIncomingCall(callID, ani, dnis string) (*models.CallResponse, error) {
     r.mu.Lock()
     defer r.mu.Unlock()
     
+    if r.closed {
+        return nil, ErrRouterClosed
+    }
+    
     log.Printf("[ROUTER] Processing incoming call - CallID: %s, ANI: %s, DNIS: %s", callID, ani, dnis)
     
+    // The ANI is not checked, withheld numbers arrive as e.g. "anonymous"
+    if !validNumber(dnis) {
+        return nil, fmt.Errorf("%w: DNIS %q", ErrInvalidNumber, dnis)
+    }
+    
+    // A repeated call ID (e.g. a CURL retry from the dialplan) gets the
+    // original routing decision instead of a second DID
+    if record, err := r.findCall(callID); err != nil {
+        return nil, err
+    } else if record != nil {
+        return r.replayIncomingCall(record, ani, dnis)
+    }
+    
+    if ok, wait := r.aniLimiter.Allow(ani); !ok {
+        return nil, &RateLimitError{Limit: "ani", Key: ani, RetryAfter: wait}
+    }
+    
     // Determine provider based on routing rules or use round-robin
     providerName := r.selectProvider(dnis)
     
@@ -54,9 +209,14 @@ func (r *Router) ProcessIncomingCall(callID, ani, dnis string) (*models.CallResp
     did, err := r.providerManager.GetAvailableDID(providerName)
     if err != nil {
         // Try any provider if specific one fails
-        did, err = r.providerManager.GetAvailableDID("")
-        if err != nil {
-            return nil, fmt.Errorf("no available DIDs: %w", err)
+        var fallbackErr error
+        did, fallbackErr = r.providerManager.GetAvailableDID("")
+        if fallbackErr != nil {
+            // Report the selected provider being full rather than the pool being empty
+            if errors.Is(err, provider.ErrProviderAtCapacity) && errors.Is(fallbackErr, provider.ErrNoDIDAvailable) {
+                return nil, err
+            }
+            return nil, fallbackErr
         }
     }
     
@@ -75,6 +235,11 @@ func (r *Router) ProcessIncomingCall(callID, ani, dnis string) (*models.CallResp
         provider, _ = r.providerManager.GetProvider(actualProviderName)
     }
     
+    // Carrier call rate caps apply to the provider actually used
+    if ok, wait := r.providerLimiter.Allow(actualProviderName); !ok {
+        return nil, &RateLimitError{Limit: "provider", Key: actualProviderName, RetryAfter: wait}
+    }
+    
     // Mark DID as in use
     if err := r.markDIDInUse(did, dnis); err != nil {
         return nil, err
@@ -93,45 +258,120 @@ func (r *Router) ProcessIncomingCall(callID, ani, dnis string) (*models.CallResp
         RecordingPath: fmt.Sprintf("%s/%s.wav", r.recordingPath, callID),
     }
     
+    // Store in database first; a retry must find the call there if this
+    // process goes away
+    if err := r.storeCallRecord(record); err != nil {
+        if releaseErr := r.releaseDID(did); releaseErr != nil {
+            log.Printf("[ROUTER] Failed to release DID %s of unrecorded call %s: %v", did, callID, releaseErr)
+        }
+        return nil, fmt.Errorf("failed to store call record: %w", err)
+    }
+    
     // Store in memory
     r.activeCallsMap[callID] = record
     r.didToCallMap[did] = callID
     
-    // Store in database
-    r.storeCallRecord(record)
+    log.Printf("[ROUTER] Call routed via provider %s - DID: %s", actualProviderName, did)
     
-    // Build response
-    response := &models.CallResponse{
+    return incomingResponse(record), nil
+}
+
+// validNumber accepts digits with an optional leading +, as sent by carriers.
+func validNumber(number string) bool {
+    digits := strings.TrimPrefix(number, "+")
+    if len(digits) < 3 || len(digits) > 20 {
+        return false
+    }
+    for _, c := range digits {
+        if c < '0' || c > '9' {
+            return false
+        }
+    }
+    return true
+}
+
+// incomingResponse builds the routing answer for an incoming call record.
+func incomingResponse(record *models.CallRecord) *models.CallResponse {
+    return &models.CallResponse{
         Status:       "success",
-        DIDAssigned:  did,
-        NextHop:      fmt.Sprintf("trunk-%s", actualProviderName),
-        ANIToSend:    dnis,
-        DNISToSend:   did,
-        ProviderName: actualProviderName,
-        TrunkName:    fmt.Sprintf("trunk-%s", actualProviderName),
+        DIDAssigned:  record.AssignedDID,
+        NextHop:      fmt.Sprintf("trunk-%s", record.ProviderName),
+        ANIToSend:    record.OriginalDNIS,
+        DNISToSend:   record.AssignedDID,
+        ProviderName: record.ProviderName,
+        TrunkName:    fmt.Sprintf("trunk-%s", record.ProviderName),
+    }
+}
+
+// findCall looks up a call ID in memory, then in the database. It returns
+// nil without error when the call ID has never been seen. Must be called
+// with r.mu held.
+func (r *Router) findCall(callID string) (*models.CallRecord, error) {
+    if record, exists := r.activeCallsMap[callID]; exists {
+        return record, nil
     }
     
-    log.Printf("[ROUTER] Call routed via provider %s - DID: %s", actualProviderName, did)
+    row := r.db.QueryRow(fmt.Sprintf("SELECT %s FROM call_records WHERE call_id = ?", callColumns), callID)
+    record, err := scanCall(row)
+    if err == sql.ErrNoRows {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to look up call %s: %w", callID, err)
+    }
     
-    return response, nil
+    return record, nil
+}
+
+// replayIncomingCall answers a retried incoming request with the response
+// given the first time, as long as the retry describes the same call.
+func (r *Router) replayIncomingCall(record *models.CallRecord, ani, dnis string) (*models.CallResponse, error) {
+    if record.OriginalANI != ani || record.OriginalDNIS != dnis {
+        return nil, fmt.Errorf("%w: call %s was routed with ANI %s, DNIS %s", 
+            ErrCallIDConflict, record.CallID, record.OriginalANI, record.OriginalDNIS)
+    }
+    
+    switch record.Status {
+    case models.CallStateCompleted, models.CallStateFailed:
+        return nil, fmt.Errorf("%w: call %s is %s", ErrCallEnded, record.CallID, record.Status)
+    }
+    
+    // Keep the call tracked if it was only found in the database
+    r.activeCallsMap[record.CallID] = record
+    r.didToCallMap[record.AssignedDID] = record.CallID
+    
+    log.Printf("[ROUTER] Repeated call ID %s - returning original DID %s", record.CallID, record.AssignedDID)
+    
+    return incomingResponse(record), nil
 }
 
 func (r *Router) ProcessReturnCall(ani2, did string) (*models.CallResponse, error) {
     r.mu.Lock()
     defer r.mu.Unlock()
     
+    if r.closed {
+        return nil, ErrRouterClosed
+    }
+    
     did = strings.TrimSpace(did)
     ani2 = strings.TrimSpace(ani2)
     
     log.Printf("[ROUTER] Processing return call - ANI2: %s, DID: %s", ani2, did)
     
+    if !validNumber(did) {
+        return nil, fmt.Errorf("%w: DID %q", ErrInvalidNumber, did)
+    }
+    
     // Find call by DID
     callID, exists := r.didToCallMap[did]
     if !exists {
         // Try to restore from database
         record, err := r.getCallRecordByDID(did)
+        if err == sql.ErrNoRows {
+            return nil, fmt.Errorf("%w: no active call for DID %s", ErrCallNotFound, did)
+        }
         if err != nil {
-            return nil, fmt.Errorf("no active call for DID %s", did)
+            return nil, fmt.Errorf("failed to look up DID %s: %w", did, err)
         }
         callID = record.CallID
         r.activeCallsMap[callID] = record
@@ -141,6 +381,7 @@ func (r *Router) ProcessReturnCall(ani2, did string) (*models.CallResponse, erro
     record := r.activeCallsMap[callID]
     
     // Update status
+    record.Status = models.CallStateReturned
     r.updateCallStatus(callID, models.CallStateReturned)
     
     response := &models.CallResponse{
@@ -156,9 +397,96 @@ func (r *Router) ProcessReturnCall(ani2, did string) (*models.CallResponse, erro
     return response, nil
 }
 
+// EndCall finishes a call when its incoming leg hangs up and releases its
+// DID. Calls that came back are completed, calls still waiting for their
+// return leg have failed. Ending a call that already ended is not an error.
+func (r *Router) EndCall(callID string) (*models.CallRecord, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    
+    record, err := r.findCall(callID)
+    if err != nil {
+        return nil, err
+    }
+    if record == nil {
+        return nil, fmt.Errorf("%w: %s", ErrCallNotFound, callID)
+    }
+    
+    switch record.Status {
+    case models.CallStateCompleted, models.CallStateFailed:
+        return record, nil
+    }
+    
+    final := models.CallStateFailed
+    if record.Status == models.CallStateReturned {
+        final = models.CallStateCompleted
+    }
+    
+    if err := r.updateCallStatus(callID, final); err != nil {
+        return nil, fmt.Errorf("failed to end call %s: %w", callID, err)
+    }
+    record.Status = final
+    
+    delete(r.activeCallsMap, callID)
+    if r.didToCallMap[record.AssignedDID] == callID {
+        delete(r.didToCallMap, record.AssignedDID)
+    }
+    if err := r.releaseDID(record.AssignedDID); err != nil {
+        log.Printf("[ROUTER] Failed to release DID %s: %v", record.AssignedDID, err)
+    }
+    
+    log.Printf("[ROUTER] Call %s ended as %s - DID %s released", callID, final, record.AssignedDID)
+    return record, nil
+}
+
+// MarkForwarded records that an active call was answered on its outbound
+// leg. Calls in any other state are left alone.
+func (r *Router) MarkForwarded(callID string) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    
+    record, exists := r.activeCallsMap[callID]
+    if !exists {
+        return fmt.Errorf("%w: %s", ErrCallNotFound, callID)
+    }
+    if record.Status != models.CallStateActive {
+        return nil
+    }
+    
+    if err := r.updateCallStatus(callID, models.CallStateForwarded); err != nil {
+        return fmt.Errorf("failed to update call %s: %w", callID, err)
+    }
+    record.Status = models.CallStateForwarded
+    return nil
+}
+
+// TracksCall reports whether callID is a call in progress. It only checks
+// memory, so it is cheap enough to call for every channel event.
+func (r *Router) TracksCall(callID string) bool {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    
+    _, exists := r.activeCallsMap[callID]
+    return exists
+}
+
 func (r *Router) selectProvider(dnis string) string {
-    // Implement provider selection logic
-    // For now, use round-robin among active providers
+    // Longest matching DNIS prefix rule wins
+    best := ""
+    for prefix := range r.routingRules {
+        if strings.HasPrefix(dnis, prefix) && len(prefix) > len(best) {
+            best = prefix
+        }
+    }
+    if best != "" {
+        return r.routingRules[best]
+    }
+    
+    if r.config.DefaultProvider != "" {
+        return r.config.DefaultProvider
+    }
+    
+    // Otherwise use round-robin among active providers
     providers := r.providerManager.ListProviders()
     if len(providers) == 0 {
         return ""
@@ -205,18 +533,29 @@ func (r *Router) updateCallStatus(callID string, status models.CallState) error
     return err
 }
 
+// liveCallWindow keeps call_records rows (aliased cr, joined to providers p)
+// that are still inside their timeout: returned calls until the maximum call
+// duration, calls waiting for their return leg until the return timeout. It
+// takes the global max call duration and return timeout, in seconds, as args.
+const liveCallWindow = `cr.start_time > DATE_SUB(NOW(), INTERVAL
+       CASE WHEN cr.status = 'RETURNED'
+           THEN COALESCE(NULLIF(p.max_call_duration, 0), ?)
+           ELSE COALESCE(NULLIF(p.return_timeout, 0), ?)
+       END SECOND)`
+
 func (r *Router) getCallRecordByDID(did string) (*models.CallRecord, error) {
     record := &models.CallRecord{}
     err := r.db.QueryRow(`
-        SELECT call_id, original_ani, original_dnis, assigned_did, 
-               provider_id, provider_name, status, start_time, recording_path
-        FROM call_records
-        WHERE assigned_did = ? 
-        AND status IN ('ACTIVE', 'FORWARDED', 'RETURNED')
-       AND start_time > DATE_SUB(NOW(), INTERVAL 10 MINUTE)
-       ORDER BY start_time DESC
+        SELECT cr.call_id, cr.original_ani, cr.original_dnis, cr.assigned_did, 
+               cr.provider_id, cr.provider_name, cr.status, cr.start_time, cr.recording_path
+        FROM call_records cr
+        LEFT JOIN providers p ON p.id = cr.provider_id
+        WHERE cr.assigned_did = ? 
+        AND cr.status IN ('ACTIVE', 'FORWARDED', 'RETURNED')
+       AND `+liveCallWindow+`
+       ORDER BY cr.start_time DESC
        LIMIT 1
-   `, did).Scan(
+   `, did, seconds(r.config.MaxCallDuration), seconds(r.config.ReturnTimeout)).Scan(
        &record.CallID, &record.OriginalANI, &record.OriginalDNIS,
        &record.AssignedDID, &record.ProviderID, &record.ProviderName,
        &record.Status, &record.StartTime, &record.RecordingPath,
@@ -226,13 +565,15 @@ func (r *Router) getCallRecordByDID(did string) (*models.CallRecord, error) {
 }
 
 func (r *Router) restoreActiveCalls() {
+   cfg := r.currentConfig()
    rows, err := r.db.Query(`
-       SELECT call_id, original_ani, original_dnis, assigned_did, 
-              provider_id, provider_name, status, start_time, recording_path
-       FROM call_records
-       WHERE status IN ('ACTIVE', 'FORWARDED', 'RETURNED')
-       AND start_time > DATE_SUB(NOW(), INTERVAL 10 MINUTE)
-   `)
+       SELECT cr.call_id, cr.original_ani, cr.original_dnis, cr.assigned_did, 
+              cr.provider_id, cr.provider_name, cr.status, cr.start_time, cr.recording_path
+       FROM call_records cr
+       LEFT JOIN providers p ON p.id = cr.provider_id
+       WHERE cr.status IN ('ACTIVE', 'FORWARDED', 'RETURNED')
+       AND `+liveCallWindow+`
+   `, seconds(cfg.MaxCallDuration), seconds(cfg.ReturnTimeout))
    if err != nil {
        return
    }
@@ -255,48 +596,175 @@ func (r *Router) restoreActiveCalls() {
    log.Printf("[ROUTER] Restored %d active calls", count)
 }
 
-func (r *Router) cleanupRoutine() {
-   ticker := time.NewTicker(30 * time.Second)
+func (r *Router) cleanupRoutine(ctx context.Context) {
+   interval := r.currentConfig().CleanupInterval
+   ticker := time.NewTicker(interval)
    defer ticker.Stop()
    
-   for range ticker.C {
-       r.cleanupStaleCalls()
+   for {
+       select {
+       case <-ctx.Done():
+           return
+       case <-ticker.C:
+           r.cleanupStaleCalls()
+       }
+       
+       // Pick up interval changes from UpdateConfig
+       if current := r.currentConfig().CleanupInterval; current != interval {
+           interval = current
+           ticker.Reset(interval)
+       }
    }
 }
 
 func (r *Router) cleanupStaleCalls() {
-   result, err := r.db.Exec(`
-       UPDATE call_records 
-       SET status = 'FAILED', end_time = NOW()
-       WHERE status IN ('ACTIVE', 'FORWARDED')
-       AND start_time < DATE_SUB(NOW(), INTERVAL 10 MINUTE)
-   `)
+   cfg := r.currentConfig()
+   
+   // Calls past their window in the database: waiting calls never came back
+   // and are failed, returned calls ran past the maximum duration and are
+   // considered completed
+   rows, err := r.db.Query(`
+       SELECT cr.call_id, cr.assigned_did, cr.status
+       FROM call_records cr
+       LEFT JOIN providers p ON p.id = cr.provider_id
+       WHERE cr.status IN ('ACTIVE', 'FORWARDED', 'RETURNED')
+       AND NOT `+liveCallWindow+`
+   `, seconds(cfg.MaxCallDuration), seconds(cfg.ReturnTimeout))
+   if err != nil {
+       log.Printf("[ROUTER] Failed to query stale calls: %v", err)
+       return
+   }
+   
+   type staleCall struct {
+       callID, did string
+       status      models.CallState
+   }
+   var stale []staleCall
+   for rows.Next() {
+       var c staleCall
+       if err := rows.Scan(&c.callID, &c.did, &c.status); err != nil {
+           log.Printf("[ROUTER] Failed to scan stale call: %v", err)
+           continue
+       }
+       stale = append(stale, c)
+   }
+   if err := rows.Err(); err != nil {
+       log.Printf("[ROUTER] Failed to read stale calls: %v", err)
+   }
+   rows.Close()
    
-   if err == nil {
-       rows, _ := result.RowsAffected()
-       if rows > 0 {
-           log.Printf("[ROUTER] Cleaned up %d stale calls", rows)
-           
-           // Release DIDs
-           r.db.Exec(`
-               UPDATE dids d
-               INNER JOIN call_records cr ON d.did = cr.assigned_did
-               SET d.in_use = 0, d.destination = NULL
-               WHERE cr.status = 'FAILED'
-               AND cr.end_time > DATE_SUB(NOW(), INTERVAL 1 MINUTE)
-           `)
+   for _, c := range stale {
+       final := models.CallStateFailed
+       if c.status == models.CallStateReturned {
+           final = models.CallStateCompleted
+       }
+       
+       if err := r.updateCallStatus(c.callID, final); err != nil {
+           log.Printf("[ROUTER] Failed to expire call %s: %v", c.callID, err)
+           continue
        }
+       if err := r.releaseDID(c.did); err != nil {
+           log.Printf("[ROUTER] Failed to release DID %s of expired call %s: %v", c.did, c.callID, err)
+       }
+   }
+   
+   if len(stale) > 0 {
+       log.Printf("[ROUTER] Cleaned up %d stale calls", len(stale))
+   }
+   
+   // Evict expired calls from memory, including ones already finished in
+   // the database
+   r.mu.Lock()
+   defer r.mu.Unlock()
+   
+   now := time.Now()
+   evicted := 0
+   for callID, record := range r.activeCallsMap {
+       returnTimeout, maxDuration := r.timeoutsFor(record.ProviderName)
+       
+       age := now.Sub(record.StartTime)
+       expired := age > maxDuration
+       if record.Status != models.CallStateReturned && age > returnTimeout {
+           expired = true
+       }
+       
+       if expired {
+           delete(r.activeCallsMap, callID)
+           if r.didToCallMap[record.AssignedDID] == callID {
+               delete(r.didToCallMap, record.AssignedDID)
+           }
+           evicted++
+       }
+   }
+   
+   for did, callID := range r.didToCallMap {
+       if _, exists := r.activeCallsMap[callID]; !exists {
+           delete(r.didToCallMap, did)
+       }
+   }
+   
+   if evicted > 0 {
+       log.Printf("[ROUTER] Evicted %d expired calls from memory", evicted)
    }
 }
 
+// timeoutsFor returns the return timeout and max call duration for calls on
+// a provider, applying the provider's overrides to the global settings.
+func (r *Router) timeoutsFor(providerName string) (time.Duration, time.Duration) {
+   returnTimeout := r.config.ReturnTimeout
+   maxDuration := r.config.MaxCallDuration
+   
+   if p, err := r.providerManager.GetProvider(providerName); err == nil {
+       if p.ReturnTimeout > 0 {
+           returnTimeout = time.Duration(p.ReturnTimeout) * time.Second
+       }
+       if p.MaxCallDuration > 0 {
+           maxDuration = time.Duration(p.MaxCallDuration) * time.Second
+       }
+   }
+   
+   return returnTimeout, maxDuration
+}
+
+func seconds(d time.Duration) int {
+   return int(d / time.Second)
+}
+
+func (r *Router) releaseDID(did string) error {
+   _, err := r.db.Exec(`
+       UPDATE dids 
+       SET in_use = 0, destination = NULL, updated_at = NOW()
+       WHERE did = ?
+   `, did)
+   return err
+}
+
 func (r *Router) GetStatistics() map[string]interface{} {
    r.mu.RLock()
+   started := r.started
+   cfg := r.config
    activeCalls := len(r.activeCallsMap)
    r.mu.RUnlock()
    
+   // Without a running router nothing is tracked in memory, count the
+   // calls it would have restored instead
+   if !started {
+       r.db.QueryRow(`
+           SELECT COUNT(*)
+           FROM call_records cr
+           LEFT JOIN providers p ON p.id = cr.provider_id
+           WHERE cr.status IN ('ACTIVE', 'FORWARDED', 'RETURNED')
+           AND `+liveCallWindow+`
+       `, seconds(cfg.MaxCallDuration), seconds(cfg.ReturnTimeout)).Scan(&activeCalls)
+   }
+   
    stats := map[string]interface{}{
        "active_calls": activeCalls,
        "providers":    make([]map[string]interface{}, 0),
+       "rate_limits": map[string]ratelimit.Stats{
+           "ani":      r.aniLimiter.Stats(),
+           "provider": r.providerLimiter.Stats(),
+       },
    }
    
    // Get provider statistics
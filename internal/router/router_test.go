@@ -0,0 +1,356 @@
+package router
+
+import (
+    "bytes"
+    "database/sql/driver"
+    "errors"
+    "log"
+    "reflect"
+    "sort"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/router-production/internal/database/dbtest"
+    "github.com/router-production/internal/models"
+    "github.com/router-production/internal/provider"
+)
+
+var providerColumns = []string{
+    "id", "name", "host", "port", "username", "password", "realm", "transport",
+    "codecs", "max_channels", "active", "country", "return_timeout", "max_call_duration",
+    "media_encryption", "tls_cert_file", "tls_key_file", "tls_ca_file", "tls_verify_server",
+    "registration", "registration_expiration", "registration_retry_interval",
+    "registration_server_uri", "registration_client_uri", "match_addresses", "contacts",
+    "template",
+}
+
+// providerRow returns p as a row of the providers table.
+func providerRow(p *models.Provider) []driver.Value {
+    return []driver.Value{
+        int64(p.ID), p.Name, p.Host, int64(p.Port), p.Username, p.Password, p.Realm, p.Transport,
+        []byte("[]"), int64(p.MaxChannels), true, p.Country, int64(p.ReturnTimeout), int64(p.MaxCallDuration),
+        "", "", "", "", false,
+        "none", int64(0), int64(0),
+        "", "", []byte("[]"), []byte("[]"),
+        "",
+    }
+}
+
+var testConfig = Config{
+    ReturnTimeout:   10 * time.Minute,
+    MaxCallDuration: 2 * time.Hour,
+    CleanupInterval: time.Minute,
+}
+
+// newTestRouter returns a router over a fake database holding providers.
+func newTestRouter(t *testing.T, providers ...*models.Provider) (*Router, *dbtest.DB) {
+    t.Helper()
+
+    fake, db := dbtest.New()
+    fake.Handle("FROM providers WHERE active = 1", func([]driver.Value) (*dbtest.Result, error) {
+        result := dbtest.Rows(providerColumns)
+        for _, p := range providers {
+            result.Rows = append(result.Rows, providerRow(p))
+        }
+        return result, nil
+    })
+
+    pm, err := provider.NewManager(db, provider.Config{AsteriskConfigDir: t.TempDir()})
+    if err != nil {
+        t.Fatal(err)
+    }
+    return NewRouter(db, pm, testConfig), fake
+}
+
+// captureLog collects the log output of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+    t.Helper()
+    var buf bytes.Buffer
+    old := log.Writer()
+    log.SetOutput(&buf)
+    t.Cleanup(func() { log.SetOutput(old) })
+    return &buf
+}
+
+func TestTimeoutsFor(t *testing.T) {
+    r, _ := newTestRouter(t,
+        &models.Provider{ID: 1, Name: "carrier"},
+        &models.Provider{ID: 2, Name: "slow", ReturnTimeout: 3600, MaxCallDuration: 4 * 3600},
+        &models.Provider{ID: 3, Name: "short", MaxCallDuration: 1800},
+    )
+
+    tests := []struct {
+        provider      string
+        returnTimeout time.Duration
+        maxDuration   time.Duration
+    }{
+        {"carrier", 10 * time.Minute, 2 * time.Hour},
+        {"slow", time.Hour, 4 * time.Hour},
+        {"short", 10 * time.Minute, 30 * time.Minute},
+        {"unknown", 10 * time.Minute, 2 * time.Hour},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.provider, func(t *testing.T) {
+            returnTimeout, maxDuration := r.timeoutsFor(tt.provider)
+            if returnTimeout != tt.returnTimeout || maxDuration != tt.maxDuration {
+                t.Errorf("timeoutsFor(%q) = %v, %v, want %v, %v",
+                    tt.provider, returnTimeout, maxDuration, tt.returnTimeout, tt.maxDuration)
+            }
+        })
+    }
+}
+
+func TestCleanupEvictsExpiredCalls(t *testing.T) {
+    r, _ := newTestRouter(t,
+        &models.Provider{ID: 1, Name: "carrier"},
+        &models.Provider{ID: 2, Name: "slow", ReturnTimeout: 3600, MaxCallDuration: 4 * 3600},
+    )
+
+    now := time.Now()
+    calls := []struct {
+        callID   string
+        provider string
+        status   models.CallState
+        age      time.Duration
+    }{
+        {"fresh", "carrier", models.CallStateActive, time.Minute},
+        {"unreturned", "carrier", models.CallStateActive, 20 * time.Minute},
+        {"forwarded", "carrier", models.CallStateForwarded, 20 * time.Minute},
+        {"returned", "carrier", models.CallStateReturned, 20 * time.Minute},
+        {"too-long", "carrier", models.CallStateReturned, 3 * time.Hour},
+        {"slow-waiting", "slow", models.CallStateActive, 20 * time.Minute},
+        {"slow-returned", "slow", models.CallStateReturned, 3 * time.Hour},
+        {"slow-too-long", "slow", models.CallStateReturned, 5 * time.Hour},
+    }
+    for i, c := range calls {
+        did := "555100" + string(rune('0'+i))
+        r.activeCallsMap[c.callID] = &models.CallRecord{
+            CallID: c.callID, AssignedDID: did, ProviderName: c.provider,
+            Status: c.status, StartTime: now.Add(-c.age),
+        }
+        r.didToCallMap[did] = c.callID
+    }
+    // A DID left pointing at a call that is no longer tracked
+    r.didToCallMap["5559999"] = "gone"
+
+    r.cleanupStaleCalls()
+
+    var kept []string
+    for callID := range r.activeCallsMap {
+        kept = append(kept, callID)
+    }
+    sort.Strings(kept)
+    want := []string{"fresh", "returned", "slow-returned", "slow-waiting"}
+    if !reflect.DeepEqual(kept, want) {
+        t.Errorf("calls kept = %v, want %v", kept, want)
+    }
+
+    if len(r.didToCallMap) != len(want) {
+        t.Errorf("didToCallMap has %d entries, want %d: %v", len(r.didToCallMap), len(want), r.didToCallMap)
+    }
+    for did, callID := range r.didToCallMap {
+        if record, ok := r.activeCallsMap[callID]; !ok || record.AssignedDID != did {
+            t.Errorf("didToCallMap[%s] = %s, which is not tracked under that DID", did, callID)
+        }
+    }
+}
+
+func TestCleanupExpiresStaleRecords(t *testing.T) {
+    r, fake := newTestRouter(t)
+    fake.Handle("SELECT cr.call_id, cr.assigned_did, cr.status", func([]driver.Value) (*dbtest.Result, error) {
+        return dbtest.Rows([]string{"call_id", "assigned_did", "status"},
+            []driver.Value{"waiting", "5551001", "ACTIVE"},
+            []driver.Value{"returned", "5551002", "RETURNED"},
+            []driver.Value{nil, "5551003", "ACTIVE"},
+        ), nil
+    })
+    out := captureLog(t)
+
+    r.cleanupStaleCalls()
+
+    // The window takes the global max call duration, then the return timeout
+    query := fake.Statements("SELECT cr.call_id, cr.assigned_did, cr.status")
+    if len(query) != 1 {
+        t.Fatalf("stale calls queried %d times, want 1", len(query))
+    }
+    if !strings.Contains(query[0].Query, "NOT "+compact(liveCallWindow)) {
+        t.Errorf("stale call query %q does not exclude the live call window", query[0].Query)
+    }
+    if want := []driver.Value{int64(7200), int64(600)}; !reflect.DeepEqual(query[0].Args, want) {
+        t.Errorf("stale call query args = %v, want %v", query[0].Args, want)
+    }
+
+    var expired [][]driver.Value
+    for _, s := range fake.Statements("UPDATE call_records") {
+        expired = append(expired, []driver.Value{s.Args[0], s.Args[3]})
+    }
+    wantExpired := [][]driver.Value{{"FAILED", "waiting"}, {"COMPLETED", "returned"}}
+    if !reflect.DeepEqual(expired, wantExpired) {
+        t.Errorf("status updates = %v, want %v", expired, wantExpired)
+    }
+
+    var released []driver.Value
+    for _, s := range fake.Statements("UPDATE dids SET in_use = 0") {
+        released = append(released, s.Args[0])
+    }
+    if want := []driver.Value{"5551001", "5551002"}; !reflect.DeepEqual(released, want) {
+        t.Errorf("released DIDs = %v, want %v", released, want)
+    }
+
+    if !strings.Contains(out.String(), "Failed to scan stale call") {
+        t.Errorf("log %q does not report the unreadable row", out.String())
+    }
+}
+
+func TestCleanupLogsReleaseFailure(t *testing.T) {
+    r, fake := newTestRouter(t)
+    fake.Handle("SELECT cr.call_id, cr.assigned_did, cr.status", func([]driver.Value) (*dbtest.Result, error) {
+        return dbtest.Rows([]string{"call_id", "assigned_did", "status"},
+            []driver.Value{"waiting", "5551001", "ACTIVE"},
+        ), nil
+    })
+    fake.Handle("UPDATE dids SET in_use = 0", func([]driver.Value) (*dbtest.Result, error) {
+        return nil, errors.New("lock wait timeout")
+    })
+    out := captureLog(t)
+
+    r.cleanupStaleCalls()
+
+    want := "Failed to release DID 5551001 of expired call waiting: lock wait timeout"
+    if !strings.Contains(out.String(), want) {
+        t.Errorf("log %q does not contain %q", out.String(), want)
+    }
+}
+
+var callRecordColumns = []string{
+    "id", "call_id", "original_ani", "original_dnis", "assigned_did", "provider_id",
+    "provider_name", "status", "start_time", "end_time", "duration", "recording_path",
+}
+
+// newIncomingRouter returns a router with one provider handing out DID
+// 5551001 to incoming calls.
+func newIncomingRouter(t *testing.T) (*Router, *dbtest.DB) {
+    t.Helper()
+
+    r, fake := newTestRouter(t, &models.Provider{ID: 1, Name: "carrier"})
+    fake.Handle("SELECT d.did FROM dids d", func([]driver.Value) (*dbtest.Result, error) {
+        return dbtest.Rows([]string{"did"}, []driver.Value{"5551001"}), nil
+    })
+    fake.Handle("SELECT p.id, p.name FROM providers p", func([]driver.Value) (*dbtest.Result, error) {
+        return dbtest.Rows([]string{"id", "name"}, []driver.Value{int64(1), "carrier"}), nil
+    })
+    return r, fake
+}
+
+func TestProcessIncomingCallReplay(t *testing.T) {
+    r, fake := newIncomingRouter(t)
+
+    first, err := r.ProcessIncomingCall("call-1", "15550001", "12125550100")
+    if err != nil {
+        t.Fatalf("first request: %v", err)
+    }
+    if first.DIDAssigned != "5551001" || first.ProviderName != "carrier" {
+        t.Fatalf("first request routed to %s via %s, want 5551001 via carrier", first.DIDAssigned, first.ProviderName)
+    }
+
+    again, err := r.ProcessIncomingCall("call-1", "15550001", "12125550100")
+    if err != nil {
+        t.Fatalf("retried request: %v", err)
+    }
+    if !reflect.DeepEqual(again, first) {
+        t.Errorf("retried request = %+v, want %+v", again, first)
+    }
+
+    if n := len(fake.Statements("INSERT INTO call_records")); n != 1 {
+        t.Errorf("call recorded %d times, want 1", n)
+    }
+    if n := len(fake.Statements("UPDATE dids SET in_use = 1")); n != 1 {
+        t.Errorf("DID marked in use %d times, want 1", n)
+    }
+}
+
+func TestProcessIncomingCallReplayFromDatabase(t *testing.T) {
+    tests := []struct {
+        name    string
+        ani     string
+        status  models.CallState
+        wantErr error
+    }{
+        {"active", "15550001", models.CallStateActive, nil},
+        {"returned", "15550001", models.CallStateReturned, nil},
+        {"different ANI", "15550002", models.CallStateActive, ErrCallIDConflict},
+        {"completed", "15550001", models.CallStateCompleted, ErrCallEnded},
+        {"failed", "15550001", models.CallStateFailed, ErrCallEnded},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            // The call was routed by another instance or before a restart
+            r, fake := newIncomingRouter(t)
+            fake.Handle("FROM call_records WHERE call_id = ?", func([]driver.Value) (*dbtest.Result, error) {
+                return dbtest.Rows(callRecordColumns, []driver.Value{
+                    int64(7), "call-1", "15550001", "12125550100", "5552002", int64(1),
+                    "carrier", string(tt.status), time.Now().Add(-time.Minute), nil, int64(0), "",
+                }), nil
+            })
+
+            resp, err := r.ProcessIncomingCall("call-1", tt.ani, "12125550100")
+            if tt.wantErr != nil {
+                if !errors.Is(err, tt.wantErr) {
+                    t.Fatalf("error = %v, want %v", err, tt.wantErr)
+                }
+                if r.TracksCall("call-1") {
+                    t.Error("rejected call is tracked")
+                }
+            } else {
+                if err != nil {
+                    t.Fatalf("ProcessIncomingCall: %v", err)
+                }
+                if resp.DIDAssigned != "5552002" {
+                    t.Errorf("DID = %s, want the original 5552002", resp.DIDAssigned)
+                }
+                if !r.TracksCall("call-1") {
+                    t.Error("replayed call is not tracked")
+                }
+            }
+
+            if n := len(fake.Statements("UPDATE dids SET in_use = 1")); n != 0 {
+                t.Errorf("DID marked in use %d times for a known call ID, want 0", n)
+            }
+        })
+    }
+}
+
+func TestProcessIncomingCallConflictInMemory(t *testing.T) {
+    r, _ := newIncomingRouter(t)
+    if _, err := r.ProcessIncomingCall("call-1", "15550001", "12125550100"); err != nil {
+        t.Fatal(err)
+    }
+
+    _, err := r.ProcessIncomingCall("call-1", "15550001", "12125550199")
+    if !errors.Is(err, ErrCallIDConflict) {
+        t.Errorf("error = %v, want %v", err, ErrCallIDConflict)
+    }
+}
+
+func TestProcessIncomingCallStoreFailure(t *testing.T) {
+    r, fake := newIncomingRouter(t)
+    fake.Handle("INSERT INTO call_records", func([]driver.Value) (*dbtest.Result, error) {
+        return nil, errors.New("deadlock found")
+    })
+
+    _, err := r.ProcessIncomingCall("call-1", "15550001", "12125550100")
+    if err == nil || !strings.Contains(err.Error(), "deadlock found") {
+        t.Fatalf("error = %v, want the store failure", err)
+    }
+    if r.TracksCall("call-1") {
+        t.Error("unrecorded call is tracked")
+    }
+
+    released := fake.Statements("UPDATE dids SET in_use = 0")
+    if len(released) != 1 || released[0].Args[0] != "5551001" {
+        t.Errorf("released DIDs = %v, want 5551001 once", released)
+    }
+}
@@ -0,0 +1,163 @@
+package secrets
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+)
+
+// Encrypted values look like enc:v1:<key id>:<base64 nonce+ciphertext>
+const encryptedPrefix = "enc:v1:"
+
+const keySize = 32
+
+var ErrUnknownKey = errors.New("secret encrypted with an unknown key")
+
+// Keyring holds the AES-256 keys used to encrypt secrets at rest. The
+// primary key encrypts new values; the others are kept so values encrypted
+// before a rotation can still be read.
+type Keyring struct {
+    primary string
+    keys    map[string]cipher.AEAD
+}
+
+// ParseKeyring reads keys given one per line (or comma separated) as
+// <id>:<base64 key>. The first key is the primary. Blank lines and lines
+// starting with # are ignored.
+func ParseKeyring(data string) (*Keyring, error) {
+    k := &Keyring{keys: make(map[string]cipher.AEAD)}
+
+    entries := strings.FieldsFunc(data, func(r rune) bool { return r == '\n' || r == ',' })
+    for _, entry := range entries {
+        entry = strings.TrimSpace(entry)
+        if entry == "" || strings.HasPrefix(entry, "#") {
+            continue
+        }
+
+        id, encoded, ok := strings.Cut(entry, ":")
+        if !ok || id == "" {
+            return nil, fmt.Errorf("invalid key entry, expected <id>:<base64 key>")
+        }
+        if _, exists := k.keys[id]; exists {
+            return nil, fmt.Errorf("duplicate key id %s", id)
+        }
+
+        raw, err := base64.StdEncoding.DecodeString(encoded)
+        if err != nil || len(raw) != keySize {
+            return nil, fmt.Errorf("key %s must be %d bytes, base64 encoded", id, keySize)
+        }
+
+        block, err := aes.NewCipher(raw)
+        if err != nil {
+            return nil, err
+        }
+        aead, err := cipher.NewGCM(block)
+        if err != nil {
+            return nil, err
+        }
+
+        k.keys[id] = aead
+        if k.primary == "" {
+            k.primary = id
+        }
+    }
+
+    if k.primary == "" {
+        return nil, fmt.Errorf("no keys found")
+    }
+    return k, nil
+}
+
+// LoadKeyring reads a keyring from a file.
+func LoadKeyring(path string) (*Keyring, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read key file: %w", err)
+    }
+
+    k, err := ParseKeyring(string(data))
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", path, err)
+    }
+    return k, nil
+}
+
+// GenerateKey returns a new random key entry in keyring format.
+func GenerateKey(id string) (string, error) {
+    raw := make([]byte, keySize)
+    if _, err := rand.Read(raw); err != nil {
+        return "", err
+    }
+    return id + ":" + base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// IsEncrypted reports whether value was produced by Encrypt.
+func IsEncrypted(value string) bool {
+    return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// PrimaryKeyID returns the ID of the key used for new encryptions.
+func (k *Keyring) PrimaryKeyID() string {
+    return k.primary
+}
+
+// Encrypt seals plaintext with the primary key. The context (e.g. the owning
+// provider name) is authenticated, so a value cannot be moved to another row.
+func (k *Keyring) Encrypt(plaintext, context string) (string, error) {
+    if plaintext == "" {
+        return "", nil
+    }
+
+    aead := k.keys[k.primary]
+    nonce := make([]byte, aead.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+        return "", err
+    }
+
+    sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(context))
+    return encryptedPrefix + k.primary + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value produced by Encrypt. Values that are not encrypted
+// are returned unchanged, so rows written before encryption was enabled keep
+// working until they are rotated.
+func (k *Keyring) Decrypt(value, context string) (string, error) {
+    if !IsEncrypted(value) {
+        return value, nil
+    }
+
+    id, encoded, ok := strings.Cut(strings.TrimPrefix(value, encryptedPrefix), ":")
+    if !ok {
+        return "", fmt.Errorf("malformed encrypted secret")
+    }
+
+    aead, exists := k.keys[id]
+    if !exists {
+        return "", fmt.Errorf("%w %q", ErrUnknownKey, id)
+    }
+
+    sealed, err := base64.StdEncoding.DecodeString(encoded)
+    if err != nil || len(sealed) < aead.NonceSize() {
+        return "", fmt.Errorf("malformed encrypted secret")
+    }
+
+    nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+    plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(context))
+    if err != nil {
+        return "", fmt.Errorf("failed to decrypt secret: %w", err)
+    }
+    return string(plaintext), nil
+}
+
+// NeedsRotation reports whether value is not yet encrypted with the primary key.
+func (k *Keyring) NeedsRotation(value string) bool {
+    if value == "" {
+        return false
+    }
+    return !strings.HasPrefix(value, encryptedPrefix+k.primary+":")
+}
@@ -0,0 +1,213 @@
+package secrets
+
+import (
+    "encoding/base64"
+    "errors"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func mustKey(t *testing.T, id string) string {
+    t.Helper()
+    key, err := GenerateKey(id)
+    if err != nil {
+        t.Fatalf("GenerateKey: %v", err)
+    }
+    return key
+}
+
+func mustKeyring(t *testing.T, data string) *Keyring {
+    t.Helper()
+    k, err := ParseKeyring(data)
+    if err != nil {
+        t.Fatalf("ParseKeyring: %v", err)
+    }
+    return k
+}
+
+func TestParseKeyring(t *testing.T) {
+    k1, k2 := mustKey(t, "k1"), mustKey(t, "k2")
+
+    tests := []struct {
+        name        string
+        data        string
+        wantPrimary string
+        wantErr     string
+    }{
+        {"one per line", "# keys\n" + k2 + "\n\n" + k1 + "\n", "k2", ""},
+        {"comma separated", k1 + ", " + k2, "k1", ""},
+        {"empty", "# nothing here\n", "", "no keys found"},
+        {"missing id", ":" + strings.SplitN(k1, ":", 2)[1], "", "invalid key entry"},
+        {"no separator", "justakey", "", "invalid key entry"},
+        {"duplicate id", k1 + "\n" + k1, "", "duplicate key id k1"},
+        {"short key", "k1:c2hvcnQ=", "", "must be 32 bytes"},
+        {"not base64", "k1:!!!", "", "must be 32 bytes"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            k, err := ParseKeyring(tt.data)
+            if tt.wantErr != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                    t.Fatalf("err = %v, want %q", err, tt.wantErr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("ParseKeyring: %v", err)
+            }
+            if k.PrimaryKeyID() != tt.wantPrimary {
+                t.Errorf("primary = %q, want %q", k.PrimaryKeyID(), tt.wantPrimary)
+            }
+        })
+    }
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+    k := mustKeyring(t, mustKey(t, "k1"))
+
+    sealed, err := k.Encrypt("s3cret", "carrier-a")
+    if err != nil {
+        t.Fatalf("Encrypt: %v", err)
+    }
+    if !IsEncrypted(sealed) || !strings.HasPrefix(sealed, "enc:v1:k1:") {
+        t.Fatalf("sealed value %q is not tagged with the key", sealed)
+    }
+    if strings.Contains(sealed, "s3cret") {
+        t.Fatalf("sealed value contains the plaintext")
+    }
+
+    again, _ := k.Encrypt("s3cret", "carrier-a")
+    if again == sealed {
+        t.Errorf("two encryptions gave the same value, nonce reused")
+    }
+
+    plaintext, err := k.Decrypt(sealed, "carrier-a")
+    if err != nil || plaintext != "s3cret" {
+        t.Errorf("Decrypt = %q, %v, want s3cret", plaintext, err)
+    }
+}
+
+func TestDecryptChecksContext(t *testing.T) {
+    k := mustKeyring(t, mustKey(t, "k1"))
+    sealed, _ := k.Encrypt("s3cret", "carrier-a")
+
+    // A value copied to another provider's row must not open
+    if _, err := k.Decrypt(sealed, "carrier-b"); err == nil {
+        t.Errorf("Decrypt with another context succeeded")
+    }
+}
+
+func TestDecryptPassesThroughPlaintext(t *testing.T) {
+    k := mustKeyring(t, mustKey(t, "k1"))
+
+    for _, value := range []string{"", "legacy-password"} {
+        got, err := k.Decrypt(value, "carrier-a")
+        if err != nil || got != value {
+            t.Errorf("Decrypt(%q) = %q, %v", value, got, err)
+        }
+    }
+
+    sealed, err := k.Encrypt("", "carrier-a")
+    if err != nil || sealed != "" {
+        t.Errorf("Encrypt(\"\") = %q, %v, want empty", sealed, err)
+    }
+}
+
+func TestDecryptMalformed(t *testing.T) {
+    k := mustKeyring(t, mustKey(t, "k1"))
+    sealed, _ := k.Encrypt("s3cret", "carrier-a")
+    encoded := strings.TrimPrefix(sealed, "enc:v1:k1:")
+
+    tests := []struct {
+        name    string
+        value   string
+        wantErr error
+    }{
+        {"unknown key", "enc:v1:k9:" + encoded, ErrUnknownKey},
+        {"no key id", "enc:v1:" + encoded[:10], nil},
+        {"bad base64", "enc:v1:k1:%%%", nil},
+        {"too short", "enc:v1:k1:AAAA", nil},
+        {"tampered", "enc:v1:k1:" + flipLast(encoded), nil},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, err := k.Decrypt(tt.value, "carrier-a")
+            if err == nil {
+                t.Fatalf("Decrypt succeeded")
+            }
+            if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+                t.Errorf("err = %v, want %v", err, tt.wantErr)
+            }
+        })
+    }
+}
+
+// flipLast changes the last ciphertext byte so the value no longer
+// authenticates.
+func flipLast(encoded string) string {
+    sealed, _ := base64.StdEncoding.DecodeString(encoded)
+    sealed[len(sealed)-1] ^= 0xff
+    return base64.StdEncoding.EncodeToString(sealed)
+}
+
+func TestRotation(t *testing.T) {
+    oldKey, newKey := mustKey(t, "old"), mustKey(t, "new")
+
+    before := mustKeyring(t, oldKey)
+    sealed, _ := before.Encrypt("s3cret", "carrier-a")
+
+    // The new key goes first, the old one stays to read existing values
+    after := mustKeyring(t, newKey+"\n"+oldKey)
+    if !after.NeedsRotation(sealed) {
+        t.Fatalf("value sealed with the old key does not need rotation")
+    }
+    if !after.NeedsRotation("legacy-password") {
+        t.Errorf("plaintext value does not need rotation")
+    }
+    if after.NeedsRotation("") {
+        t.Errorf("empty value needs rotation")
+    }
+
+    plaintext, err := after.Decrypt(sealed, "carrier-a")
+    if err != nil {
+        t.Fatalf("Decrypt with the old key kept: %v", err)
+    }
+    rotated, err := after.Encrypt(plaintext, "carrier-a")
+    if err != nil {
+        t.Fatalf("Encrypt: %v", err)
+    }
+    if after.NeedsRotation(rotated) {
+        t.Errorf("re-encrypted value still needs rotation")
+    }
+
+    // Once the old key is dropped only rotated values open
+    newOnly := mustKeyring(t, newKey)
+    if _, err := newOnly.Decrypt(sealed, "carrier-a"); !errors.Is(err, ErrUnknownKey) {
+        t.Errorf("Decrypt of unrotated value = %v, want ErrUnknownKey", err)
+    }
+    if got, err := newOnly.Decrypt(rotated, "carrier-a"); err != nil || got != "s3cret" {
+        t.Errorf("Decrypt of rotated value = %q, %v", got, err)
+    }
+}
+
+func TestLoadKeyring(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "secrets.key")
+    if err := os.WriteFile(path, []byte(mustKey(t, "k1")+"\n"), 0600); err != nil {
+        t.Fatal(err)
+    }
+    k, err := LoadKeyring(path)
+    if err != nil || k.PrimaryKeyID() != "k1" {
+        t.Fatalf("LoadKeyring = %v, %v", k, err)
+    }
+
+    if err := os.WriteFile(path, []byte("k1:short\n"), 0600); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := LoadKeyring(path); err == nil || !strings.Contains(err.Error(), path) {
+        t.Errorf("err = %v, want an error naming the file", err)
+    }
+}
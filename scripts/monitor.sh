@@ -1,5 +1,6 @@
 #!/bin/bash
 # Monitor router and providers
+# Set ROUTER_API_KEY to a key with the "read" scope
 
 while true; do
    clear
@@ -7,7 +8,7 @@ while true; do
    echo ""
    
    # Get stats via API
-   curl -s http://localhost:8001/api/stats 2>/dev/null | jq . || echo "Router not running"
+   curl -s -H "X-API-Key: $ROUTER_API_KEY" http://localhost:8001/api/stats 2>/dev/null | jq . || echo "Router not running"
    
    echo ""
    echo "Press Ctrl+C to exit"